@@ -0,0 +1,124 @@
+// Package admin serves the operator API under /_hput/. It is how grants,
+// stored KV data, saved versions, and anything else that must not be
+// reachable from stored code, are managed.
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"hput/kv"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// AdminHeader marks a request as sent by an admin client rather than by a
+// page in a browser. Browsers only send such a header to another origin
+// after a CORS preflight, which the admin API never answers.
+const AdminHeader = "X-Hput-Admin"
+
+// Logger logs out.
+type Logger interface {
+	Debugf(msg string, args ...interface{})
+	Warnf(msg string, args ...interface{})
+	Errorf(msg string, args ...interface{})
+}
+
+// Handler serves the admin routes.
+type Handler struct {
+	KV       kv.KV
+	Versions Versioner // when nil, the version routes respond with 501
+	Cache    Cacher    // when nil, the cache route responds with 501
+	Reset    Resetter  // when nil, the reset route responds with 501
+	Logger   Logger
+	Token    string // when set, callers must send "Authorization: Bearer <Token>"; when empty, only callers connecting from loopback are allowed
+	mux      *http.ServeMux
+}
+
+// New creates an admin handler backed by store.
+func New(l Logger, store kv.KV, token string) *Handler {
+	h := &Handler{
+		KV:     store,
+		Logger: l,
+		Token:  token,
+		mux:    http.NewServeMux(),
+	}
+	h.mux.HandleFunc("GET /_hput/shared", h.listShared)
+	h.mux.HandleFunc("GET /_hput/shared/{name}", h.getGrants)
+	h.mux.HandleFunc("PUT /_hput/shared/{name}", h.putGrants)
+	h.mux.HandleFunc("DELETE /_hput/shared/{name}", h.deleteGrants)
+	h.mux.HandleFunc("GET /_hput/kv", h.listPaths)
+	h.mux.HandleFunc("GET /_hput/kv/keys", h.listKeys)
+	h.mux.HandleFunc("DELETE /_hput/kv/keys", h.wipe)
+	h.mux.HandleFunc("GET /_hput/kv/key", h.getKey)
+	h.mux.HandleFunc("PUT /_hput/kv/key", h.putKey)
+	h.mux.HandleFunc("DELETE /_hput/kv/key", h.deleteKey)
+	h.mux.HandleFunc("GET /_hput/kv/usage", h.usage)
+	h.mux.HandleFunc("GET /_hput/kv/{path...}", h.kvRoute)
+	h.mux.HandleFunc("GET /_hput/versions", h.listVersions)
+	h.mux.HandleFunc("GET /_hput/versions/diff", h.diffVersions)
+	h.mux.HandleFunc("GET /_hput/versions/{id}", h.getVersion)
+	h.mux.HandleFunc("POST /_hput/versions/{id}/rollback", h.rollback)
+	h.mux.HandleFunc("GET /_hput/cache", h.cacheStats)
+	h.mux.HandleFunc("POST /_hput/reset", h.reset)
+	return h
+}
+
+// ServeHTTP checks the caller is an admin and routes the request.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.Authorized(r) {
+		h.Logger.Warnf("admin.ServeHTTP(): rejected unauthorized caller %s for %s", r.RemoteAddr, r.URL.Path)
+		http.Error(w, "admin access denied", http.StatusUnauthorized)
+		return
+	}
+	if h.Token == "" && crossSite(r) {
+		h.Logger.Warnf("admin.ServeHTTP(): rejected %s %s without %s or a JSON body from %s", r.Method, r.URL.Path, AdminHeader, r.RemoteAddr)
+		http.Error(w, "admin POSTs need an "+AdminHeader+" header or a JSON body", http.StatusForbidden)
+		return
+	}
+	h.mux.ServeHTTP(w, r)
+}
+
+// crossSite reports whether r could have been sent by a page on another
+// origin, in a browser on the same host, without a CORS preflight: a POST
+// with neither AdminHeader nor a JSON body. PUT and DELETE are always
+// preflighted. Without a Token such a request would be trusted as coming
+// from loopback, so it is refused.
+func crossSite(r *http.Request) bool {
+	if r.Method != http.MethodPost || r.Header.Get(AdminHeader) != "" {
+		return false
+	}
+	mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mt != "application/json"
+}
+
+// Authorized reports whether r may use the admin API, and so see anything
+// else that shows what it manages, such as the KV data in /dump. Without a
+// Token it trusts any connection from loopback, so behind a reverse proxy on
+// the same host every proxied request is trusted; set a Token there. A
+// browser on the same host connects from loopback too, which is why
+// ServeHTTP also refuses cross-site POSTs then.
+func (h *Handler) Authorized(r *http.Request) bool {
+	if h.Token != "" {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		return ok && subtle.ConstantTimeCompare([]byte(got), []byte(h.Token)) == 1
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// writeJSON responds with v encoded as JSON.
+func (h *Handler) writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		h.Logger.Errorf("admin.writeJSON(): could not write response: %v", err)
+	}
+}
@@ -0,0 +1,175 @@
+package admin
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"hput/kv"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type TestLogger struct{}
+
+func (t *TestLogger) Debugf(msg string, args ...interface{}) {}
+
+func (t *TestLogger) Warnf(msg string, args ...interface{}) {}
+
+func (t *TestLogger) Errorf(msg string, args ...interface{}) {}
+
+func newTestStore(t *testing.T) kv.KV {
+	t.Helper()
+	store := kv.NewMemory()
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+// do sends one request to h, marked as from an admin client, and returns the status code and body
+func do(h http.Handler, method, path, body, remote, auth string) (int, string) {
+	var r io.Reader
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, path, r)
+	req.Header.Set(AdminHeader, "1")
+	if remote != "" {
+		req.RemoteAddr = remote
+	}
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec.Code, rec.Body.String()
+}
+
+// TestAuth verifies callers need the token when one is set and must be local otherwise
+func TestAuth(t *testing.T) {
+	tt := []struct {
+		name   string
+		token  string
+		remote string
+		auth   string
+		want   int
+	}{
+		{name: "local without token", remote: "127.0.0.1:1234", want: http.StatusOK},
+		{name: "local ipv6 without token", remote: "[::1]:1234", want: http.StatusOK},
+		{name: "remote without token", remote: "192.0.2.1:1234", want: http.StatusUnauthorized},
+		{name: "remote with token", token: "s3cret", remote: "192.0.2.1:1234", auth: "Bearer s3cret", want: http.StatusOK},
+		{name: "wrong token", token: "s3cret", remote: "192.0.2.1:1234", auth: "Bearer nope", want: http.StatusUnauthorized},
+		{name: "local still needs token", token: "s3cret", remote: "127.0.0.1:1234", want: http.StatusUnauthorized},
+		{name: "token without bearer", token: "s3cret", remote: "127.0.0.1:1234", auth: "s3cret", want: http.StatusUnauthorized},
+	}
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			h := New(&TestLogger{}, newTestStore(t), test.token)
+			code, _ := do(h, http.MethodGet, "/_hput/shared", "", test.remote, test.auth)
+			assert.Equal(t, test.want, code)
+		})
+	}
+}
+
+// TestCrossSite verifies POSTs a page on another origin could send from the same host are refused without a token
+func TestCrossSite(t *testing.T) {
+	tt := []struct {
+		name        string
+		token       string
+		auth        string
+		header      string
+		contentType string
+		want        int
+	}{
+		{name: "bare POST", want: http.StatusForbidden},
+		{name: "form POST", contentType: "application/x-www-form-urlencoded", want: http.StatusForbidden},
+		{name: "text POST", contentType: "text/plain", want: http.StatusForbidden},
+		{name: "admin header", header: "1", want: http.StatusNotImplemented},
+		{name: "JSON body", contentType: "application/json; charset=utf-8", want: http.StatusNotImplemented},
+		{name: "token", token: "s3cret", auth: "Bearer s3cret", contentType: "application/x-www-form-urlencoded", want: http.StatusNotImplemented},
+	}
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			h := New(&TestLogger{}, newTestStore(t), test.token)
+			for _, path := range []string{"/_hput/reset?path=/page", "/_hput/versions/1/rollback?path=/page"} {
+				req := httptest.NewRequest(http.MethodPost, path, strings.NewReader("path=/page"))
+				req.RemoteAddr = "127.0.0.1:1234"
+				if test.auth != "" {
+					req.Header.Set("Authorization", test.auth)
+				}
+				if test.header != "" {
+					req.Header.Set(AdminHeader, test.header)
+				}
+				if test.contentType != "" {
+					req.Header.Set("Content-Type", test.contentType)
+				}
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, req)
+				assert.Equal(t, test.want, rec.Code, path)
+			}
+		})
+	}
+}
+
+// TestShared verifies grants can be created, read, listed and revoked
+func TestShared(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+	h := New(&TestLogger{}, store, "")
+	local := "127.0.0.1:1234"
+
+	code, body := do(h, http.MethodGet, "/_hput/shared/flags", "", local, "")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	code, _ = do(h, http.MethodPut, "/_hput/shared/flags", `{"read": ["/"], "write": ["/admin"]}`, local, "")
+	assert.Equal(t, http.StatusOK, code)
+	g, ok, err := kv.GetGrants(ctx, store, "flags")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, kv.Grants{Read: []string{"/"}, Write: []string{"/admin"}}, g)
+
+	code, body = do(h, http.MethodGet, "/_hput/shared/flags", "", local, "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"read": ["/"], "write": ["/admin"]}`, body)
+
+	code, body = do(h, http.MethodGet, "/_hput/shared", "", local, "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"names": ["flags"]}`, body)
+
+	assert.NoError(t, store.Put(ctx, "_shared/flags", "beta", []byte(`true`)))
+	code, _ = do(h, http.MethodDelete, "/_hput/shared/flags", "", local, "")
+	assert.Equal(t, http.StatusNoContent, code)
+	_, ok, _ = kv.GetGrants(ctx, store, "flags")
+	assert.False(t, ok)
+	v, _ := store.Get(ctx, "_shared/flags", "beta")
+	assert.Equal(t, []byte(`true`), v, "revoking grants keeps the data")
+
+	code, body = do(h, http.MethodGet, "/_hput/shared", "", local, "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"names": []}`, body)
+}
+
+// TestSharedInvalid verifies bad names and grants are rejected
+func TestSharedInvalid(t *testing.T) {
+	tt := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+	}{
+		{name: "name starting with dot", method: http.MethodPut, path: "/_hput/shared/.hidden", body: `{"read": ["/"]}`},
+		{name: "relative grant", method: http.MethodPut, path: "/_hput/shared/flags", body: `{"read": ["app"]}`},
+		{name: "traversal grant", method: http.MethodPut, path: "/_hput/shared/flags", body: `{"write": ["/app/../other"]}`},
+		{name: "not json", method: http.MethodPut, path: "/_hput/shared/flags", body: `read everything`},
+		{name: "get bad name", method: http.MethodGet, path: "/_hput/shared/a%20b"},
+	}
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			h := New(&TestLogger{}, newTestStore(t), "")
+			code, _ := do(h, test.method, test.path, test.body, "127.0.0.1:1234", "")
+			assert.Equal(t, http.StatusBadRequest, code)
+		})
+	}
+}
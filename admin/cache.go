@@ -0,0 +1,20 @@
+package admin
+
+import (
+	"hput/cachesaver"
+	"net/http"
+)
+
+// Cacher is what the cache route needs from the server's read cache.
+type Cacher interface {
+	Stats() cachesaver.Stats
+}
+
+// cacheStats responds with the read cache's hits, misses and size.
+func (h *Handler) cacheStats(w http.ResponseWriter, r *http.Request) {
+	if h.Cache == nil {
+		http.Error(w, "this server has no read cache, see -cache-size", http.StatusNotImplemented)
+		return
+	}
+	h.writeJSON(w, h.Cache.Stats())
+}
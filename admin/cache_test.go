@@ -0,0 +1,34 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"hput/cachesaver"
+	"hput/mapsaver"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCacheStats verifies the read cache's counters are reported, and 501 without one
+func TestCacheStats(t *testing.T) {
+	h := New(&TestLogger{}, newTestStore(t), "")
+	local := "127.0.0.1:1234"
+	code, _ := do(h, http.MethodGet, "/_hput/cache", "", local, "")
+	assert.Equal(t, http.StatusNotImplemented, code)
+
+	c := cachesaver.New(mapsaver.New(&TestLogger{}), &TestLogger{}, 1000, time.Minute)
+	h.Cache = c
+	for range 3 {
+		_, err := c.GetRunnable(context.Background(), url.URL{Path: "/a"})
+		assert.NoError(t, err)
+	}
+	code, body := do(h, http.MethodGet, "/_hput/cache", "", local, "")
+	assert.Equal(t, http.StatusOK, code)
+	var stats cachesaver.Stats
+	assert.NoError(t, json.Unmarshal([]byte(body), &stats))
+	assert.Equal(t, cachesaver.Stats{Hits: 2, Misses: 1, Entries: 1, Bytes: 2, MaxBytes: 1000}, stats)
+}
@@ -0,0 +1,152 @@
+package admin
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// diffContext is how many unchanged lines surround each change in a hunk.
+const diffContext = 3
+
+// maxDiffCells caps the table the line diff fills in, which is the product
+// of the changed region's line counts, so one request cannot eat the memory.
+const maxDiffCells = 4 << 20
+
+// errTooLarge is returned by diff when two texts differ over too many lines.
+var errTooLarge = errors.New("versions differ over too many lines to diff")
+
+// edit is one line of a diff: kept (' '), removed ('-') or added ('+').
+// a and b count the lines of each text before it.
+type edit struct {
+	op   byte
+	line string
+	a, b int
+}
+
+// splitLines splits s after each newline, so a missing final newline is
+// seen as a change.
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diff returns a unified diff of texts a and b, labelled with the names
+// given, or "" if they are the same.
+func diff(aName, bName, a, b string) (string, error) {
+	edits, err := diffLines(splitLines(a), splitLines(b))
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	for i := 0; i < len(edits); {
+		first := i
+		for first < len(edits) && edits[first].op == ' ' {
+			first++
+		}
+		if first == len(edits) {
+			break
+		}
+		// Hunks take in later changes until 2*diffContext unchanged lines sit between them.
+		last := first
+		for j := first; j < len(edits) && j-last <= 2*diffContext; j++ {
+			if edits[j].op != ' ' {
+				last = j
+			}
+		}
+		start, end := max(first-diffContext, i), min(last+diffContext+1, len(edits))
+		if sb.Len() == 0 {
+			fmt.Fprintf(&sb, "--- %s\n+++ %s\n", aName, bName)
+		}
+		writeHunk(&sb, edits[start:end])
+		i = end
+	}
+	return sb.String(), nil
+}
+
+// writeHunk writes one hunk's header and lines.
+func writeHunk(sb *strings.Builder, hunk []edit) {
+	var aLen, bLen int
+	for _, e := range hunk {
+		if e.op != '+' {
+			aLen++
+		}
+		if e.op != '-' {
+			bLen++
+		}
+	}
+	// An empty side is numbered by the line before it.
+	aStart, bStart := hunk[0].a, hunk[0].b
+	if aLen > 0 {
+		aStart++
+	}
+	if bLen > 0 {
+		bStart++
+	}
+	fmt.Fprintf(sb, "@@ -%d,%d +%d,%d @@\n", aStart, aLen, bStart, bLen)
+	for _, e := range hunk {
+		sb.WriteByte(e.op)
+		sb.WriteString(e.line)
+		if !strings.HasSuffix(e.line, "\n") {
+			sb.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
+
+// diffLines returns the edits that turn a into b, keeping the longest
+// common subsequence of lines.
+func diffLines(a, b []string) ([]edit, error) {
+	var pre int
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	var suf int
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+	ma, mb := a[pre:len(a)-suf], b[pre:len(b)-suf]
+	if len(ma)*len(mb) > maxDiffCells {
+		return nil, errTooLarge
+	}
+
+	// lcs[i][j] is the longest common subsequence of ma[i:] and mb[j:].
+	w := len(mb) + 1
+	lcs := make([]int32, (len(ma)+1)*w)
+	for i := len(ma) - 1; i >= 0; i-- {
+		for j := len(mb) - 1; j >= 0; j-- {
+			if ma[i] == mb[j] {
+				lcs[i*w+j] = lcs[(i+1)*w+j+1] + 1
+			} else {
+				lcs[i*w+j] = max(lcs[(i+1)*w+j], lcs[i*w+j+1])
+			}
+		}
+	}
+
+	edits := make([]edit, 0, len(a)+len(b))
+	for i := 0; i < pre; i++ {
+		edits = append(edits, edit{' ', a[i], i, i})
+	}
+	i, j := 0, 0
+	for i < len(ma) || j < len(mb) {
+		switch {
+		case i < len(ma) && j < len(mb) && ma[i] == mb[j]:
+			edits = append(edits, edit{' ', ma[i], pre + i, pre + j})
+			i++
+			j++
+		case j == len(mb) || (i < len(ma) && lcs[(i+1)*w+j] >= lcs[i*w+j+1]):
+			edits = append(edits, edit{'-', ma[i], pre + i, pre + j})
+			i++
+		default:
+			edits = append(edits, edit{'+', mb[j], pre + i, pre + j})
+			j++
+		}
+	}
+	for k := 0; k < suf; k++ {
+		ai, bi := len(a)-suf+k, len(b)-suf+k
+		edits = append(edits, edit{' ', a[ai], ai, bi})
+	}
+	return edits, nil
+}
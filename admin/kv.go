@@ -0,0 +1,217 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"hput/kv"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// keyValue is one key and its value as listed by listKeys.
+type keyValue struct {
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value"`
+}
+
+// listPaths responds with every namespace that holds a key: the paths of
+// stored code, shared namespaces as "_shared/<name>", and "_grants".
+func (h *Handler) listPaths(w http.ResponseWriter, r *http.Request) {
+	paths, err := h.KV.Paths(r.Context())
+	if err != nil {
+		h.Logger.Errorf("admin.listPaths(): %v", err)
+		http.Error(w, "could not list namespaces", http.StatusInternalServerError)
+		return
+	}
+	if paths == nil {
+		paths = []string{}
+	}
+	h.writeJSON(w, map[string][]string{"paths": paths})
+}
+
+// namespace returns the ?path= of r, or responds with 400 if it is missing.
+func namespace(w http.ResponseWriter, r *http.Request) (string, bool) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		http.Error(w, "missing ?path= naming the namespace, e.g. ?path=/app", http.StatusBadRequest)
+		return "", false
+	}
+	return path, true
+}
+
+// namespaceKey returns the ?path= and ?key= of r, or responds with 400 if either is missing.
+func namespaceKey(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+	path, ok := namespace(w, r)
+	if !ok {
+		return "", "", false
+	}
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		http.Error(w, "missing ?key=", http.StatusBadRequest)
+		return "", "", false
+	}
+	return path, key, true
+}
+
+// listKeys responds with a page of a namespace's keys and values, as
+// {"keys": [{"key", "value"}], "cursor"}. ?prefix=, ?limit= and ?cursor=
+// work like the options of hput.list.
+func (h *Handler) listKeys(w http.ResponseWriter, r *http.Request) {
+	path, ok := namespace(w, r)
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+	opts := kv.ListOptions{Prefix: q.Get("prefix"), Cursor: q.Get("cursor"), IncludeValues: true}
+	if l := q.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 0 {
+			http.Error(w, "limit must be a whole number", http.StatusBadRequest)
+			return
+		}
+		opts.Limit = n
+	}
+	res, err := h.KV.List(r.Context(), path, opts)
+	if err != nil {
+		h.Logger.Errorf("admin.listKeys(): %v", err)
+		http.Error(w, "could not list keys", http.StatusInternalServerError)
+		return
+	}
+	keys := make([]keyValue, len(res.Keys))
+	for i, k := range res.Keys {
+		keys[i] = keyValue{Key: k, Value: kv.JSONValue(res.Values[i])}
+	}
+	h.writeJSON(w, struct {
+		Keys   []keyValue `json:"keys"`
+		Cursor string     `json:"cursor"`
+	}{keys, res.Cursor})
+}
+
+// wipe deletes every key in a namespace and responds with {"deleted": n}.
+func (h *Handler) wipe(w http.ResponseWriter, r *http.Request) {
+	path, ok := namespace(w, r)
+	if !ok {
+		return
+	}
+	n, err := kv.Wipe(r.Context(), h.KV, path)
+	if err != nil {
+		h.Logger.Errorf("admin.wipe(): deleted %d keys before: %v", n, err)
+		http.Error(w, "could not wipe namespace", http.StatusInternalServerError)
+		return
+	}
+	h.Logger.Debugf("admin.wipe(): deleted %d keys from %s", n, path)
+	h.writeJSON(w, map[string]int{"deleted": n})
+}
+
+// getKey responds with the stored bytes of one key, exactly as JS wrote them.
+func (h *Handler) getKey(w http.ResponseWriter, r *http.Request) {
+	path, key, ok := namespaceKey(w, r)
+	if !ok {
+		return
+	}
+	v, err := h.KV.Get(r.Context(), path, key)
+	if err != nil {
+		h.Logger.Errorf("admin.getKey(): %v", err)
+		http.Error(w, "could not read key", http.StatusInternalServerError)
+		return
+	}
+	if v == nil {
+		http.Error(w, "no key "+key+" in "+path, http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(v)
+}
+
+// putKey stores the request body as one key's value, expiring at the RFC
+// 3339 time in ?expires= if it is set, and never otherwise.
+func (h *Handler) putKey(w http.ResponseWriter, r *http.Request) {
+	path, key, ok := namespaceKey(w, r)
+	if !ok {
+		return
+	}
+	v, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "could not read body", http.StatusBadRequest)
+		return
+	}
+	var expires time.Time
+	if e := r.URL.Query().Get("expires"); e != "" {
+		if expires, err = time.Parse(time.RFC3339Nano, e); err != nil {
+			http.Error(w, "expires must be an RFC 3339 time", http.StatusBadRequest)
+			return
+		}
+	}
+	err = h.KV.PutExpiring(r.Context(), path, key, v, expires)
+	if errors.Is(err, kv.ErrQuotaExceeded) {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		h.Logger.Errorf("admin.putKey(): %v", err)
+		http.Error(w, "could not save key", http.StatusInternalServerError)
+		return
+	}
+	h.Logger.Debugf("admin.putKey(): set %s in %s", key, path)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deleteKey removes one key. Deleting a missing key succeeds.
+func (h *Handler) deleteKey(w http.ResponseWriter, r *http.Request) {
+	path, key, ok := namespaceKey(w, r)
+	if !ok {
+		return
+	}
+	if err := h.KV.Delete(r.Context(), path, key); err != nil {
+		h.Logger.Errorf("admin.deleteKey(): %v", err)
+		http.Error(w, "could not delete key", http.StatusInternalServerError)
+		return
+	}
+	h.Logger.Debugf("admin.deleteKey(): deleted %s from %s", key, path)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// pathUsage is what one namespace stores and the limits it is held to.
+type pathUsage struct {
+	kv.Usage
+	kv.Limits
+}
+
+// usage responds with what a namespace stores and its limits, or with
+// {"paths": {namespace: usage}} for every namespace when ?path= is not given.
+func (h *Handler) usage(w http.ResponseWriter, r *http.Request) {
+	quota, ok := kv.Find[*kv.Quota](h.KV)
+	if !ok {
+		http.Error(w, "this server's KV store does not track usage", http.StatusNotImplemented)
+		return
+	}
+	if path := r.URL.Query().Get("path"); path != "" {
+		u, err := quota.Usage(r.Context(), path)
+		if err != nil {
+			h.Logger.Errorf("admin.usage(): %v", err)
+			http.Error(w, "could not read usage", http.StatusInternalServerError)
+			return
+		}
+		h.writeJSON(w, pathUsage{u, quota.Limits()})
+		return
+	}
+	paths, err := h.KV.Paths(r.Context())
+	if err != nil {
+		h.Logger.Errorf("admin.usage(): %v", err)
+		http.Error(w, "could not list namespaces", http.StatusInternalServerError)
+		return
+	}
+	all := map[string]pathUsage{}
+	for _, path := range paths {
+		u, err := quota.Usage(r.Context(), path)
+		if err != nil {
+			h.Logger.Errorf("admin.usage(): %v", err)
+			http.Error(w, "could not read usage", http.StatusInternalServerError)
+			return
+		}
+		all[path] = pathUsage{u, quota.Limits()}
+	}
+	h.writeJSON(w, map[string]map[string]pathUsage{"paths": all})
+}
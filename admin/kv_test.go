@@ -0,0 +1,131 @@
+package admin
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"hput/kv"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestKV verifies namespaces and keys can be listed, read, edited and wiped
+func TestKV(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+	h := New(&TestLogger{}, store, "")
+	local := "127.0.0.1:1234"
+
+	code, body := do(h, http.MethodGet, "/_hput/kv", "", local, "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"paths": []}`, body)
+
+	assert.NoError(t, store.Put(ctx, "/app", "user:1", []byte(`{"name":"ann"}`)))
+	assert.NoError(t, store.Put(ctx, "/app", "user:2", []byte(`not json`)))
+	assert.NoError(t, store.Put(ctx, "/app", "count", []byte(`2`)))
+	assert.NoError(t, store.Put(ctx, "_shared/flags", "beta", []byte(`true`)))
+
+	code, body = do(h, http.MethodGet, "/_hput/kv", "", local, "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"paths": ["/app", "_shared/flags"]}`, body)
+
+	code, body = do(h, http.MethodGet, "/_hput/kv/keys?path=/app&prefix=user:", "", local, "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"keys": [{"key": "user:1", "value": {"name": "ann"}}, {"key": "user:2", "value": "not json"}], "cursor": ""}`, body)
+
+	code, body = do(h, http.MethodGet, "/_hput/kv/keys?path=/app&limit=1", "", local, "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"keys": [{"key": "count", "value": 2}], "cursor": "user:1"}`, body)
+
+	code, body = do(h, http.MethodGet, "/_hput/kv/key?path=/app&key=user:2", "", local, "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "not json", body)
+
+	code, _ = do(h, http.MethodPut, "/_hput/kv/key?path=/app&key=user:2", `{"name":"bob"}`, local, "")
+	assert.Equal(t, http.StatusNoContent, code)
+	v, _ := store.Get(ctx, "/app", "user:2")
+	assert.Equal(t, []byte(`{"name":"bob"}`), v)
+
+	code, _ = do(h, http.MethodPut, "/_hput/kv/key?path=/app&key=session&expires=2030-01-02T03:04:05Z", `"s"`, local, "")
+	assert.Equal(t, http.StatusNoContent, code)
+	assert.NoError(t, store.Update(ctx, "/app", func(tx kv.Tx) error {
+		expiresAt, err := tx.ExpiresAt("session")
+		assert.Equal(t, time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC), expiresAt.UTC())
+		return err
+	}))
+	assert.NoError(t, store.Delete(ctx, "/app", "session"))
+
+	code, _ = do(h, http.MethodDelete, "/_hput/kv/key?path=/app&key=user:2", "", local, "")
+	assert.Equal(t, http.StatusNoContent, code)
+	code, _ = do(h, http.MethodGet, "/_hput/kv/key?path=/app&key=user:2", "", local, "")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	code, body = do(h, http.MethodDelete, "/_hput/kv/keys?path=/app", "", local, "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"deleted": 2}`, body)
+	v, _ = store.Get(ctx, "_shared/flags", "beta")
+	assert.Equal(t, []byte(`true`), v, "wiping one namespace leaves the others")
+
+	code, body = do(h, http.MethodGet, "/_hput/kv", "", local, "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"paths": ["_shared/flags"]}`, body)
+}
+
+// TestKVInvalid verifies requests without a namespace or key are rejected
+func TestKVInvalid(t *testing.T) {
+	tt := []struct {
+		name   string
+		method string
+		path   string
+		remote string
+		want   int
+	}{
+		{name: "list without path", method: http.MethodGet, path: "/_hput/kv/keys", want: http.StatusBadRequest},
+		{name: "wipe without path", method: http.MethodDelete, path: "/_hput/kv/keys", want: http.StatusBadRequest},
+		{name: "bad limit", method: http.MethodGet, path: "/_hput/kv/keys?path=/app&limit=-1", want: http.StatusBadRequest},
+		{name: "get without key", method: http.MethodGet, path: "/_hput/kv/key?path=/app", want: http.StatusBadRequest},
+		{name: "put without path", method: http.MethodPut, path: "/_hput/kv/key?key=k", want: http.StatusBadRequest},
+		{name: "bad expires", method: http.MethodPut, path: "/_hput/kv/key?path=/app&key=k&expires=tomorrow", want: http.StatusBadRequest},
+		{name: "delete without key", method: http.MethodDelete, path: "/_hput/kv/key?path=/app", want: http.StatusBadRequest},
+		{name: "remote caller", method: http.MethodGet, path: "/_hput/kv", remote: "192.0.2.1:1234", want: http.StatusUnauthorized},
+	}
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			h := New(&TestLogger{}, kv.NewMemory(), "")
+			remote := test.remote
+			if remote == "" {
+				remote = "127.0.0.1:1234"
+			}
+			code, _ := do(h, test.method, test.path, "", remote, "")
+			assert.Equal(t, test.want, code)
+		})
+	}
+}
+
+// TestKVUsage verifies usage is reported per namespace and writes over a quota are rejected
+func TestKVUsage(t *testing.T) {
+	ctx := context.Background()
+	store := kv.NewNotifier(kv.NewQuota(newTestStore(t), kv.Limits{MaxKeys: 2}))
+	h := New(&TestLogger{}, store, "")
+	local := "127.0.0.1:1234"
+	assert.NoError(t, store.Put(ctx, "/app", "a", []byte(`1`)))
+	assert.NoError(t, store.Put(ctx, "/app", "b", []byte(`22`)))
+	assert.NoError(t, store.Put(ctx, "/other", "c", []byte(`3`)))
+
+	code, body := do(h, http.MethodGet, "/_hput/kv/usage?path=/app", "", local, "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"keys": 2, "bytes": 5, "maxKeys": 2}`, body)
+
+	code, body = do(h, http.MethodGet, "/_hput/kv/usage", "", local, "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"paths": {"/app": {"keys": 2, "bytes": 5, "maxKeys": 2}, "/other": {"keys": 1, "bytes": 2, "maxKeys": 2}}}`, body)
+
+	code, body = do(h, http.MethodPut, "/_hput/kv/key?path=/app&key=c", `1`, local, "")
+	assert.Equal(t, http.StatusRequestEntityTooLarge, code)
+	assert.Contains(t, body, "quota exceeded")
+
+	code, _ = do(New(&TestLogger{}, newTestStore(t), ""), http.MethodGet, "/_hput/kv/usage", "", local, "")
+	assert.Equal(t, http.StatusNotImplemented, code)
+}
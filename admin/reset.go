@@ -0,0 +1,33 @@
+package admin
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// Resetter is what the reset route needs from the server's Saver when it
+// overlays saved paths on a base.
+type Resetter interface {
+	Delete(ctx context.Context, p url.URL) error
+}
+
+// reset drops what is saved at ?path=, so its base version is served
+// again. Resetting a path that was never saved succeeds.
+func (h *Handler) reset(w http.ResponseWriter, r *http.Request) {
+	if h.Reset == nil {
+		http.Error(w, "this server has no base to reset paths to, see -base-root", http.StatusNotImplemented)
+		return
+	}
+	path, ok := namespace(w, r)
+	if !ok {
+		return
+	}
+	if err := h.Reset.Delete(r.Context(), url.URL{Path: path}); err != nil {
+		h.Logger.Errorf("admin.reset(): %v", err)
+		http.Error(w, "could not reset path", http.StatusInternalServerError)
+		return
+	}
+	h.Logger.Debugf("admin.reset(): reset %s to its base version", path)
+	w.WriteHeader(http.StatusNoContent)
+}
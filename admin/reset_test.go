@@ -0,0 +1,37 @@
+package admin
+
+import (
+	"context"
+	"hput"
+	"hput/mapsaver"
+	"hput/overlaysaver"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestReset verifies a saved path can be reset to its base version, and 501 without a base
+func TestReset(t *testing.T) {
+	ctx := context.Background()
+	h := New(&TestLogger{}, newTestStore(t), "")
+	local := "127.0.0.1:1234"
+	code, _ := do(h, http.MethodPost, "/_hput/reset?path=/page", "", local, "")
+	assert.Equal(t, http.StatusNotImplemented, code)
+
+	base := mapsaver.New(&TestLogger{})
+	p := url.URL{Path: "/page"}
+	assert.NoError(t, base.SaveText(ctx, "base", p, &hput.PutResult{}))
+	o := overlaysaver.New(mapsaver.New(&TestLogger{}), base, &TestLogger{})
+	assert.NoError(t, o.SaveText(ctx, "saved", p, &hput.PutResult{}))
+	h.Reset = o
+
+	code, _ = do(h, http.MethodPost, "/_hput/reset", "", local, "")
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = do(h, http.MethodPost, "/_hput/reset?path=/page", "", local, "")
+	assert.Equal(t, http.StatusNoContent, code)
+	r, err := o.GetRunnable(ctx, p)
+	assert.NoError(t, err)
+	assert.Equal(t, "base", r.Text)
+}
@@ -0,0 +1,81 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"hput/kv"
+	"net/http"
+)
+
+// listShared responds with the names of every shared namespace that has grants.
+func (h *Handler) listShared(w http.ResponseWriter, r *http.Request) {
+	names, err := kv.ListShared(r.Context(), h.KV)
+	if err != nil {
+		h.Logger.Errorf("admin.listShared(): %v", err)
+		http.Error(w, "could not list shared namespaces", http.StatusInternalServerError)
+		return
+	}
+	if names == nil {
+		names = []string{}
+	}
+	h.writeJSON(w, map[string][]string{"names": names})
+}
+
+// getGrants responds with the grants of one shared namespace.
+func (h *Handler) getGrants(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	g, ok, err := kv.GetGrants(r.Context(), h.KV, name)
+	if errors.Is(err, kv.ErrInvalidSharedName) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		h.Logger.Errorf("admin.getGrants(): %v", err)
+		http.Error(w, "could not read grants", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "no grants for shared namespace "+name, http.StatusNotFound)
+		return
+	}
+	h.writeJSON(w, g)
+}
+
+// putGrants replaces the grants of one shared namespace with the JSON body,
+// e.g. {"read": ["/"], "write": ["/admin"]}.
+func (h *Handler) putGrants(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	var g kv.Grants
+	if err := json.NewDecoder(r.Body).Decode(&g); err != nil {
+		http.Error(w, "body must be JSON like {\"read\": [\"/path\"], \"write\": [\"/path\"]}", http.StatusBadRequest)
+		return
+	}
+	err := kv.PutGrants(r.Context(), h.KV, name, g)
+	if errors.Is(err, kv.ErrInvalidSharedName) || errors.Is(err, kv.ErrInvalidGrant) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		h.Logger.Errorf("admin.putGrants(): %v", err)
+		http.Error(w, "could not save grants", http.StatusInternalServerError)
+		return
+	}
+	h.Logger.Debugf("admin.putGrants(): granted %q: %+v", name, g)
+	h.writeJSON(w, g)
+}
+
+// deleteGrants revokes every grant on one shared namespace, keeping its data.
+func (h *Handler) deleteGrants(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	err := kv.DeleteGrants(r.Context(), h.KV, name)
+	if errors.Is(err, kv.ErrInvalidSharedName) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		h.Logger.Errorf("admin.deleteGrants(): %v", err)
+		http.Error(w, "could not delete grants", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
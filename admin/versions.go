@@ -0,0 +1,181 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hput"
+	"net/http"
+	"strconv"
+	"unicode/utf8"
+)
+
+// Versioner is what the version routes need from the server's Saver.
+type Versioner interface {
+	Versions(ctx context.Context, path string) ([]hput.Version, error)
+	GetVersion(ctx context.Context, path string, id int) (hput.Runnable, error)
+	// Rollback saves version id of path again, within the storage, so a
+	// large binary is not read through the server. It reports false if
+	// that version is not kept.
+	Rollback(ctx context.Context, path string, id int) (bool, error)
+}
+
+// versioner returns h.Versions, or responds with 501 if it is not set.
+func (h *Handler) versioner(w http.ResponseWriter) (Versioner, bool) {
+	if h.Versions == nil {
+		http.Error(w, "this server's storage does not keep versions", http.StatusNotImplemented)
+		return nil, false
+	}
+	return h.Versions, true
+}
+
+// listVersions responds with {"versions": [...]}, the kept versions of
+// ?path= newest first.
+func (h *Handler) listVersions(w http.ResponseWriter, r *http.Request) {
+	v, ok := h.versioner(w)
+	if !ok {
+		return
+	}
+	path, ok := namespace(w, r)
+	if !ok {
+		return
+	}
+	versions, err := v.Versions(r.Context(), path)
+	if err != nil {
+		h.Logger.Errorf("admin.listVersions(): %v", err)
+		http.Error(w, "could not list versions", http.StatusInternalServerError)
+		return
+	}
+	if versions == nil {
+		versions = []hput.Version{}
+	}
+	h.writeJSON(w, map[string][]hput.Version{"versions": versions})
+}
+
+// version returns the version of ?path= named by the query parameter or
+// path value called name, or responds with 400 or 404.
+func (h *Handler) version(w http.ResponseWriter, r *http.Request, v Versioner, path, name, id string) (hput.Runnable, bool) {
+	n, ok := versionID(w, name, id)
+	if !ok {
+		return hput.Runnable{}, false
+	}
+	ru, err := v.GetVersion(r.Context(), path, n)
+	if err != nil {
+		h.Logger.Errorf("admin.version(): %v", err)
+		http.Error(w, "could not read version", http.StatusInternalServerError)
+		return hput.Runnable{}, false
+	}
+	if ru.Type == "" {
+		http.Error(w, fmt.Sprintf("no version %d of %s", n, path), http.StatusNotFound)
+		return hput.Runnable{}, false
+	}
+	return ru, true
+}
+
+// versionID parses id, the query parameter or path value called name, or
+// responds with 400.
+func versionID(w http.ResponseWriter, name, id string) (int, bool) {
+	n, err := strconv.Atoi(id)
+	if err != nil || n < 1 {
+		http.Error(w, name+" must be a version id", http.StatusBadRequest)
+		return 0, false
+	}
+	return n, true
+}
+
+// getVersion responds with the content of one version, as it was saved.
+func (h *Handler) getVersion(w http.ResponseWriter, r *http.Request) {
+	v, ok := h.versioner(w)
+	if !ok {
+		return
+	}
+	path, ok := namespace(w, r)
+	if !ok {
+		return
+	}
+	ru, ok := h.version(w, r, v, path, "id", r.PathValue("id"))
+	if !ok {
+		return
+	}
+	switch ru.Type {
+	case hput.Binary:
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(ru.Binary)
+	case hput.Js:
+		w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+		w.Write([]byte(ru.Text))
+	default:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(ru.Text))
+	}
+}
+
+// diffVersions responds with a unified diff from version ?from= to version
+// ?to= of a text or code path.
+func (h *Handler) diffVersions(w http.ResponseWriter, r *http.Request) {
+	v, ok := h.versioner(w)
+	if !ok {
+		return
+	}
+	path, ok := namespace(w, r)
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+	from, ok := h.version(w, r, v, path, "from", q.Get("from"))
+	if !ok {
+		return
+	}
+	to, ok := h.version(w, r, v, path, "to", q.Get("to"))
+	if !ok {
+		return
+	}
+	for _, ru := range []hput.Runnable{from, to} {
+		if ru.Type == hput.Binary || !utf8.ValidString(ru.Text) {
+			http.Error(w, "only text and code versions can be diffed", http.StatusBadRequest)
+			return
+		}
+	}
+	d, err := diff(path+"@"+q.Get("from"), path+"@"+q.Get("to"), from.Text, to.Text)
+	if errors.Is(err, errTooLarge) {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	w.Header().Set("Content-Type", "text/x-diff; charset=utf-8")
+	w.Write([]byte(d))
+}
+
+// rollback saves one version again, so it becomes what the path serves and
+// its newest version, and responds with that new version.
+func (h *Handler) rollback(w http.ResponseWriter, r *http.Request) {
+	v, ok := h.versioner(w)
+	if !ok {
+		return
+	}
+	path, ok := namespace(w, r)
+	if !ok {
+		return
+	}
+	id, ok := versionID(w, "id", r.PathValue("id"))
+	if !ok {
+		return
+	}
+	found, err := v.Rollback(r.Context(), path, id)
+	if err != nil {
+		h.Logger.Errorf("admin.rollback(): %v", err)
+		http.Error(w, "could not save version", http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, fmt.Sprintf("no version %d of %s", id, path), http.StatusNotFound)
+		return
+	}
+	versions, err := v.Versions(r.Context(), path)
+	if err != nil || len(versions) == 0 {
+		h.Logger.Errorf("admin.rollback(): rolled back but could not list versions: %v", err)
+		http.Error(w, "rolled back, but could not list versions", http.StatusInternalServerError)
+		return
+	}
+	h.Logger.Debugf("admin.rollback(): rolled %s back to version %d as version %d", path, id, versions[0].ID)
+	h.writeJSON(w, versions[0])
+}
@@ -0,0 +1,119 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"hput"
+	"hput/mapsaver"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestVersions verifies versions can be listed, read, diffed and rolled back
+func TestVersions(t *testing.T) {
+	ctx := context.Background()
+	saver := mapsaver.New(&TestLogger{})
+	saver.Retention = hput.Retention{Count: 10}
+	h := New(&TestLogger{}, newTestStore(t), "")
+	h.Versions = saver
+	local := "127.0.0.1:1234"
+	p := url.URL{Path: "/admin-versions"}
+	assert.NoError(t, saver.SaveCode(ctx, "a\nb\nc\n", p, &hput.PutResult{}))
+	assert.NoError(t, saver.SaveCode(ctx, "a\nB\nc\n", p, &hput.PutResult{}))
+	assert.NoError(t, saver.SaveBinary(ctx, []byte{255}, p, &hput.PutResult{}))
+
+	code, body := do(h, http.MethodGet, "/_hput/versions?path=/admin-versions", "", local, "")
+	assert.Equal(t, http.StatusOK, code)
+	var list struct{ Versions []hput.Version }
+	assert.NoError(t, json.Unmarshal([]byte(body), &list))
+	assert.Len(t, list.Versions, 3)
+	assert.Equal(t, 3, list.Versions[0].ID)
+	assert.Equal(t, hput.Input(hput.Binary), list.Versions[0].Type)
+
+	code, body = do(h, http.MethodGet, "/_hput/versions/1?path=/admin-versions", "", local, "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "a\nb\nc\n", body)
+
+	code, body = do(h, http.MethodGet, "/_hput/versions/diff?path=/admin-versions&from=1&to=2", "", local, "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "--- /admin-versions@1\n+++ /admin-versions@2\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n", body)
+
+	code, _ = do(h, http.MethodGet, "/_hput/versions/diff?path=/admin-versions&from=1&to=3", "", local, "")
+	assert.Equal(t, http.StatusBadRequest, code, "binary versions cannot be diffed")
+
+	code, body = do(h, http.MethodPost, "/_hput/versions/2/rollback?path=/admin-versions", "", local, "")
+	assert.Equal(t, http.StatusOK, code)
+	var v hput.Version
+	assert.NoError(t, json.Unmarshal([]byte(body), &v))
+	assert.Equal(t, 4, v.ID)
+	r, err := saver.GetRunnable(ctx, p)
+	assert.NoError(t, err)
+	assert.Equal(t, hput.Runnable{Path: "/admin-versions", Type: hput.Js, Text: "a\nB\nc\n"}, r)
+}
+
+// TestVersionsInvalid verifies bad version requests are rejected
+func TestVersionsInvalid(t *testing.T) {
+	ctx := context.Background()
+	saver := mapsaver.New(&TestLogger{})
+	assert.NoError(t, saver.SaveText(ctx, "x", url.URL{Path: "/admin-invalid"}, &hput.PutResult{}))
+	tt := []struct {
+		name     string
+		method   string
+		path     string
+		versions Versioner
+		want     int
+	}{
+		{name: "no versions kept", method: http.MethodGet, path: "/_hput/versions?path=/a", want: http.StatusNotImplemented},
+		{name: "list without path", method: http.MethodGet, path: "/_hput/versions", versions: saver, want: http.StatusBadRequest},
+		{name: "bad id", method: http.MethodGet, path: "/_hput/versions/x?path=/admin-invalid", versions: saver, want: http.StatusBadRequest},
+		{name: "missing version", method: http.MethodGet, path: "/_hput/versions/9?path=/admin-invalid", versions: saver, want: http.StatusNotFound},
+		{name: "diff without to", method: http.MethodGet, path: "/_hput/versions/diff?path=/admin-invalid&from=1", versions: saver, want: http.StatusBadRequest},
+		{name: "roll back missing version", method: http.MethodPost, path: "/_hput/versions/9/rollback?path=/admin-invalid", versions: saver, want: http.StatusNotFound},
+	}
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			h := New(&TestLogger{}, newTestStore(t), "")
+			if test.versions != nil {
+				h.Versions = test.versions
+			}
+			code, _ := do(h, test.method, test.path, "", "127.0.0.1:1234", "")
+			assert.Equal(t, test.want, code)
+		})
+	}
+}
+
+// TestDiff verifies the unified diff of two texts
+func TestDiff(t *testing.T) {
+	tt := []struct {
+		name string
+		a, b string
+		want string
+	}{
+		{name: "same", a: "a\nb\n", b: "a\nb\n", want: ""},
+		{name: "from empty", a: "", b: "a\n", want: "--- a\n+++ b\n@@ -0,0 +1,1 @@\n+a\n"},
+		{name: "to empty", a: "a\n", b: "", want: "--- a\n+++ b\n@@ -1,1 +0,0 @@\n-a\n"},
+		{name: "no final newline", a: "a\n", b: "a", want: "--- a\n+++ b\n@@ -1,1 +1,1 @@\n-a\n+a\n\\ No newline at end of file\n"},
+		{
+			name: "context only near changes",
+			a:    "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
+			b:    "one\n2\n3\n4\n5\n6\n7\n8\n9\nten\n",
+			want: "--- a\n+++ b\n@@ -1,4 +1,4 @@\n-1\n+one\n 2\n 3\n 4\n@@ -7,4 +7,4 @@\n 7\n 8\n 9\n-10\n+ten\n",
+		},
+		{
+			name: "nearby changes share a hunk",
+			a:    "1\n2\n3\n4\n5\n",
+			b:    "one\n2\n3\n4\nfive\n",
+			want: "--- a\n+++ b\n@@ -1,5 +1,5 @@\n-1\n+one\n 2\n 3\n 4\n-5\n+five\n",
+		},
+	}
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			d, err := diff("a", "b", test.a, test.b)
+			assert.NoError(t, err)
+			assert.Equal(t, test.want, d)
+		})
+	}
+}
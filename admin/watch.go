@@ -0,0 +1,67 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"hput/kv"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// keepAliveInterval is how often an idle watch stream sends a comment, so
+// proxies don't close it.
+const keepAliveInterval = 15 * time.Second
+
+// kvRoute serves GET /_hput/kv/<path>/watch, where <path> is the namespace
+// without its leading slash; /_hput/kv/watch watches "/".
+func (h *Handler) kvRoute(w http.ResponseWriter, r *http.Request) {
+	rest := r.PathValue("path")
+	if rest != "watch" && !strings.HasSuffix(rest, "/watch") {
+		http.NotFound(w, r)
+		return
+	}
+	h.watch(w, r, "/"+strings.TrimSuffix(strings.TrimSuffix(rest, "watch"), "/"))
+}
+
+// watch streams changes to path's keys as server-sent events until the
+// caller disconnects. Each event is named after its type, put or delete,
+// and its data is the change as JSON. ?prefix= limits it to matching keys.
+func (h *Handler) watch(w http.ResponseWriter, r *http.Request, path string) {
+	watcher, ok := kv.Find[kv.Watcher](h.KV)
+	if !ok {
+		http.Error(w, "this server's KV store cannot be watched", http.StatusNotImplemented)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	events := watcher.Watch(r.Context(), path, r.URL.Query().Get("prefix"))
+	h.Logger.Debugf("admin.watch(): streaming changes to %s", path)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(e)
+			if err != nil {
+				h.Logger.Errorf("admin.watch(): could not encode event: %v", err)
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Op, data)
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,68 @@
+package admin
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"hput/kv"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestWatch verifies that changes to a namespace are streamed as server-sent events
+func TestWatch(t *testing.T) {
+	ctx := context.Background()
+	store := kv.NewNotifier(newTestStore(t))
+	srv := httptest.NewServer(New(&TestLogger{}, store, ""))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/_hput/kv/app/sub/watch?prefix=user:")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	assert.NoError(t, store.Put(ctx, "/app", "user:1", []byte(`1`)))
+	assert.NoError(t, store.Put(ctx, "/app/sub", "post:1", []byte(`1`)))
+	assert.NoError(t, store.Put(ctx, "/app/sub", "user:1", []byte(`{"a":1}`)))
+	assert.NoError(t, store.Delete(ctx, "/app/sub", "user:1"))
+
+	lines := bufio.NewScanner(resp.Body)
+	var got []string
+	for len(got) < 6 && lines.Scan() {
+		got = append(got, lines.Text())
+	}
+	assert.Equal(t, []string{
+		"event: put",
+		`data: {"path":"/app/sub","type":"put","key":"user:1","value":{"a":1}}`,
+		"",
+		"event: delete",
+		`data: {"path":"/app/sub","type":"delete","key":"user:1","value":null}`,
+		"",
+	}, got)
+}
+
+// TestWatchRoutes verifies which kv routes exist and that unwatchable stores are reported
+func TestWatchRoutes(t *testing.T) {
+	tt := []struct {
+		name  string
+		store kv.KV
+		path  string
+		want  int
+	}{
+		{name: "not a watch", store: kv.NewNotifier(kv.NewMemory()), path: "/_hput/kv/app", want: http.StatusNotFound},
+		{name: "watch is a suffix", store: kv.NewNotifier(kv.NewMemory()), path: "/_hput/kv/appwatch", want: http.StatusNotFound},
+		{name: "store cannot be watched", store: kv.NewMemory(), path: "/_hput/kv/app/watch", want: http.StatusNotImplemented},
+	}
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			defer test.store.Close()
+			code, body := do(New(&TestLogger{}, test.store, ""), http.MethodGet, test.path, "", "127.0.0.1:1234", "")
+			assert.Equal(t, test.want, code, strings.TrimSpace(body))
+		})
+	}
+}
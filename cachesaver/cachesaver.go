@@ -0,0 +1,357 @@
+// Package cachesaver keeps recently read paths of another Saver in memory,
+// so serving them again does not go back to its storage.
+package cachesaver
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hput"
+	"io"
+	"iter"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Logger logs out.
+type Logger interface {
+	Debugf(msg string, args ...interface{})
+}
+
+// Saver is what Cache wraps.
+type Saver interface {
+	SaveText(ctx context.Context, s string, p url.URL, r *hput.PutResult) error
+	SaveCode(ctx context.Context, s string, p url.URL, r *hput.PutResult) error
+	SaveBinary(ctx context.Context, b []byte, p url.URL, r *hput.PutResult) error
+	GetRunnable(ctx context.Context, p url.URL) (hput.Runnable, error)
+	List(ctx context.Context, prefix, cursor string, limit int) iter.Seq2[hput.Entry, error]
+}
+
+// streamSaver is a Saver that can store and serve binaries without holding
+// them in memory.
+type streamSaver interface {
+	SaveStream(ctx context.Context, b io.Reader, p url.URL, r *hput.PutResult) error
+	GetStream(ctx context.Context, p url.URL) (io.ReadSeekCloser, error)
+}
+
+// versioner is a Saver that keeps versions.
+type versioner interface {
+	Versions(ctx context.Context, path string) ([]hput.Version, error)
+	GetVersion(ctx context.Context, path string, id int) (hput.Runnable, error)
+	Rollback(ctx context.Context, path string, id int) (bool, error)
+}
+
+// deleter is a Saver that can remove what is saved at a path.
+type deleter interface {
+	Delete(ctx context.Context, p url.URL) error
+}
+
+// binaryInfo is what a stream from GetStream may also tell about its binary.
+type binaryInfo interface {
+	ETag() string
+	ModTime() time.Time
+}
+
+var (
+	// errNoVersions is returned for versions when the wrapped Saver keeps none.
+	errNoVersions = errors.New("storage does not keep versions")
+	// errNoDelete is returned by Delete when the wrapped Saver cannot delete.
+	errNoDelete = errors.New("storage cannot delete paths")
+)
+
+// Stats counts how well the cache is doing.
+type Stats struct {
+	Hits     int64 `json:"hits"`
+	Misses   int64 `json:"misses"`
+	Entries  int   `json:"entries"`
+	Bytes    int64 `json:"bytes"`
+	MaxBytes int64 `json:"maxBytes"`
+}
+
+// Cache is a Saver that serves reads from memory, holding the most
+// recently used paths up to MaxBytes. An entry is dropped when its path is
+// saved through the Cache, or once it is older than TTL, which bounds how
+// long saves made around it, such as by another server sharing a bucket,
+// go unseen. Paths with nothing saved are cached too. Listings are not.
+type Cache struct {
+	Saver  Saver
+	Logger Logger
+
+	maxBytes int64
+	ttl      time.Duration // 0 means entries only leave when evicted or saved over
+	now      func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // of *entry, most recently used first
+	bytes   int64
+	gen     uint64 // counts saves, so reads that raced one are not cached
+	hits    int64
+	misses  int64
+}
+
+// entry is one cached path.
+type entry struct {
+	path    string
+	r       hput.Runnable
+	etag    string
+	modTime time.Time
+	expires time.Time
+	size    int64
+}
+
+// New wraps s with a cache holding up to maxBytes of paths for ttl each.
+func New(s Saver, l Logger, maxBytes int64, ttl time.Duration) *Cache {
+	return &Cache{
+		Saver:    s,
+		Logger:   l,
+		maxBytes: maxBytes,
+		ttl:      ttl,
+		now:      time.Now,
+		entries:  map[string]*list.Element{},
+		lru:      list.New(),
+	}
+}
+
+// Stats returns the hits and misses so far, and what is cached now.
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return Stats{Hits: c.hits, Misses: c.misses, Entries: c.lru.Len(), Bytes: c.bytes, MaxBytes: c.maxBytes}
+}
+
+// get returns the entry for path, counting a hit or a miss. On a miss it
+// also returns the generation to pass to add.
+func (c *Cache) get(path string) (*entry, uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[path]; ok {
+		e := el.Value.(*entry)
+		if c.ttl <= 0 || c.now().Before(e.expires) {
+			c.lru.MoveToFront(el)
+			c.hits++
+			return e, 0
+		}
+		c.remove(el)
+	}
+	c.misses++
+	return nil, c.gen
+}
+
+// add caches e, read at generation gen, evicting the least recently used
+// entries to make room. It is not cached if a save has happened since gen.
+func (c *Cache) add(e *entry, gen uint64) {
+	e.size = int64(len(e.path) + len(e.r.Text) + len(e.r.Binary))
+	if e.size > c.maxBytes {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if gen != c.gen {
+		return
+	}
+	if el, ok := c.entries[e.path]; ok {
+		c.remove(el)
+	}
+	e.expires = c.now().Add(c.ttl)
+	c.entries[e.path] = c.lru.PushFront(e)
+	c.bytes += e.size
+	for c.bytes > c.maxBytes {
+		evicted := c.lru.Back().Value.(*entry)
+		c.Logger.Debugf("evicting %s from the cache", evicted.path)
+		c.remove(c.lru.Back())
+	}
+}
+
+// remove drops el. c.mu must be held.
+func (c *Cache) remove(el *list.Element) {
+	e := c.lru.Remove(el).(*entry)
+	delete(c.entries, e.path)
+	c.bytes -= e.size
+}
+
+// invalidate drops path, and stops reads started before now from caching
+// what they read.
+func (c *Cache) invalidate(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	if el, ok := c.entries[path]; ok {
+		c.remove(el)
+	}
+}
+
+// SaveText saves through the wrapped Saver, then drops the path from the cache
+func (c *Cache) SaveText(ctx context.Context, s string, p url.URL, r *hput.PutResult) error {
+	defer c.invalidate(p.Path)
+	return c.Saver.SaveText(ctx, s, p, r)
+}
+
+// SaveCode saves through the wrapped Saver, then drops the path from the cache
+func (c *Cache) SaveCode(ctx context.Context, s string, p url.URL, r *hput.PutResult) error {
+	defer c.invalidate(p.Path)
+	return c.Saver.SaveCode(ctx, s, p, r)
+}
+
+// SaveBinary saves through the wrapped Saver, then drops the path from the cache
+func (c *Cache) SaveBinary(ctx context.Context, b []byte, p url.URL, r *hput.PutResult) error {
+	defer c.invalidate(p.Path)
+	return c.Saver.SaveBinary(ctx, b, p, r)
+}
+
+// SaveStream streams through the wrapped Saver if it can, otherwise reads
+// the binary and saves it, then drops the path from the cache.
+func (c *Cache) SaveStream(ctx context.Context, b io.Reader, p url.URL, r *hput.PutResult) error {
+	defer c.invalidate(p.Path)
+	if ss, ok := c.Saver.(streamSaver); ok {
+		return ss.SaveStream(ctx, b, p, r)
+	}
+	bts, err := io.ReadAll(b)
+	if err != nil {
+		return err
+	}
+	return c.Saver.SaveBinary(ctx, bts, p, r)
+}
+
+// Delete deletes through the wrapped Saver, then drops the path from the cache
+func (c *Cache) Delete(ctx context.Context, p url.URL) error {
+	d, ok := c.Saver.(deleter)
+	if !ok {
+		return errNoDelete
+	}
+	defer c.invalidate(p.Path)
+	return d.Delete(ctx, p)
+}
+
+// GetRunnable returns the runnable at a path from the cache, reading it
+// from the wrapped Saver on a miss. The runnable must not be modified.
+func (c *Cache) GetRunnable(ctx context.Context, p url.URL) (hput.Runnable, error) {
+	e, gen := c.get(p.Path)
+	if e == nil {
+		var err error
+		if e, err = c.read(ctx, p, gen); err != nil {
+			return hput.Runnable{}, err
+		}
+	}
+	return e.r, nil
+}
+
+// read reads the runnable at p from the wrapped Saver into the cache and
+// returns its entry.
+func (c *Cache) read(ctx context.Context, p url.URL, gen uint64) (*entry, error) {
+	r, err := c.Saver.GetRunnable(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	e := &entry{path: p.Path, r: r}
+	if r.Type == hput.Binary {
+		sum := sha256.Sum256(r.Binary)
+		e.etag = fmt.Sprintf(`"%x"`, sum[:16])
+	}
+	c.add(e, gen)
+	return e, nil
+}
+
+// GetStream returns the binary at a path, or nil if the path does not hold
+// one. A binary that fits in the cache is read whole from the wrapped
+// Saver on a miss and served from memory after; a larger one is streamed.
+func (c *Cache) GetStream(ctx context.Context, p url.URL) (io.ReadSeekCloser, error) {
+	e, gen := c.get(p.Path)
+	if e == nil {
+		var rs io.ReadSeekCloser
+		var err error
+		if ss, ok := c.Saver.(streamSaver); ok {
+			e, rs, err = c.fill(ctx, ss, p, gen)
+		} else {
+			e, err = c.read(ctx, p, gen)
+		}
+		if e == nil {
+			return rs, err
+		}
+	}
+	if e.r.Type != hput.Binary {
+		return nil, nil
+	}
+	return &stream{Reader: bytes.NewReader(e.r.Binary), etag: e.etag, modTime: e.modTime}, nil
+}
+
+// fill reads the binary at p from ss into the cache and returns its entry.
+// A binary too large to cache is returned as a stream instead. If p does
+// not hold a binary, the entry is what it holds, for the GetRunnable that
+// follows.
+func (c *Cache) fill(ctx context.Context, ss streamSaver, p url.URL, gen uint64) (*entry, io.ReadSeekCloser, error) {
+	rs, err := ss.GetStream(ctx, p)
+	if err != nil {
+		return nil, nil, err
+	}
+	if rs == nil {
+		e, err := c.read(ctx, p, gen)
+		return e, nil, err
+	}
+	size, err := rs.Seek(0, io.SeekEnd)
+	if err == nil {
+		_, err = rs.Seek(0, io.SeekStart)
+	}
+	if err != nil || size > c.maxBytes {
+		return nil, rs, err
+	}
+	defer rs.Close()
+	b, err := io.ReadAll(rs)
+	if err != nil {
+		return nil, nil, err
+	}
+	e := &entry{path: p.Path, r: hput.Runnable{Path: p.Path, Type: hput.Binary, Binary: b}}
+	if info, ok := rs.(binaryInfo); ok {
+		e.etag, e.modTime = info.ETag(), info.ModTime()
+	}
+	c.add(e, gen)
+	return e, nil, nil
+}
+
+// stream serves a cached binary.
+type stream struct {
+	*bytes.Reader
+	etag    string
+	modTime time.Time
+}
+
+func (s *stream) Close() error       { return nil }
+func (s *stream) ETag() string       { return s.etag }
+func (s *stream) ModTime() time.Time { return s.modTime }
+
+// List lists through the wrapped Saver; listings are not cached
+func (c *Cache) List(ctx context.Context, prefix, cursor string, limit int) iter.Seq2[hput.Entry, error] {
+	return c.Saver.List(ctx, prefix, cursor, limit)
+}
+
+// Versions lists the kept versions of a path from the wrapped Saver
+func (c *Cache) Versions(ctx context.Context, path string) ([]hput.Version, error) {
+	v, ok := c.Saver.(versioner)
+	if !ok {
+		return nil, errNoVersions
+	}
+	return v.Versions(ctx, path)
+}
+
+// GetVersion returns one version of a path from the wrapped Saver
+func (c *Cache) GetVersion(ctx context.Context, path string, id int) (hput.Runnable, error) {
+	v, ok := c.Saver.(versioner)
+	if !ok {
+		return hput.Runnable{}, errNoVersions
+	}
+	return v.GetVersion(ctx, path, id)
+}
+
+// Rollback rolls a path back in the wrapped Saver, then drops it from the cache
+func (c *Cache) Rollback(ctx context.Context, path string, id int) (bool, error) {
+	v, ok := c.Saver.(versioner)
+	if !ok {
+		return false, errNoVersions
+	}
+	defer c.invalidate(path)
+	return v.Rollback(ctx, path, id)
+}
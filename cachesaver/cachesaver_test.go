@@ -0,0 +1,216 @@
+package cachesaver
+
+import (
+	"bytes"
+	"context"
+	"hput"
+	"hput/dirsaver"
+	"hput/mapsaver"
+	"io"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type TestLogger struct{}
+
+func (t *TestLogger) Debug(msg string) {}
+
+func (t *TestLogger) Debugf(msg string, args ...interface{}) {}
+
+func (t *TestLogger) Errorf(msg string, args ...interface{}) {}
+
+// countingSaver counts reads of the saver it wraps, and runs during before
+// each one returns.
+type countingSaver struct {
+	*mapsaver.MapSaver
+	gets   int
+	during func()
+}
+
+func (s *countingSaver) GetRunnable(ctx context.Context, p url.URL) (hput.Runnable, error) {
+	s.gets++
+	r, err := s.MapSaver.GetRunnable(ctx, p)
+	if s.during != nil {
+		s.during()
+	}
+	return r, err
+}
+
+// texts returns the text saved at p.
+func (s *countingSaver) texts(p string) string {
+	r, _ := s.MapSaver.GetRunnable(context.Background(), url.URL{Path: p})
+	return r.Text
+}
+
+// TestGetRunnable verifies that reads are served from the cache until the
+// path is saved, expires or is evicted
+func TestGetRunnable(t *testing.T) {
+	ctx := context.Background()
+	tt := []struct {
+		name     string
+		maxBytes int64
+		change   func(c *Cache, clock *time.Time)
+		gets     int
+	}{
+		{name: "cached", maxBytes: 100, change: func(c *Cache, clock *time.Time) {}, gets: 1},
+		{name: "saved over", maxBytes: 100, change: func(c *Cache, clock *time.Time) {
+			assert.NoError(t, c.SaveText(ctx, "new", url.URL{Path: "/a"}, &hput.PutResult{}))
+		}, gets: 2},
+		{name: "expired", maxBytes: 100, change: func(c *Cache, clock *time.Time) {
+			*clock = clock.Add(time.Minute)
+		}, gets: 2},
+		{name: "evicted", maxBytes: 9, change: func(c *Cache, clock *time.Time) {
+			_, err := c.GetRunnable(ctx, url.URL{Path: "/b"})
+			assert.NoError(t, err)
+		}, gets: 3},
+		{name: "too large to cache", maxBytes: 4, change: func(c *Cache, clock *time.Time) {}, gets: 2},
+	}
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			inner := &countingSaver{MapSaver: mapsaver.New(&TestLogger{})}
+			assert.NoError(t, inner.SaveText(ctx, "old", url.URL{Path: "/a"}, &hput.PutResult{}))
+			assert.NoError(t, inner.SaveText(ctx, "bbb", url.URL{Path: "/b"}, &hput.PutResult{}))
+			c := New(inner, &TestLogger{}, test.maxBytes, time.Minute)
+			clock := time.Now()
+			c.now = func() time.Time { return clock }
+
+			r, err := c.GetRunnable(ctx, url.URL{Path: "/a"})
+			assert.NoError(t, err)
+			assert.Equal(t, "old", r.Text)
+			test.change(c, &clock)
+			want := inner.texts("/a")
+			r, err = c.GetRunnable(ctx, url.URL{Path: "/a"})
+			assert.NoError(t, err)
+			assert.Equal(t, want, r.Text)
+			assert.Equal(t, test.gets, inner.gets)
+			stats := c.Stats()
+			assert.Equal(t, int64(inner.gets), stats.Misses)
+			assert.LessOrEqual(t, stats.Bytes, test.maxBytes)
+		})
+	}
+}
+
+// TestNothingSaved verifies that paths with nothing saved are cached too
+func TestNothingSaved(t *testing.T) {
+	ctx := context.Background()
+	inner := &countingSaver{MapSaver: mapsaver.New(&TestLogger{})}
+	c := New(inner, &TestLogger{}, 100, 0)
+	for range 3 {
+		r, err := c.GetRunnable(ctx, url.URL{Path: "/missing"})
+		assert.NoError(t, err)
+		assert.Equal(t, hput.Runnable{}, r)
+	}
+	assert.Equal(t, 1, inner.gets)
+	assert.Equal(t, Stats{Hits: 2, Misses: 1, Entries: 1, Bytes: int64(len("/missing")), MaxBytes: 100}, c.Stats())
+}
+
+// TestSaveDuringRead verifies that a read racing a save to its path does
+// not cache what the path held before
+func TestSaveDuringRead(t *testing.T) {
+	ctx := context.Background()
+	inner := &countingSaver{MapSaver: mapsaver.New(&TestLogger{})}
+	assert.NoError(t, inner.SaveText(ctx, "old", url.URL{Path: "/a"}, &hput.PutResult{}))
+	c := New(inner, &TestLogger{}, 100, 0)
+	inner.during = func() {
+		inner.during = nil
+		assert.NoError(t, c.SaveText(ctx, "new", url.URL{Path: "/a"}, &hput.PutResult{}))
+	}
+	r, err := c.GetRunnable(ctx, url.URL{Path: "/a"})
+	assert.NoError(t, err)
+	assert.Equal(t, "old", r.Text)
+	r, err = c.GetRunnable(ctx, url.URL{Path: "/a"})
+	assert.NoError(t, err)
+	assert.Equal(t, "new", r.Text)
+}
+
+// TestGetStream verifies that binaries that fit are served from memory
+// with the wrapped Saver's ETag, and larger ones are streamed
+func TestGetStream(t *testing.T) {
+	ctx := context.Background()
+	tt := []struct {
+		name   string
+		size   int
+		cached bool
+	}{
+		{name: "fits", size: 50, cached: true},
+		{name: "too large", size: 500},
+	}
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			inner, err := dirsaver.New(&TestLogger{}, t.TempDir())
+			assert.NoError(t, err)
+			c := New(inner, &TestLogger{}, 100, 0)
+			p := url.URL{Path: "/img"}
+			want := bytes.Repeat([]byte{0xff}, test.size)
+			assert.NoError(t, c.SaveStream(ctx, bytes.NewReader(want), p, &hput.PutResult{}))
+			direct, err := inner.GetStream(ctx, p)
+			assert.NoError(t, err)
+			defer direct.Close()
+
+			for range 2 {
+				rs, err := c.GetStream(ctx, p)
+				assert.NoError(t, err)
+				got, err := io.ReadAll(rs)
+				assert.NoError(t, err)
+				assert.Equal(t, want, got)
+				assert.Equal(t, direct.(binaryInfo).ETag(), rs.(binaryInfo).ETag())
+				rs.Close()
+			}
+			assert.Equal(t, test.cached, c.Stats().Hits == 1)
+
+			assert.NoError(t, c.SaveText(ctx, "text", p, &hput.PutResult{}))
+			hits := c.Stats().Hits
+			rs, err := c.GetStream(ctx, p)
+			assert.NoError(t, err)
+			assert.Nil(t, rs, "a path saved over with text is not a binary")
+			r, err := c.GetRunnable(ctx, p)
+			assert.NoError(t, err)
+			assert.Equal(t, "text", r.Text)
+			assert.Equal(t, hits+1, c.Stats().Hits, "what GetStream read is cached for GetRunnable")
+		})
+	}
+}
+
+// TestDelete verifies that deleting a path drops it from the cache
+func TestDelete(t *testing.T) {
+	ctx := context.Background()
+	inner := &countingSaver{MapSaver: mapsaver.New(&TestLogger{})}
+	c := New(inner, &TestLogger{}, 100, 0)
+	p := url.URL{Path: "/a"}
+	assert.NoError(t, c.SaveText(ctx, "text", p, &hput.PutResult{}))
+	_, err := c.GetRunnable(ctx, p)
+	assert.NoError(t, err)
+	assert.NoError(t, c.Delete(ctx, p))
+	r, err := c.GetRunnable(ctx, p)
+	assert.NoError(t, err)
+	assert.Equal(t, hput.Runnable{}, r)
+
+	noDelete := New(struct{ Saver }{inner}, &TestLogger{}, 100, 0)
+	assert.ErrorIs(t, noDelete.Delete(ctx, p), errNoDelete)
+}
+
+// TestRollback verifies that rolling a path back drops it from the cache
+func TestRollback(t *testing.T) {
+	ctx := context.Background()
+	inner := &countingSaver{MapSaver: mapsaver.New(&TestLogger{})}
+	inner.Retention = hput.Retention{Count: 5}
+	c := New(inner, &TestLogger{}, 100, 0)
+	p := url.URL{Path: "/a"}
+	assert.NoError(t, c.SaveText(ctx, "one", p, &hput.PutResult{}))
+	assert.NoError(t, c.SaveText(ctx, "two", p, &hput.PutResult{}))
+	_, err := c.GetRunnable(ctx, p)
+	assert.NoError(t, err)
+	found, err := c.Rollback(ctx, "/a", 1)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	r, err := c.GetRunnable(ctx, p)
+	assert.NoError(t, err)
+	assert.Equal(t, "one", r.Text)
+
+	noVersions := New(struct{ Saver }{inner}, &TestLogger{}, 100, 0)
+	_, err = noVersions.Rollback(ctx, "/a", 1)
+	assert.ErrorIs(t, err, errNoVersions)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// build compiles a server with a site embedded in it, for
+// `hput build -site ./dir -o myserver`. It runs go build on the hput
+// checkout in -src with the embedsite tag, laying the site over
+// cmd/hput/site through an overlay, so the checkout is left untouched.
+func build(args []string) error {
+	fl := flag.NewFlagSet("build", flag.ContinueOnError)
+	sitePtr := fl.String("site", "site", "directory of content and javascript to embed, laid out as -storage dir saves it")
+	outPtr := fl.String("o", "hput-site", "file to write the server to")
+	srcPtr := fl.String("src", ".", "hput checkout to build the server from")
+	if err := fl.Parse(args); err != nil {
+		return err
+	}
+	src, err := filepath.Abs(*srcPtr)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(filepath.Join(src, "cmd", "hput", "site.go")); err != nil {
+		return fmt.Errorf("%s is not an hput checkout, set -src: %w", src, err)
+	}
+	replace, err := overlay(src, *sitePtr)
+	if err != nil {
+		return err
+	}
+	out, err := filepath.Abs(*outPtr)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.MkdirTemp("", "hput-build")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	b, err := json.Marshal(map[string]map[string]string{"Replace": replace})
+	if err != nil {
+		return err
+	}
+	ov := filepath.Join(tmp, "overlay.json")
+	if err := os.WriteFile(ov, b, 0o644); err != nil {
+		return err
+	}
+	cmd := exec.Command("go", "build", "-tags", "embedsite", "-overlay", ov, "-o", out, "./cmd/hput")
+	cmd.Dir = src
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("could not build %s: %w", out, err)
+	}
+	fmt.Fprintf(os.Stderr, "wrote %s with %d files from %s\n", out, len(replace), *sitePtr)
+	return nil
+}
+
+// overlay maps where each file of the site in dir goes under the checkout
+// in src to where it is now, for go build -overlay. Hidden directories,
+// such as .git, and hidden files other than sidecars are left out.
+func overlay(src, dir string) (map[string]string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	replace := map[string]string{}
+	err = filepath.WalkDir(dir, func(n string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, n)
+		if err != nil || rel == "." {
+			return err
+		}
+		hidden := strings.HasPrefix(d.Name(), ".")
+		switch {
+		case d.IsDir() && hidden:
+			return fs.SkipDir
+		case d.IsDir():
+			return nil
+		case hidden && !strings.HasSuffix(d.Name(), ".hput"):
+			return nil
+		case !d.Type().IsRegular():
+			return fmt.Errorf("%s is not a regular file, which cannot be embedded", n)
+		}
+		replace[filepath.Join(src, "cmd", "hput", "site", rel)] = n
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(replace) == 0 {
+		return nil, errors.New("no files to embed in " + dir)
+	}
+	return replace, nil
+}
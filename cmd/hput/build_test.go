@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestOverlay verifies that a site's files and sidecars are laid over cmd/hput/site, leaving out hidden files
+func TestOverlay(t *testing.T) {
+	tt := []struct {
+		name  string
+		files []string
+		want  []string
+		err   bool
+	}{
+		{
+			name:  "files and sidecars",
+			files: []string{"index.html", "api/hello", "api/.hello.hput"},
+			want:  []string{"index.html", "api/hello", "api/.hello.hput"},
+		},
+		{
+			name:  "hidden",
+			files: []string{"index.html", ".git/HEAD", ".index.html.tmp-abc"},
+			want:  []string{"index.html"},
+		},
+		{
+			name:  "nothing to embed",
+			files: []string{".git/HEAD"},
+			err:   true,
+		},
+	}
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, f := range test.files {
+				assert.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, f)), 0o755))
+				assert.NoError(t, os.WriteFile(filepath.Join(dir, f), []byte(f), 0o644))
+			}
+			got, err := overlay("/src", dir)
+			if test.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			want := map[string]string{}
+			for _, f := range test.want {
+				want[filepath.Join("/src/cmd/hput/site", f)] = filepath.Join(dir, f)
+			}
+			assert.Equal(t, want, got)
+		})
+	}
+}
@@ -4,28 +4,72 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"hput"
+	"hput/admin"
+	"hput/cachesaver"
+	"hput/dirsaver"
 	"hput/discsaver"
+	"hput/embedsaver"
 	"hput/httpserver"
 	"hput/javascript"
 	"hput/kv"
 	"hput/logger"
 	"hput/mapsaver"
+	"hput/overlaysaver"
 	"hput/s3saver"
 	"hput/service"
+	"io/fs"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
+// embeddedSite is the site compiled in by hput build, or nil.
+var embeddedSite fs.FS
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "build" {
+		if err := build(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "hput build: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
 	ctx := context.Background()
+	defaultStorage := "local"
+	if embeddedSite != nil {
+		defaultStorage = "embedded"
+	}
 	portPtr := flag.Int("port", 80, "an int")
 	allTrafficPtr := flag.Bool("nonlocal", false, "allow traffic which is not local")
-	storagePtr := flag.String("storage", "local", "which storage to use, currently supported: local and memory")
+	storagePtr := flag.String("storage", defaultStorage, "which storage to use, currently supported: local, memory, s3, dir and, in a server made with hput build, embedded")
 	fileNamePtr := flag.String("filename", "hput.db", "if using local storage, name of the database file to create and use")
+	rootPtr := flag.String("root", "site", "if using dir storage, the directory to store each path in as a file")
+	snapshotPtr := flag.String("snapshot", "", "if using memory storage, file to restore paths from on start and write them to on ctrl-c or SIGTERM")
+	baseRootPtr := flag.String("base-root", "", "directory of files to serve, read only, at any path not saved to the storage")
+	basePrefixPtr := flag.String("base-prefix", "", "prefix in -bucket of objects to serve, read only, at any path not saved to the storage")
+	cacheSizePtr := flag.Int64("cache-size", 0, "bytes of recently read paths to keep in memory in front of the storage; 0 disables the cache")
+	cacheTTLPtr := flag.Duration("cache-ttl", time.Minute, "how long a path stays cached, which bounds how long changes not made through this server go unseen; 0 keeps it until evicted or saved over")
 	lockedPtr := flag.Bool("locked", false, "pass all requests to run, do not store any paths")
 	logLvlPtr := flag.String("log", "info", "which log level to use, options are: debug, info, warn, error")
 	bucketPtr := flag.String("bucket", "", "if using s3 storage, the bucket to use")
 	prefixPtr := flag.String("prefix", "", "if using s3 storage, the prefix to use")
-	kvBackendPtr := flag.String("kv-backend", "bbolt", "which KV backend to use for JS private storage, currently supported: bbolt")
-	kvFilePtr := flag.String("kv-file", "hput-kv.db", "if using bbolt KV backend, name of the database file to create and use")
+	s3EndpointPtr := flag.String("s3-endpoint", "", "if using s3 storage, URL of an S3-compatible store to use instead of AWS, e.g. http://localhost:9000")
+	s3RegionPtr := flag.String("s3-region", "", "if using s3 storage, the region to use instead of AWS_REGION or the shared config")
+	s3PathStylePtr := flag.Bool("s3-path-style", false, "if using s3 storage, address the bucket in the URL path, as MinIO and most S3-compatible stores need")
+	kvBackendPtr := flag.String("kv-backend", "bbolt", "which KV backend to use for JS private storage, currently supported: bbolt, sqlite, memory, redis, s3; defaults to memory with -storage memory and s3 with -storage s3")
+	kvFilePtr := flag.String("kv-file", "hput-kv.db", "if using bbolt or sqlite KV backend, name of the database file to create and use")
+	kvURLPtr := flag.String("kv-url", "", "if using redis KV backend, the server to use, e.g. redis://:password@host:6379/0")
+	kvMaxValuePtr := flag.Int("kv-max-value", 0, "largest value in bytes a script may store under one key; 0 means unlimited")
+	kvMaxKeysPtr := flag.Int("kv-max-keys", 0, "most keys each path's KV namespace may hold; 0 means unlimited")
+	kvMaxBytesPtr := flag.Int64("kv-max-bytes", 0, "most bytes of keys and values each path's KV namespace may hold; 0 means unlimited")
+	kvSweepPtr := flag.Duration("kv-sweep", time.Minute, "how often to purge expired keys from the KV store; 0 disables sweeping, and expired keys still read as missing")
+	maxUploadPtr := flag.Int64("max-upload", 0, "largest PUT body in bytes; larger uploads get 413. 0 means unlimited")
+	versionsPtr := flag.Int("versions", 10, "how many earlier versions of each saved path to keep for rollback; 0 keeps only the current one")
+	versionsAgePtr := flag.Duration("versions-age", 0, "drop earlier versions of saved paths older than this, e.g. 720h; 0 keeps them regardless of age")
+	listingsPtr := flag.String("listings", "", "where GET of a path ending in / with nothing saved, or with ?list, serves a listing of what is under it: comma separated /prefix=on or /prefix=off rules, the longest matching prefix wins, e.g. /docs/=on,/docs/private/=off; listings are off wherever no rule turns them on")
+	adminTokenPtr := flag.String("admin-token", "", "bearer token required by the admin API under /_hput/; if empty, only callers connecting from loopback may use it, which is unsafe behind a local reverse proxy, and with -nonlocal the admin API is off")
 	flag.Parse()
 
 	l, err := logger.New(*logLvlPtr)
@@ -33,27 +77,118 @@ func main() {
 		fmt.Printf("Unable to initialize logger, stopping, %+v", err)
 	}
 
+	listings, err := service.ParseListings(*listingsPtr)
+	if err != nil {
+		l.Errorf("main.Main(): bad -listings: %v", err)
+		return
+	}
+
+	retention := hput.Retention{Count: *versionsPtr, Age: *versionsAgePtr}
 	var saver service.Saver
 	switch *storagePtr {
 	case "local":
-		saver, err = discsaver.New(&l, *fileNamePtr)
+		sa, err := discsaver.New(&l, *fileNamePtr)
 		if err != nil {
 			l.Errorf("main.Main(): could not initialize discsaver: %v", err)
 			return
 		}
+		sa.Retention = retention
+		saver = sa
 		l.Debug("Initialized local saver")
 	case "memory":
-		saver = &mapsaver.MapSaver{
-			Logger: &l,
+		sa := mapsaver.New(&l)
+		sa.Retention = retention
+		if *snapshotPtr != "" {
+			if err := sa.RestoreFile(*snapshotPtr); err != nil {
+				l.Errorf("main.Main(): could not restore snapshot: %v", err)
+				return
+			}
+			go snapshotOnExit(sa, *snapshotPtr, &l)
 		}
+		saver = sa
 		l.Debug("Initialized map saver")
 	case "s3":
-		saver, err = s3saver.New(ctx, &l, *bucketPtr, *&s3saver.PrefixOption{Prefix: *prefixPtr})
+		sa, err := s3saver.New(ctx, &l, *bucketPtr,
+			s3saver.PrefixOption{Prefix: *prefixPtr},
+			s3saver.EndpointOption{URL: *s3EndpointPtr},
+			s3saver.RegionOption{Region: *s3RegionPtr},
+			s3saver.PathStyleOption{PathStyle: *s3PathStylePtr})
 		if err != nil {
 			l.Errorf("Unable to initialize s3saver: %v", err)
+			return
+		}
+		sa.Retention = retention
+		saver = sa
+	case "dir":
+		sa, err := dirsaver.New(&l, *rootPtr)
+		if err != nil {
+			l.Errorf("main.Main(): could not initialize dirsaver: %v", err)
+			return
+		}
+		saver = sa
+		l.Debug("Initialized dir saver")
+	case "embedded":
+		if embeddedSite == nil {
+			l.Errorf("main.Main(): embedded storage needs a server made with hput build")
+			return
 		}
+		saver = embedsaver.New(&l, embeddedSite)
+		// Nothing can be saved to the site, so serve it as it was built.
+		*lockedPtr = true
+		l.Debug("Initialized embedded saver")
 	default:
-		l.Errorf("main.Main(): incorrect storage parameter passed, use 'local' or 'memory'")
+		l.Errorf("main.Main(): incorrect storage parameter passed, use 'local', 'memory', 's3', 'dir' or 'embedded'")
+	}
+	// The overlay and cache pass versions through, so only offer them if the storage keeps them.
+	_, versioned := saver.(admin.Versioner)
+	var base overlaysaver.Base
+	switch {
+	case *baseRootPtr != "" && *basePrefixPtr != "":
+		l.Errorf("main.Main(): set only one of -base-root and -base-prefix")
+		return
+	case *baseRootPtr != "":
+		if _, err := os.Stat(*baseRootPtr); err != nil {
+			l.Errorf("main.Main(): could not find base: %v", err)
+			return
+		}
+		sa, err := dirsaver.New(&l, *baseRootPtr)
+		if err != nil {
+			l.Errorf("main.Main(): could not initialize base: %v", err)
+			return
+		}
+		base = sa
+	case *basePrefixPtr != "":
+		sa, err := s3saver.New(ctx, &l, *bucketPtr,
+			s3saver.PrefixOption{Prefix: *basePrefixPtr},
+			s3saver.EndpointOption{URL: *s3EndpointPtr},
+			s3saver.RegionOption{Region: *s3RegionPtr},
+			s3saver.PathStyleOption{PathStyle: *s3PathStylePtr})
+		if err != nil {
+			l.Errorf("main.Main(): could not initialize base: %v", err)
+			return
+		}
+		base = sa
+	}
+	if base != nil {
+		saver = overlaysaver.New(saver, base, &l)
+		l.Debug("Initialized overlay on base")
+	}
+	var cache *cachesaver.Cache
+	if *cacheSizePtr > 0 {
+		cache = cachesaver.New(saver, &l, *cacheSizePtr, *cacheTTLPtr)
+		saver = cache
+		l.Debugf("Initialized cache of %d bytes", *cacheSizePtr)
+	}
+	// -storage memory and -storage s3 should leave nothing on disk, so unless
+	// a KV backend was chosen explicitly, keep the KV store beside the paths.
+	// An embedded site has nowhere to keep it, so it is kept in memory.
+	kvBackendSet := false
+	flag.Visit(func(f *flag.Flag) { kvBackendSet = kvBackendSet || f.Name == "kv-backend" })
+	if (*storagePtr == "memory" || *storagePtr == "s3") && !kvBackendSet {
+		*kvBackendPtr = *storagePtr
+	}
+	if *storagePtr == "embedded" && !kvBackendSet {
+		*kvBackendPtr = "memory"
 	}
 	var kvStore kv.KV
 	switch *kvBackendPtr {
@@ -64,10 +199,43 @@ func main() {
 			return
 		}
 		l.Debugf("Initialized bbolt KV store at %s", *kvFilePtr)
+	case "sqlite":
+		kvStore, err = kv.NewSQLite(*kvFilePtr)
+		if err != nil {
+			l.Errorf("main.Main(): could not initialize sqlite KV store: %v", err)
+			return
+		}
+		l.Debugf("Initialized sqlite KV store at %s", *kvFilePtr)
+	case "memory":
+		kvStore = kv.NewMemory()
+		l.Debug("Initialized memory KV store")
+	case "redis":
+		kvStore, err = kv.NewRedis(*kvURLPtr)
+		if err != nil {
+			l.Errorf("main.Main(): could not initialize redis KV store: %v", err)
+			return
+		}
+		l.Debug("Initialized redis KV store")
+	case "s3":
+		kvStore, err = s3saver.NewKV(ctx, &l, *bucketPtr,
+			s3saver.PrefixOption{Prefix: *prefixPtr},
+			s3saver.EndpointOption{URL: *s3EndpointPtr},
+			s3saver.RegionOption{Region: *s3RegionPtr},
+			s3saver.PathStyleOption{PathStyle: *s3PathStylePtr})
+		if err != nil {
+			l.Errorf("main.Main(): could not initialize s3 KV store: %v", err)
+			return
+		}
+		l.Debugf("Initialized s3 KV store in %s", *bucketPtr)
 	default:
-		l.Errorf("main.Main(): unknown kv-backend %q, supported: bbolt", *kvBackendPtr)
+		l.Errorf("main.Main(): unknown kv-backend %q, supported: bbolt, sqlite, memory, redis, s3", *kvBackendPtr)
 		return
 	}
+	// Track usage of every path, so it can be reported, and hold it to any limits.
+	kvStore = kv.NewQuota(kvStore, kv.Limits{MaxValueBytes: *kvMaxValuePtr, MaxKeys: *kvMaxKeysPtr, MaxBytes: *kvMaxBytesPtr})
+	// Publish every write so scripts and the admin API can watch for changes.
+	kvStore = kv.NewNotifier(kvStore)
+	go kv.Sweep(ctx, kvStore, *kvSweepPtr, &l)
 
 	js, err := javascript.New(&l)
 	if err != nil {
@@ -80,8 +248,20 @@ func main() {
 		Saver:       saver,
 		KV:          kvStore,
 		Logger:      &l,
+		MaxUpload:   *maxUploadPtr,
+		Listings:    listings,
 	}
 	l.Debug("Initialized service module")
+	adm := admin.New(&l, kvStore, *adminTokenPtr)
+	if versioned {
+		adm.Versions = saver.(admin.Versioner)
+	}
+	if cache != nil {
+		adm.Cache = cache
+	}
+	if base != nil {
+		adm.Reset = saver.(admin.Resetter)
+	}
 	h := httpserver.Httpserver{
 		Port:     *portPtr,
 		Service:  &s,
@@ -89,9 +269,30 @@ func main() {
 		NonLocal: *allTrafficPtr,
 		Locked:   *lockedPtr,
 	}
+	if *allTrafficPtr && *adminTokenPtr == "" {
+		// Proxied requests arrive from loopback, so it cannot stand in for a token.
+		l.Warnf("serving no admin API under %s: -nonlocal needs -admin-token", httpserver.AdminPrefix)
+	} else {
+		h.Admin = adm
+		s.Admin = adm.Authorized
+	}
 	if *allTrafficPtr {
 		l.Debug("Allowing nonlocal traffic")
 	}
 	l.Debug("Initialized http server")
 	h.Serve()
 }
+
+// snapshotOnExit waits for ctrl-c or SIGTERM, then writes a snapshot of
+// the memory saver and exits.
+func snapshotOnExit(sa *mapsaver.MapSaver, name string, l *logger.Logger) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	<-sig
+	if err := sa.SnapshotFile(name); err != nil {
+		l.Errorf("main.snapshotOnExit(): %v", err)
+		os.Exit(1)
+	}
+	l.Infof("wrote snapshot to %s", name)
+	os.Exit(0)
+}
@@ -0,0 +1,22 @@
+//go:build embedsite
+
+package main
+
+import (
+	"embed"
+	"io/fs"
+)
+
+// site is the directory given to hput build, which lays it over ./site
+// when it runs go build with the embedsite tag.
+//
+//go:embed all:site
+var site embed.FS
+
+func init() {
+	sub, err := fs.Sub(site, "site")
+	if err != nil {
+		panic(err)
+	}
+	embeddedSite = sub
+}
@@ -0,0 +1,232 @@
+// Package dirsaver implements the hput.Saver interface and stores each path
+// as a file in a directory tree, so content can be edited with normal tools,
+// served straight from a checkout and backed up with rsync.
+package dirsaver
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"hput"
+	"hput/internal/sitefs"
+	"io"
+	"io/fs"
+	"iter"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// Logger logs out.
+type Logger interface {
+	Debug(msg string)
+	Debugf(msg string, args ...interface{})
+	Errorf(msg string, args ...interface{})
+}
+
+// Saver stores each path as a file under Root, with a sidecar beside it,
+// .<file>.hput, recording its type. A file without a sidecar, such as one
+// copied in by hand, is text if it looks like text and binary otherwise.
+// Paths can't reach outside Root, through ".." or through symlinks.
+type Saver struct {
+	Root   *os.Root
+	Logger Logger
+}
+
+// New creates a saver storing paths under dir, creating dir if needed.
+func New(l Logger, dir string) (*Saver, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		l.Errorf("dirsaver.New(): could not create root %s: %v", dir, err)
+		return nil, err
+	}
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		l.Errorf("dirsaver.New(): could not open root %s: %v", dir, err)
+		return nil, err
+	}
+	l.Debugf("dirsaver.New(): storing paths under %s", dir)
+	return &Saver{Root: root, Logger: l}, nil
+}
+
+// Shutdown gracefully clean this up
+func (sa *Saver) Shutdown() {
+	sa.Root.Close()
+}
+
+// SaveText saves a text value to a path
+func (sa *Saver) SaveText(_ context.Context, s string, p url.URL, r *hput.PutResult) error {
+	return sa.save(p, hput.Text, func(w io.Writer) error {
+		_, err := io.WriteString(w, s)
+		return err
+	}, r)
+}
+
+// SaveCode saves code to a path
+func (sa *Saver) SaveCode(_ context.Context, s string, p url.URL, r *hput.PutResult) error {
+	return sa.save(p, hput.Js, func(w io.Writer) error {
+		_, err := io.WriteString(w, s)
+		return err
+	}, r)
+}
+
+// SaveBinary saves a binary value to a path
+func (sa *Saver) SaveBinary(_ context.Context, b []byte, p url.URL, r *hput.PutResult) error {
+	return sa.save(p, hput.Binary, func(w io.Writer) error {
+		_, err := w.Write(b)
+		return err
+	}, r)
+}
+
+// SaveStream saves a binary read from b to a path, copying it straight to
+// the file.
+func (sa *Saver) SaveStream(ctx context.Context, b io.Reader, p url.URL, r *hput.PutResult) error {
+	return sa.save(p, hput.Binary, func(w io.Writer) error {
+		_, err := io.Copy(w, b)
+		if err == nil {
+			err = ctx.Err()
+		}
+		return err
+	}, r)
+}
+
+// save writes a path's file and then its sidecar, and reports if the path
+// was replaced.
+func (sa *Saver) save(p url.URL, t hput.Input, write func(io.Writer) error, r *hput.PutResult) error {
+	sa.Logger.Debugf("dirsaver.save(): saving %s at %s", t, p.Path)
+	n, err := sitefs.FileName(p.Path)
+	if err != nil {
+		return err
+	}
+	if dir := path.Dir(n); dir != "." {
+		if err := sa.Root.MkdirAll(dir, 0o755); err != nil {
+			sa.Logger.Errorf("dirsaver.save(): could not create directory for %s: %v", p.Path, err)
+			return fmt.Errorf("could not create directory for %s: %w", p.Path, err)
+		}
+	}
+	info, err := sa.Root.Stat(n)
+	switch {
+	case err == nil && info.IsDir():
+		return fmt.Errorf("%s is a directory, save to %s/ instead", p.Path, strings.TrimSuffix(p.Path, "/"))
+	case err == nil:
+		r.Overwrote = true
+	case !sitefs.Missing(err):
+		sa.Logger.Errorf("dirsaver.save(): could not check %s: %v", p.Path, err)
+		return fmt.Errorf("could not check %s: %w", p.Path, err)
+	}
+	if err := sa.writeFile(n, write); err != nil {
+		sa.Logger.Errorf("dirsaver.save(): could not write %s: %v", p.Path, err)
+		return fmt.Errorf("could not write %s: %w", p.Path, err)
+	}
+	sc, err := json.Marshal(sitefs.Sidecar{Type: t})
+	if err != nil {
+		return err
+	}
+	err = sa.writeFile(sitefs.SidecarName(n), func(w io.Writer) error {
+		_, err := w.Write(sc)
+		return err
+	})
+	if err != nil {
+		sa.Logger.Errorf("dirsaver.save(): could not write sidecar of %s: %v", p.Path, err)
+		return fmt.Errorf("could not write sidecar of %s: %w", p.Path, err)
+	}
+	return nil
+}
+
+// writeFile writes a temporary file beside file n and renames it into
+// place, so n is always either the old file or the whole new one.
+func (sa *Saver) writeFile(n string, write func(io.Writer) error) error {
+	tmp := path.Join(path.Dir(n), "."+path.Base(n)+".tmp-"+rand.Text())
+	f, err := sa.Root.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	err = write(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = sa.Root.Rename(tmp, n)
+	}
+	if err != nil {
+		sa.Root.Remove(tmp)
+	}
+	return err
+}
+
+// Delete removes a path's file and its sidecar. Deleting a path with
+// nothing saved succeeds.
+func (sa *Saver) Delete(_ context.Context, p url.URL) error {
+	n, err := sitefs.FileName(p.Path)
+	if err != nil {
+		return err
+	}
+	for _, name := range []string{n, sitefs.SidecarName(n)} {
+		if err := sa.Root.Remove(name); err != nil && !sitefs.Missing(err) {
+			sa.Logger.Errorf("dirsaver.Delete(): error deleting %s: %v", name, err)
+			return fmt.Errorf("error deleting %s: %w", p.Path, err)
+		}
+	}
+	return nil
+}
+
+// tree reads the files under Root.
+func (sa *Saver) tree() sitefs.Tree {
+	return sitefs.Tree{FS: sa.Root.FS()}
+}
+
+// GetRunnable returns the runnable from a path
+func (sa *Saver) GetRunnable(_ context.Context, p url.URL) (hput.Runnable, error) {
+	sa.Logger.Debugf("dirsaver.GetRunnable(): retrieving runnable at url %+v", p)
+	runnable, err := sa.tree().Runnable(p.Path)
+	if err != nil {
+		sa.Logger.Errorf("dirsaver.GetRunnable(): %v", err)
+		return hput.Runnable{}, err
+	}
+	if runnable.Type == "" {
+		sa.Logger.Debug("dirsaver.GetRunnable(): got no runnable")
+	}
+	return runnable, nil
+}
+
+// GetStream returns the binary at a path, or nil if the path does not hold
+// a binary.
+func (sa *Saver) GetStream(_ context.Context, p url.URL) (io.ReadSeekCloser, error) {
+	rs, _, info, err := sa.tree().OpenBinary(p.Path)
+	if err != nil {
+		sa.Logger.Errorf("dirsaver.GetStream(): %v", err)
+		return nil, err
+	}
+	if rs == nil {
+		return nil, nil
+	}
+	return &file{ReadSeekCloser: rs, info: info}, nil
+}
+
+// file is a binary opened by GetStream.
+type file struct {
+	io.ReadSeekCloser
+	info fs.FileInfo
+}
+
+// ETag is made from the file's size and modification time.
+func (f *file) ETag() string {
+	return fmt.Sprintf(`"%x-%x"`, f.info.ModTime().UnixNano(), f.info.Size())
+}
+
+// ModTime is when the file was last written.
+func (f *file) ModTime() time.Time {
+	return f.info.ModTime()
+}
+
+// List yields every path under Root with a prefix of the given one that
+// sorts after cursor, in path order. Hidden files and directories are
+// skipped.
+func (sa *Saver) List(ctx context.Context, prefix, cursor string, limit int) iter.Seq2[hput.Entry, error] {
+	return sa.tree().List(ctx, prefix, cursor, limit)
+}
@@ -0,0 +1,263 @@
+package dirsaver
+
+import (
+	"context"
+	"hput"
+	"hput/internal/sitefs"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type TestLogger struct{}
+
+func (t *TestLogger) Debugf(msg string, args ...interface{}) {}
+
+func (t *TestLogger) Debug(msg string) {}
+
+func (t *TestLogger) Errorf(msg string, args ...interface{}) {}
+
+func newSaver(t *testing.T) (*Saver, string) {
+	dir := t.TempDir()
+	sa, err := New(&TestLogger{}, dir)
+	assert.NoError(t, err)
+	t.Cleanup(sa.Shutdown)
+	return sa, dir
+}
+
+// TestSave verifies that each path is saved as a file with its type in a sidecar
+func TestSave(t *testing.T) {
+	tt := []struct {
+		name     string
+		path     string
+		save     func(sa *Saver, p url.URL, r *hput.PutResult) error
+		file     string
+		contents string
+		runnable hput.Runnable
+	}{
+		{
+			name: "text",
+			path: "/hello",
+			save: func(sa *Saver, p url.URL, r *hput.PutResult) error {
+				return sa.SaveText(context.Background(), "hi", p, r)
+			},
+			file:     "hello",
+			contents: "hi",
+			runnable: hput.Runnable{Path: "/hello", Type: hput.Text, Text: "hi"},
+		},
+		{
+			name: "code in a subdirectory",
+			path: "/api/time",
+			save: func(sa *Saver, p url.URL, r *hput.PutResult) error {
+				return sa.SaveCode(context.Background(), "new Date()", p, r)
+			},
+			file:     "api/time",
+			contents: "new Date()",
+			runnable: hput.Runnable{Path: "/api/time", Type: hput.Js, Text: "new Date()"},
+		},
+		{
+			name: "binary",
+			path: "/img.png",
+			save: func(sa *Saver, p url.URL, r *hput.PutResult) error {
+				return sa.SaveBinary(context.Background(), []byte{0x89, 'P', 'N', 'G', 0}, p, r)
+			},
+			file:     "img.png",
+			contents: "\x89PNG\x00",
+			runnable: hput.Runnable{Path: "/img.png", Type: hput.Binary, Binary: []byte{0x89, 'P', 'N', 'G', 0}},
+		},
+		{
+			name: "directory index",
+			path: "/docs/",
+			save: func(sa *Saver, p url.URL, r *hput.PutResult) error {
+				return sa.SaveText(context.Background(), "<html>", p, r)
+			},
+			file:     "docs/index.html",
+			contents: "<html>",
+			runnable: hput.Runnable{Path: "/docs/", Type: hput.Text, Text: "<html>"},
+		},
+	}
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			sa, dir := newSaver(t)
+			p := url.URL{Path: test.path}
+			r := hput.PutResult{}
+			assert.NoError(t, test.save(sa, p, &r))
+			assert.False(t, r.Overwrote)
+			b, err := os.ReadFile(filepath.Join(dir, test.file))
+			assert.NoError(t, err)
+			assert.Equal(t, test.contents, string(b))
+
+			got, err := sa.GetRunnable(context.Background(), p)
+			assert.NoError(t, err)
+			assert.Equal(t, test.runnable, got)
+
+			assert.NoError(t, test.save(sa, p, &r))
+			assert.True(t, r.Overwrote)
+		})
+	}
+}
+
+// TestReserved verifies that paths cannot reach hidden files or leave the root
+func TestReserved(t *testing.T) {
+	tt := []struct {
+		name string
+		path string
+	}{
+		{name: "parent directory", path: "/../outside"},
+		{name: "parent directory in the middle", path: "/a/../../outside"},
+		{name: "hidden directory", path: "/.git/config"},
+		{name: "sidecar", path: "/.hello.hput"},
+		{name: "current directory", path: "/a/./b"},
+	}
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			sa, _ := newSaver(t)
+			p := url.URL{Path: test.path}
+			assert.ErrorIs(t, sa.SaveText(context.Background(), "x", p, &hput.PutResult{}), sitefs.ErrReserved)
+			got, err := sa.GetRunnable(context.Background(), p)
+			assert.NoError(t, err)
+			assert.Equal(t, hput.Runnable{}, got)
+		})
+	}
+
+	t.Run("symlink out of the root", func(t *testing.T) {
+		sa, dir := newSaver(t)
+		outside := t.TempDir()
+		assert.NoError(t, os.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0o644))
+		assert.NoError(t, os.Symlink(outside, filepath.Join(dir, "link")))
+		p := url.URL{Path: "/link/secret"}
+		_, err := sa.GetRunnable(context.Background(), p)
+		assert.Error(t, err)
+		assert.Error(t, sa.SaveText(context.Background(), "x", p, &hput.PutResult{}))
+		b, err := os.ReadFile(filepath.Join(outside, "secret"))
+		assert.NoError(t, err)
+		assert.Equal(t, "secret", string(b))
+	})
+}
+
+// TestNoSidecar verifies that files put in the root by hand are served by what they hold
+func TestNoSidecar(t *testing.T) {
+	sa, dir := newSaver(t)
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "app.js"), []byte("new Date()"), 0o644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "blob"), []byte{0, 1, 2}, 0o644))
+
+	got, err := sa.GetRunnable(context.Background(), url.URL{Path: "/app.js"})
+	assert.NoError(t, err)
+	assert.Equal(t, hput.Runnable{Path: "/app.js", Type: hput.Text, Text: "new Date()"}, got, "code is not run unless saved as code")
+	got, err = sa.GetRunnable(context.Background(), url.URL{Path: "/blob"})
+	assert.NoError(t, err)
+	assert.Equal(t, hput.Runnable{Path: "/blob", Type: hput.Binary, Binary: []byte{0, 1, 2}}, got)
+
+	rs, err := sa.GetStream(context.Background(), url.URL{Path: "/blob"})
+	assert.NoError(t, err)
+	defer rs.Close()
+	b, err := io.ReadAll(rs)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0, 1, 2}, b)
+	rs, err = sa.GetStream(context.Background(), url.URL{Path: "/app.js"})
+	assert.NoError(t, err)
+	assert.Nil(t, rs, "text is not streamed")
+}
+
+// TestList verifies that the tree is listed in path order, a page at a time, skipping hidden files
+func TestList(t *testing.T) {
+	sa, dir := newSaver(t)
+	ctx := context.Background()
+	for _, p := range []string{"/b", "/a/2", "/a/1", "/ab", "/a-b", "/c/d"} {
+		assert.NoError(t, sa.SaveText(ctx, "text at "+p, url.URL{Path: p}, &hput.PutResult{}))
+	}
+	assert.NoError(t, sa.SaveCode(ctx, "1+1", url.URL{Path: "/c/code"}, &hput.PutResult{}))
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, ".git"), 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, ".git", "HEAD"), []byte("ref"), 0o644))
+
+	tt := []struct {
+		name   string
+		prefix string
+		cursor string
+		limit  int
+		paths  []string
+	}{
+		{name: "everything", prefix: "/", paths: []string{"/a-b", "/a/1", "/a/2", "/ab", "/b", "/c/code", "/c/d"}},
+		{name: "prefix of a file and a directory", prefix: "/a", paths: []string{"/a-b", "/a/1", "/a/2", "/ab"}},
+		{name: "directory", prefix: "/a/", paths: []string{"/a/1", "/a/2"}},
+		{name: "first page", prefix: "/", limit: 2, paths: []string{"/a-b", "/a/1"}},
+		{name: "page after a cursor in a directory", prefix: "/", cursor: "/a/1", limit: 3, paths: []string{"/a/2", "/ab", "/b"}},
+		{name: "page after a cursor past a directory", prefix: "/", cursor: "/ab", paths: []string{"/b", "/c/code", "/c/d"}},
+		{name: "nothing", prefix: "/z", paths: nil},
+	}
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			var paths []string
+			for e, err := range sa.List(ctx, test.prefix, test.cursor, test.limit) {
+				assert.NoError(t, err)
+				if e.Path == "/c/code" {
+					assert.Equal(t, hput.Entry{Path: e.Path, Type: hput.Js, Size: 3, Modified: e.Modified}, e)
+				} else {
+					assert.Equal(t, hput.Entry{Path: e.Path, Type: hput.Text, Size: int64(len("text at " + e.Path)), Modified: e.Modified}, e)
+				}
+				assert.False(t, e.Modified.IsZero())
+				paths = append(paths, e.Path)
+			}
+			assert.Equal(t, test.paths, paths)
+		})
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	for _, err := range sa.List(cancelled, "/", "", 0) {
+		assert.ErrorIs(t, err, context.Canceled)
+	}
+}
+
+// TestListIndex verifies that a path ending in "/" is listed as saved, not as its index file, so it can be fetched and dumped again
+func TestListIndex(t *testing.T) {
+	sa, _ := newSaver(t)
+	ctx := context.Background()
+	saved := []string{"/", "/x/", "/x/a", "/x-y", "/x/y/"}
+	for _, p := range saved {
+		assert.NoError(t, sa.SaveText(ctx, "text at "+p, url.URL{Path: p}, &hput.PutResult{}))
+	}
+	tt := []struct {
+		name   string
+		prefix string
+		cursor string
+		paths  []string
+	}{
+		{name: "everything", prefix: "/", paths: []string{"/", "/x-y", "/x/", "/x/a", "/x/y/"}},
+		{name: "directory", prefix: "/x/", paths: []string{"/x/", "/x/a", "/x/y/"}},
+		{name: "after the index", prefix: "/", cursor: "/x/", paths: []string{"/x/a", "/x/y/"}},
+	}
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			var paths []string
+			for e, err := range sa.List(ctx, test.prefix, test.cursor, 0) {
+				assert.NoError(t, err)
+				r, err := sa.GetRunnable(ctx, url.URL{Path: e.Path})
+				assert.NoError(t, err)
+				assert.Equal(t, "text at "+e.Path, r.Text)
+				paths = append(paths, e.Path)
+			}
+			assert.Equal(t, test.paths, paths)
+		})
+	}
+}
+
+// TestDelete verifies that deleting a path removes its file and sidecar
+func TestDelete(t *testing.T) {
+	ctx := context.Background()
+	sa, dir := newSaver(t)
+	p := url.URL{Path: "/a/page"}
+	assert.NoError(t, sa.SaveCode(ctx, "1+1", p, &hput.PutResult{}))
+	assert.NoError(t, sa.Delete(ctx, p))
+	assert.NoFileExists(t, filepath.Join(dir, "a", "page"))
+	assert.NoFileExists(t, filepath.Join(dir, "a", ".page.hput"))
+	r, err := sa.GetRunnable(ctx, p)
+	assert.NoError(t, err)
+	assert.Equal(t, hput.Runnable{}, r)
+	assert.NoError(t, sa.Delete(ctx, p), "deleting nothing succeeds")
+	assert.ErrorIs(t, sa.Delete(ctx, url.URL{Path: "/.git/config"}), sitefs.ErrReserved)
+}
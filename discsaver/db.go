@@ -8,13 +8,24 @@ import (
 	"encoding/json"
 	"fmt"
 	"hput"
+	"iter"
 	"net/url"
+	"time"
 
 	bolt "go.etcd.io/bbolt"
 )
 
 var bucketName = []byte("hput")
 
+// record is how a path's runnable is stored. A binary saved with SaveStream
+// keeps its bytes in blobsBucket under Blob, rather than in the record.
+type record struct {
+	hput.Runnable
+	Blob  uint64    `json:",omitempty"`
+	Size  int64     `json:",omitempty"` // length of the blob
+	Saved time.Time `json:",omitzero"`  // zero if saved before it was recorded
+}
+
 // Logger logs out.
 type Logger interface {
 	Debug(msg string)
@@ -24,9 +35,10 @@ type Logger interface {
 
 // Saver describes a database-based store which can save and retrieve for the hput server
 type Saver struct {
-	Db     *bolt.DB
-	Logger Logger
-	Path   string
+	Db        *bolt.DB
+	Logger    Logger
+	Path      string
+	Retention hput.Retention // which earlier versions of each path to keep
 }
 
 // New create a new saver
@@ -42,6 +54,14 @@ func New(l Logger, f string) (*Saver, error) {
 		if err != nil {
 			return fmt.Errorf("create bucket: %s", err)
 		}
+		_, err = tx.CreateBucketIfNotExists(versionsBucket)
+		if err != nil {
+			return fmt.Errorf("create versions bucket: %s", err)
+		}
+		_, err = tx.CreateBucketIfNotExists(blobsBucket)
+		if err != nil {
+			return fmt.Errorf("create blobs bucket: %s", err)
+		}
 		return nil
 	})
 	if err != nil {
@@ -49,6 +69,17 @@ func New(l Logger, f string) (*Saver, error) {
 		db.Close()
 		return nil, err
 	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		n, err := sweepBlobs(tx)
+		if n > 0 {
+			l.Debugf("discsaver.New(): deleted %d unreferenced blobs", n)
+		}
+		return err
+	})
+	if err != nil {
+		// Leave the blobs for the next open rather than refuse to start.
+		l.Errorf("discsaver.New(): could not sweep unreferenced blobs: %v", err)
+	}
 	return &Saver{
 		Db:     db,
 		Logger: l,
@@ -67,7 +98,7 @@ func (sa *Saver) SaveText(_ context.Context, s string, p url.URL, r *hput.PutRes
 		Type: hput.Text,
 		Text: s,
 	}
-	return sa.saveRunnable(ru, p, r)
+	return sa.saveRecord(record{Runnable: ru}, p, r)
 }
 
 func (sa *Saver) SaveCode(_ context.Context, s string, p url.URL, r *hput.PutResult) error {
@@ -75,7 +106,7 @@ func (sa *Saver) SaveCode(_ context.Context, s string, p url.URL, r *hput.PutRes
 		Type: hput.Js,
 		Text: s,
 	}
-	return sa.saveRunnable(ru, p, r)
+	return sa.saveRecord(record{Runnable: ru}, p, r)
 }
 
 // SaveBinary saves a binary value to a path
@@ -84,86 +115,166 @@ func (sa *Saver) SaveBinary(_ context.Context, b []byte, p url.URL, r *hput.PutR
 		Type:   hput.Binary,
 		Binary: b,
 	}
-	return sa.saveRunnable(ru, p, r)
+	return sa.saveRecord(record{Runnable: ru}, p, r)
 }
 
-// saveRunnable saves a runnable and reports if the runnable was replaced
-func (sa *Saver) saveRunnable(ru hput.Runnable, p url.URL, r *hput.PutResult) error {
-	sa.Logger.Debugf("discsaver.saveRunnable(): retrieving runnable %+v", ru)
-	v, err := json.Marshal(ru)
-	if err != nil {
-		sa.Logger.Errorf("discsaver.saveRunnable(): could not prepare saved record: %v", err)
+// saveRecord saves a runnable's record and reports if the runnable was replaced
+func (sa *Saver) saveRecord(rec record, p url.URL, r *hput.PutResult) error {
+	sa.Logger.Debugf("discsaver.saveRecord(): saving record of type %s at %s", rec.Type, p.Path)
+	err := sa.Db.Update(func(tx *bolt.Tx) error {
+		overwrote, err := sa.putRecord(tx, p.Path, rec)
+		r.Overwrote = overwrote
 		return err
+	})
+	if err != nil {
+		sa.Logger.Errorf("discsaver.saveRecord(): error saving text to database %s", err)
+		return fmt.Errorf("error saving text to database %w", err)
+	}
+	return nil
+}
+
+// putRecord stores rec as what path holds, saved now, and as its newest
+// version, and reports if it replaced something.
+func (sa *Saver) putRecord(tx *bolt.Tx, path string, rec record) (bool, error) {
+	now := time.Now()
+	rec.Saved = now
+	v, err := json.Marshal(rec)
+	if err != nil {
+		return false, fmt.Errorf("could not prepare saved record: %w", err)
 	}
-	err = sa.Db.Update(func(tx *bolt.Tx) error {
+	b := tx.Bucket(bucketName)
+	// Copied, as Put may reuse the memory Get returned.
+	existing := bytes.Clone(b.Get([]byte(path)))
+	if err := b.Put([]byte(path), v); err != nil {
+		return false, err
+	}
+	return len(existing) > 0, sa.addVersion(tx, path, existing, rec, now)
+}
+
+// Delete removes what is saved at a path, keeping its versions, which still
+// hold a streamed binary's blob. Deleting a path with nothing saved succeeds.
+func (sa *Saver) Delete(_ context.Context, p url.URL) error {
+	err := sa.Db.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket(bucketName)
-		existing := b.Get([]byte(p.Path))
-		if len(existing) > 0 {
-			r.Overwrote = true
-		}
-		err = b.Put([]byte(p.Path), v)
-		if err != nil {
-			return err
+		existing := bytes.Clone(b.Get([]byte(p.Path)))
+		if vb := tx.Bucket(versionsBucket).Bucket([]byte(p.Path)); vb != nil {
+			if err := writeOut(vb, existing); err != nil {
+				return err
+			}
 		}
-		return nil
+		return b.Delete([]byte(p.Path))
 	})
 	if err != nil {
-		sa.Logger.Errorf("discsaver.saveRunnable(): error saving text to database %s", err)
-		return fmt.Errorf("error saving text to database %w", err)
+		sa.Logger.Errorf("discsaver.Delete(): error deleting %s from database: %v", p.Path, err)
+		return fmt.Errorf("error deleting from database: %w", err)
 	}
 	return nil
 }
 
 // GetRunnable returns the runnable from a path
 func (sa *Saver) GetRunnable(_ context.Context, p url.URL) (hput.Runnable, error) {
-	var runnableBytes []byte
+	var runnable hput.Runnable
+	var found bool
 	sa.Logger.Debugf("discsaver.GetRunnable(): retrieving runnable at url %+v", p)
 	err := sa.Db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket(bucketName)
-		runnableBytes = b.Get([]byte(p.Path))
-		return nil
+		runnableBytes := tx.Bucket(bucketName).Get([]byte(p.Path))
+		if len(runnableBytes) == 0 {
+			return nil
+		}
+		found = true
+		var err error
+		runnable, err = readRecord(tx, runnableBytes)
+		return err
 	})
 	if err != nil {
 		sa.Logger.Errorf("discsaver.GetRunnable(): error retrieving runnable from database %v", err)
 		return hput.Runnable{}, fmt.Errorf("error retrieving runnable from database: %w", err)
 	}
-	if len(runnableBytes) == 0 {
+	if !found {
 		sa.Logger.Debug("discsaver.GetRunnable(): got no runnable")
 		return hput.Runnable{}, nil
 	}
-	runnable := &hput.Runnable{}
-	err = json.Unmarshal(runnableBytes, runnable)
-	if err != nil {
-		sa.Logger.Errorf("discsaver.GetRunnable(): error unmarshaling runnable from database %v", err)
-		return hput.Runnable{}, fmt.Errorf("error unmarshaling runnable from database %w", err)
-	}
 	runnable.Path = p.Path
-	sa.Logger.Debugf("discsaver.GetRunnable(): returning runnable %+v", *runnable)
-	return *runnable, nil
+	sa.Logger.Debugf("discsaver.GetRunnable(): returning runnable of type %s", runnable.Type)
+	return runnable, nil
 }
 
-// SendRunnables returns all runnables from the database with a prefix of the given path
-func (sa *Saver) SendRunnables(_ context.Context, p string, runnables chan<- hput.Runnable, done chan<- bool) error {
-	defer func() { done <- true }()
-	err := sa.Db.View(func(tx *bolt.Tx) error {
-		c := tx.Bucket(bucketName).Cursor()
+// listBatch is how many paths List reads in each transaction, so a slow
+// reader does not hold one open.
+const listBatch = 100
 
-		prefix := []byte(p)
-		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
-			runnable := &hput.Runnable{}
-			err := json.Unmarshal(v, runnable)
+// List yields every path in the database with a prefix of the given one
+// that sorts after cursor, in path order.
+func (sa *Saver) List(ctx context.Context, prefix, cursor string, limit int) iter.Seq2[hput.Entry, error] {
+	return func(yield func(hput.Entry, error) bool) {
+		n := 0
+		for {
+			page, err := sa.listPage(prefix, cursor)
 			if err != nil {
-				sa.Logger.Errorf("discsaver.SendRunnables(): error marshaling runnable from database scan %v", err)
-				return fmt.Errorf("error marshaling runnable from database scan %w", err)
+				sa.Logger.Errorf("discsaver.List() could not iterate through runnables: %+v", err)
+				yield(hput.Entry{}, fmt.Errorf("could not iterate through runnables: %w", err))
+				return
+			}
+			for _, e := range page {
+				if limit > 0 && n == limit {
+					return
+				}
+				if err := ctx.Err(); err != nil {
+					yield(hput.Entry{}, err)
+					return
+				}
+				if !yield(e, nil) {
+					return
+				}
+				n++
+			}
+			if len(page) < listBatch {
+				return
+			}
+			cursor = page[len(page)-1].Path
+		}
+	}
+}
+
+// listPage reads up to listBatch paths with prefix that sort after cursor.
+func (sa *Saver) listPage(prefix, cursor string) ([]hput.Entry, error) {
+	var page []hput.Entry
+	err := sa.Db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucketName).Cursor()
+		start := max(prefix, cursor)
+		k, v := c.Seek([]byte(start))
+		if k != nil && string(k) == cursor {
+			k, v = c.Next()
+		}
+		for ; k != nil && bytes.HasPrefix(k, []byte(prefix)) && len(page) < listBatch; k, v = c.Next() {
+			var rec record
+			if err := json.Unmarshal(v, &rec); err != nil {
+				return fmt.Errorf("error reading %s: %w", k, err)
 			}
-			runnable.Path = string(k)
-			runnables <- *runnable
+			size := rec.Size
+			if rec.Blob == 0 {
+				size = int64(len(rec.Text) + len(rec.Binary))
+			}
+			page = append(page, hput.Entry{Path: string(k), Type: rec.Type, Size: size, Modified: rec.Saved})
 		}
 		return nil
 	})
+	return page, err
+}
+
+// readRecord returns the runnable stored as v, with the bytes of its blob if it has one.
+func readRecord(tx *bolt.Tx, v []byte) (hput.Runnable, error) {
+	var rec record
+	if err := json.Unmarshal(v, &rec); err != nil {
+		return hput.Runnable{}, err
+	}
+	if rec.Blob == 0 {
+		return rec.Runnable, nil
+	}
+	b, err := readBlob(tx, rec.Blob, rec.Size)
 	if err != nil {
-		sa.Logger.Errorf("discsaver.SendRunnables() could not iterate through runnables: %+v", err)
-		return fmt.Errorf("could not iterate through runnables: %w", err)
+		return hput.Runnable{}, err
 	}
-	return nil
+	rec.Runnable.Binary = b
+	return rec.Runnable, nil
 }
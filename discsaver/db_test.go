@@ -3,10 +3,14 @@ package discsaver
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"hput"
 	"net/url"
 	"os"
+	"path/filepath"
+	"sort"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"go.etcd.io/bbolt"
@@ -258,115 +262,123 @@ func Test_GetRunnable(t *testing.T) {
 	}
 }
 
-// Test_SendRunnables verify we can send runnables as a to a channel
-func Test_SendRunnables(t *testing.T) {
+// Test_List verifies that paths are listed in path order, a page at a time, without their contents
+func Test_List(t *testing.T) {
+	dbRunnables := map[string]hput.Runnable{
+		"/pth":             {Type: hput.Text, Text: "Some Text"},
+		"/some/pth":        {Type: hput.Text, Text: "Some Text"},
+		"/some/other/pth":  {Type: hput.Js, Text: "var a=1"},
+		"/some/binary/pth": {Type: hput.Binary, Binary: []byte{255, 255, 0}},
+	}
 	tt := []struct {
-		name         string
-		dbRunnables  map[string]hput.Runnable
-		p            string // path to gather runnables from
-		expRunnables map[string]hput.Runnable
+		name       string
+		p          string // prefix to list
+		cursor     string
+		limit      int
+		expEntries []hput.Entry
 	}{
 		{
-			name: "Return a single text runnable",
-			dbRunnables: map[string]hput.Runnable{
-				"/pth": {
-					Type: hput.Text,
-					Text: "Some Text",
-				},
-			},
-			expRunnables: map[string]hput.Runnable{
-				"/pth": {
-					Path: "/pth",
-					Type: hput.Text,
-					Text: "Some Text",
-				},
-			},
-			p: "/pth",
+			name:       "Return a single entry",
+			p:          "/pth",
+			expEntries: []hput.Entry{{Path: "/pth", Type: hput.Text, Size: 9}},
 		},
 		{
-			name: "Return a single empty runnable",
-			dbRunnables: map[string]hput.Runnable{
-				"/pth": {},
-			},
-			expRunnables: map[string]hput.Runnable{
-				"/pth": {
-					Path: "/pth",
-				},
+			name: "Return 3 different entries in path order",
+			p:    "/some",
+			expEntries: []hput.Entry{
+				{Path: "/some/binary/pth", Type: hput.Binary, Size: 3},
+				{Path: "/some/other/pth", Type: hput.Js, Size: 7},
+				{Path: "/some/pth", Type: hput.Text, Size: 9},
 			},
-			p: "/pth",
 		},
 		{
-			name: "Return a 3 different runnables",
-			dbRunnables: map[string]hput.Runnable{
-				"/some/pth": {
-					Type: hput.Text,
-					Text: "Some Text",
-				},
-				"/some/other/pth": {
-					Type: hput.Js,
-					Text: "var a=1",
-				},
-				"/some/binary/pth": {
-					Type:   hput.Binary,
-					Binary: []byte{255, 255, 0},
-				},
-			},
-			expRunnables: map[string]hput.Runnable{
-				"/some/pth": {
-					Path: "/some/pth",
-					Type: hput.Text,
-					Text: "Some Text",
-				},
-				"/some/other/pth": {
-					Path: "/some/other/pth",
-					Type: hput.Js,
-					Text: "var a=1",
-				},
-				"/some/binary/pth": {
-					Path:   "/some/binary/pth",
-					Type:   hput.Binary,
-					Binary: []byte{255, 255, 0},
-				},
-			},
-			p: "/some",
+			name:       "Return a page",
+			p:          "/some",
+			limit:      1,
+			expEntries: []hput.Entry{{Path: "/some/binary/pth", Type: hput.Binary, Size: 3}},
+		},
+		{
+			name:       "Return the page after a cursor",
+			p:          "/some",
+			cursor:     "/some/binary/pth",
+			limit:      1,
+			expEntries: []hput.Entry{{Path: "/some/other/pth", Type: hput.Js, Size: 7}},
+		},
+		{
+			name:   "Return nothing past the prefix",
+			p:      "/some",
+			cursor: "/some/pth",
 		},
 	}
+	sa, err := New(&TestLogger{}, filepath.Join(t.TempDir(), "unit_test.db"))
+	assert.NoError(t, err)
+	defer sa.Shutdown()
+	// setup the database with records saved before their time was recorded
+	for key, dbRunnable := range dbRunnables {
+		dbBytes, err := json.Marshal(dbRunnable)
+		assert.NoError(t, err)
+		assert.NoError(t, sa.Db.Update(func(tx *bbolt.Tx) error {
+			return tx.Bucket(bucketName).Put([]byte(key), dbBytes)
+		}))
+	}
 	for _, test := range tt {
 		t.Run(test.name, func(t *testing.T) {
-			os.Remove("unit_test.db")
-			defer os.Remove("unit_test.db")
-			sa, err := New(&TestLogger{}, "unit_test.db")
-			assert.NoError(t, err)
-			// setup the database with elements
-			for key, dbRunnable := range test.dbRunnables {
-				dbBytes, err := json.Marshal(dbRunnable)
+			var entries []hput.Entry
+			for e, err := range sa.List(context.Background(), test.p, test.cursor, test.limit) {
 				assert.NoError(t, err)
-				sa.Db.Update(func(tx *bbolt.Tx) error {
-					b := tx.Bucket(bucketName)
-					err := b.Put([]byte(key), dbBytes)
-					assert.NoError(t, err)
-					return err
-				})
-			}
-
-			runnablesChan := make(chan hput.Runnable, 1)
-			doneChan := make(chan bool, 1)
-			go func() {
-				sa.SendRunnables(context.Background(), test.p, runnablesChan, doneChan)
-			}()
-			var done bool
-			for done == false {
-				select {
-				case run := <-runnablesChan:
-					val, ok := test.expRunnables[run.Path]
-					assert.True(t, ok, "runnable %+v not found", run)
-					assert.Equal(t, val, run)
-					delete(test.dbRunnables, run.Path)
-				case <-doneChan:
-					done = true
-				}
+				entries = append(entries, e)
 			}
-			assert.Empty(t, test.dbRunnables)
+			assert.Equal(t, test.expEntries, entries)
 		})
 	}
 }
+
+// Test_ListBatches verifies that listing carries on across transactions, and stops when cancelled
+func Test_ListBatches(t *testing.T) {
+	ctx := context.Background()
+	sa, err := New(&TestLogger{}, filepath.Join(t.TempDir(), "unit_test.db"))
+	assert.NoError(t, err)
+	defer sa.Shutdown()
+	before := time.Now()
+	for i := range listBatch + 50 {
+		assert.NoError(t, sa.SaveText(ctx, "x", url.URL{Path: fmt.Sprintf("/p/%03d", i)}, &hput.PutResult{}))
+	}
+	var paths []string
+	for e, err := range sa.List(ctx, "/p/", "", 0) {
+		assert.NoError(t, err)
+		assert.False(t, e.Modified.Before(before), "saves record when they were made")
+		paths = append(paths, e.Path)
+	}
+	assert.Len(t, paths, listBatch+50)
+	assert.True(t, sort.StringsAreSorted(paths))
+
+	cancelled, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var errs []error
+	for _, err := range sa.List(cancelled, "/p/", "", 0) {
+		cancel()
+		errs = append(errs, err)
+	}
+	assert.Equal(t, []error{nil, context.Canceled}, errs)
+}
+
+// Test_Delete verifies that deleting a path removes it but keeps its versions
+func Test_Delete(t *testing.T) {
+	ctx := context.Background()
+	sa, err := New(&TestLogger{}, filepath.Join(t.TempDir(), "unit_test.db"))
+	assert.NoError(t, err)
+	defer sa.Shutdown()
+	p := url.URL{Path: "/gone"}
+	assert.NoError(t, sa.SaveText(ctx, "text", p, &hput.PutResult{}))
+	assert.NoError(t, sa.Delete(ctx, p))
+	r, err := sa.GetRunnable(ctx, p)
+	assert.NoError(t, err)
+	assert.Equal(t, hput.Runnable{}, r)
+	versions, err := sa.Versions(ctx, "/gone")
+	assert.NoError(t, err)
+	assert.Len(t, versions, 1)
+	assert.NoError(t, sa.Delete(ctx, p), "deleting nothing succeeds")
+	res := hput.PutResult{}
+	assert.NoError(t, sa.SaveText(ctx, "again", p, &res))
+	assert.False(t, res.Overwrote)
+}
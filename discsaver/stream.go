@@ -0,0 +1,331 @@
+package discsaver
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hput"
+	"io"
+	"net/url"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// blobsBucket holds a bucket per binary saved with SaveStream, keyed by
+// big-endian blob ID, holding its bytes in chunks keyed by big-endian index.
+var blobsBucket = []byte("hput-blobs")
+
+// chunkSize is how many bytes of a blob each key holds.
+const chunkSize = 1 << 20
+
+// blobBatch is how many chunks of a blob are written in one transaction, and
+// so about how much of an upload, in chunks, is held in memory at once.
+const blobBatch = 4
+
+// SaveStream saves a binary read from b to a path. Its bytes are written
+// blobBatch chunks at a time, each batch in its own transaction, so a large
+// upload is never held in memory or in one transaction. The path only
+// changes once the whole binary is written. A blob left part written, by an
+// error or by the process stopping, is deleted, if not at once then when the
+// database is next opened.
+func (sa *Saver) SaveStream(ctx context.Context, b io.Reader, p url.URL, r *hput.PutResult) error {
+	id, size, err := sa.writeBlob(ctx, b)
+	if err != nil {
+		sa.Logger.Errorf("discsaver.SaveStream(): error writing binary for %s: %v", p.Path, err)
+		sa.dropBlob(id)
+		return fmt.Errorf("error writing binary: %w", err)
+	}
+	rec := record{Runnable: hput.Runnable{Type: hput.Binary}, Blob: id, Size: size}
+	if err := sa.saveRecord(rec, p, r); err != nil {
+		sa.dropBlob(id)
+		return err
+	}
+	return nil
+}
+
+// writeBlob copies b into a new blob and returns its ID and length. The ID
+// is set even on error, so a partly written blob can be dropped.
+func (sa *Saver) writeBlob(ctx context.Context, b io.Reader) (uint64, int64, error) {
+	var id uint64
+	err := sa.Db.Update(func(tx *bolt.Tx) error {
+		blobs := tx.Bucket(blobsBucket)
+		var err error
+		if id, err = blobs.NextSequence(); err != nil {
+			return err
+		}
+		_, err = blobs.CreateBucket(idKey(id))
+		return err
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	var size int64
+	bufs := make([][]byte, blobBatch) // made as needed, so small uploads stay small
+	for idx := uint64(0); ; {
+		if err := ctx.Err(); err != nil {
+			return id, size, err
+		}
+		var chunks [][]byte
+		var rerr error
+		for len(chunks) < blobBatch && rerr == nil {
+			if bufs[len(chunks)] == nil {
+				bufs[len(chunks)] = make([]byte, chunkSize)
+			}
+			var n int
+			n, rerr = io.ReadFull(b, bufs[len(chunks)])
+			if n > 0 {
+				chunks = append(chunks, bufs[len(chunks)][:n])
+			}
+		}
+		if len(chunks) > 0 {
+			werr := sa.Db.Update(func(tx *bolt.Tx) error {
+				bb := tx.Bucket(blobsBucket).Bucket(idKey(id))
+				for i, chunk := range chunks {
+					if err := bb.Put(idKey(idx+uint64(i)), chunk); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if werr != nil {
+				return id, size, werr
+			}
+			for _, chunk := range chunks {
+				size += int64(len(chunk))
+			}
+			idx += uint64(len(chunks))
+		}
+		if errors.Is(rerr, io.EOF) || errors.Is(rerr, io.ErrUnexpectedEOF) {
+			return id, size, nil
+		}
+		if rerr != nil {
+			return id, size, rerr
+		}
+	}
+}
+
+// dropBlob deletes a blob that no path or version refers to.
+func (sa *Saver) dropBlob(id uint64) {
+	if id == 0 {
+		return
+	}
+	err := sa.Db.Update(func(tx *bolt.Tx) error {
+		return deleteBlob(tx, id)
+	})
+	if err != nil {
+		sa.Logger.Errorf("discsaver.dropBlob(): could not delete blob %d: %v", id, err)
+	}
+}
+
+// deleteBlob deletes a blob's chunks. Deleting a missing blob succeeds.
+func deleteBlob(tx *bolt.Tx, id uint64) error {
+	err := tx.Bucket(blobsBucket).DeleteBucket(idKey(id))
+	if errors.Is(err, bolt.ErrBucketNotFound) {
+		return nil
+	}
+	return err
+}
+
+// sweepBlobs deletes the blobs no path or version refers to, which an
+// upload leaves behind if the process stops part way, and returns how many.
+func sweepBlobs(tx *bolt.Tx) (int, error) {
+	blobs := tx.Bucket(blobsBucket)
+	if k, _ := blobs.Cursor().First(); k == nil {
+		return 0, nil
+	}
+	kept := map[uint64]bool{}
+	err := tx.Bucket(bucketName).ForEach(func(_, v []byte) error {
+		if len(v) == 0 {
+			return nil
+		}
+		var rec record
+		if err := json.Unmarshal(v, &rec); err != nil {
+			return fmt.Errorf("reading record: %w", err)
+		}
+		kept[rec.Blob] = true
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	versions := tx.Bucket(versionsBucket)
+	err = versions.ForEach(func(k, _ []byte) error {
+		vb := versions.Bucket(k)
+		if vb == nil {
+			return nil
+		}
+		return vb.ForEach(func(_, v []byte) error {
+			var ver version
+			if err := json.Unmarshal(v, &ver); err != nil {
+				return fmt.Errorf("reading version: %w", err)
+			}
+			kept[ver.Runnable.Blob] = true
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+	var orphans [][]byte
+	err = blobs.ForEach(func(k, v []byte) error {
+		if v == nil && len(k) == 8 && !kept[binary.BigEndian.Uint64(k)] {
+			orphans = append(orphans, k)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	// Delete after iterating; deleting during ForEach can skip keys.
+	for _, k := range orphans {
+		if err := blobs.DeleteBucket(k); err != nil {
+			return 0, err
+		}
+	}
+	return len(orphans), nil
+}
+
+// readBlob returns all size bytes of a blob.
+func readBlob(tx *bolt.Tx, id uint64, size int64) ([]byte, error) {
+	bb := tx.Bucket(blobsBucket).Bucket(idKey(id))
+	if bb == nil {
+		return nil, fmt.Errorf("blob %d is missing", id)
+	}
+	b := make([]byte, 0, size)
+	c := bb.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		b = append(b, v...)
+	}
+	return b, nil
+}
+
+// GetStream returns the binary at a path, read a chunk at a time, or nil if
+// the path does not hold a binary.
+func (sa *Saver) GetStream(ctx context.Context, p url.URL) (io.ReadSeekCloser, error) {
+	_, rs, err := sa.GetRunnableStream(ctx, p)
+	return rs, err
+}
+
+// GetRunnableStream reads a path's record once and returns the binary there
+// as GetStream does, or if the path does not hold a binary, what GetRunnable
+// would return.
+func (sa *Saver) GetRunnableStream(_ context.Context, p url.URL) (hput.Runnable, io.ReadSeekCloser, error) {
+	var rec record
+	err := sa.Db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(bucketName).Get([]byte(p.Path))
+		if len(v) == 0 {
+			return nil
+		}
+		return json.Unmarshal(v, &rec)
+	})
+	if err != nil {
+		sa.Logger.Errorf("discsaver.GetRunnableStream(): error retrieving runnable from database %v", err)
+		return hput.Runnable{}, nil, fmt.Errorf("error retrieving runnable from database: %w", err)
+	}
+	switch {
+	case rec.Type == "":
+		return hput.Runnable{}, nil, nil
+	case rec.Type != hput.Binary:
+		rec.Runnable.Path = p.Path
+		return rec.Runnable, nil, nil
+	case rec.Blob == 0:
+		sum := sha256.Sum256(rec.Binary)
+		return hput.Runnable{}, memReader{bytes.NewReader(rec.Binary), fmt.Sprintf(`"%x"`, sum[:16]), rec.Saved}, nil
+	}
+	return hput.Runnable{}, &blobReader{db: sa.Db, id: rec.Blob, size: rec.Size, saved: rec.Saved}, nil
+}
+
+// memReader reads a binary kept in its record.
+type memReader struct {
+	*bytes.Reader
+	etag  string
+	saved time.Time
+}
+
+func (memReader) Close() error { return nil }
+
+// ETag is a hash of the binary.
+func (m memReader) ETag() string { return m.etag }
+
+// ModTime is when the binary was saved, or zero if that was not recorded.
+func (m memReader) ModTime() time.Time { return m.saved }
+
+// blobReader reads a blob one chunk at a time, each in its own transaction.
+// If the blob is deleted while it is being read, Read fails.
+type blobReader struct {
+	db    *bolt.DB
+	id    uint64
+	size  int64
+	saved time.Time
+	pos   int64
+
+	chunk    []byte // copy of the chunk at index chunkIdx, or nil
+	chunkIdx int64
+}
+
+func (b *blobReader) Read(p []byte) (int, error) {
+	if b.pos >= b.size {
+		return 0, io.EOF
+	}
+	idx := b.pos / chunkSize
+	if b.chunk == nil || b.chunkIdx != idx {
+		b.chunk = nil
+		err := b.db.View(func(tx *bolt.Tx) error {
+			bb := tx.Bucket(blobsBucket).Bucket(idKey(b.id))
+			if bb == nil {
+				return nil
+			}
+			if v := bb.Get(idKey(uint64(idx))); v != nil {
+				b.chunk = append([]byte{}, v...)
+			}
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+		if b.chunk == nil {
+			return 0, fmt.Errorf("blob %d was deleted while being read: %w", b.id, io.ErrUnexpectedEOF)
+		}
+		b.chunkIdx = idx
+	}
+	n := copy(p, b.chunk[b.pos-idx*chunkSize:])
+	b.pos += int64(n)
+	return n, nil
+}
+
+func (b *blobReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += b.pos
+	case io.SeekEnd:
+		offset += b.size
+	default:
+		return 0, errors.New("discsaver: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("discsaver: negative position")
+	}
+	b.pos = offset
+	return offset, nil
+}
+
+// ETag names the blob, which is written once and never changed.
+func (b *blobReader) ETag() string {
+	return fmt.Sprintf(`"blob-%d"`, b.id)
+}
+
+// ModTime is when the binary was saved, or zero if that was not recorded.
+func (b *blobReader) ModTime() time.Time {
+	return b.saved
+}
+
+func (b *blobReader) Close() error {
+	b.chunk = nil
+	return nil
+}
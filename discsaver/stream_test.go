@@ -0,0 +1,153 @@
+package discsaver
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hput"
+	"io"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/bbolt"
+)
+
+// Test_SaveStream verifies that streamed binaries are stored in chunks and read back
+func Test_SaveStream(t *testing.T) {
+	tt := []struct {
+		name string
+		size int
+	}{
+		{name: "empty", size: 0},
+		{name: "one chunk", size: 1000},
+		{name: "several chunks", size: 2*chunkSize + 10},
+		{name: "several batches", size: (blobBatch+1)*chunkSize + 10},
+	}
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			sa, err := New(&TestLogger{}, filepath.Join(t.TempDir(), "unit_test.db"))
+			assert.NoError(t, err)
+			defer sa.Shutdown()
+			want := bytes.Repeat([]byte{0xff, 1, 2}, test.size/3+1)[:test.size]
+			p := url.URL{Path: "/video"}
+			assert.NoError(t, sa.SaveStream(ctx, bytes.NewReader(want), p, &hput.PutResult{}))
+
+			r, err := sa.GetRunnable(ctx, p)
+			assert.NoError(t, err)
+			assert.Equal(t, hput.Input(hput.Binary), r.Type)
+			assert.True(t, bytes.Equal(want, r.Binary))
+
+			rs, err := sa.GetStream(ctx, p)
+			assert.NoError(t, err)
+			defer rs.Close()
+			got, err := io.ReadAll(rs)
+			assert.NoError(t, err)
+			assert.True(t, bytes.Equal(want, got))
+
+			_, err = rs.Seek(int64(max(len(want)-5, 0)), io.SeekStart)
+			assert.NoError(t, err)
+			got, err = io.ReadAll(rs)
+			assert.NoError(t, err)
+			assert.Equal(t, want[max(len(want)-5, 0):], got)
+		})
+	}
+}
+
+// Test_GetRunnableStream verifies a path is read once as a stream for a binary, with when it
+// was saved, and as a runnable otherwise
+func Test_GetRunnableStream(t *testing.T) {
+	ctx := context.Background()
+	sa, err := New(&TestLogger{}, filepath.Join(t.TempDir(), "unit_test.db"))
+	assert.NoError(t, err)
+	defer sa.Shutdown()
+	before := time.Now().Add(-time.Second)
+	assert.NoError(t, sa.SaveStream(ctx, bytes.NewReader([]byte{0xff, 0}), url.URL{Path: "/blob"}, &hput.PutResult{}))
+	assert.NoError(t, sa.SaveBinary(ctx, []byte{0xff, 1}, url.URL{Path: "/bin"}, &hput.PutResult{}))
+	assert.NoError(t, sa.SaveText(ctx, "hi", url.URL{Path: "/text"}, &hput.PutResult{}))
+
+	for _, p := range []string{"/blob", "/bin"} {
+		r, rs, err := sa.GetRunnableStream(ctx, url.URL{Path: p})
+		assert.NoError(t, err, p)
+		assert.Equal(t, hput.Runnable{}, r, p)
+		info, ok := rs.(interface{ ModTime() time.Time })
+		assert.True(t, ok, p)
+		assert.True(t, info.ModTime().After(before), p)
+		rs.Close()
+	}
+
+	r, rs, err := sa.GetRunnableStream(ctx, url.URL{Path: "/text"})
+	assert.NoError(t, err)
+	assert.Nil(t, rs)
+	assert.Equal(t, hput.Runnable{Path: "/text", Type: hput.Text, Text: "hi"}, r)
+
+	r, rs, err = sa.GetRunnableStream(ctx, url.URL{Path: "/missing"})
+	assert.NoError(t, err)
+	assert.Nil(t, rs)
+	assert.Equal(t, hput.Runnable{}, r)
+}
+
+// Test_SaveStreamDropsBlobs verifies that a blob is deleted once no version keeps it
+func Test_SaveStreamDropsBlobs(t *testing.T) {
+	ctx := context.Background()
+	sa, err := New(&TestLogger{}, filepath.Join(t.TempDir(), "unit_test.db"))
+	assert.NoError(t, err)
+	defer sa.Shutdown()
+	sa.Retention = hput.Retention{Count: 1}
+	p := url.URL{Path: "/video"}
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, sa.SaveStream(ctx, bytes.NewReader([]byte{0xff, byte(i)}), p, &hput.PutResult{}))
+	}
+	assert.NoError(t, sa.SaveText(ctx, "gone", p, &hput.PutResult{}))
+
+	blobs := 0
+	sa.Db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(blobsBucket).ForEach(func(k, v []byte) error {
+			blobs++
+			return nil
+		})
+	})
+	assert.Equal(t, 1, blobs, "only the blob of the one kept earlier version is left")
+	r, err := sa.GetVersion(ctx, "/video", 3)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0xff, 2}, r.Binary)
+
+	rs, err := sa.GetStream(ctx, p)
+	assert.NoError(t, err)
+	assert.Nil(t, rs, "text is not streamed")
+}
+
+// Test_SweepBlobs verifies that opening the database deletes blobs left by an upload that never finished
+func Test_SweepBlobs(t *testing.T) {
+	ctx := context.Background()
+	f := filepath.Join(t.TempDir(), "unit_test.db")
+	sa, err := New(&TestLogger{}, f)
+	assert.NoError(t, err)
+	sa.Retention = hput.Retention{Count: 2}
+	assert.NoError(t, sa.SaveStream(ctx, bytes.NewReader([]byte{0xff, 1}), url.URL{Path: "/old"}, &hput.PutResult{}))
+	assert.NoError(t, sa.SaveText(ctx, "replaced", url.URL{Path: "/old"}, &hput.PutResult{}))
+	assert.NoError(t, sa.SaveStream(ctx, bytes.NewReader([]byte{0xff, 2}), url.URL{Path: "/video"}, &hput.PutResult{}))
+	// An upload stopped part way leaves a blob nothing refers to.
+	orphan, _, err := sa.writeBlob(ctx, bytes.NewReader([]byte{0xff, 3}))
+	assert.NoError(t, err)
+	sa.Shutdown()
+
+	sa, err = New(&TestLogger{}, f)
+	assert.NoError(t, err)
+	defer sa.Shutdown()
+	var ids []uint64
+	sa.Db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(blobsBucket).ForEach(func(k, v []byte) error {
+			ids = append(ids, binary.BigEndian.Uint64(k))
+			return nil
+		})
+	})
+	assert.Len(t, ids, 2, "the blobs of a path and of an earlier version are kept")
+	assert.NotContains(t, ids, orphan)
+	r, err := sa.GetVersion(ctx, "/old", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0xff, 1}, r.Binary)
+}
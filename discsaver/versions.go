@@ -0,0 +1,267 @@
+package discsaver
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hput"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// versionsBucket holds a bucket per path of every version saved to it,
+// keyed by big-endian version ID so they sort oldest first.
+var versionsBucket = []byte("hput-versions")
+
+// version is how one version is stored. The newest version of a path is
+// what its record holds, so rather than store it twice it is kept Current,
+// without its text or bytes, and Size is their length. It is written out in
+// full from the record once the record is replaced or deleted.
+type version struct {
+	Runnable record
+	Saved    time.Time
+	Current  bool `json:",omitempty"`
+}
+
+// current returns the version kept for rec, which the path's record holds.
+func current(rec record, now time.Time) version {
+	stub := rec
+	stub.Text, stub.Binary = "", nil
+	if rec.Blob == 0 {
+		stub.Size = int64(len(rec.Text) + len(rec.Binary))
+	}
+	return version{Runnable: stub, Saved: now, Current: true}
+}
+
+// idKey encodes an ID big-endian, so keys sort in ID order.
+func idKey(id uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, id)
+	return k
+}
+
+// addVersion records rec, which path's record now holds, as its newest
+// version and drops the earlier versions sa.Retention no longer keeps, with
+// the blobs nothing else refers to. existing is what the record held
+// before, which the version kept for it is filled in from, or which is kept
+// as version 1 if it was saved before versions were kept.
+func (sa *Saver) addVersion(tx *bolt.Tx, path string, existing []byte, rec record, now time.Time) error {
+	vb, err := tx.Bucket(versionsBucket).CreateBucketIfNotExists([]byte(path))
+	if err != nil {
+		return err
+	}
+	k, _ := vb.Cursor().Last()
+	switch {
+	case k == nil && len(existing) > 0:
+		var old record
+		if err := json.Unmarshal(existing, &old); err != nil {
+			return fmt.Errorf("reading version saved before history: %w", err)
+		}
+		if err := putVersion(vb, version{Runnable: old}); err != nil {
+			return err
+		}
+	case k != nil:
+		if err := writeOut(vb, existing); err != nil {
+			return err
+		}
+	}
+	if err := putVersion(vb, current(rec, now)); err != nil {
+		return err
+	}
+
+	var dead [][]byte
+	deadBlobs := map[uint64]bool{}
+	keptBlobs := map[uint64]bool{rec.Blob: true}
+	n := 0
+	c := vb.Cursor()
+	k, v := c.Last()
+	if err := keepBlob(keptBlobs, v); err != nil {
+		return err
+	}
+	for k, v = c.Prev(); k != nil; k, v = c.Prev() {
+		var ver version
+		if err := json.Unmarshal(v, &ver); err != nil {
+			return fmt.Errorf("reading version: %w", err)
+		}
+		if sa.Retention.Keep(n, ver.Saved, now) {
+			keptBlobs[ver.Runnable.Blob] = true
+		} else {
+			dead = append(dead, k)
+			deadBlobs[ver.Runnable.Blob] = true
+		}
+		n++
+	}
+	// Delete after iterating; deleting under a live cursor can skip keys.
+	for _, k := range dead {
+		if err := vb.Delete(k); err != nil {
+			return err
+		}
+	}
+	// A blob is shared by every version rolled back to it.
+	for id := range deadBlobs {
+		if id == 0 || keptBlobs[id] {
+			continue
+		}
+		if err := deleteBlob(tx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// keepBlob adds the blob of the version stored as v to kept.
+func keepBlob(kept map[uint64]bool, v []byte) error {
+	var ver version
+	if err := json.Unmarshal(v, &ver); err != nil {
+		return fmt.Errorf("reading version: %w", err)
+	}
+	kept[ver.Runnable.Blob] = true
+	return nil
+}
+
+// writeOut fills in the newest version in vb, if it is kept Current, from
+// existing, the record it was kept for, which is about to be replaced or
+// deleted.
+func writeOut(vb *bolt.Bucket, existing []byte) error {
+	k, v := vb.Cursor().Last()
+	if k == nil || len(existing) == 0 {
+		return nil
+	}
+	var ver version
+	if err := json.Unmarshal(v, &ver); err != nil {
+		return fmt.Errorf("reading version: %w", err)
+	}
+	if !ver.Current {
+		return nil
+	}
+	var rec record
+	if err := json.Unmarshal(existing, &rec); err != nil {
+		return fmt.Errorf("reading record: %w", err)
+	}
+	b, err := json.Marshal(version{Runnable: rec, Saved: ver.Saved})
+	if err != nil {
+		return err
+	}
+	return vb.Put(k, b)
+}
+
+// putVersion stores v under the path's next version ID.
+func putVersion(vb *bolt.Bucket, v version) error {
+	id, err := vb.NextSequence()
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return vb.Put(idKey(id), b)
+}
+
+// Versions lists the kept versions of a path, newest first
+func (sa *Saver) Versions(_ context.Context, path string) ([]hput.Version, error) {
+	var versions []hput.Version
+	err := sa.Db.View(func(tx *bolt.Tx) error {
+		vb := tx.Bucket(versionsBucket).Bucket([]byte(path))
+		if vb == nil {
+			return nil
+		}
+		c := vb.Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			var ver version
+			if err := json.Unmarshal(v, &ver); err != nil {
+				return err
+			}
+			versions = append(versions, hput.Version{
+				ID:    int(binary.BigEndian.Uint64(k)),
+				Saved: ver.Saved,
+				Type:  ver.Runnable.Type,
+				Size:  len(ver.Runnable.Text) + len(ver.Runnable.Binary) + int(ver.Runnable.Size),
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		sa.Logger.Errorf("discsaver.Versions(): error listing versions of %s: %v", path, err)
+		return nil, fmt.Errorf("error listing versions: %w", err)
+	}
+	return versions, nil
+}
+
+// GetVersion returns one version of a path, or an empty runnable if it is not kept
+func (sa *Saver) GetVersion(_ context.Context, path string, id int) (hput.Runnable, error) {
+	var ru hput.Runnable
+	err := sa.Db.View(func(tx *bolt.Tx) error {
+		vb := tx.Bucket(versionsBucket).Bucket([]byte(path))
+		if vb == nil || id < 1 {
+			return nil
+		}
+		v := vb.Get(idKey(uint64(id)))
+		if v == nil {
+			return nil
+		}
+		var ver version
+		if err := json.Unmarshal(v, &ver); err != nil {
+			return fmt.Errorf("error unmarshaling version: %w", err)
+		}
+		if ver.Current {
+			var err error
+			ru, err = readRecord(tx, tx.Bucket(bucketName).Get([]byte(path)))
+			return err
+		}
+		ru = ver.Runnable.Runnable
+		if ver.Runnable.Blob == 0 {
+			return nil
+		}
+		var err error
+		ru.Binary, err = readBlob(tx, ver.Runnable.Blob, ver.Runnable.Size)
+		return err
+	})
+	if err != nil {
+		sa.Logger.Errorf("discsaver.GetVersion(): error retrieving version %d of %s: %v", id, path, err)
+		return hput.Runnable{}, fmt.Errorf("error retrieving version: %w", err)
+	}
+	if ru.Type == "" {
+		return hput.Runnable{}, nil
+	}
+	ru.Path = path
+	return ru, nil
+}
+
+// Rollback saves version id of a path again and reports false if that
+// version is not kept. A streamed binary's blob is shared with the version
+// rather than copied, so it is never read.
+func (sa *Saver) Rollback(_ context.Context, path string, id int) (bool, error) {
+	found := false
+	err := sa.Db.Update(func(tx *bolt.Tx) error {
+		vb := tx.Bucket(versionsBucket).Bucket([]byte(path))
+		if vb == nil || id < 1 {
+			return nil
+		}
+		v := vb.Get(idKey(uint64(id)))
+		if v == nil {
+			return nil
+		}
+		var ver version
+		if err := json.Unmarshal(v, &ver); err != nil {
+			return fmt.Errorf("error unmarshaling version: %w", err)
+		}
+		rec := ver.Runnable
+		if ver.Current {
+			existing := tx.Bucket(bucketName).Get([]byte(path))
+			if err := json.Unmarshal(existing, &rec); err != nil {
+				return fmt.Errorf("reading record: %w", err)
+			}
+		}
+		found = true
+		_, err := sa.putRecord(tx, path, rec)
+		return err
+	})
+	if err != nil {
+		sa.Logger.Errorf("discsaver.Rollback(): error rolling %s back to version %d: %v", path, id, err)
+		return false, fmt.Errorf("error rolling back: %w", err)
+	}
+	return found, nil
+}
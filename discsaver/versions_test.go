@@ -0,0 +1,182 @@
+package discsaver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"hput"
+	"io"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/bbolt"
+)
+
+// Test_Versions verifies that saves are kept as versions within the retention
+func Test_Versions(t *testing.T) {
+	tt := []struct {
+		name      string
+		retention hput.Retention
+		ids       []int
+	}{
+		{name: "keep none", ids: []int{4}},
+		{name: "keep two", retention: hput.Retention{Count: 2}, ids: []int{4, 3, 2}},
+		{name: "keep more than saved", retention: hput.Retention{Count: 10}, ids: []int{4, 3, 2, 1}},
+		{name: "too old", retention: hput.Retention{Count: 10, Age: time.Nanosecond}, ids: []int{4}},
+	}
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			sa, err := New(&TestLogger{}, filepath.Join(t.TempDir(), "unit_test.db"))
+			assert.NoError(t, err)
+			defer sa.Shutdown()
+			sa.Retention = test.retention
+			p := url.URL{Path: "/pth"}
+			for _, s := range []string{"one", "two", "three"} {
+				assert.NoError(t, sa.SaveText(ctx, s, p, &hput.PutResult{}))
+			}
+			assert.NoError(t, sa.SaveCode(ctx, "'four'", p, &hput.PutResult{}))
+
+			versions, err := sa.Versions(ctx, "/pth")
+			assert.NoError(t, err)
+			var ids []int
+			for _, v := range versions {
+				ids = append(ids, v.ID)
+			}
+			assert.Equal(t, test.ids, ids)
+			assert.Equal(t, hput.Input(hput.Js), versions[0].Type)
+			assert.Equal(t, 6, versions[0].Size)
+
+			r, err := sa.GetVersion(ctx, "/pth", 4)
+			assert.NoError(t, err)
+			assert.Equal(t, hput.Runnable{Path: "/pth", Type: hput.Js, Text: "'four'"}, r)
+		})
+	}
+}
+
+// Test_VersionsBeforeHistory verifies that content saved before versions
+// were kept becomes version 1 when it is overwritten
+func Test_VersionsBeforeHistory(t *testing.T) {
+	ctx := context.Background()
+	sa, err := New(&TestLogger{}, filepath.Join(t.TempDir(), "unit_test.db"))
+	assert.NoError(t, err)
+	defer sa.Shutdown()
+	sa.Retention = hput.Retention{Count: 5}
+	old, _ := json.Marshal(hput.Runnable{Type: hput.Text, Text: "old"})
+	assert.NoError(t, sa.Db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucketName).Put([]byte("/pth"), old)
+	}))
+
+	assert.NoError(t, sa.SaveText(ctx, "new", url.URL{Path: "/pth"}, &hput.PutResult{}))
+	versions, err := sa.Versions(ctx, "/pth")
+	assert.NoError(t, err)
+	assert.Len(t, versions, 2)
+	assert.Equal(t, 1, versions[1].ID)
+	assert.True(t, versions[1].Saved.IsZero())
+	r, err := sa.GetVersion(ctx, "/pth", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "old", r.Text)
+
+	r, err = sa.GetVersion(ctx, "/pth", 9)
+	assert.NoError(t, err)
+	assert.Equal(t, hput.Runnable{}, r)
+}
+
+// Test_VersionsStoredOnce verifies the newest version refers to the record rather than holding a
+// copy, and is written out when the record is replaced or deleted
+func Test_VersionsStoredOnce(t *testing.T) {
+	ctx := context.Background()
+	sa, err := New(&TestLogger{}, filepath.Join(t.TempDir(), "unit_test.db"))
+	assert.NoError(t, err)
+	defer sa.Shutdown()
+	sa.Retention = hput.Retention{Count: 5}
+	p := url.URL{Path: "/pth"}
+	stored := func(id int) version {
+		var ver version
+		assert.NoError(t, sa.Db.View(func(tx *bbolt.Tx) error {
+			return json.Unmarshal(tx.Bucket(versionsBucket).Bucket([]byte("/pth")).Get(idKey(uint64(id))), &ver)
+		}))
+		return ver
+	}
+
+	assert.NoError(t, sa.SaveText(ctx, "one", p, &hput.PutResult{}))
+	assert.True(t, stored(1).Current)
+	assert.Empty(t, stored(1).Runnable.Text)
+	versions, err := sa.Versions(ctx, "/pth")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, versions[0].Size)
+	r, err := sa.GetVersion(ctx, "/pth", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, hput.Runnable{Path: "/pth", Type: hput.Text, Text: "one"}, r)
+
+	assert.NoError(t, sa.SaveText(ctx, "two", p, &hput.PutResult{}))
+	assert.False(t, stored(1).Current)
+	assert.Equal(t, "one", stored(1).Runnable.Text)
+	assert.False(t, stored(1).Saved.IsZero())
+	assert.True(t, stored(2).Current)
+
+	assert.NoError(t, sa.Delete(ctx, p))
+	assert.False(t, stored(2).Current)
+	r, err = sa.GetVersion(ctx, "/pth", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, hput.Runnable{Path: "/pth", Type: hput.Text, Text: "two"}, r)
+}
+
+// Test_Rollback verifies rolling back shares a streamed binary's blob with its version, and keeps
+// the blob while either needs it
+func Test_Rollback(t *testing.T) {
+	ctx := context.Background()
+	sa, err := New(&TestLogger{}, filepath.Join(t.TempDir(), "unit_test.db"))
+	assert.NoError(t, err)
+	defer sa.Shutdown()
+	sa.Retention = hput.Retention{Count: 1}
+	p := url.URL{Path: "/video"}
+	blobs := func() int {
+		n := 0
+		assert.NoError(t, sa.Db.View(func(tx *bbolt.Tx) error {
+			return tx.Bucket(blobsBucket).ForEach(func(_, _ []byte) error {
+				n++
+				return nil
+			})
+		}))
+		return n
+	}
+	assert.NoError(t, sa.SaveStream(ctx, bytes.NewReader([]byte{0xff, 1}), p, &hput.PutResult{}))
+	assert.NoError(t, sa.SaveText(ctx, "two", p, &hput.PutResult{}))
+
+	found, err := sa.Rollback(ctx, "/pth", 1)
+	assert.NoError(t, err)
+	assert.False(t, found, "a path without versions")
+	found, err = sa.Rollback(ctx, "/video", 9)
+	assert.NoError(t, err)
+	assert.False(t, found, "a version that is not kept")
+
+	found, err = sa.Rollback(ctx, "/video", 1)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, 1, blobs(), "the blob is shared, not copied")
+	versions, err := sa.Versions(ctx, "/video")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, versions[0].ID)
+	assert.Equal(t, 2, versions[0].Size)
+
+	// Version 1 is dropped, but the record still holds its blob.
+	assert.NoError(t, sa.SaveText(ctx, "four", p, &hput.PutResult{}))
+	assert.Equal(t, 1, blobs())
+	found, err = sa.Rollback(ctx, "/video", 3)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	rs, err := sa.GetStream(ctx, p)
+	assert.NoError(t, err)
+	b, err := io.ReadAll(rs)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0xff, 1}, b)
+	rs.Close()
+
+	assert.NoError(t, sa.SaveText(ctx, "six", p, &hput.PutResult{}))
+	assert.NoError(t, sa.SaveText(ctx, "seven", p, &hput.PutResult{}))
+	assert.Zero(t, blobs(), "no version or record refers to the blob")
+}
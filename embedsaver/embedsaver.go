@@ -0,0 +1,146 @@
+// Package embedsaver implements the hput.Saver interface over a read-only
+// fs.FS, such as a site compiled into the binary with go:embed, so a locked
+// server ships as one file with nothing to write to disk.
+package embedsaver
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hput"
+	"hput/internal/sitefs"
+	"io"
+	"io/fs"
+	"iter"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// errReadOnly is returned by every save.
+var errReadOnly = errors.New("embedded site is read only")
+
+// Logger logs out.
+type Logger interface {
+	Debug(msg string)
+	Debugf(msg string, args ...interface{})
+	Errorf(msg string, args ...interface{})
+}
+
+// Saver serves each path from a file in FS, laid out as dirsaver lays out
+// its root: a path ending in "/" is that directory's index.html, and a
+// sidecar beside a file, .<file>.hput, records its type. A file without a
+// sidecar is text if it looks like text and binary otherwise. Paths with a
+// segment starting with "." are never served.
+type Saver struct {
+	FS     fs.FS
+	Logger Logger
+
+	etags sync.Map // file name to the ETag of its binary, as files never change
+}
+
+// New creates a saver serving the files in fsys.
+func New(l Logger, fsys fs.FS) *Saver {
+	return &Saver{FS: fsys, Logger: l}
+}
+
+// SaveText returns an error, as nothing can be saved to an embedded site
+func (sa *Saver) SaveText(_ context.Context, _ string, _ url.URL, _ *hput.PutResult) error {
+	return errReadOnly
+}
+
+// SaveCode returns an error, as nothing can be saved to an embedded site
+func (sa *Saver) SaveCode(_ context.Context, _ string, _ url.URL, _ *hput.PutResult) error {
+	return errReadOnly
+}
+
+// SaveBinary returns an error, as nothing can be saved to an embedded site
+func (sa *Saver) SaveBinary(_ context.Context, _ []byte, _ url.URL, _ *hput.PutResult) error {
+	return errReadOnly
+}
+
+// tree reads the files in FS.
+func (sa *Saver) tree() sitefs.Tree {
+	return sitefs.Tree{FS: sa.FS}
+}
+
+// GetRunnable returns the runnable from a path
+func (sa *Saver) GetRunnable(_ context.Context, p url.URL) (hput.Runnable, error) {
+	sa.Logger.Debugf("embedsaver.GetRunnable(): retrieving runnable at url %+v", p)
+	runnable, err := sa.tree().Runnable(p.Path)
+	if err != nil {
+		sa.Logger.Errorf("embedsaver.GetRunnable(): %v", err)
+		return hput.Runnable{}, err
+	}
+	if runnable.Type == "" {
+		sa.Logger.Debug("embedsaver.GetRunnable(): got no runnable")
+	}
+	return runnable, nil
+}
+
+// GetStream returns the binary at a path, or nil if the path does not hold
+// a binary. Files of an FS that cannot seek are left to GetRunnable.
+func (sa *Saver) GetStream(_ context.Context, p url.URL) (io.ReadSeekCloser, error) {
+	rs, n, info, err := sa.tree().OpenBinary(p.Path)
+	if err != nil {
+		sa.Logger.Errorf("embedsaver.GetStream(): %v", err)
+		return nil, err
+	}
+	if rs == nil {
+		return nil, nil
+	}
+	etag, err := sa.etag(n, rs)
+	if err != nil {
+		rs.Close()
+		return nil, fmt.Errorf("error reading %s: %w", p.Path, err)
+	}
+	return &file{ReadSeekCloser: rs, etag: etag, modTime: info.ModTime()}, nil
+}
+
+// etag returns the ETag of file n, hashing it from rs the first time, and
+// leaves rs at its start.
+func (sa *Saver) etag(n string, rs io.ReadSeeker) (string, error) {
+	if etag, ok := sa.etags.Load(n); ok {
+		_, err := rs.Seek(0, io.SeekStart)
+		return etag.(string), err
+	}
+	if _, err := rs.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, rs); err != nil {
+		return "", err
+	}
+	if _, err := rs.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	etag := fmt.Sprintf(`"%x"`, h.Sum(nil)[:16])
+	sa.etags.Store(n, etag)
+	return etag, nil
+}
+
+// file is a binary opened by GetStream.
+type file struct {
+	io.ReadSeekCloser
+	etag    string
+	modTime time.Time
+}
+
+// ETag is made from the file's contents, since an embedded file's
+// modification time is unknown.
+func (f *file) ETag() string {
+	return f.etag
+}
+
+// ModTime is when the file was last written, or zero if unknown.
+func (f *file) ModTime() time.Time {
+	return f.modTime
+}
+
+// List yields every path in FS with a prefix of the given one that
+// sorts after cursor, in path order. Hidden files and directories are
+// skipped.
+func (sa *Saver) List(ctx context.Context, prefix, cursor string, limit int) iter.Seq2[hput.Entry, error] {
+	return sa.tree().List(ctx, prefix, cursor, limit)
+}
@@ -0,0 +1,142 @@
+package embedsaver
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"hput"
+	"io"
+	"net/url"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type TestLogger struct{}
+
+func (t *TestLogger) Debugf(msg string, args ...interface{}) {}
+
+func (t *TestLogger) Debug(msg string) {}
+
+func (t *TestLogger) Errorf(msg string, args ...interface{}) {}
+
+// newSaver serves a site laid out as dirsaver saves it.
+func newSaver() *Saver {
+	return New(&TestLogger{}, fstest.MapFS{
+		"index.html":            {Data: []byte("<h1>home</h1>")},
+		"about":                 {Data: []byte("about us")},
+		"api/time":              {Data: []byte("new Date()")},
+		"api/.time.hput":        {Data: []byte(`{"type":"Javascript"}`)},
+		"logo":                  {Data: []byte{0xff, 0, 0xfe}, ModTime: time.Unix(100, 0)},
+		"notes.txt":             {Data: []byte("\xff\x00 saved as text")},
+		".notes.txt.hput":       {Data: []byte(`{"type":"Text"}`)},
+		".git/config":           {Data: []byte("[core]")},
+		"docs/guide/index.html": {Data: []byte("guide")},
+	})
+}
+
+// TestGetRunnable verifies that each path is read from its file, typed by its sidecar or its contents
+func TestGetRunnable(t *testing.T) {
+	sa := newSaver()
+	tt := []struct {
+		name string
+		path string
+		want hput.Runnable
+	}{
+		{name: "index", path: "/", want: hput.Runnable{Path: "/", Type: hput.Text, Text: "<h1>home</h1>"}},
+		{name: "text without a sidecar", path: "/about", want: hput.Runnable{Path: "/about", Type: hput.Text, Text: "about us"}},
+		{name: "code", path: "/api/time", want: hput.Runnable{Path: "/api/time", Type: hput.Js, Text: "new Date()"}},
+		{name: "binary without a sidecar", path: "/logo", want: hput.Runnable{Path: "/logo", Type: hput.Binary, Binary: []byte{0xff, 0, 0xfe}}},
+		{name: "sidecar overrides contents", path: "/notes.txt", want: hput.Runnable{Path: "/notes.txt", Type: hput.Text, Text: "\xff\x00 saved as text"}},
+		{name: "directory index", path: "/docs/guide/", want: hput.Runnable{Path: "/docs/guide/", Type: hput.Text, Text: "guide"}},
+		{name: "directory", path: "/docs", want: hput.Runnable{}},
+		{name: "missing", path: "/missing", want: hput.Runnable{}},
+		{name: "hidden", path: "/.git/config", want: hput.Runnable{}},
+		{name: "sidecar", path: "/api/.time.hput", want: hput.Runnable{}},
+	}
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			r, err := sa.GetRunnable(context.Background(), url.URL{Path: test.path})
+			assert.NoError(t, err)
+			assert.Equal(t, test.want, r)
+		})
+	}
+}
+
+// TestSave verifies that nothing can be saved
+func TestSave(t *testing.T) {
+	ctx := context.Background()
+	sa := newSaver()
+	p := url.URL{Path: "/about"}
+	assert.ErrorIs(t, sa.SaveText(ctx, "new", p, &hput.PutResult{}), errReadOnly)
+	assert.ErrorIs(t, sa.SaveCode(ctx, "1+1", p, &hput.PutResult{}), errReadOnly)
+	assert.ErrorIs(t, sa.SaveBinary(ctx, []byte{1}, p, &hput.PutResult{}), errReadOnly)
+	r, err := sa.GetRunnable(ctx, p)
+	assert.NoError(t, err)
+	assert.Equal(t, "about us", r.Text)
+}
+
+// TestGetStream verifies that binaries are streamed with an ETag of their contents, and other files are not
+func TestGetStream(t *testing.T) {
+	ctx := context.Background()
+	sa := newSaver()
+	rs, err := sa.GetStream(ctx, url.URL{Path: "/logo"})
+	assert.NoError(t, err)
+	b, err := io.ReadAll(rs)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0xff, 0, 0xfe}, b)
+	info := rs.(*file)
+	sum := sha256.Sum256([]byte{0xff, 0, 0xfe})
+	assert.Equal(t, fmt.Sprintf(`"%x"`, sum[:16]), info.ETag())
+	assert.Equal(t, time.Unix(100, 0), info.ModTime())
+	rs.Close()
+
+	again, err := sa.GetStream(ctx, url.URL{Path: "/logo"})
+	assert.NoError(t, err)
+	assert.Equal(t, info.ETag(), again.(*file).ETag())
+	again.Close()
+
+	for _, p := range []string{"/about", "/notes.txt", "/docs", "/missing", "/.git/config"} {
+		rs, err := sa.GetStream(ctx, url.URL{Path: p})
+		assert.NoError(t, err)
+		assert.Nil(t, rs, p)
+	}
+}
+
+// TestList verifies that listing walks the files under a prefix in path order, a page at a time, skipping hidden ones and listing an index as its directory
+func TestList(t *testing.T) {
+	tt := []struct {
+		name   string
+		prefix string
+		cursor string
+		limit  int
+		want   []string
+	}{
+		{name: "everything", prefix: "/", want: []string{"/", "/about", "/api/time", "/docs/guide/", "/logo", "/notes.txt"}},
+		{name: "a directory", prefix: "/api/", want: []string{"/api/time"}},
+		{name: "part of a name", prefix: "/do", want: []string{"/docs/guide/"}},
+		{name: "a page after a cursor", prefix: "/", cursor: "/api/time", limit: 2, want: []string{"/docs/guide/", "/logo"}},
+	}
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			var got []string
+			for e, err := range newSaver().List(context.Background(), test.prefix, test.cursor, test.limit) {
+				assert.NoError(t, err)
+				got = append(got, e.Path)
+			}
+			assert.Equal(t, test.want, got)
+		})
+	}
+
+	var entries []hput.Entry
+	for e, err := range newSaver().List(context.Background(), "/", "/api", 2) {
+		assert.NoError(t, err)
+		entries = append(entries, e)
+	}
+	assert.Equal(t, []hput.Entry{
+		{Path: "/api/time", Type: hput.Js, Size: 10},
+		{Path: "/docs/guide/", Type: hput.Text, Size: 5},
+	}, entries)
+}
@@ -3,11 +3,14 @@ package hput
 
 import (
 	"errors"
+	"time"
 )
 
 var (
 	// ErrCannotReadPostPayload A payload was sent via POST, but it cannot be read
 	ErrCannotReadPostPayload = errors.New("cannot read POST Payload")
+	// ErrTooLarge A payload was larger than the server accepts
+	ErrTooLarge = errors.New("payload too large")
 )
 
 // Input describes the type of input which was sent or retrieved
@@ -36,3 +39,33 @@ type Runnable struct {
 	Text   string // to be returned to the runner
 	Binary []byte // raw bytes
 }
+
+// Entry describes a saved path without its contents, as listings return it.
+type Entry struct {
+	Path     string    `json:"path"`
+	Type     Input     `json:"type"`
+	Size     int64     `json:"size"`     // length of the text or binary in bytes
+	Modified time.Time `json:"modified"` // when it was last saved; zero if unknown
+}
+
+// Version describes one saved state of a path. Every save adds a version,
+// so the newest version is what the path holds now.
+type Version struct {
+	ID    int       `json:"id"`    // counts up from 1 with each save to the path
+	Saved time.Time `json:"saved"` // zero if it was saved before versions were kept
+	Type  Input     `json:"type"`
+	Size  int       `json:"size"` // length of the text or binary in bytes
+}
+
+// Retention decides which earlier versions of a path are kept.
+// The newest version is always kept.
+type Retention struct {
+	Count int           // how many earlier versions to keep; 0 keeps none
+	Age   time.Duration // drop earlier versions saved longer ago than this; 0 means no age limit
+}
+
+// Keep reports whether the earlier version saved at saved, which is the
+// nth newest earlier version counting from 0, should be kept at now.
+func (r Retention) Keep(n int, saved, now time.Time) bool {
+	return n < r.Count && (r.Age == 0 || now.Sub(saved) <= r.Age)
+}
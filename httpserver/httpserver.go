@@ -2,6 +2,7 @@ package httpserver
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"hput"
 	"net/http"
@@ -13,10 +14,14 @@ type Httpserver struct {
 	Port     int     // number of port to listen to. Required.
 	Service  Service // Handler functions for activities performed
 	Logger   Logger
-	NonLocal bool // Reject any traffic that doesn't come from local traffic
-	Locked   bool // Pass all requests to run and don't put any paths
+	NonLocal bool         // Reject any traffic that doesn't come from local traffic
+	Locked   bool         // Pass all requests to run and don't put any paths
+	Admin    http.Handler // Serves paths under AdminPrefix; nil answers them with 404
 }
 
+// AdminPrefix is reserved for the admin API; requests under it never reach Service.
+const AdminPrefix = "/_hput/"
+
 // Logger logs out.
 type Logger interface {
 	Debugf(msg string, args ...interface{})
@@ -58,6 +63,10 @@ func (s *Httpserver) handle(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
+	if strings.HasPrefix(r.URL.Path, AdminPrefix) {
+		s.admin(w, r)
+		return
+	}
 	s.Logger.Debugf("Handling request with method %s", r.Method)
 	switch r.Method {
 	case "OPTIONS":
@@ -76,6 +85,17 @@ func (s *Httpserver) handle(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// admin passes a request under AdminPrefix to the admin API, if there is one.
+func (s *Httpserver) admin(w http.ResponseWriter, r *http.Request) {
+	if s.Admin == nil {
+		s.Logger.Debugf("admin API disabled, rejecting %s", r.URL.Path)
+		http.NotFound(w, r)
+		return
+	}
+	s.Logger.Debugf("Handling admin request for %s", r.URL.Path)
+	s.Admin.ServeHTTP(w, r)
+}
+
 // options returns the allowed methods to every endpoint. Filling this
 // in was required to allow xhr to PUT requests.
 func (s *Httpserver) options(w http.ResponseWriter, r *http.Request) {
@@ -95,6 +115,12 @@ func (s *Httpserver) options(w http.ResponseWriter, r *http.Request) {
 func (s *Httpserver) put(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	s.Logger.Debugf("processing PUT request")
 	putResult, err := s.Service.Put(ctx, w, r)
+	if errors.Is(err, hput.ErrTooLarge) {
+		s.Logger.Warnf("rejected PUT request, %v", err)
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		w.Write([]byte(err.Error()))
+		return
+	}
 	if err != nil {
 		s.Logger.Warnf("error PUT request, %v", err)
 		w.WriteHeader(http.StatusBadRequest)
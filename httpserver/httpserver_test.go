@@ -29,6 +29,9 @@ func (t *TestLogger) Infof(msg string, args ...interface{}) {}
 type TestService struct{}
 
 func (t *TestService) Put(ctx context.Context, w http.ResponseWriter, r *http.Request) (*hput.PutResult, error) {
+	if r.URL.Path == "/tooLarge" {
+		return nil, fmt.Errorf("%w: the limit is 1 bytes", hput.ErrTooLarge)
+	}
 	p, err := io.ReadAll(r.Body)
 	if err != nil {
 		panic(fmt.Sprintf("error reading incoming payload: %v", err))
@@ -103,6 +106,14 @@ func Test_handle(t *testing.T) {
 			reqPayload: bytes.NewBufferString("aPayload"),
 			resPayload: []byte("Saved input of type: Text"),
 		},
+		{
+			name:       "PUT too large",
+			method:     http.MethodPut,
+			path:       "/tooLarge",
+			statusCode: http.StatusRequestEntityTooLarge,
+			reqPayload: bytes.NewBufferString("aPayload"),
+			resPayload: []byte("payload too large: the limit is 1 bytes"),
+		},
 		{
 			name:       "OPTIONS",
 			method:     http.MethodOptions,
@@ -131,3 +142,66 @@ func Test_handle(t *testing.T) {
 		})
 	}
 }
+
+// Test_handleAdmin verifies admin paths go to the admin handler and never to the service
+func Test_handleAdmin(t *testing.T) {
+	admin := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(fmt.Sprintf("passed request with path %s to Admin", r.URL.Path)))
+	})
+	tt := []struct {
+		name       string
+		admin      http.Handler
+		method     string
+		path       string
+		resPayload []byte
+		statusCode int
+	}{
+		{
+			name:       "GET admin path",
+			admin:      admin,
+			method:     http.MethodGet,
+			path:       "/_hput/shared",
+			resPayload: []byte("passed request with path /_hput/shared to Admin"),
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "PUT admin path",
+			admin:      admin,
+			method:     http.MethodPut,
+			path:       "/_hput/shared/flags",
+			resPayload: []byte("passed request with path /_hput/shared/flags to Admin"),
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "admin disabled",
+			method:     http.MethodPut,
+			path:       "/_hput/shared/flags",
+			statusCode: http.StatusNotFound,
+		},
+		{
+			name:       "similar path is not admin",
+			admin:      admin,
+			method:     http.MethodGet,
+			path:       "/_hputx",
+			resPayload: []byte("passed request with path /_hputx to Run"),
+			statusCode: http.StatusOK,
+		},
+	}
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			h := Httpserver{
+				Logger:   &TestLogger{},
+				Service:  &TestService{},
+				NonLocal: true,
+				Admin:    test.admin,
+			}
+			request := httptest.NewRequest(test.method, test.path, nil)
+			responseRecorder := httptest.NewRecorder()
+			h.handle(responseRecorder, request)
+			assert.Equal(t, test.statusCode, responseRecorder.Code)
+			if len(test.resPayload) > 0 {
+				assert.Equal(t, test.resPayload, responseRecorder.Body.Bytes())
+			}
+		})
+	}
+}
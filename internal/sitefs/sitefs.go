@@ -0,0 +1,259 @@
+// Package sitefs reads paths from a tree of files laid out as dirsaver saves
+// them, over any fs.FS, so dirsaver and embedsaver serve a tree the same way.
+//
+// A path is a file of the same name, and a path ending in "/" is that
+// directory's index.html. A sidecar beside a file, .<file>.hput, records its
+// type, and a file without one is text if it looks like text and binary
+// otherwise. Paths with a segment starting with "." are reserved.
+package sitefs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hput"
+	"io"
+	"io/fs"
+	"iter"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"syscall"
+)
+
+// IndexFile holds a path ending in "/".
+const IndexFile = "index.html"
+
+// ErrReserved is returned when a path has a segment starting with ".".
+// Those name sidecars and partly written files, or a checkout's .git.
+var ErrReserved = errors.New("paths with a segment starting with '.' are reserved")
+
+// Sidecar is what a sidecar file holds.
+type Sidecar struct {
+	Type hput.Input `json:"type"`
+}
+
+// FileName returns the name in the tree of the file holding path p.
+func FileName(p string) (string, error) {
+	for _, seg := range strings.Split(p, "/") {
+		if strings.HasPrefix(seg, ".") {
+			return "", ErrReserved
+		}
+	}
+	n := path.Clean("/" + p)
+	if strings.HasSuffix(p, "/") || n == "/" {
+		n = path.Join(n, IndexFile)
+	}
+	return n[1:], nil
+}
+
+// SidecarName returns the name of the sidecar of file n.
+func SidecarName(n string) string {
+	return path.Join(path.Dir(n), "."+path.Base(n)+".hput")
+}
+
+// Missing reports if err means there is no file, including when part of
+// its directory is a file.
+func Missing(err error) bool {
+	return errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.ENOTDIR)
+}
+
+// Tree reads paths from the files in FS.
+type Tree struct {
+	FS fs.FS
+}
+
+// fileType returns the type of file n, from its sidecar or else from head,
+// the start of the file.
+func (t Tree) fileType(n string, head []byte) (hput.Input, error) {
+	b, err := fs.ReadFile(t.FS, SidecarName(n))
+	if Missing(err) {
+		if strings.HasPrefix(http.DetectContentType(head), "text/") {
+			return hput.Text, nil
+		}
+		return hput.Binary, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	var sc Sidecar
+	if err := json.Unmarshal(b, &sc); err != nil {
+		return "", fmt.Errorf("bad sidecar for %s: %w", n, err)
+	}
+	return sc.Type, nil
+}
+
+// Runnable returns the runnable at path p, or an empty one if nothing is
+// there or p is reserved.
+func (t Tree) Runnable(p string) (hput.Runnable, error) {
+	n, err := FileName(p)
+	if err != nil {
+		return hput.Runnable{}, nil
+	}
+	info, err := fs.Stat(t.FS, n)
+	if Missing(err) || (err == nil && info.IsDir()) {
+		return hput.Runnable{}, nil
+	}
+	var b []byte
+	if err == nil {
+		b, err = fs.ReadFile(t.FS, n)
+	}
+	var typ hput.Input
+	if err == nil {
+		typ, err = t.fileType(n, b)
+	}
+	if err != nil {
+		return hput.Runnable{}, fmt.Errorf("error reading %s: %w", p, err)
+	}
+	if typ == hput.Binary {
+		return hput.Runnable{Path: p, Type: typ, Binary: b}, nil
+	}
+	return hput.Runnable{Path: p, Type: typ, Text: string(b)}, nil
+}
+
+// OpenBinary opens the binary at path p at its start, with the name of its
+// file and its info, or returns nil if p does not hold a binary. A file the
+// FS cannot seek in is not opened, and is left to Runnable.
+func (t Tree) OpenBinary(p string) (io.ReadSeekCloser, string, fs.FileInfo, error) {
+	n, err := FileName(p)
+	if err != nil {
+		return nil, "", nil, nil
+	}
+	f, err := t.FS.Open(n)
+	if Missing(err) {
+		return nil, "", nil, nil
+	}
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("error opening %s: %w", p, err)
+	}
+	info, err := f.Stat()
+	rs, seeks := f.(io.ReadSeekCloser)
+	if err != nil || info.IsDir() || !seeks {
+		f.Close()
+		return nil, "", nil, err
+	}
+	head := make([]byte, 512)
+	k, err := io.ReadFull(rs, head)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		f.Close()
+		return nil, "", nil, fmt.Errorf("error reading %s: %w", p, err)
+	}
+	typ, err := t.fileType(n, head[:k])
+	if err == nil && typ == hput.Binary {
+		_, err = rs.Seek(0, io.SeekStart)
+		if err == nil {
+			return rs, n, info, nil
+		}
+	}
+	f.Close()
+	return nil, "", nil, err
+}
+
+// List yields every path in the tree with a prefix of the given one that
+// sorts after cursor, in path order, up to limit of them if limit is above
+// 0. Hidden files and directories are skipped.
+func (t Tree) List(ctx context.Context, prefix, cursor string, limit int) iter.Seq2[hput.Entry, error] {
+	return func(yield func(hput.Entry, error) bool) {
+		n := 0
+		_, err := walk(ctx, t.FS, ".", prefix, cursor, func(name, p string, d fs.DirEntry) (bool, error) {
+			if limit > 0 && n == limit {
+				return false, nil
+			}
+			e, err := t.entry(name, p, d)
+			if err != nil {
+				return false, err
+			}
+			n++
+			return yield(e, nil), nil
+		})
+		if err != nil {
+			yield(hput.Entry{}, fmt.Errorf("could not walk through runnables: %w", err))
+		}
+	}
+}
+
+// walk calls fn on every file under dir that is not hidden and has a path
+// with prefix p sorting after cursor, in path order, until fn returns false.
+// A directory's index file holds the path of the directory, ending in "/".
+// It only goes into directories that can hold such paths.
+func walk(ctx context.Context, fsys fs.FS, dir, p, cursor string, fn func(name, p string, d fs.DirEntry) (bool, error)) (bool, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return false, err
+	}
+	// A directory's paths sort as its name followed by "/", and its index
+	// before them all.
+	key := func(d fs.DirEntry) string {
+		switch {
+		case d.IsDir():
+			return d.Name() + "/"
+		case d.Name() == IndexFile:
+			return ""
+		}
+		return d.Name()
+	}
+	slices.SortFunc(entries, func(a, b fs.DirEntry) int { return strings.Compare(key(a), key(b)) })
+	for _, d := range entries {
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+		if strings.HasPrefix(d.Name(), ".") {
+			continue
+		}
+		name := path.Join(dir, d.Name())
+		rp := "/" + name
+		if d.Name() == IndexFile && !d.IsDir() {
+			if rp = path.Dir(rp); rp != "/" {
+				rp += "/"
+			}
+		}
+		if d.IsDir() {
+			under := rp + "/"
+			if !strings.HasPrefix(under, p) && !strings.HasPrefix(p, under) {
+				continue
+			}
+			// Every path under it sorts before a cursor past under that
+			// does not start with it.
+			if cursor > under && !strings.HasPrefix(cursor, under) {
+				continue
+			}
+			if ok, err := walk(ctx, fsys, name, p, cursor, fn); err != nil || !ok {
+				return ok, err
+			}
+			continue
+		}
+		if !d.Type().IsRegular() || !strings.HasPrefix(rp, p) || rp <= cursor {
+			continue
+		}
+		if ok, err := fn(name, rp, d); err != nil || !ok {
+			return ok, err
+		}
+	}
+	return true, nil
+}
+
+// entry describes file name, holding path p, without reading more of it
+// than fileType needs.
+func (t Tree) entry(name, p string, d fs.DirEntry) (hput.Entry, error) {
+	info, err := d.Info()
+	if err != nil {
+		return hput.Entry{}, err
+	}
+	f, err := t.FS.Open(name)
+	if err != nil {
+		return hput.Entry{}, err
+	}
+	defer f.Close()
+	head := make([]byte, 512)
+	k, err := io.ReadFull(f, head)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return hput.Entry{}, err
+	}
+	typ, err := t.fileType(name, head[:k])
+	if err != nil {
+		return hput.Entry{}, err
+	}
+	return hput.Entry{Path: p, Type: typ, Size: info.Size(), Modified: info.ModTime()}, nil
+}
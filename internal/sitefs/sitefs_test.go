@@ -0,0 +1,78 @@
+package sitefs
+
+import (
+	"context"
+	"hput"
+	"io"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestFileName verifies which file holds each path, and that reserved paths have none
+func TestFileName(t *testing.T) {
+	tt := []struct {
+		path string
+		want string
+		err  error
+	}{
+		{path: "/", want: "index.html"},
+		{path: "", want: "index.html"},
+		{path: "/about", want: "about"},
+		{path: "/docs/", want: "docs/index.html"},
+		{path: "/a//c", want: "a/c"},
+		{path: "/a/../c", err: ErrReserved},
+		{path: "/.git/config", err: ErrReserved},
+		{path: "/docs/.index.html.hput", err: ErrReserved},
+	}
+	for _, test := range tt {
+		t.Run(test.path, func(t *testing.T) {
+			n, err := FileName(test.path)
+			assert.ErrorIs(t, err, test.err)
+			assert.Equal(t, test.want, n)
+		})
+	}
+}
+
+// TestTree verifies that runnables, binaries and listings agree on the path each file holds
+func TestTree(t *testing.T) {
+	ctx := context.Background()
+	tree := Tree{FS: fstest.MapFS{
+		"index.html":        {Data: []byte("home")},
+		"docs/index.html":   {Data: []byte("docs")},
+		"docs/logo":         {Data: []byte{0xff, 0}},
+		"docs/.logo.hput":   {Data: []byte(`{"type":"Binary"}`)},
+		"docs/api":          {Data: []byte("1+1")},
+		"docs/.api.hput":    {Data: []byte(`{"type":"Javascript"}`)},
+		"docs/.draft.tmp-x": {Data: []byte("partly written")},
+	}}
+	var paths []string
+	for e, err := range tree.List(ctx, "/", "", 0) {
+		assert.NoError(t, err)
+		paths = append(paths, e.Path)
+		r, err := tree.Runnable(e.Path)
+		assert.NoError(t, err)
+		assert.Equal(t, e.Path, r.Path)
+		assert.Equal(t, e.Type, r.Type)
+	}
+	assert.Equal(t, []string{"/", "/docs/", "/docs/api", "/docs/logo"}, paths)
+
+	rs, n, info, err := tree.OpenBinary("/docs/logo")
+	assert.NoError(t, err)
+	assert.Equal(t, "docs/logo", n)
+	assert.Equal(t, int64(2), info.Size())
+	b, err := io.ReadAll(rs)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0xff, 0}, b)
+	rs.Close()
+
+	for _, p := range []string{"/docs/", "/docs/api", "/docs", "/missing", "/docs/logo/x"} {
+		rs, _, _, err := tree.OpenBinary(p)
+		assert.NoError(t, err, p)
+		assert.Nil(t, rs, p)
+	}
+	r, err := tree.Runnable("/docs")
+	assert.NoError(t, err)
+	assert.Equal(t, hput.Runnable{}, r, "a directory holds nothing")
+}
@@ -1,10 +1,15 @@
 package javascript
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"hput/kv"
+	"math"
+	"strings"
+	"time"
 
 	v8 "github.com/tommie/v8go"
 )
@@ -13,40 +18,123 @@ import (
 // All KV operations are scoped to path — the JS caller never specifies
 // which path they belong to.
 func attachHput(ctx context.Context, iso *v8.Isolate, v8ctx *v8.Context, path string, store kv.KV) error {
+	hputObj, err := newHputObject(ctx, iso, v8ctx, hputScope{root: path, path: path}, store)
+	if err != nil {
+		return err
+	}
+	return v8ctx.Global().Set("hput", hputObj)
+}
+
+// hputScope describes what one hput object may touch.
+type hputScope struct {
+	root     string // path of the running script; bounds hput.at and is checked against shared grants
+	path     string // namespace the object reads and writes
+	shared   string // name of the shared namespace, if path is one
+	readOnly bool   // writes throw instead of reaching the store
+}
+
+// denyWrite throws from fn if scope may not be written, and returns nil otherwise.
+func (scope hputScope) denyWrite(iso *v8.Isolate, fn string) *v8.Value {
+	if !scope.readOnly {
+		return nil
+	}
+	return throwError(iso, "%s: shared namespace %q is read-only for %s", fn, scope.shared, scope.root)
+}
+
+// newHputObject builds an hput object whose operations act on scope.path.
+// Objects for the script's own subtree have hput.at and hput.shared;
+// objects for a shared namespace have neither.
+func newHputObject(ctx context.Context, iso *v8.Isolate, v8ctx *v8.Context, scope hputScope, store kv.KV) (*v8.Object, error) {
 	hputTmpl := v8.NewObjectTemplate(iso)
+	path := scope.path
+
+	if scope.shared == "" {
+		// hput.at(subpath) → hput object for another namespace in this script's subtree
+		// subpath is relative to this object's path, or absolute within the subtree.
+		hputTmpl.Set("at", v8.NewFunctionTemplate(iso, func(info *v8.FunctionCallbackInfo) *v8.Value {
+			if len(info.Args()) != 1 {
+				return throwError(iso, "hput.at requires exactly 1 argument")
+			}
+			target := info.Args()[0].String()
+			if !strings.HasPrefix(target, "/") {
+				target = strings.TrimSuffix(path, "/") + "/" + target
+			}
+			resolved, err := kv.Resolve(scope.root, target)
+			if err != nil {
+				return throwError(iso, "hput.at(%q): %s", info.Args()[0].String(), err)
+			}
+			obj, err := newHputObject(ctx, iso, v8ctx, hputScope{root: scope.root, path: resolved}, store)
+			if err != nil {
+				return throwError(iso, "hput.at: %s", err)
+			}
+			return obj.Value
+		}))
+
+		// hput.shared(name) → hput object for a shared namespace
+		// Throws unless an admin granted this script's path read access;
+		// writes throw unless it was also granted write access.
+		hputTmpl.Set("shared", v8.NewFunctionTemplate(iso, func(info *v8.FunctionCallbackInfo) *v8.Value {
+			if len(info.Args()) != 1 {
+				return throwError(iso, "hput.shared requires exactly 1 argument")
+			}
+			name := info.Args()[0].String()
+			ns, err := kv.SharedNamespace(name)
+			if err != nil {
+				return throwError(iso, "hput.shared(%q): %s", name, err)
+			}
+			grants, _, err := kv.GetGrants(ctx, store, name)
+			if err != nil {
+				return throwError(iso, "hput.shared(%q): %s", name, err)
+			}
+			if !grants.CanRead(scope.root) {
+				return throwError(iso, "hput.shared(%q): %s has not been granted access", name, scope.root)
+			}
+			sharedScope := hputScope{root: scope.root, path: ns, shared: name, readOnly: !grants.CanWrite(scope.root)}
+			obj, err := newHputObject(ctx, iso, v8ctx, sharedScope, store)
+			if err != nil {
+				return throwError(iso, "hput.shared: %s", err)
+			}
+			return obj.Value
+		}))
+	}
 
 	// hput.get(key) → value | null
 	hputTmpl.Set("get", v8.NewFunctionTemplate(iso, func(info *v8.FunctionCallbackInfo) *v8.Value {
 		if len(info.Args()) != 1 {
-			panic("hput.get requires exactly 1 argument")
+			return throwError(iso, "hput.get requires exactly 1 argument")
 		}
 		key := info.Args()[0].String()
 		val, err := store.Get(ctx, path, key)
 		if err != nil {
-			panic(fmt.Sprintf("hput.get: %s", err))
-		}
-		if val == nil {
-			return v8.Null(iso)
+			return throwError(iso, "hput.get: %s", err)
 		}
-		parsed, err := v8ctx.RunScript("JSON.parse("+jsonStringLiteral(string(val))+")", "hput_get")
+		parsed, err := parseStored(iso, v8ctx, val)
 		if err != nil {
-			panic(fmt.Sprintf("hput.get: parsing stored value: %s", err))
+			return throwError(iso, "hput.get: parsing stored value: %s", err)
 		}
 		return parsed
 	}))
 
-	// hput.put(key, value) → undefined
+	// hput.put(key, value, opts?) → undefined
+	// opts: { ttl?: seconds, expiresAt?: Date | epoch milliseconds }
 	hputTmpl.Set("put", v8.NewFunctionTemplate(iso, func(info *v8.FunctionCallbackInfo) *v8.Value {
-		if len(info.Args()) != 2 {
-			panic("hput.put requires exactly 2 arguments")
+		if len(info.Args()) < 2 || len(info.Args()) > 3 {
+			return throwError(iso, "hput.put requires 2 or 3 arguments")
+		}
+		if denied := scope.denyWrite(iso, "hput.put"); denied != nil {
+			return denied
 		}
 		key := info.Args()[0].String()
 		valueJSON, err := info.Args()[1].MarshalJSON()
 		if err != nil {
-			panic(fmt.Sprintf("hput.put: serializing value: %s", err))
+			return throwError(iso, "hput.put: serializing value: %s", err)
 		}
-		if err := store.Put(ctx, path, key, valueJSON); err != nil {
-			panic(fmt.Sprintf("hput.put: %s", err))
+		expiresAt, err := expiryOption(info.Args(), 2)
+		if err != nil {
+			return throwError(iso, "hput.put: %s", err)
+		}
+		if err := store.PutExpiring(ctx, path, key, valueJSON, expiresAt); err != nil {
+			return writeFailed(iso, v8ctx, "hput.put", err)
 		}
 		return v8.Undefined(iso)
 	}))
@@ -54,17 +142,20 @@ func attachHput(ctx context.Context, iso *v8.Isolate, v8ctx *v8.Context, path st
 	// hput.delete(key) → undefined
 	hputTmpl.Set("delete", v8.NewFunctionTemplate(iso, func(info *v8.FunctionCallbackInfo) *v8.Value {
 		if len(info.Args()) != 1 {
-			panic("hput.delete requires exactly 1 argument")
+			return throwError(iso, "hput.delete requires exactly 1 argument")
+		}
+		if denied := scope.denyWrite(iso, "hput.delete"); denied != nil {
+			return denied
 		}
 		key := info.Args()[0].String()
 		if err := store.Delete(ctx, path, key); err != nil {
-			panic(fmt.Sprintf("hput.delete: %s", err))
+			return throwError(iso, "hput.delete: %s", err)
 		}
 		return v8.Undefined(iso)
 	}))
 
-	// hput.list(opts?) → { keys: string[], cursor: string }
-	// opts: { prefix?: string, limit?: number, cursor?: string }
+	// hput.list(opts?) → { keys: string[], values?: any[], cursor: string }
+	// opts: { prefix?: string, limit?: number, cursor?: string, includeValues?: boolean }
 	hputTmpl.Set("list", v8.NewFunctionTemplate(iso, func(info *v8.FunctionCallbackInfo) *v8.Value {
 		opts := kv.ListOptions{}
 		if len(info.Args()) > 0 && info.Args()[0].IsObject() {
@@ -79,30 +170,628 @@ func attachHput(ctx context.Context, iso *v8.Isolate, v8ctx *v8.Context, path st
 				if v, err := obj.Get("cursor"); err == nil && v.IsString() {
 					opts.Cursor = v.String()
 				}
+				if v, err := obj.Get("includeValues"); err == nil {
+					opts.IncludeValues = v.Boolean()
+				}
 			}
 		}
 
 		result, err := store.List(ctx, path, opts)
 		if err != nil {
-			panic(fmt.Sprintf("hput.list: %s", err))
+			return throwError(iso, "hput.list: %s", err)
+		}
+		val, err := listResult(v8ctx, result, opts.IncludeValues)
+		if err != nil {
+			return throwError(iso, "hput.list: %s", err)
+		}
+		return val
+	}))
+
+	// hput.createIndex(name, field) → undefined
+	// field is a path into stored values such as '$.email' or '$.address.city'.
+	hputTmpl.Set("createIndex", v8.NewFunctionTemplate(iso, func(info *v8.FunctionCallbackInfo) *v8.Value {
+		if len(info.Args()) != 2 {
+			return throwError(iso, "hput.createIndex requires exactly 2 arguments")
+		}
+		if denied := scope.denyWrite(iso, "hput.createIndex"); denied != nil {
+			return denied
+		}
+		name := info.Args()[0].String()
+		if err := store.CreateIndex(ctx, path, name, info.Args()[1].String()); err != nil {
+			return throwError(iso, "hput.createIndex(%q): %s", name, err)
+		}
+		return v8.Undefined(iso)
+	}))
+
+	// hput.dropIndex(name) → undefined
+	hputTmpl.Set("dropIndex", v8.NewFunctionTemplate(iso, func(info *v8.FunctionCallbackInfo) *v8.Value {
+		if len(info.Args()) != 1 {
+			return throwError(iso, "hput.dropIndex requires exactly 1 argument")
+		}
+		if denied := scope.denyWrite(iso, "hput.dropIndex"); denied != nil {
+			return denied
+		}
+		name := info.Args()[0].String()
+		if err := store.DropIndex(ctx, path, name); err != nil {
+			return throwError(iso, "hput.dropIndex(%q): %s", name, err)
+		}
+		return v8.Undefined(iso)
+	}))
+
+	// hput.query(name, opts?) → { keys: string[], values?: any[], cursor: string }
+	// opts: { eq?, gt?, gte?, lt?, lte?, limit?: number, cursor?: string, includeValues?: boolean }
+	hputTmpl.Set("query", v8.NewFunctionTemplate(iso, func(info *v8.FunctionCallbackInfo) *v8.Value {
+		if len(info.Args()) < 1 || len(info.Args()) > 2 {
+			return throwError(iso, "hput.query requires 1 or 2 arguments")
+		}
+		name := info.Args()[0].String()
+		q, err := queryOptions(info.Args())
+		if err != nil {
+			return throwError(iso, "hput.query(%q): %s", name, err)
+		}
+		result, err := store.Query(ctx, path, name, q)
+		if err != nil {
+			return throwError(iso, "hput.query(%q): %s", name, err)
+		}
+		val, err := listResult(v8ctx, result, q.IncludeValues)
+		if err != nil {
+			return throwError(iso, "hput.query: %s", err)
 		}
+		return val
+	}))
 
-		// Build { keys: [...], cursor: "..." } as a JSON string then parse it.
-		keysJSON, _ := json.Marshal(result.Keys)
-		cursorJSON, _ := json.Marshal(result.Cursor)
-		script := fmt.Sprintf("({keys:%s,cursor:%s})", keysJSON, cursorJSON)
-		val, err := v8ctx.RunScript(script, "hput_list")
+	// hput.getMany(keys) → values[], null for each missing key
+	hputTmpl.Set("getMany", v8.NewFunctionTemplate(iso, func(info *v8.FunctionCallbackInfo) *v8.Value {
+		if len(info.Args()) != 1 {
+			return throwError(iso, "hput.getMany requires exactly 1 argument")
+		}
+		keys, err := stringArray(info.Args()[0])
+		if err != nil {
+			return throwError(iso, "hput.getMany: %s", err)
+		}
+		vals, err := store.GetMany(ctx, path, keys)
+		if err != nil {
+			return throwError(iso, "hput.getMany: %s", err)
+		}
+		valuesJSON, err := storedArrayJSON(vals)
+		if err != nil {
+			return throwError(iso, "hput.getMany: %s", err)
+		}
+		val, err := v8ctx.RunScript(string(valuesJSON), "hput_get_many")
 		if err != nil {
-			panic(fmt.Sprintf("hput.list: building result: %s", err))
+			return throwError(iso, "hput.getMany: building result: %s", err)
 		}
 		return val
 	}))
 
+	// hput.putMany(entries) → undefined
+	// entries: { key: value, ... } or [{ key, value, ttl?, expiresAt? }, ...]
+	hputTmpl.Set("putMany", v8.NewFunctionTemplate(iso, func(info *v8.FunctionCallbackInfo) *v8.Value {
+		if len(info.Args()) != 1 {
+			return throwError(iso, "hput.putMany requires exactly 1 argument")
+		}
+		if denied := scope.denyWrite(iso, "hput.putMany"); denied != nil {
+			return denied
+		}
+		entries, err := putManyEntries(info.Args()[0])
+		if err != nil {
+			return throwError(iso, "hput.putMany: %s", err)
+		}
+		if err := store.PutMany(ctx, path, entries); err != nil {
+			return writeFailed(iso, v8ctx, "hput.putMany", err)
+		}
+		return v8.Undefined(iso)
+	}))
+
+	// hput.deleteMany(keys) → undefined
+	hputTmpl.Set("deleteMany", v8.NewFunctionTemplate(iso, func(info *v8.FunctionCallbackInfo) *v8.Value {
+		if len(info.Args()) != 1 {
+			return throwError(iso, "hput.deleteMany requires exactly 1 argument")
+		}
+		if denied := scope.denyWrite(iso, "hput.deleteMany"); denied != nil {
+			return denied
+		}
+		keys, err := stringArray(info.Args()[0])
+		if err != nil {
+			return throwError(iso, "hput.deleteMany: %s", err)
+		}
+		if err := store.DeleteMany(ctx, path, keys); err != nil {
+			return throwError(iso, "hput.deleteMany: %s", err)
+		}
+		return v8.Undefined(iso)
+	}))
+
+	// hput.compareAndSwap(key, expected, next) → boolean
+	// expected of null means the key must not exist yet.
+	hputTmpl.Set("compareAndSwap", v8.NewFunctionTemplate(iso, func(info *v8.FunctionCallbackInfo) *v8.Value {
+		if len(info.Args()) != 3 {
+			return throwError(iso, "hput.compareAndSwap requires exactly 3 arguments")
+		}
+		if denied := scope.denyWrite(iso, "hput.compareAndSwap"); denied != nil {
+			return denied
+		}
+		key := info.Args()[0].String()
+		var expected []byte
+		if e := info.Args()[1]; !e.IsNullOrUndefined() {
+			var err error
+			if expected, err = e.MarshalJSON(); err != nil {
+				return throwError(iso, "hput.compareAndSwap: serializing expected value: %s", err)
+			}
+		}
+		next, err := info.Args()[2].MarshalJSON()
+		if err != nil {
+			return throwError(iso, "hput.compareAndSwap: serializing value: %s", err)
+		}
+		swapped, err := kv.CompareAndSwap(ctx, store, path, key, expected, next)
+		if err != nil {
+			return writeFailed(iso, v8ctx, "hput.compareAndSwap", err)
+		}
+		val, _ := v8.NewValue(iso, swapped)
+		return val
+	}))
+
+	// hput.increment(key, delta?, opts?) → number
+	// delta defaults to 1; a missing key counts as 0. opts takes the same
+	// ttl/expiresAt as hput.put but only applies when the key is created.
+	hputTmpl.Set("increment", v8.NewFunctionTemplate(iso, func(info *v8.FunctionCallbackInfo) *v8.Value {
+		if len(info.Args()) < 1 || len(info.Args()) > 3 {
+			return throwError(iso, "hput.increment requires 1 to 3 arguments")
+		}
+		if denied := scope.denyWrite(iso, "hput.increment"); denied != nil {
+			return denied
+		}
+		key := info.Args()[0].String()
+		delta := 1.0
+		if len(info.Args()) > 1 && !info.Args()[1].IsUndefined() {
+			delta = info.Args()[1].Number()
+		}
+		expiresAt, err := expiryOption(info.Args(), 2)
+		if err != nil {
+			return throwError(iso, "hput.increment: %s", err)
+		}
+		n, err := kv.Increment(ctx, store, path, key, delta, expiresAt)
+		if err != nil {
+			return writeFailed(iso, v8ctx, "hput.increment", err)
+		}
+		val, _ := v8.NewValue(iso, n)
+		return val
+	}))
+
+	// hput.watch(prefix?) → watch object for changes to keys starting with prefix
+	// watch.next(timeoutMs?) blocks until the next { path, type, key, value } and
+	// watch.close() stops watching. Only writes made by this server are seen.
+	hputTmpl.Set("watch", v8.NewFunctionTemplate(iso, func(info *v8.FunctionCallbackInfo) *v8.Value {
+		if len(info.Args()) > 1 {
+			return throwError(iso, "hput.watch takes at most 1 argument")
+		}
+		watcher, ok := kv.Find[kv.Watcher](store)
+		if !ok {
+			return throwError(iso, "hput.watch: this server's KV store cannot be watched")
+		}
+		prefix := ""
+		if len(info.Args()) == 1 && !info.Args()[0].IsNullOrUndefined() {
+			prefix = info.Args()[0].String()
+		}
+		obj, err := newWatchObject(ctx, iso, v8ctx, watcher, path, prefix)
+		if err != nil {
+			return throwError(iso, "hput.watch: %s", err)
+		}
+		return obj.Value
+	}))
+
+	// hput.usage() → { keys, bytes, maxKeys?, maxBytes?, maxValueBytes? }
+	// What this namespace stores, and the limits it is held to; a missing
+	// limit means unlimited.
+	hputTmpl.Set("usage", v8.NewFunctionTemplate(iso, func(info *v8.FunctionCallbackInfo) *v8.Value {
+		if len(info.Args()) != 0 {
+			return throwError(iso, "hput.usage takes no arguments")
+		}
+		quota, ok := kv.Find[*kv.Quota](store)
+		if !ok {
+			return throwError(iso, "hput.usage: this server's KV store does not track usage")
+		}
+		u, err := quota.Usage(ctx, path)
+		if err != nil {
+			return throwError(iso, "hput.usage: %s", err)
+		}
+		b, err := json.Marshal(struct {
+			kv.Usage
+			kv.Limits
+		}{u, quota.Limits()})
+		if err != nil {
+			return throwError(iso, "hput.usage: %s", err)
+		}
+		val, err := parseStored(iso, v8ctx, b)
+		if err != nil {
+			return throwError(iso, "hput.usage: %s", err)
+		}
+		return val
+	}))
+
+	// hput.path → the namespace this object reads and writes
+	// Shared objects expose their name instead.
+	if scope.shared == "" {
+		hputTmpl.Set("path", path)
+	} else {
+		hputTmpl.Set("name", scope.shared)
+	}
+
 	hputObj, err := hputTmpl.NewInstance(v8ctx)
 	if err != nil {
-		return fmt.Errorf("creating hput object: %w", err)
+		return nil, fmt.Errorf("creating hput object: %w", err)
 	}
-	return v8ctx.Global().Set("hput", hputObj)
+
+	// hput.transaction(async tx => { ... }) → Promise of fn's result
+	// tx has get, put and delete. Reads and writes are buffered and committed
+	// atomically once fn settles; if another request changed a key this
+	// transaction read, fn is run again against fresh data.
+	begin := v8.NewFunctionTemplate(iso, func(info *v8.FunctionCallbackInfo) *v8.Value {
+		t := &hputTx{reads: map[string][]byte{}, writes: map[string]txWrite{}}
+		obj, err := t.object(ctx, iso, v8ctx, scope, store)
+		if err != nil {
+			return throwError(iso, "hput.transaction: %s", err)
+		}
+		return obj.Value
+	})
+	wrapper, err := v8ctx.RunScript(fmt.Sprintf(transactionScript, maxTransactionAttempts), "hput_transaction")
+	if err != nil {
+		return nil, fmt.Errorf("creating hput.transaction: %w", err)
+	}
+	wrapperFn, err := wrapper.AsFunction()
+	if err != nil {
+		return nil, fmt.Errorf("creating hput.transaction: %w", err)
+	}
+	transaction, err := wrapperFn.Call(v8.Undefined(iso), begin.GetFunction(v8ctx))
+	if err != nil {
+		return nil, fmt.Errorf("creating hput.transaction: %w", err)
+	}
+	if err := hputObj.Set("transaction", transaction); err != nil {
+		return nil, fmt.Errorf("creating hput.transaction: %w", err)
+	}
+	return hputObj, nil
+}
+
+// throwError schedules a JS Error to be thrown when the callback returns,
+// so scripts can catch it instead of the request failing outright.
+func throwError(iso *v8.Isolate, format string, args ...interface{}) *v8.Value {
+	return iso.ThrowException(v8.NewError(iso, fmt.Sprintf(format, args...)).Value)
+}
+
+// writeFailed throws a failed write as an Error, whose code is
+// 'QUOTA_EXCEEDED' if it went over a quota, so scripts can catch it.
+func writeFailed(iso *v8.Isolate, v8ctx *v8.Context, name string, err error) *v8.Value {
+	msg := fmt.Sprintf("%s: %s", name, err)
+	if !errors.Is(err, kv.ErrQuotaExceeded) {
+		return throwError(iso, "%s", msg)
+	}
+	e, scriptErr := v8ctx.RunScript("Object.assign(new Error("+jsonStringLiteral(msg)+"), { code: 'QUOTA_EXCEEDED' })", "hput_quota")
+	if scriptErr != nil {
+		return throwError(iso, "%s", msg)
+	}
+	return iso.ThrowException(e)
+}
+
+// maxTransactionAttempts bounds how often hput.transaction re-runs fn on conflict.
+const maxTransactionAttempts = 10
+
+// transactionScript builds hput.transaction around a Go function that begins
+// a buffered transaction attempt.
+const transactionScript = `(function (begin) {
+	return async function transaction(fn) {
+		for (let attempt = 0; attempt < %d; attempt++) {
+			const tx = begin();
+			const result = await fn(tx);
+			if (tx.commit()) {
+				return result;
+			}
+		}
+		throw new Error('hput.transaction: too many conflicting writes, giving up');
+	};
+})`
+
+// errTxConflict reports that a key read by a transaction changed before commit.
+var errTxConflict = errors.New("transaction conflict")
+
+// hputTx buffers the reads and writes of one hput.transaction attempt.
+type hputTx struct {
+	reads  map[string][]byte
+	writes map[string]txWrite
+}
+
+// txWrite is a buffered transaction write; a nil value records a delete.
+type txWrite struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+// object builds the JS tx object passed to a transaction function.
+func (t *hputTx) object(ctx context.Context, iso *v8.Isolate, v8ctx *v8.Context, scope hputScope, store kv.KV) (*v8.Object, error) {
+	tmpl := v8.NewObjectTemplate(iso)
+	path := scope.path
+
+	// tx.get(key) → value | null
+	tmpl.Set("get", v8.NewFunctionTemplate(iso, func(info *v8.FunctionCallbackInfo) *v8.Value {
+		if len(info.Args()) != 1 {
+			return throwError(iso, "tx.get requires exactly 1 argument")
+		}
+		key := info.Args()[0].String()
+		w, ok := t.writes[key]
+		val := w.value
+		if !ok {
+			if val, ok = t.reads[key]; !ok {
+				var err error
+				if val, err = store.Get(ctx, path, key); err != nil {
+					return throwError(iso, "tx.get: %s", err)
+				}
+				t.reads[key] = val
+			}
+		}
+		parsed, err := parseStored(iso, v8ctx, val)
+		if err != nil {
+			return throwError(iso, "tx.get: parsing stored value: %s", err)
+		}
+		return parsed
+	}))
+
+	// tx.put(key, value, opts?) → undefined
+	tmpl.Set("put", v8.NewFunctionTemplate(iso, func(info *v8.FunctionCallbackInfo) *v8.Value {
+		if len(info.Args()) < 2 || len(info.Args()) > 3 {
+			return throwError(iso, "tx.put requires 2 or 3 arguments")
+		}
+		if denied := scope.denyWrite(iso, "tx.put"); denied != nil {
+			return denied
+		}
+		valueJSON, err := info.Args()[1].MarshalJSON()
+		if err != nil {
+			return throwError(iso, "tx.put: serializing value: %s", err)
+		}
+		expiresAt, err := expiryOption(info.Args(), 2)
+		if err != nil {
+			return throwError(iso, "tx.put: %s", err)
+		}
+		t.writes[info.Args()[0].String()] = txWrite{value: valueJSON, expiresAt: expiresAt}
+		return v8.Undefined(iso)
+	}))
+
+	// tx.delete(key) → undefined
+	tmpl.Set("delete", v8.NewFunctionTemplate(iso, func(info *v8.FunctionCallbackInfo) *v8.Value {
+		if len(info.Args()) != 1 {
+			return throwError(iso, "tx.delete requires exactly 1 argument")
+		}
+		if denied := scope.denyWrite(iso, "tx.delete"); denied != nil {
+			return denied
+		}
+		t.writes[info.Args()[0].String()] = txWrite{}
+		return v8.Undefined(iso)
+	}))
+
+	// tx.commit() → boolean, false if the transaction must be retried
+	tmpl.Set("commit", v8.NewFunctionTemplate(iso, func(info *v8.FunctionCallbackInfo) *v8.Value {
+		err := t.commit(ctx, path, store)
+		if err != nil && !errors.Is(err, errTxConflict) {
+			return writeFailed(iso, v8ctx, "hput.transaction", err)
+		}
+		val, _ := v8.NewValue(iso, err == nil)
+		return val
+	}))
+
+	return tmpl.NewInstance(v8ctx)
+}
+
+// commit applies the buffered writes in one store.Update, provided every key
+// read by the transaction still holds the value that was read.
+func (t *hputTx) commit(ctx context.Context, path string, store kv.KV) error {
+	return store.Update(ctx, path, func(tx kv.Tx) error {
+		for key, read := range t.reads {
+			current, err := tx.Get(key)
+			if err != nil {
+				return err
+			}
+			if (current == nil) != (read == nil) || !bytes.Equal(current, read) {
+				return errTxConflict
+			}
+		}
+		for key, w := range t.writes {
+			var err error
+			if w.value == nil {
+				err = tx.Delete(key)
+			} else {
+				err = tx.PutExpiring(key, w.value, w.expiresAt)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// expiryOption reads { ttl, expiresAt } from args[i], if present, and returns
+// the absolute expiry. ttl is in seconds; expiresAt is a Date or epoch
+// milliseconds. No options means no expiry.
+func expiryOption(args []*v8.Value, i int) (time.Time, error) {
+	if len(args) <= i || args[i].IsNullOrUndefined() {
+		return time.Time{}, nil
+	}
+	obj, err := args[i].AsObject()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("options must be an object")
+	}
+	if v, err := obj.Get("ttl"); err == nil && !v.IsUndefined() {
+		seconds := v.Number()
+		if !v.IsNumber() || math.IsNaN(seconds) || math.IsInf(seconds, 0) || seconds <= 0 {
+			return time.Time{}, fmt.Errorf("ttl must be a positive number of seconds")
+		}
+		return time.Now().Add(time.Duration(seconds * float64(time.Second))), nil
+	}
+	if v, err := obj.Get("expiresAt"); err == nil && !v.IsUndefined() {
+		if (!v.IsNumber() && !v.IsDate()) || math.IsNaN(v.Number()) || math.IsInf(v.Number(), 0) {
+			return time.Time{}, fmt.Errorf("expiresAt must be a Date or epoch milliseconds")
+		}
+		return time.UnixMilli(int64(v.Number())), nil
+	}
+	return time.Time{}, nil
+}
+
+// stringArray reads a JS array of strings.
+func stringArray(v *v8.Value) ([]string, error) {
+	if !v.IsArray() {
+		return nil, fmt.Errorf("keys must be an array of strings")
+	}
+	b, err := v.MarshalJSON()
+	if err != nil {
+		return nil, fmt.Errorf("serializing keys: %w", err)
+	}
+	var keys []string
+	if err := json.Unmarshal(b, &keys); err != nil {
+		return nil, fmt.Errorf("keys must be an array of strings")
+	}
+	return keys, nil
+}
+
+// putManyEntries reads the argument to hput.putMany. A plain object maps keys
+// to values; an array holds { key, value, ttl?, expiresAt? } objects.
+func putManyEntries(v *v8.Value) ([]kv.Entry, error) {
+	if !v.IsArray() {
+		if !v.IsObject() {
+			return nil, fmt.Errorf("entries must be an object or an array")
+		}
+		b, err := v.MarshalJSON()
+		if err != nil {
+			return nil, fmt.Errorf("serializing entries: %w", err)
+		}
+		var m map[string]json.RawMessage
+		if err := json.Unmarshal(b, &m); err != nil {
+			return nil, fmt.Errorf("reading entries: %w", err)
+		}
+		entries := make([]kv.Entry, 0, len(m))
+		for key, val := range m {
+			entries = append(entries, kv.Entry{Key: key, Value: val})
+		}
+		return entries, nil
+	}
+	arr, err := v.AsObject()
+	if err != nil {
+		return nil, err
+	}
+	length, err := arr.Get("length")
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]kv.Entry, 0, length.Uint32())
+	for i := uint32(0); i < length.Uint32(); i++ {
+		item, err := arr.GetIdx(i)
+		if err != nil {
+			return nil, err
+		}
+		obj, err := item.AsObject()
+		if err != nil {
+			return nil, fmt.Errorf("entry %d must be an object with key and value", i)
+		}
+		key, err := obj.Get("key")
+		if err != nil || !key.IsString() {
+			return nil, fmt.Errorf("entry %d must have a string key", i)
+		}
+		val, err := obj.Get("value")
+		if err != nil {
+			return nil, err
+		}
+		valueJSON, err := val.MarshalJSON()
+		if err != nil {
+			return nil, fmt.Errorf("serializing value of %q: %w", key.String(), err)
+		}
+		expiresAt, err := expiryOption([]*v8.Value{item}, 0)
+		if err != nil {
+			return nil, fmt.Errorf("entry %q: %w", key.String(), err)
+		}
+		entries = append(entries, kv.Entry{Key: key.String(), Value: valueJSON, ExpiresAt: expiresAt})
+	}
+	return entries, nil
+}
+
+// listResult builds { keys, values?, cursor } for hput.list and hput.query.
+func listResult(v8ctx *v8.Context, result kv.ListResult, includeValues bool) (*v8.Value, error) {
+	// Build { keys: [...], cursor: "..." } as a JSON string then parse it.
+	keysJSON, _ := json.Marshal(result.Keys)
+	cursorJSON, _ := json.Marshal(result.Cursor)
+	script := fmt.Sprintf("({keys:%s,cursor:%s})", keysJSON, cursorJSON)
+	if includeValues {
+		valuesJSON, err := storedArrayJSON(result.Values)
+		if err != nil {
+			return nil, err
+		}
+		script = fmt.Sprintf("({keys:%s,values:%s,cursor:%s})", keysJSON, valuesJSON, cursorJSON)
+	}
+	val, err := v8ctx.RunScript(script, "hput_list")
+	if err != nil {
+		return nil, fmt.Errorf("building result: %w", err)
+	}
+	return val, nil
+}
+
+// queryOptions reads the optional opts object of hput.query at args[1].
+// Bounds are serialized to JSON; null and undefined leave a bound unset.
+func queryOptions(args []*v8.Value) (kv.Query, error) {
+	var q kv.Query
+	if len(args) < 2 || args[1].IsNullOrUndefined() {
+		return q, nil
+	}
+	obj, err := args[1].AsObject()
+	if err != nil {
+		return q, fmt.Errorf("options must be an object")
+	}
+	bounds := []struct {
+		name string
+		dst  *[]byte
+	}{
+		{"eq", &q.Eq},
+		{"gt", &q.Gt},
+		{"gte", &q.Gte},
+		{"lt", &q.Lt},
+		{"lte", &q.Lte},
+	}
+	for _, b := range bounds {
+		v, err := obj.Get(b.name)
+		if err != nil || v.IsNullOrUndefined() {
+			continue
+		}
+		if *b.dst, err = v.MarshalJSON(); err != nil {
+			return q, fmt.Errorf("serializing %s: %w", b.name, err)
+		}
+	}
+	if v, err := obj.Get("limit"); err == nil && v.IsInt32() {
+		q.Limit = int(v.Int32())
+	}
+	if v, err := obj.Get("cursor"); err == nil && v.IsString() {
+		q.Cursor = v.String()
+	}
+	if v, err := obj.Get("includeValues"); err == nil {
+		q.IncludeValues = v.Boolean()
+	}
+	return q, nil
+}
+
+// storedArrayJSON encodes stored JSON values as one JSON array, with nil as null.
+func storedArrayJSON(vals [][]byte) ([]byte, error) {
+	raw := make([]json.RawMessage, len(vals))
+	for i, v := range vals {
+		if v != nil {
+			raw[i] = v
+		}
+	}
+	b, err := json.Marshal(raw)
+	if err != nil {
+		return nil, fmt.Errorf("encoding stored values: %w", err)
+	}
+	return b, nil
+}
+
+// parseStored converts a stored JSON value into a JS value; nil becomes null.
+func parseStored(iso *v8.Isolate, v8ctx *v8.Context, val []byte) (*v8.Value, error) {
+	if val == nil {
+		return v8.Null(iso), nil
+	}
+	return v8ctx.RunScript("JSON.parse("+jsonStringLiteral(string(val))+")", "hput_get")
 }
 
 // jsonStringLiteral returns a JavaScript string literal containing s,
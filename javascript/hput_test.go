@@ -0,0 +1,615 @@
+package javascript
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"hput/kv"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestStore(t *testing.T) kv.KV {
+	t.Helper()
+	store := kv.NewMemory()
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+// runAt runs code at path against store and returns the response body
+func runAt(t *testing.T, store kv.KV, path, code string) string {
+	t.Helper()
+	js, err := New(&TestLogger{})
+	assert.NoError(t, err)
+	req := &http.Request{Method: http.MethodGet, URL: &url.URL{Path: path}}
+	rec := httptest.NewRecorder()
+	assert.NoError(t, js.Run(code, req, rec, store))
+	return rec.Body.String()
+}
+
+// Test_HputAtomic verifies compareAndSwap, increment and transaction from JS
+func Test_HputAtomic(t *testing.T) {
+	tt := []struct {
+		name string
+		code string
+		want string
+	}{
+		{
+			name: "increment missing key",
+			code: "hput.increment('n')",
+			want: "1",
+		},
+		{
+			name: "increment by delta",
+			code: "hput.increment('n', 5); hput.increment('n', -2)",
+			want: "3",
+		},
+		{
+			name: "compareAndSwap on missing key",
+			code: "JSON.stringify([hput.compareAndSwap('k', null, 'a'), hput.compareAndSwap('k', null, 'b'), hput.get('k')])",
+			want: `[true,false,"a"]`,
+		},
+		{
+			name: "compareAndSwap objects",
+			code: "hput.put('k', {v: 1}); JSON.stringify([hput.compareAndSwap('k', {v: 2}, {v: 3}), hput.compareAndSwap('k', {v: 1}, {v: 3}), hput.get('k')])",
+			want: `[false,true,{"v":3}]`,
+		},
+		{
+			name: "transaction commits",
+			code: `(async () => {
+	await hput.transaction(async tx => {
+		tx.put('a', 1);
+		tx.put('b', tx.get('a') + 1);
+		tx.delete('c');
+	});
+	return JSON.stringify([hput.get('a'), hput.get('b')]);
+})()`,
+			want: `[1,2]`,
+		},
+		{
+			name: "transaction returns result",
+			code: "hput.transaction(tx => 'done')",
+			want: "done",
+		},
+		{
+			name: "throwing transaction writes nothing",
+			code: `(async () => {
+	try {
+		await hput.transaction(async tx => {
+			tx.put('a', 1);
+			throw new Error('nope');
+		});
+	} catch (e) {}
+	return String(hput.get('a'));
+})()`,
+			want: "null",
+		},
+	}
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			store := newTestStore(t)
+			assert.Equal(t, test.want, runAt(t, store, "/pth", test.code))
+		})
+	}
+}
+
+// Test_HputTransactionRetries verifies that a transaction whose read is changed
+// underneath it runs again instead of overwriting the other write
+func Test_HputTransactionRetries(t *testing.T) {
+	store := newTestStore(t)
+	code := `(async () => {
+	let attempts = 0;
+	await hput.transaction(async tx => {
+		attempts++;
+		const n = tx.get('n') || 0;
+		if (attempts === 1) {
+			hput.put('n', 100);
+		}
+		tx.put('n', n + 1);
+	});
+	return JSON.stringify([attempts, hput.get('n')]);
+})()`
+	assert.Equal(t, `[2,101]`, runAt(t, store, "/pth", code))
+}
+
+// Test_HputNoLostUpdates verifies that concurrent requests counting through
+// increment and transaction never lose an update
+func Test_HputNoLostUpdates(t *testing.T) {
+	tt := []struct {
+		name string
+		code string
+	}{
+		{
+			name: "increment",
+			code: "hput.increment('n')",
+		},
+		{
+			name: "transaction",
+			code: "hput.transaction(async tx => { tx.put('n', (tx.get('n') || 0) + 1) })",
+		},
+	}
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			store := newTestStore(t)
+			const workers, perWorker = 4, 10
+			var wg sync.WaitGroup
+			for i := 0; i < workers; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for j := 0; j < perWorker; j++ {
+						runAt(t, store, "/counter", test.code)
+					}
+				}()
+			}
+			wg.Wait()
+			n, err := store.Get(context.Background(), "/counter", "n")
+			assert.NoError(t, err)
+			assert.Equal(t, "40", string(n))
+		})
+	}
+}
+
+// Test_HputExpiry verifies ttl and expiresAt options on writes
+func Test_HputExpiry(t *testing.T) {
+	tt := []struct {
+		name string
+		code string
+		want string
+	}{
+		{
+			name: "ttl keeps value readable",
+			code: "hput.put('s', 'v', { ttl: 60 }); hput.get('s')",
+			want: "v",
+		},
+		{
+			name: "expiresAt in the past reads as missing",
+			code: "hput.put('s', 'v', { expiresAt: new Date(Date.now() - 1000) }); String(hput.get('s'))",
+			want: "null",
+		},
+		{
+			name: "expired keys are not listed",
+			code: "hput.put('a', 1, { expiresAt: Date.now() - 1000 }); hput.put('b', 2, { ttl: 60 }); JSON.stringify(hput.list().keys)",
+			want: `["b"]`,
+		},
+		{
+			name: "increment with ttl",
+			code: "hput.increment('hits', 1, { ttl: 60 }); hput.increment('hits', undefined, { ttl: 1 })",
+			want: "2",
+		},
+		{
+			name: "ttl of NaN throws",
+			code: "try { hput.put('s', 'v', { ttl: NaN }); 'stored' } catch (e) { e.message }",
+			want: "hput.put: ttl must be a positive number of seconds",
+		},
+		{
+			name: "ttl of Infinity throws",
+			code: "try { hput.put('s', 'v', { ttl: Infinity }); 'stored' } catch (e) { e.message }",
+			want: "hput.put: ttl must be a positive number of seconds",
+		},
+		{
+			name: "invalid expiresAt throws",
+			code: "try { hput.put('s', 'v', { expiresAt: new Date('never') }); 'stored' } catch (e) { e.message }",
+			want: "hput.put: expiresAt must be a Date or epoch milliseconds",
+		},
+		{
+			name: "transaction put with expiry",
+			code: "hput.transaction(tx => tx.put('s', 'v', { expiresAt: 0 })).then(() => String(hput.get('s')))",
+			want: "null",
+		},
+	}
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			store := newTestStore(t)
+			assert.Equal(t, test.want, runAt(t, store, "/pth", test.code))
+		})
+	}
+}
+
+// Test_HputBatch verifies getMany, putMany, deleteMany and list with values
+func Test_HputBatch(t *testing.T) {
+	tt := []struct {
+		name string
+		code string
+		want string
+	}{
+		{
+			name: "putMany object then getMany",
+			code: "hput.putMany({ a: 1, b: { x: 'y' } }); JSON.stringify(hput.getMany(['b', 'missing', 'a']))",
+			want: `[{"x":"y"},null,1]`,
+		},
+		{
+			name: "putMany array with expiry",
+			code: "hput.putMany([{ key: 'a', value: 1 }, { key: 'b', value: 2, expiresAt: 0 }, { key: 'c', value: 3, ttl: 60 }]); JSON.stringify(hput.getMany(['a', 'b', 'c']))",
+			want: `[1,null,3]`,
+		},
+		{
+			name: "deleteMany",
+			code: "hput.putMany({ a: 1, b: 2, c: 3 }); hput.deleteMany(['a', 'c', 'missing']); JSON.stringify(hput.list().keys)",
+			want: `["b"]`,
+		},
+		{
+			name: "list with values",
+			code: "hput.putMany({ a: 'x', b: [1, 2], c: null }); JSON.stringify(hput.list({ includeValues: true, limit: 2 }))",
+			want: `{"keys":["a","b"],"values":["x",[1,2]],"cursor":"c"}`,
+		},
+		{
+			name: "list without values",
+			code: "hput.put('a', 1); JSON.stringify(hput.list())",
+			want: `{"keys":["a"],"cursor":""}`,
+		},
+	}
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			store := newTestStore(t)
+			assert.Equal(t, test.want, runAt(t, store, "/pth", test.code))
+		})
+	}
+}
+
+// Test_HputAt verifies that hput.at reaches the script's subtree and nothing else
+func Test_HputAt(t *testing.T) {
+	tt := []struct {
+		name string
+		path string
+		code string
+		want string
+	}{
+		{
+			name: "relative path",
+			path: "/app",
+			code: "hput.at('admin').put('k', 1); hput.at('admin').path",
+			want: "/app/admin",
+		},
+		{
+			name: "absolute path within subtree",
+			path: "/app",
+			code: "hput.at('/app/admin').get('k')",
+			want: "42",
+		},
+		{
+			name: "nested at is relative to its object",
+			path: "/app",
+			code: "hput.at('admin').at('users').path",
+			want: "/app/admin/users",
+		},
+		{
+			name: "absolute path back to own namespace",
+			path: "/app",
+			code: "hput.at('admin').at('/app').get('own')",
+			want: "own",
+		},
+		{
+			name: "sibling is rejected",
+			path: "/app",
+			code: "try { hput.at('/other') } catch (e) { e.message }",
+			want: `hput.at("/other"): kv: path is outside the caller's subtree`,
+		},
+		{
+			name: "parent is rejected",
+			path: "/app/admin",
+			code: "try { hput.at('/app') } catch (e) { e.message }",
+			want: `hput.at("/app"): kv: path is outside the caller's subtree`,
+		},
+		{
+			name: "traversal is rejected",
+			path: "/app",
+			code: "try { hput.at('admin/../../other') } catch (e) { e.message }",
+			want: `hput.at("admin/../../other"): kv: '..' is not allowed in paths`,
+		},
+		{
+			name: "traversal from sub object is rejected",
+			path: "/app",
+			code: "try { hput.at('admin').at('..') } catch (e) { e.message }",
+			want: `hput.at(".."): kv: '..' is not allowed in paths`,
+		},
+		{
+			name: "misused calls throw like hput.at",
+			path: "/app",
+			code: "[() => hput.get(), () => hput.at('admin').put('k'), () => hput.getMany('k')].map(f => { try { f() } catch (e) { return e.message } }).join('; ')",
+			want: "hput.get requires exactly 1 argument; hput.put requires 2 or 3 arguments; hput.getMany: keys must be an array of strings",
+		},
+	}
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := newTestStore(t)
+			assert.NoError(t, store.Put(ctx, "/app/admin", "k", []byte(`42`)))
+			assert.NoError(t, store.Put(ctx, "/app", "own", []byte(`"own"`)))
+			assert.Equal(t, test.want, runAt(t, store, test.path, test.code))
+		})
+	}
+}
+
+// Test_HputAtIsolation verifies that writes through hput.at land in the
+// child's namespace and that the child cannot see its parent
+func Test_HputAtIsolation(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+	runAt(t, store, "/app", "hput.put('parent', 1); hput.at('admin').put('child', 2)")
+
+	v, err := store.Get(ctx, "/app/admin", "child")
+	assert.NoError(t, err)
+	assert.Equal(t, "2", string(v))
+	v, _ = store.Get(ctx, "/app", "child")
+	assert.Nil(t, v)
+
+	assert.Equal(t, `["child"]`, runAt(t, store, "/app/admin", "JSON.stringify(hput.list().keys)"))
+	assert.Equal(t, "null", runAt(t, store, "/app/admin", "String(hput.get('parent'))"))
+}
+
+// Test_HputShared verifies that hput.shared follows the namespace's grants
+func Test_HputShared(t *testing.T) {
+	tt := []struct {
+		name   string
+		grants *kv.Grants
+		path   string
+		code   string
+		want   string
+	}{
+		{
+			name: "no grants",
+			path: "/app",
+			code: "try { hput.shared('flags') } catch (e) { e.message }",
+			want: `hput.shared("flags"): /app has not been granted access`,
+		},
+		{
+			name:   "path outside grants",
+			grants: &kv.Grants{Read: []string{"/other"}},
+			path:   "/app",
+			code:   "try { hput.shared('flags') } catch (e) { e.message }",
+			want:   `hput.shared("flags"): /app has not been granted access`,
+		},
+		{
+			name: "invalid name",
+			path: "/app",
+			code: "try { hput.shared('../x') } catch (e) { e.message }",
+			want: `hput.shared("../x"): kv: shared namespace names may only contain letters, digits, '.', '_' and '-'`,
+		},
+		{
+			name:   "read grant reads",
+			grants: &kv.Grants{Read: []string{"/"}},
+			path:   "/app",
+			code:   "const f = hput.shared('flags'); JSON.stringify([f.name, f.get('beta')])",
+			want:   `["flags",true]`,
+		},
+		{
+			name:   "read grant cannot write",
+			grants: &kv.Grants{Read: []string{"/app"}},
+			path:   "/app/sub",
+			code:   "try { hput.shared('flags').put('beta', false) } catch (e) { e.message }",
+			want:   `hput.put: shared namespace "flags" is read-only for /app/sub`,
+		},
+		{
+			name:   "read grant cannot write in a transaction",
+			grants: &kv.Grants{Read: []string{"/app"}},
+			path:   "/app",
+			code:   "hput.shared('flags').transaction(tx => tx.put('beta', false)).catch(e => e.message)",
+			want:   `tx.put: shared namespace "flags" is read-only for /app`,
+		},
+		{
+			name:   "write grant writes",
+			grants: &kv.Grants{Write: []string{"/admin"}},
+			path:   "/admin",
+			code:   "const f = hput.shared('flags'); f.put('beta', false); f.increment('n'); JSON.stringify(f.list().keys)",
+			want:   `["beta","n"]`,
+		},
+		{
+			name:   "shared objects cannot move",
+			grants: &kv.Grants{Read: []string{"/"}},
+			path:   "/app",
+			code:   "const f = hput.shared('flags'); JSON.stringify([typeof f.at, typeof f.shared, f.path])",
+			want:   `["undefined","undefined",null]`,
+		},
+	}
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := newTestStore(t)
+			ns, err := kv.SharedNamespace("flags")
+			assert.NoError(t, err)
+			assert.NoError(t, store.Put(ctx, ns, "beta", []byte(`true`)))
+			if test.grants != nil {
+				assert.NoError(t, kv.PutGrants(ctx, store, "flags", *test.grants))
+			}
+			assert.Equal(t, test.want, runAt(t, store, test.path, test.code))
+		})
+	}
+}
+
+// Test_HputSharedIsolation verifies shared data is kept apart from every path's own namespace
+func Test_HputSharedIsolation(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+	assert.NoError(t, kv.PutGrants(ctx, store, "flags", kv.Grants{Read: []string{"/"}, Write: []string{"/admin"}}))
+	runAt(t, store, "/admin", "hput.shared('flags').put('beta', true)")
+
+	v, err := store.Get(ctx, "_shared/flags", "beta")
+	assert.NoError(t, err)
+	assert.Equal(t, "true", string(v))
+	assert.Equal(t, "null", runAt(t, store, "/admin", "String(hput.get('beta'))"))
+	assert.Equal(t, "true", runAt(t, store, "/app", "String(hput.shared('flags').get('beta'))"))
+}
+
+// Test_HputQuery verifies createIndex, query and dropIndex from JS
+func Test_HputQuery(t *testing.T) {
+	const users = `hput.putMany({
+	ann: { email: 'ann@x.io', age: 31 },
+	bob: { email: 'bob@x.io', age: 25 },
+	cat: { email: 'cat@x.io', age: 31 },
+});
+hput.createIndex('byEmail', '$.email');
+hput.createIndex('byAge', '$.age');
+`
+	tt := []struct {
+		name string
+		code string
+		want string
+	}{
+		{
+			name: "eq",
+			code: "JSON.stringify(hput.query('byEmail', { eq: 'bob@x.io' }))",
+			want: `{"keys":["bob"],"cursor":""}`,
+		},
+		{
+			name: "range with values",
+			code: "JSON.stringify(hput.query('byAge', { gte: 30, includeValues: true }).values.map(u => u.email))",
+			want: `["ann@x.io","cat@x.io"]`,
+		},
+		{
+			name: "pagination",
+			code: "const p = hput.query('byAge', { limit: 2 }); JSON.stringify([p.keys, hput.query('byAge', { limit: 2, cursor: p.cursor }).keys])",
+			want: `[["bob","ann"],["cat"]]`,
+		},
+		{
+			name: "writes update the index",
+			code: "hput.put('bob', { email: 'bob@y.io', age: 26 }); hput.delete('ann'); JSON.stringify([hput.query('byEmail', { eq: 'bob@x.io' }).keys, hput.query('byAge', { lt: 100 }).keys])",
+			want: `[null,["bob","cat"]]`,
+		},
+		{
+			name: "transaction writes update the index",
+			code: "hput.transaction(tx => tx.put('dan', { email: 'dan@x.io', age: 1 })).then(() => hput.query('byAge', { lt: 20 }).keys[0])",
+			want: "dan",
+		},
+		{
+			name: "unknown index",
+			code: "try { hput.query('byName', { eq: 'ann' }) } catch (e) { e.message }",
+			want: `hput.query("byName"): kv: query: kv: no such index: "byName"`,
+		},
+		{
+			name: "dropped index",
+			code: "hput.dropIndex('byAge'); try { hput.query('byAge') } catch (e) { e.message }",
+			want: `hput.query("byAge"): kv: query: kv: no such index: "byAge"`,
+		},
+		{
+			name: "bad query",
+			code: "try { hput.query('byAge', { eq: 1, lt: 2 }) } catch (e) { e.message }",
+			want: `hput.query("byAge"): kv: invalid query: eq cannot be combined with other bounds`,
+		},
+		{
+			name: "bad field",
+			code: "try { hput.createIndex('x', 'email') } catch (e) { e.message }",
+			want: `hput.createIndex("x"): kv: index names must not be empty and fields must look like $.name: "email"`,
+		},
+	}
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			store := newTestStore(t)
+			assert.Equal(t, test.want, runAt(t, store, "/users", users+test.code))
+		})
+	}
+}
+
+// Test_HputWatch verifies that hput.watch reports changes to the script's own namespace
+func Test_HputWatch(t *testing.T) {
+	tt := []struct {
+		name string
+		code string
+		want string
+	}{
+		{
+			name: "puts and deletes",
+			code: "const w = hput.watch(); hput.put('k', { a: 1 }); hput.delete('k'); JSON.stringify([w.next(1000), w.next(1000), w.next(10)])",
+			want: `[{"path":"/app","type":"put","key":"k","value":{"a":1}},{"path":"/app","type":"delete","key":"k","value":null},null]`,
+		},
+		{
+			name: "prefix",
+			code: "const w = hput.watch('user:'); hput.putMany({ 'post:1': 1, 'user:1': 2 }); JSON.stringify([w.next(1000), w.next(10)])",
+			want: `[{"path":"/app","type":"put","key":"user:1","value":2},null]`,
+		},
+		{
+			name: "other namespaces are not seen",
+			code: "const w = hput.watch(); hput.at('sub').put('k', 1); JSON.stringify(w.next(10))",
+			want: `null`,
+		},
+		{
+			name: "transactions are seen once committed",
+			code: "const w = hput.watch(); hput.transaction(tx => { tx.put('a', 1); tx.put('a', 2) }).then(() => JSON.stringify([w.next(1000), w.next(10)]))",
+			want: `[{"path":"/app","type":"put","key":"a","value":2},null]`,
+		},
+		{
+			name: "closed",
+			code: "const w = hput.watch(); w.close(); hput.put('k', 1); JSON.stringify(w.next())",
+			want: `null`,
+		},
+	}
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			store := kv.NewNotifier(newTestStore(t))
+			assert.Equal(t, test.want, runAt(t, store, "/app", test.code))
+		})
+	}
+
+	t.Run("writes from other requests", func(t *testing.T) {
+		store := kv.NewNotifier(newTestStore(t))
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			store.Put(context.Background(), "/app", "k", []byte(`"elsewhere"`))
+		}()
+		assert.Equal(t, "elsewhere", runAt(t, store, "/app", "hput.watch().next(5000).value"))
+	})
+
+	t.Run("store cannot be watched", func(t *testing.T) {
+		assert.Equal(t, "hput.watch: this server's KV store cannot be watched", runAt(t, newTestStore(t), "/app", "try { hput.watch() } catch (e) { e.message }"))
+	})
+}
+
+// Test_HputQuota verifies hput.usage and that writes over a quota throw catchable errors
+func Test_HputQuota(t *testing.T) {
+	tt := []struct {
+		name string
+		code string
+		want string
+	}{
+		{
+			name: "usage",
+			code: "hput.put('a', 1); hput.put('b', 'xy'); JSON.stringify(hput.usage())",
+			want: `{"keys":2,"bytes":7,"maxValueBytes":10,"maxKeys":2,"maxBytes":20}`,
+		},
+		{
+			name: "value too large",
+			code: "try { hput.put('a', 'xxxxxxxxxxxx') } catch (e) { e.code + ' ' + e.message }",
+			want: "QUOTA_EXCEEDED hput.put: kv: quota exceeded: a value of 14 bytes is over the limit of 10 bytes",
+		},
+		{
+			name: "too many keys",
+			code: "hput.put('a', 1); hput.put('b', 1); try { hput.put('c', 1) } catch (e) { e.message }",
+			want: "hput.put: kv: quota exceeded: /app would hold 3 keys, over its limit of 2",
+		},
+		{
+			name: "overwrite at the limit",
+			code: "hput.put('a', 1); hput.put('b', 1); hput.put('a', 2); hput.get('a')",
+			want: "2",
+		},
+		{
+			name: "too many bytes in a batch",
+			code: "try { hput.putMany({ a: 'xxxxxxxx', b: 'xxxxxxxx' }) } catch (e) { e.code + ' ' + hput.usage().bytes }",
+			want: "QUOTA_EXCEEDED 0",
+		},
+		{
+			name: "transaction",
+			code: "hput.transaction(tx => { tx.put('a', 1); tx.put('b', 1); tx.put('c', 1) }).catch(e => e.code + ' ' + hput.usage().keys)",
+			want: "QUOTA_EXCEEDED 0",
+		},
+		{
+			name: "increment",
+			code: "hput.put('a', 1); hput.put('b', 1); try { hput.increment('c') } catch (e) { e.code }",
+			want: "QUOTA_EXCEEDED",
+		},
+	}
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			store := kv.NewQuota(newTestStore(t), kv.Limits{MaxValueBytes: 10, MaxKeys: 2, MaxBytes: 20})
+			assert.Equal(t, test.want, runAt(t, store, "/app", test.code))
+		})
+	}
+
+	t.Run("usage without quotas", func(t *testing.T) {
+		assert.Equal(t, "hput.usage: this server's KV store does not track usage", runAt(t, newTestStore(t), "/app", "try { hput.usage() } catch (e) { e.message }"))
+	})
+}
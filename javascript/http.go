@@ -211,6 +211,18 @@ func (e *express) attachResponse(w http.ResponseWriter) error {
 		return resObj.Value
 	})
 	res.Set("send", sendFn)
+	// write sends a chunk of the body at once, so handlers can stream
+	writeFn := v8.NewFunctionTemplate(e.RunVM, func(info *v8.FunctionCallbackInfo) *v8.Value {
+		if len(info.Args()) != 1 {
+			panic("Provide 1 parameter")
+		}
+		w.Write([]byte(info.Args()[0].String()))
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+		return resObj.Value
+	})
+	res.Set("write", writeFn)
 	sendStatusFn := v8.NewFunctionTemplate(e.RunVM, func(info *v8.FunctionCallbackInfo) *v8.Value {
 		if len(info.Args()) != 1 {
 			panic("Provide 1 parameter")
@@ -71,10 +71,11 @@ func (j *Javascript) IsCode(s string) (bool, string) {
 // Adds objects to the global context:
 // console.log logs out at INFO level
 // request: has express fields for: body, cookies, hostname, ip, method, path, protocol, query
-// response: has express functions for: append, cookie, json, location, redirect, sendStatus, set, status
+// response: has express functions for: append, cookie, json, location, redirect, sendStatus, set, status, write
 // fetch: standard fetch API
 // setTimeout/setInterval/clearTimeout/clearInterval: timer APIs
-// hput: per-path private KV store (get, put, delete, list)
+// hput: per-path private KV store (get, put, delete, list, getMany, putMany, deleteMany, at,
+// shared, compareAndSwap, increment, transaction, createIndex, dropIndex, query, watch)
 func (j *Javascript) Run(c string, r *http.Request, w http.ResponseWriter, store kv.KV) error {
 	j.Logger.Debugf("Running code: %s", c)
 
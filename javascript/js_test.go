@@ -193,6 +193,15 @@ func Test_Run(t *testing.T) {
 			},
 			msgIncludes: []string{`{"a":"b"}`},
 		},
+		{
+			name: "stream with write",
+			code: "response.write('chunk 1, '); response.write('chunk 2')",
+			r: &http.Request{
+				Method: http.MethodGet,
+				URL:    &url.URL{Path: "/pth"},
+			},
+			msgIncludes: []string{"chunk 1, chunk 2"},
+		},
 		{
 			name: "send status",
 			code: "response.sendStatus(100)",
@@ -0,0 +1,74 @@
+package javascript
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hput/kv"
+	"time"
+
+	v8 "github.com/tommie/v8go"
+)
+
+// newWatchObject builds the object returned by hput.watch. It watches until
+// close() is called or the request ends.
+func newWatchObject(ctx context.Context, iso *v8.Isolate, v8ctx *v8.Context, watcher kv.Watcher, path, prefix string) (*v8.Object, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	events := watcher.Watch(ctx, path, prefix)
+	tmpl := v8.NewObjectTemplate(iso)
+
+	// watch.next(timeoutMs?) → { path, type, key, value } | null
+	// Blocks until the next change. Returns null once timeoutMs passes, the
+	// request ends or the watch is closed; without timeoutMs it waits for one of the others.
+	tmpl.Set("next", v8.NewFunctionTemplate(iso, func(info *v8.FunctionCallbackInfo) *v8.Value {
+		if len(info.Args()) > 1 {
+			return throwError(iso, "watch.next takes at most 1 argument")
+		}
+		if ctx.Err() != nil {
+			return v8.Null(iso) // closed; don't race pending events against ctx.Done
+		}
+		var timeout <-chan time.Time
+		if len(info.Args()) == 1 && !info.Args()[0].IsNullOrUndefined() {
+			timer := time.NewTimer(time.Duration(info.Args()[0].Number() * float64(time.Millisecond)))
+			defer timer.Stop()
+			timeout = timer.C
+		}
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return v8.Null(iso)
+			}
+			val, err := eventValue(v8ctx, e)
+			if err != nil {
+				return throwError(iso, "watch.next: %s", err)
+			}
+			return val
+		case <-timeout:
+			return v8.Null(iso)
+		case <-ctx.Done():
+			return v8.Null(iso)
+		}
+	}))
+
+	// watch.close() → undefined; stops watching
+	tmpl.Set("close", v8.NewFunctionTemplate(iso, func(info *v8.FunctionCallbackInfo) *v8.Value {
+		cancel()
+		return v8.Undefined(iso)
+	}))
+
+	obj, err := tmpl.NewInstance(v8ctx)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("creating watch object: %w", err)
+	}
+	return obj, nil
+}
+
+// eventValue converts e into a JS { path, type, key, value } object.
+func eventValue(v8ctx *v8.Context, e kv.Event) (*v8.Value, error) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return nil, fmt.Errorf("encoding event: %w", err)
+	}
+	return v8ctx.RunScript("("+string(b)+")", "hput_watch")
+}
@@ -0,0 +1,64 @@
+package kv
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// CompareAndSwap stores next at key only if the current value equals expected.
+// A nil expected means the key must not exist. Values are compared byte for
+// byte and next is stored without an expiry. Reports whether the swap happened.
+func CompareAndSwap(ctx context.Context, store KV, path, key string, expected, next []byte) (bool, error) {
+	var swapped bool
+	err := store.Update(ctx, path, func(tx Tx) error {
+		current, err := tx.Get(key)
+		if err != nil {
+			return err
+		}
+		if (current == nil) != (expected == nil) || !bytes.Equal(current, expected) {
+			return nil
+		}
+		swapped = true
+		return tx.Put(key, next)
+	})
+	if err != nil {
+		return false, fmt.Errorf("kv: compare and swap: %w", err)
+	}
+	return swapped, nil
+}
+
+// Increment atomically adds delta to the JSON number stored at key and
+// returns the new value. A missing key counts as 0 and is created with the
+// given expiresAt (zero for none); an existing key keeps its expiry, so a
+// counter created with an expiry works as a fixed window.
+func Increment(ctx context.Context, store KV, path, key string, delta float64, expiresAt time.Time) (float64, error) {
+	var n float64
+	err := store.Update(ctx, path, func(tx Tx) error {
+		current, err := tx.Get(key)
+		if err != nil {
+			return err
+		}
+		n = 0
+		if current != nil {
+			if err := json.Unmarshal(current, &n); err != nil {
+				return fmt.Errorf("value at %q is not a number", key)
+			}
+			if expiresAt, err = tx.ExpiresAt(key); err != nil {
+				return err
+			}
+		}
+		n += delta
+		next, err := json.Marshal(n)
+		if err != nil {
+			return err
+		}
+		return tx.PutExpiring(key, next, expiresAt)
+	})
+	if err != nil {
+		return 0, fmt.Errorf("kv: increment: %w", err)
+	}
+	return n, nil
+}
@@ -2,7 +2,9 @@ package kv
 
 import (
 	"context"
+	"encoding/binary"
 	"fmt"
+	"time"
 
 	bolt "go.etcd.io/bbolt"
 )
@@ -22,9 +24,12 @@ func NewBbolt(file string) (*BboltKV, error) {
 	if err != nil {
 		return nil, fmt.Errorf("kv: opening bbolt db: %w", err)
 	}
-	// Ensure the top-level bucket exists.
+	// Ensure the top-level buckets exist.
 	err = db.Update(func(tx *bolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists(topBucket)
+		if _, err := tx.CreateBucketIfNotExists(topBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(indexTopBucket)
 		return err
 	})
 	if err != nil {
@@ -45,11 +50,7 @@ func (b *BboltKV) Get(_ context.Context, path, key string) ([]byte, error) {
 		if pb == nil {
 			return nil
 		}
-		v := pb.Get([]byte(key))
-		if v != nil {
-			val = make([]byte, len(v))
-			copy(val, v)
-		}
+		val, _ = readLive(pb, key, time.Now())
 		return nil
 	})
 	if err != nil {
@@ -58,13 +59,21 @@ func (b *BboltKV) Get(_ context.Context, path, key string) ([]byte, error) {
 	return val, nil
 }
 
-func (b *BboltKV) Put(_ context.Context, path, key string, value []byte) error {
+func (b *BboltKV) Put(ctx context.Context, path, key string, value []byte) error {
+	return b.PutExpiring(ctx, path, key, value, time.Time{})
+}
+
+func (b *BboltKV) PutExpiring(_ context.Context, path, key string, value []byte, expiresAt time.Time) error {
 	return b.db.Update(func(tx *bolt.Tx) error {
 		pb, err := tx.Bucket(topBucket).CreateBucketIfNotExists([]byte(path))
 		if err != nil {
 			return fmt.Errorf("kv: creating path bucket %q: %w", path, err)
 		}
-		return pb.Put([]byte(key), value)
+		ix, err := loadIndexes(tx, path)
+		if err != nil {
+			return err
+		}
+		return ix.put(pb, key, value, expiresAt)
 	})
 }
 
@@ -74,12 +83,119 @@ func (b *BboltKV) Delete(_ context.Context, path, key string) error {
 		if pb == nil {
 			return nil
 		}
-		return pb.Delete([]byte(key))
+		ix, err := loadIndexes(tx, path)
+		if err != nil {
+			return err
+		}
+		return ix.delete(pb, key)
+	})
+}
+
+func (b *BboltKV) GetMany(_ context.Context, path string, keys []string) ([][]byte, error) {
+	vals := make([][]byte, len(keys))
+	now := time.Now()
+	err := b.db.View(func(tx *bolt.Tx) error {
+		pb := tx.Bucket(topBucket).Bucket([]byte(path))
+		if pb == nil {
+			return nil
+		}
+		for i, key := range keys {
+			vals[i], _ = readLive(pb, key, now)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("kv: get many: %w", err)
+	}
+	return vals, nil
+}
+
+func (b *BboltKV) PutMany(_ context.Context, path string, entries []Entry) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		pb, err := tx.Bucket(topBucket).CreateBucketIfNotExists([]byte(path))
+		if err != nil {
+			return fmt.Errorf("kv: creating path bucket %q: %w", path, err)
+		}
+		ix, err := loadIndexes(tx, path)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if err := ix.put(pb, e.Key, e.Value, e.ExpiresAt); err != nil {
+				return fmt.Errorf("kv: put many: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+func (b *BboltKV) DeleteMany(_ context.Context, path string, keys []string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		pb := tx.Bucket(topBucket).Bucket([]byte(path))
+		if pb == nil {
+			return nil
+		}
+		ix, err := loadIndexes(tx, path)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if err := ix.delete(pb, key); err != nil {
+				return fmt.Errorf("kv: delete many: %w", err)
+			}
+		}
+		return nil
 	})
 }
 
+// Update runs fn inside one bbolt read-write transaction. bbolt allows a
+// single writer at a time, so concurrent Updates are serialized.
+func (b *BboltKV) Update(_ context.Context, path string, fn func(tx Tx) error) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		pb, err := tx.Bucket(topBucket).CreateBucketIfNotExists([]byte(path))
+		if err != nil {
+			return fmt.Errorf("kv: creating path bucket %q: %w", path, err)
+		}
+		ix, err := loadIndexes(tx, path)
+		if err != nil {
+			return err
+		}
+		return fn(bboltTx{bucket: pb, indexes: ix, now: time.Now()})
+	})
+}
+
+// bboltTx implements Tx over a path bucket inside an open bbolt transaction.
+type bboltTx struct {
+	bucket  *bolt.Bucket
+	indexes bboltIndexes
+	now     time.Time
+}
+
+func (t bboltTx) Get(key string) ([]byte, error) {
+	val, _ := readLive(t.bucket, key, t.now)
+	return val, nil
+}
+
+func (t bboltTx) ExpiresAt(key string) (time.Time, error) {
+	_, expiresAt := readLive(t.bucket, key, t.now)
+	return expiresAt, nil
+}
+
+func (t bboltTx) Put(key string, value []byte) error {
+	return t.PutExpiring(key, value, time.Time{})
+}
+
+func (t bboltTx) PutExpiring(key string, value []byte, expiresAt time.Time) error {
+	return t.indexes.put(t.bucket, key, value, expiresAt)
+}
+
+func (t bboltTx) Delete(key string) error {
+	return t.indexes.delete(t.bucket, key)
+}
+
 func (b *BboltKV) List(_ context.Context, path string, opts ListOptions) (ListResult, error) {
 	var result ListResult
+	now := time.Now()
 	err := b.db.View(func(tx *bolt.Tx) error {
 		pb := tx.Bucket(topBucket).Bucket([]byte(path))
 		if pb == nil {
@@ -98,18 +214,24 @@ func (b *BboltKV) List(_ context.Context, path string, opts ListOptions) (ListRe
 		} else {
 			k, v = c.First()
 		}
-		_ = v
 
-		for ; k != nil; k, _ = c.Next() {
+		for ; k != nil; k, v = c.Next() {
 			if len(prefix) > 0 && !hasPrefix(k, prefix) {
 				break
 			}
+			val, expiresAt := decodeValue(v)
+			if expired(expiresAt, now) {
+				continue
+			}
 			if opts.Limit > 0 && len(result.Keys) >= opts.Limit {
 				// There are more results — encode next key as cursor.
 				result.Cursor = string(k)
 				return nil
 			}
 			result.Keys = append(result.Keys, string(k))
+			if opts.IncludeValues {
+				result.Values = append(result.Values, append([]byte{}, val...))
+			}
 		}
 		return nil
 	})
@@ -119,6 +241,118 @@ func (b *BboltKV) List(_ context.Context, path string, opts ListOptions) (ListRe
 	return result, nil
 }
 
+// Paths walks the path buckets, skipping those whose keys have all expired.
+func (b *BboltKV) Paths(_ context.Context) ([]string, error) {
+	var paths []string
+	now := time.Now()
+	err := b.db.View(func(tx *bolt.Tx) error {
+		top := tx.Bucket(topBucket)
+		return top.ForEachBucket(func(path []byte) error {
+			c := top.Bucket(path).Cursor()
+			for k, v := c.First(); k != nil; k, v = c.Next() {
+				if _, expiresAt := decodeValue(v); !expired(expiresAt, now) {
+					paths = append(paths, string(path))
+					return nil
+				}
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("kv: paths: %w", err)
+	}
+	return paths, nil
+}
+
+// PurgeExpired walks every path bucket and deletes keys that expired before now.
+func (b *BboltKV) PurgeExpired(_ context.Context, now time.Time) (int, error) {
+	var purged int
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		top := tx.Bucket(topBucket)
+		return top.ForEachBucket(func(path []byte) error {
+			pb := top.Bucket(path)
+			var dead [][]byte
+			err := pb.ForEach(func(k, v []byte) error {
+				if _, expiresAt := decodeValue(v); expired(expiresAt, now) {
+					dead = append(dead, k)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			ix, err := loadIndexes(tx, string(path))
+			if err != nil {
+				return err
+			}
+			// Delete after iterating; deleting under a live cursor can skip keys.
+			for _, k := range dead {
+				if err := ix.delete(pb, string(k)); err != nil {
+					return err
+				}
+			}
+			purged += len(dead)
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, fmt.Errorf("kv: purge expired: %w", err)
+	}
+	return purged, nil
+}
+
+// readLive returns a copy of the value at key and its expiry,
+// treating a value that expired before now as missing.
+func readLive(b *bolt.Bucket, key string, now time.Time) ([]byte, time.Time) {
+	raw := b.Get([]byte(key))
+	if raw == nil {
+		return nil, time.Time{}
+	}
+	v, expiresAt := decodeValue(raw)
+	if expired(expiresAt, now) {
+		return nil, time.Time{}
+	}
+	val := make([]byte, len(v))
+	copy(val, v)
+	return val, expiresAt
+}
+
+// envelopeMarker starts every stored value that carries an expiry.
+// Values without an expiry are stored as-is so databases written before
+// expiry support still read back unchanged.
+const envelopeMarker = 0x00
+
+// envelopeHeader is the marker byte plus the expiry as big-endian unix nanoseconds.
+const envelopeHeader = 1 + 8
+
+// encodeValue wraps value with its expiry. A value that happens to start with
+// the marker byte is always wrapped so it cannot be mistaken for an envelope.
+func encodeValue(value []byte, expiresAt time.Time) []byte {
+	if expiresAt.IsZero() && (len(value) == 0 || value[0] != envelopeMarker) {
+		return value
+	}
+	out := make([]byte, envelopeHeader+len(value))
+	out[0] = envelopeMarker
+	if !expiresAt.IsZero() {
+		// 0 means "never", so anything at or before the epoch is stored as 1ns after it.
+		binary.BigEndian.PutUint64(out[1:envelopeHeader], uint64(max(expiresAt.UnixNano(), 1)))
+	}
+	copy(out[envelopeHeader:], value)
+	return out
+}
+
+// decodeValue reverses encodeValue. The returned slice aliases raw.
+func decodeValue(raw []byte) ([]byte, time.Time) {
+	if len(raw) < envelopeHeader || raw[0] != envelopeMarker {
+		return raw, time.Time{}
+	}
+	var expiresAt time.Time
+	if n := binary.BigEndian.Uint64(raw[1:envelopeHeader]); n != 0 {
+		expiresAt = time.Unix(0, int64(n))
+	}
+	return raw[envelopeHeader:], expiresAt
+}
+
 func hasPrefix(key, prefix []byte) bool {
 	if len(key) < len(prefix) {
 		return false
@@ -0,0 +1,219 @@
+package kv
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Indexes live beside the data in a second top-level bucket, "hput-kv-indexes",
+// with one sub-bucket per path. That holds a "defs" bucket of index name to
+// field, and one "i/<name>" bucket per index whose keys are indexEntry keys
+// and whose values are the keys they point at.
+var (
+	indexTopBucket  = []byte("hput-kv-indexes")
+	indexDefsBucket = []byte("defs")
+)
+
+func indexBucketName(name string) []byte {
+	return []byte("i/" + name)
+}
+
+// bboltIndex is one index of a path, open in a read-write transaction.
+type bboltIndex struct {
+	field  []string
+	bucket *bolt.Bucket
+}
+
+// bboltIndexes are every index of one path. Writes to the path go through
+// put and delete so the indexes change in the same transaction as the data.
+type bboltIndexes []bboltIndex
+
+// loadIndexes opens the indexes of path inside tx.
+func loadIndexes(tx *bolt.Tx, path string) (bboltIndexes, error) {
+	pib := tx.Bucket(indexTopBucket).Bucket([]byte(path))
+	if pib == nil {
+		return nil, nil
+	}
+	defs := pib.Bucket(indexDefsBucket)
+	if defs == nil {
+		return nil, nil
+	}
+	var ix bboltIndexes
+	err := defs.ForEach(func(name, field []byte) error {
+		segs, err := parseField(string(field))
+		if err != nil {
+			return fmt.Errorf("kv: index %q of %q: %w", name, path, err)
+		}
+		ix = append(ix, bboltIndex{field: segs, bucket: pib.Bucket(indexBucketName(string(name)))})
+		return nil
+	})
+	return ix, err
+}
+
+// put stores value at key in pb and updates every index.
+func (ix bboltIndexes) put(pb *bolt.Bucket, key string, value []byte, expiresAt time.Time) error {
+	if err := ix.unindex(pb, key); err != nil {
+		return err
+	}
+	if err := pb.Put([]byte(key), encodeValue(value, expiresAt)); err != nil {
+		return err
+	}
+	for _, i := range ix {
+		if e, ok := indexEntry(value, i.field, key); ok {
+			if err := i.bucket.Put(e, []byte(key)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// delete removes key from pb and from every index.
+func (ix bboltIndexes) delete(pb *bolt.Bucket, key string) error {
+	if err := ix.unindex(pb, key); err != nil {
+		return err
+	}
+	return pb.Delete([]byte(key))
+}
+
+// unindex removes the index entries of the value currently stored at key.
+func (ix bboltIndexes) unindex(pb *bolt.Bucket, key string) error {
+	if len(ix) == 0 {
+		return nil
+	}
+	raw := pb.Get([]byte(key))
+	if raw == nil {
+		return nil
+	}
+	old, _ := decodeValue(raw)
+	for _, i := range ix {
+		if e, ok := indexEntry(old, i.field, key); ok {
+			if err := i.bucket.Delete(e); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// CreateIndex indexes path by field, indexing the values already stored.
+func (b *BboltKV) CreateIndex(_ context.Context, path, name, field string) error {
+	segs, err := validIndex(name, field)
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		pib, err := tx.Bucket(indexTopBucket).CreateBucketIfNotExists([]byte(path))
+		if err != nil {
+			return fmt.Errorf("kv: creating index bucket %q: %w", path, err)
+		}
+		defs, err := pib.CreateBucketIfNotExists(indexDefsBucket)
+		if err != nil {
+			return fmt.Errorf("kv: creating index bucket %q: %w", path, err)
+		}
+		if string(defs.Get([]byte(name))) == field {
+			return nil
+		}
+		if err := defs.Put([]byte(name), []byte(field)); err != nil {
+			return fmt.Errorf("kv: create index: %w", err)
+		}
+		if pib.Bucket(indexBucketName(name)) != nil {
+			if err := pib.DeleteBucket(indexBucketName(name)); err != nil {
+				return fmt.Errorf("kv: create index: %w", err)
+			}
+		}
+		ib, err := pib.CreateBucket(indexBucketName(name))
+		if err != nil {
+			return fmt.Errorf("kv: create index: %w", err)
+		}
+		pb := tx.Bucket(topBucket).Bucket([]byte(path))
+		if pb == nil {
+			return nil
+		}
+		return pb.ForEach(func(k, v []byte) error {
+			val, _ := decodeValue(v)
+			if e, ok := indexEntry(val, segs, string(k)); ok {
+				return ib.Put(e, k)
+			}
+			return nil
+		})
+	})
+}
+
+// DropIndex removes the named index of path.
+func (b *BboltKV) DropIndex(_ context.Context, path, name string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		pib := tx.Bucket(indexTopBucket).Bucket([]byte(path))
+		if pib == nil || pib.Bucket(indexDefsBucket) == nil {
+			return nil
+		}
+		if err := pib.Bucket(indexDefsBucket).Delete([]byte(name)); err != nil {
+			return fmt.Errorf("kv: drop index: %w", err)
+		}
+		if pib.Bucket(indexBucketName(name)) == nil {
+			return nil
+		}
+		return pib.DeleteBucket(indexBucketName(name))
+	})
+}
+
+// Query walks the named index of path and returns the live keys in range.
+func (b *BboltKV) Query(_ context.Context, path, name string, q Query) (ListResult, error) {
+	s, err := q.scan()
+	if err != nil {
+		return ListResult{}, err
+	}
+	var result ListResult
+	now := time.Now()
+	err = b.db.View(func(tx *bolt.Tx) error {
+		pib := tx.Bucket(indexTopBucket).Bucket([]byte(path))
+		var ib *bolt.Bucket
+		if pib != nil {
+			ib = pib.Bucket(indexBucketName(name))
+		}
+		if ib == nil {
+			return fmt.Errorf("%w: %q", ErrNoIndex, name)
+		}
+		pb := tx.Bucket(topBucket).Bucket([]byte(path))
+		if pb == nil {
+			return nil
+		}
+
+		c := ib.Cursor()
+		var k, v []byte
+		if s.seek != nil {
+			k, v = c.Seek(s.seek)
+		} else {
+			k, v = c.First()
+		}
+		for ; k != nil; k, v = c.Next() {
+			ok, stop := s.match(k)
+			if stop {
+				break
+			}
+			if !ok {
+				continue
+			}
+			val, _ := readLive(pb, string(v), now)
+			if val == nil {
+				continue
+			}
+			if q.Limit > 0 && len(result.Keys) >= q.Limit {
+				result.Cursor = indexCursor(k)
+				return nil
+			}
+			result.Keys = append(result.Keys, string(v))
+			if q.IncludeValues {
+				result.Values = append(result.Values, val)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return ListResult{}, fmt.Errorf("kv: query: %w", err)
+	}
+	return result, nil
+}
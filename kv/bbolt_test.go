@@ -0,0 +1,270 @@
+package kv
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestBbolt(t *testing.T) *BboltKV {
+	t.Helper()
+	store, err := NewBbolt(filepath.Join(t.TempDir(), "kv.db"))
+	assert.NoError(t, err)
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+// TestBboltUpdate verifies that Update applies all writes or none of them
+func TestBboltUpdate(t *testing.T) {
+	ctx := context.Background()
+	store := newTestBbolt(t)
+	assert.NoError(t, store.Put(ctx, "/pth", "a", []byte(`1`)))
+
+	err := store.Update(ctx, "/pth", func(tx Tx) error {
+		v, err := tx.Get("a")
+		assert.NoError(t, err)
+		assert.Equal(t, []byte(`1`), v)
+		assert.NoError(t, tx.Put("b", []byte(`2`)))
+		return tx.Delete("a")
+	})
+	assert.NoError(t, err)
+	a, _ := store.Get(ctx, "/pth", "a")
+	b, _ := store.Get(ctx, "/pth", "b")
+	assert.Nil(t, a)
+	assert.Equal(t, []byte(`2`), b)
+
+	boom := errors.New("boom")
+	err = store.Update(ctx, "/pth", func(tx Tx) error {
+		assert.NoError(t, tx.Put("b", []byte(`3`)))
+		return boom
+	})
+	assert.ErrorIs(t, err, boom)
+	b, _ = store.Get(ctx, "/pth", "b")
+	assert.Equal(t, []byte(`2`), b, "failed Update must not apply writes")
+
+	other, _ := store.Get(ctx, "/other", "b")
+	assert.Nil(t, other, "Update must stay inside its path")
+}
+
+// TestCompareAndSwap verifies that a swap only happens when the current value matches
+func TestCompareAndSwap(t *testing.T) {
+	tt := []struct {
+		name     string
+		existing []byte
+		expected []byte
+		swapped  bool
+		final    []byte
+	}{
+		{
+			name:     "missing key expected missing",
+			expected: nil,
+			swapped:  true,
+			final:    []byte(`"next"`),
+		},
+		{
+			name:     "missing key expected value",
+			expected: []byte(`"old"`),
+			final:    nil,
+		},
+		{
+			name:     "matching value",
+			existing: []byte(`"old"`),
+			expected: []byte(`"old"`),
+			swapped:  true,
+			final:    []byte(`"next"`),
+		},
+		{
+			name:     "different value",
+			existing: []byte(`"other"`),
+			expected: []byte(`"old"`),
+			final:    []byte(`"other"`),
+		},
+		{
+			name:     "existing value expected missing",
+			existing: []byte(`"other"`),
+			expected: nil,
+			final:    []byte(`"other"`),
+		},
+	}
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := newTestBbolt(t)
+			if test.existing != nil {
+				assert.NoError(t, store.Put(ctx, "/pth", "k", test.existing))
+			}
+			swapped, err := CompareAndSwap(ctx, store, "/pth", "k", test.expected, []byte(`"next"`))
+			assert.NoError(t, err)
+			assert.Equal(t, test.swapped, swapped)
+			final, _ := store.Get(ctx, "/pth", "k")
+			assert.Equal(t, test.final, final)
+		})
+	}
+}
+
+// TestIncrementConcurrent verifies that concurrent increments never lose an update
+func TestIncrementConcurrent(t *testing.T) {
+	ctx := context.Background()
+	store := newTestBbolt(t)
+	const workers, perWorker = 8, 50
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < perWorker; j++ {
+				_, err := Increment(ctx, store, "/counter", "n", 1, time.Time{})
+				assert.NoError(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	n, err := Increment(ctx, store, "/counter", "n", 0, time.Time{})
+	assert.NoError(t, err)
+	assert.Equal(t, float64(workers*perWorker), n)
+}
+
+// TestIncrementNotNumber verifies that incrementing a non-numeric value fails without changing it
+func TestIncrementNotNumber(t *testing.T) {
+	ctx := context.Background()
+	store := newTestBbolt(t)
+	assert.NoError(t, store.Put(ctx, "/pth", "k", []byte(`"text"`)))
+	_, err := Increment(ctx, store, "/pth", "k", 1, time.Time{})
+	assert.Error(t, err)
+	v, _ := store.Get(ctx, "/pth", "k")
+	assert.Equal(t, []byte(`"text"`), v)
+}
+
+// TestBboltExpiry verifies that expired keys read as missing and are purged
+func TestBboltExpiry(t *testing.T) {
+	ctx := context.Background()
+	store := newTestBbolt(t)
+	past := time.Now().Add(-time.Minute)
+	future := time.Now().Add(time.Hour)
+	assert.NoError(t, store.PutExpiring(ctx, "/pth", "gone", []byte(`1`), past))
+	assert.NoError(t, store.PutExpiring(ctx, "/pth", "live", []byte(`2`), future))
+	assert.NoError(t, store.Put(ctx, "/pth", "forever", []byte(`3`)))
+	assert.NoError(t, store.PutExpiring(ctx, "/other", "gone", []byte(`4`), past))
+
+	gone, err := store.Get(ctx, "/pth", "gone")
+	assert.NoError(t, err)
+	assert.Nil(t, gone)
+	live, _ := store.Get(ctx, "/pth", "live")
+	assert.Equal(t, []byte(`2`), live)
+
+	res, err := store.List(ctx, "/pth", ListOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"forever", "live"}, res.Keys)
+
+	err = store.Update(ctx, "/pth", func(tx Tx) error {
+		v, _ := tx.Get("gone")
+		assert.Nil(t, v)
+		exp, _ := tx.ExpiresAt("live")
+		assert.Equal(t, future.UnixNano(), exp.UnixNano())
+		exp, _ = tx.ExpiresAt("forever")
+		assert.True(t, exp.IsZero())
+		return nil
+	})
+	assert.NoError(t, err)
+
+	n, err := store.PurgeExpired(ctx, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	n, err = store.PurgeExpired(ctx, future.Add(time.Second))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	res, _ = store.List(ctx, "/pth", ListOptions{})
+	assert.Equal(t, []string{"forever"}, res.Keys)
+}
+
+// TestBboltPutClearsExpiry verifies that a plain Put replaces an earlier expiry
+func TestBboltPutClearsExpiry(t *testing.T) {
+	ctx := context.Background()
+	store := newTestBbolt(t)
+	assert.NoError(t, store.PutExpiring(ctx, "/pth", "k", []byte(`1`), time.Now().Add(time.Hour)))
+	assert.NoError(t, store.Put(ctx, "/pth", "k", []byte(`2`)))
+	n, err := store.PurgeExpired(ctx, time.Now().Add(2*time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+	v, _ := store.Get(ctx, "/pth", "k")
+	assert.Equal(t, []byte(`2`), v)
+}
+
+// TestBboltMarkerValue verifies that raw values starting with the envelope marker round trip
+func TestBboltMarkerValue(t *testing.T) {
+	ctx := context.Background()
+	store := newTestBbolt(t)
+	raw := []byte{0x00, 0x01, 0x02}
+	assert.NoError(t, store.Put(ctx, "/pth", "k", raw))
+	v, _ := store.Get(ctx, "/pth", "k")
+	assert.Equal(t, raw, v)
+}
+
+// TestIncrementKeepsExpiry verifies that expiresAt only applies when Increment creates the key
+func TestIncrementKeepsExpiry(t *testing.T) {
+	ctx := context.Background()
+	store := newTestBbolt(t)
+	first := time.Now().Add(time.Hour)
+	_, err := Increment(ctx, store, "/pth", "hits", 1, first)
+	assert.NoError(t, err)
+	n, err := Increment(ctx, store, "/pth", "hits", 1, first.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, float64(2), n)
+	err = store.Update(ctx, "/pth", func(tx Tx) error {
+		exp, _ := tx.ExpiresAt("hits")
+		assert.Equal(t, first.UnixNano(), exp.UnixNano())
+		return nil
+	})
+	assert.NoError(t, err)
+
+	assert.NoError(t, store.PutExpiring(ctx, "/pth", "hits", []byte(`7`), time.Now().Add(-time.Second)))
+	n, err = Increment(ctx, store, "/pth", "hits", 1, time.Time{})
+	assert.NoError(t, err)
+	assert.Equal(t, float64(1), n, "an expired counter starts again from 0")
+}
+
+// TestBboltBatch verifies GetMany, PutMany, DeleteMany and List with values
+func TestBboltBatch(t *testing.T) {
+	ctx := context.Background()
+	store := newTestBbolt(t)
+	err := store.PutMany(ctx, "/pth", []Entry{
+		{Key: "a", Value: []byte(`1`)},
+		{Key: "b", Value: []byte(`2`)},
+		{Key: "c", Value: []byte(`3`), ExpiresAt: time.Now().Add(-time.Second)},
+		{Key: "d", Value: []byte(`4`)},
+	})
+	assert.NoError(t, err)
+
+	vals, err := store.GetMany(ctx, "/pth", []string{"b", "missing", "c", "a"})
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte(`2`), nil, nil, []byte(`1`)}, vals)
+
+	vals, err = store.GetMany(ctx, "/nowhere", []string{"a"})
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{nil}, vals)
+
+	res, err := store.List(ctx, "/pth", ListOptions{IncludeValues: true, Limit: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, res.Keys)
+	assert.Equal(t, [][]byte{[]byte(`1`), []byte(`2`)}, res.Values)
+	res, err = store.List(ctx, "/pth", ListOptions{IncludeValues: true, Cursor: res.Cursor})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"d"}, res.Keys)
+	assert.Equal(t, [][]byte{[]byte(`4`)}, res.Values)
+
+	res, err = store.List(ctx, "/pth", ListOptions{})
+	assert.NoError(t, err)
+	assert.Nil(t, res.Values, "values are only returned when asked for")
+
+	assert.NoError(t, store.DeleteMany(ctx, "/pth", []string{"a", "d", "missing"}))
+	res, _ = store.List(ctx, "/pth", ListOptions{})
+	assert.Equal(t, []string{"b"}, res.Keys)
+	assert.NoError(t, store.DeleteMany(ctx, "/nowhere", []string{"a"}))
+}
@@ -0,0 +1,50 @@
+package kv_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"hput/kv"
+	"hput/kv/kvtest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestBboltConformance runs the shared KV behaviour tests against bbolt
+func TestBboltConformance(t *testing.T) {
+	kvtest.RunConformance(t, func(t *testing.T) kv.KV {
+		store, err := kv.NewBbolt(filepath.Join(t.TempDir(), "kv.db"))
+		assert.NoError(t, err)
+		return store
+	})
+}
+
+// TestSQLiteConformance runs the shared KV behaviour tests against SQLite
+func TestSQLiteConformance(t *testing.T) {
+	kvtest.RunConformance(t, func(t *testing.T) kv.KV {
+		store, err := kv.NewSQLite(filepath.Join(t.TempDir(), "kv.sqlite"))
+		assert.NoError(t, err)
+		return store
+	})
+}
+
+// TestMemoryConformance runs the shared KV behaviour tests against the in-memory store
+func TestMemoryConformance(t *testing.T) {
+	kvtest.RunConformance(t, func(t *testing.T) kv.KV {
+		return kv.NewMemory()
+	})
+}
+
+// TestNotifierConformance checks that wrapping a store for watching keeps its behaviour
+func TestNotifierConformance(t *testing.T) {
+	kvtest.RunConformance(t, func(t *testing.T) kv.KV {
+		return kv.NewNotifier(kv.NewMemory())
+	})
+}
+
+// TestQuotaConformance checks that wrapping a store with quotas keeps its behaviour
+func TestQuotaConformance(t *testing.T) {
+	kvtest.RunConformance(t, func(t *testing.T) kv.KV {
+		return kv.NewQuota(kv.NewMemory(), kv.Limits{MaxValueBytes: 1 << 20, MaxKeys: 10000, MaxBytes: 1 << 30})
+	})
+}
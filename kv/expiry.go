@@ -0,0 +1,45 @@
+package kv
+
+import (
+	"context"
+	"time"
+)
+
+// Logger logs out.
+type Logger interface {
+	Debugf(msg string, args ...interface{})
+	Errorf(msg string, args ...interface{})
+}
+
+// expired reports whether a value with the given expiry is gone at now.
+// A zero expiresAt never expires.
+func expired(expiresAt, now time.Time) bool {
+	return !expiresAt.IsZero() && !now.Before(expiresAt)
+}
+
+// Sweep calls store.PurgeExpired every interval until ctx is done.
+// It blocks, so start it in its own goroutine. An interval of 0 or less
+// disables sweeping; expired keys still read as missing.
+func Sweep(ctx context.Context, store KV, interval time.Duration, l Logger) {
+	if interval <= 0 {
+		l.Debugf("kv.Sweep(): sweeping is disabled")
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			n, err := store.PurgeExpired(ctx, now)
+			if err != nil {
+				l.Errorf("kv.Sweep(): could not purge expired keys: %v", err)
+				continue
+			}
+			if n > 0 {
+				l.Debugf("kv.Sweep(): purged %d expired keys", n)
+			}
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package kv
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	bolt "go.etcd.io/bbolt"
+)
+
+type testLogger struct{}
+
+func (t *testLogger) Debugf(msg string, args ...interface{}) {}
+
+func (t *testLogger) Errorf(msg string, args ...interface{}) {}
+
+// TestSweep verifies that the sweeper purges expired keys in the background
+func TestSweep(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	store := newTestBbolt(t)
+	assert.NoError(t, store.PutExpiring(ctx, "/pth", "gone", []byte(`1`), time.Now().Add(-time.Second)))
+
+	done := make(chan struct{})
+	go func() {
+		Sweep(ctx, store, 10*time.Millisecond, &testLogger{})
+		close(done)
+	}()
+	assert.Eventually(t, func() bool {
+		var raw []byte
+		store.db.View(func(tx *bolt.Tx) error {
+			raw = tx.Bucket(topBucket).Bucket([]byte("/pth")).Get([]byte("gone"))
+			return nil
+		})
+		return raw == nil
+	}, time.Second, 10*time.Millisecond)
+	cancel()
+	<-done
+}
+
+// TestSweepDisabled verifies that an interval of 0 or less disables sweeping rather than panicking
+func TestSweepDisabled(t *testing.T) {
+	store := newTestBbolt(t)
+	for _, interval := range []time.Duration{0, -time.Second} {
+		done := make(chan struct{})
+		go func() {
+			Sweep(context.Background(), store, interval, &testLogger{})
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("Sweep with interval %v did not return", interval)
+		}
+	}
+}
@@ -0,0 +1,324 @@
+package kv
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Indexes let code look values up by one of their JSON fields instead of
+// listing every key. A field is written as "$" followed by ".name" segments,
+// e.g. "$.email" or "$.address.city". Strings, numbers and booleans are
+// indexed; a value without the field, or with null, an object or an array
+// there, is left out of the index.
+
+var (
+	// ErrInvalidIndex means an index name is empty or its field is not of the form $.a.b.
+	ErrInvalidIndex = errors.New("kv: index names must not be empty and fields must look like $.name")
+	// ErrNoIndex means a query named an index that does not exist.
+	ErrNoIndex = errors.New("kv: no such index")
+	// ErrInvalidQuery means a query's bounds or cursor cannot be used.
+	ErrInvalidQuery = errors.New("kv: invalid query")
+)
+
+// Query selects values from an index. Bounds are JSON scalars; nil leaves a
+// bound unset, and a query without bounds returns every indexed key.
+type Query struct {
+	Eq            []byte // the field equals this; cannot be combined with other bounds
+	Gt, Gte       []byte // lower bound, exclusive or inclusive; set at most one
+	Lt, Lte       []byte // upper bound, exclusive or inclusive; set at most one
+	Limit         int    // max keys to return per call; 0 means no limit
+	Cursor        string // resume token from a previous ListResult; empty means start from beginning
+	IncludeValues bool   // also return each key's value
+}
+
+// parseField splits a field such as "$.a.b" into its segments.
+func parseField(field string) ([]string, error) {
+	rest, ok := strings.CutPrefix(field, "$")
+	if !ok {
+		return nil, ErrInvalidIndex
+	}
+	if rest == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(rest, ".") {
+		return nil, ErrInvalidIndex
+	}
+	segs := strings.Split(rest[1:], ".")
+	for _, s := range segs {
+		if s == "" {
+			return nil, ErrInvalidIndex
+		}
+	}
+	return segs, nil
+}
+
+// validIndex checks an index name and parses its field.
+func validIndex(name, field string) ([]string, error) {
+	if name == "" {
+		return nil, ErrInvalidIndex
+	}
+	segs, err := parseField(field)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q", err, field)
+	}
+	return segs, nil
+}
+
+// ValidIndex checks an index name and field the way CreateIndex does, for
+// backends outside this package.
+func ValidIndex(name, field string) error {
+	_, err := validIndex(name, field)
+	return err
+}
+
+// QueryValues answers q over live keys and their values, as if they had
+// been indexed by field, for backends that keep no index entries of their
+// own. Results and cursors are the same as the built-in backends'.
+func QueryValues(keys []string, values [][]byte, field string, q Query) (ListResult, error) {
+	s, err := q.scan()
+	if err != nil {
+		return ListResult{}, err
+	}
+	segs, err := parseField(field)
+	if err != nil {
+		return ListResult{}, err
+	}
+	type hit struct {
+		entry []byte
+		i     int
+	}
+	var hits []hit
+	for i, key := range keys {
+		if e, ok := indexEntry(values[i], segs, key); ok && bytes.Compare(e, s.seek) >= 0 {
+			hits = append(hits, hit{entry: e, i: i})
+		}
+	}
+	sort.Slice(hits, func(a, b int) bool { return bytes.Compare(hits[a].entry, hits[b].entry) < 0 })
+
+	var result ListResult
+	for _, h := range hits {
+		ok, stop := s.match(h.entry)
+		if stop {
+			break
+		}
+		if !ok {
+			continue
+		}
+		if q.Limit > 0 && len(result.Keys) >= q.Limit {
+			result.Cursor = indexCursor(h.entry)
+			break
+		}
+		result.Keys = append(result.Keys, keys[h.i])
+		if q.IncludeValues {
+			result.Values = append(result.Values, values[h.i])
+		}
+	}
+	return result, nil
+}
+
+// indexEntry returns the index key for key, whose value is value, or false
+// if the value has nothing to index at field. Index keys sort by the field's
+// value, then by key, and always start with the field's encoding.
+func indexEntry(value []byte, field []string, key string) ([]byte, bool) {
+	var v interface{}
+	if err := json.Unmarshal(value, &v); err != nil {
+		return nil, false
+	}
+	for _, seg := range field {
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if v, ok = obj[seg]; !ok {
+			return nil, false
+		}
+	}
+	enc, ok := encodeScalar(v)
+	if !ok {
+		return nil, false
+	}
+	return append(enc, key...), true
+}
+
+// Type tags order indexed values: false < true < numbers < strings.
+const (
+	tagFalse  = 0x01
+	tagTrue   = 0x02
+	tagNumber = 0x03
+	tagString = 0x04
+)
+
+// encodeScalar encodes a decoded JSON scalar so that byte order matches
+// value order. Every encoding is self-delimiting, so no encoding is a
+// prefix of a different one.
+func encodeScalar(v interface{}) ([]byte, bool) {
+	switch v := v.(type) {
+	case bool:
+		if v {
+			return []byte{tagTrue}, true
+		}
+		return []byte{tagFalse}, true
+	case float64:
+		bits := math.Float64bits(v)
+		if v == 0 {
+			bits = 0 // -0 and 0 are the same number
+		}
+		if bits&(1<<63) == 0 {
+			bits ^= 1 << 63
+		} else {
+			bits = ^bits
+		}
+		out := make([]byte, 9)
+		out[0] = tagNumber
+		binary.BigEndian.PutUint64(out[1:], bits)
+		return out, true
+	case string:
+		// 0x00 is escaped as 0x00 0xff and the string ends with 0x00 0x01,
+		// which sorts before any escaped byte.
+		out := make([]byte, 0, len(v)+3)
+		out = append(out, tagString)
+		for i := 0; i < len(v); i++ {
+			out = append(out, v[i])
+			if v[i] == 0x00 {
+				out = append(out, 0xff)
+			}
+		}
+		return append(out, 0x00, 0x01), true
+	}
+	return nil, false
+}
+
+// indexEntryKey returns the key an index entry points at: whatever follows
+// the self-delimiting encoding of the field's value.
+func indexEntryKey(e []byte) (string, bool) {
+	if len(e) == 0 {
+		return "", false
+	}
+	switch e[0] {
+	case tagFalse, tagTrue:
+		return string(e[1:]), true
+	case tagNumber:
+		if len(e) < 9 {
+			return "", false
+		}
+		return string(e[9:]), true
+	case tagString:
+		for i := 1; i+1 < len(e); i++ {
+			if e[i] != 0x00 {
+				continue
+			}
+			if e[i+1] == 0x01 {
+				return string(e[i+2:]), true
+			}
+			i++ // skip the escaped 0x00
+		}
+	}
+	return "", false
+}
+
+// encodeBound encodes one JSON bound of a query.
+func encodeBound(name string, raw []byte) ([]byte, error) {
+	var v interface{}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return nil, fmt.Errorf("%w: %s is not JSON: %v", ErrInvalidQuery, name, err)
+	}
+	enc, ok := encodeScalar(v)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s must be a string, number or boolean", ErrInvalidQuery, name)
+	}
+	return enc, nil
+}
+
+// indexScan walks index keys in order on behalf of one Query.
+type indexScan struct {
+	seek    []byte // first index key to look at; nil means the first one
+	eq      []byte
+	gt      []byte
+	lt, lte []byte
+	tag     byte // when bounded, the type every matching key starts with
+}
+
+// scan turns q into an indexScan.
+func (q Query) scan() (indexScan, error) {
+	var s indexScan
+	if q.Eq != nil && (q.Gt != nil || q.Gte != nil || q.Lt != nil || q.Lte != nil) {
+		return s, fmt.Errorf("%w: eq cannot be combined with other bounds", ErrInvalidQuery)
+	}
+	if q.Gt != nil && q.Gte != nil {
+		return s, fmt.Errorf("%w: set gt or gte, not both", ErrInvalidQuery)
+	}
+	if q.Lt != nil && q.Lte != nil {
+		return s, fmt.Errorf("%w: set lt or lte, not both", ErrInvalidQuery)
+	}
+	bounds := []struct {
+		name string
+		raw  []byte
+		dst  *[]byte
+	}{
+		{"eq", q.Eq, &s.eq},
+		{"gt", q.Gt, &s.gt},
+		{"gte", q.Gte, &s.seek},
+		{"lt", q.Lt, &s.lt},
+		{"lte", q.Lte, &s.lte},
+	}
+	for _, b := range bounds {
+		if b.raw == nil {
+			continue
+		}
+		enc, err := encodeBound(b.name, b.raw)
+		if err != nil {
+			return s, err
+		}
+		if s.tag != 0 && s.tag != enc[0] {
+			return s, fmt.Errorf("%w: bounds must all be the same type", ErrInvalidQuery)
+		}
+		s.tag = enc[0]
+		*b.dst = enc
+	}
+	switch {
+	case s.eq != nil:
+		s.seek = s.eq
+	case s.gt != nil:
+		s.seek = s.gt
+	case s.seek == nil && s.tag != 0:
+		s.seek = []byte{s.tag}
+	}
+	if q.Cursor != "" {
+		c, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+		if err != nil || bytes.Compare(c, s.seek) < 0 {
+			return s, fmt.Errorf("%w: bad cursor", ErrInvalidQuery)
+		}
+		s.seek = c
+	}
+	return s, nil
+}
+
+// match reports whether index key k is in range, and whether the scan is
+// past every key that could be.
+func (s indexScan) match(k []byte) (ok, stop bool) {
+	switch {
+	case s.tag != 0 && k[0] != s.tag:
+		return false, true
+	case s.eq != nil:
+		return hasPrefix(k, s.eq), !hasPrefix(k, s.eq)
+	case s.lt != nil && bytes.Compare(k, s.lt) >= 0:
+		return false, true
+	case s.lte != nil && bytes.Compare(k, s.lte) > 0 && !hasPrefix(k, s.lte):
+		return false, true
+	case s.gt != nil && hasPrefix(k, s.gt):
+		return false, false
+	}
+	return true, false
+}
+
+// indexCursor turns the next index key to visit into a ListResult cursor.
+func indexCursor(k []byte) string {
+	return base64.RawURLEncoding.EncodeToString(k)
+}
@@ -0,0 +1,252 @@
+package kv
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestEncodeScalarOrder verifies that encoded values sort like the values themselves
+func TestEncodeScalarOrder(t *testing.T) {
+	ordered := []interface{}{
+		false, true,
+		-1e300, -2.5, -1.0, 0.0, 0.5, 1.0, 10.0, 1e300,
+		"", "\x00", "\x00\x00", "a", "a\x00", "a\x00b", "ab", "b",
+	}
+	for i := 1; i < len(ordered); i++ {
+		lo, ok := encodeScalar(ordered[i-1])
+		assert.True(t, ok)
+		hi, ok := encodeScalar(ordered[i])
+		assert.True(t, ok)
+		assert.Equal(t, -1, bytes.Compare(lo, hi), "%#v should sort before %#v", ordered[i-1], ordered[i])
+		assert.False(t, hasPrefix(hi, lo), "%#v must not be a prefix of %#v", ordered[i-1], ordered[i])
+	}
+	for _, v := range []interface{}{nil, map[string]interface{}{}, []interface{}{}} {
+		_, ok := encodeScalar(v)
+		assert.False(t, ok, "%#v is not indexable", v)
+	}
+}
+
+// TestIndexEntryKey verifies the key can be recovered from any index entry
+func TestIndexEntryKey(t *testing.T) {
+	for _, v := range []interface{}{false, true, 3.5, "", "a\x00b", "\x00\x01"} {
+		for _, key := range []string{"", "k", "\x00\x01k"} {
+			e, ok := indexEntry(mustJSON(t, v), nil, key)
+			assert.True(t, ok)
+			got, ok := indexEntryKey(e)
+			assert.True(t, ok)
+			assert.Equal(t, key, got, "value %#v", v)
+		}
+	}
+	_, ok := indexEntryKey([]byte{tagString, 'a'})
+	assert.False(t, ok)
+}
+
+func mustJSON(t *testing.T, v interface{}) []byte {
+	t.Helper()
+	b, err := json.Marshal(v)
+	assert.NoError(t, err)
+	return b
+}
+
+// TestParseField verifies which fields indexes accept
+func TestParseField(t *testing.T) {
+	tt := []struct {
+		field string
+		want  []string
+		err   bool
+	}{
+		{field: "$", want: nil},
+		{field: "$.email", want: []string{"email"}},
+		{field: "$.address.city", want: []string{"address", "city"}},
+		{field: "email", err: true},
+		{field: "$email", err: true},
+		{field: "$.", err: true},
+		{field: "$.a..b", err: true},
+	}
+	for _, test := range tt {
+		t.Run(test.field, func(t *testing.T) {
+			got, err := parseField(test.field)
+			if test.err {
+				assert.ErrorIs(t, err, ErrInvalidIndex)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
+
+func putUsers(t *testing.T, store KV) {
+	t.Helper()
+	err := store.PutMany(context.Background(), "/users", []Entry{
+		{Key: "ann", Value: []byte(`{"email":"ann@x.io","age":31,"address":{"city":"Oslo"}}`)},
+		{Key: "bob", Value: []byte(`{"email":"bob@x.io","age":25,"address":{"city":"Rome"}}`)},
+		{Key: "cat", Value: []byte(`{"email":"cat@x.io","age":31}`)},
+		{Key: "dan", Value: []byte(`{"email":"dan@x.io","age":"unknown"}`)},
+		{Key: "eve", Value: []byte(`"not an object"`)},
+	})
+	assert.NoError(t, err)
+}
+
+// TestBboltQuery verifies equality and range queries, ordering and pagination
+func TestBboltQuery(t *testing.T) {
+	tt := []struct {
+		name string
+		idx  string
+		q    Query
+		want []string
+	}{
+		{name: "eq string", idx: "byEmail", q: Query{Eq: []byte(`"bob@x.io"`)}, want: []string{"bob"}},
+		{name: "eq missing", idx: "byEmail", q: Query{Eq: []byte(`"zed@x.io"`)}, want: nil},
+		{name: "eq number orders by key", idx: "byAge", q: Query{Eq: []byte(`31`)}, want: []string{"ann", "cat"}},
+		{name: "eq other type", idx: "byAge", q: Query{Eq: []byte(`"31"`)}, want: nil},
+		{name: "gte", idx: "byAge", q: Query{Gte: []byte(`30`)}, want: []string{"ann", "cat"}},
+		{name: "gt excludes equal", idx: "byAge", q: Query{Gt: []byte(`25`)}, want: []string{"ann", "cat"}},
+		{name: "lte includes equal", idx: "byAge", q: Query{Lte: []byte(`31`)}, want: []string{"bob", "ann", "cat"}},
+		{name: "lt", idx: "byAge", q: Query{Lt: []byte(`31`)}, want: []string{"bob"}},
+		{name: "between", idx: "byAge", q: Query{Gt: []byte(`20`), Lt: []byte(`30`)}, want: []string{"bob"}},
+		{name: "string range", idx: "byEmail", q: Query{Gte: []byte(`"b"`), Lt: []byte(`"d"`)}, want: []string{"bob", "cat"}},
+		{name: "no bounds returns everything indexed", idx: "byAge", q: Query{}, want: []string{"bob", "ann", "cat", "dan"}},
+		{name: "nested field", idx: "byCity", q: Query{}, want: []string{"ann", "bob"}},
+	}
+	ctx := context.Background()
+	store := newTestBbolt(t)
+	putUsers(t, store)
+	assert.NoError(t, store.CreateIndex(ctx, "/users", "byEmail", "$.email"))
+	assert.NoError(t, store.CreateIndex(ctx, "/users", "byAge", "$.age"))
+	assert.NoError(t, store.CreateIndex(ctx, "/users", "byCity", "$.address.city"))
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			res, err := store.Query(ctx, "/users", test.idx, test.q)
+			assert.NoError(t, err)
+			assert.Equal(t, test.want, res.Keys)
+			assert.Empty(t, res.Cursor)
+		})
+	}
+
+	t.Run("pagination", func(t *testing.T) {
+		var keys []string
+		q := Query{Limit: 2, IncludeValues: true}
+		for {
+			res, err := store.Query(ctx, "/users", "byAge", q)
+			assert.NoError(t, err)
+			assert.Len(t, res.Values, len(res.Keys))
+			keys = append(keys, res.Keys...)
+			if res.Cursor == "" {
+				break
+			}
+			q.Cursor = res.Cursor
+		}
+		assert.Equal(t, []string{"bob", "ann", "cat", "dan"}, keys)
+	})
+}
+
+// TestBboltIndexMaintenance verifies that every kind of write keeps indexes current
+func TestBboltIndexMaintenance(t *testing.T) {
+	ctx := context.Background()
+	store := newTestBbolt(t)
+	assert.NoError(t, store.CreateIndex(ctx, "/users", "byAge", "$.age"))
+	query := func(age string) []string {
+		t.Helper()
+		res, err := store.Query(ctx, "/users", "byAge", Query{Eq: []byte(age)})
+		assert.NoError(t, err)
+		return res.Keys
+	}
+
+	putUsers(t, store)
+	assert.Equal(t, []string{"ann", "cat"}, query(`31`))
+
+	assert.NoError(t, store.Put(ctx, "/users", "ann", []byte(`{"age":32}`)))
+	assert.Equal(t, []string{"cat"}, query(`31`))
+	assert.Equal(t, []string{"ann"}, query(`32`))
+
+	assert.NoError(t, store.Delete(ctx, "/users", "cat"))
+	assert.Nil(t, query(`31`))
+
+	assert.NoError(t, store.Update(ctx, "/users", func(tx Tx) error {
+		if err := tx.Put("fay", []byte(`{"age":25}`)); err != nil {
+			return err
+		}
+		return tx.Delete("bob")
+	}))
+	assert.Equal(t, []string{"fay"}, query(`25`))
+
+	assert.NoError(t, store.DeleteMany(ctx, "/users", []string{"ann", "fay"}))
+	assert.Nil(t, query(`32`))
+	assert.Nil(t, query(`25`))
+
+	assert.NoError(t, store.PutExpiring(ctx, "/users", "gus", []byte(`{"age":40}`), time.Now().Add(time.Hour)))
+	assert.Equal(t, []string{"gus"}, query(`40`))
+	_, err := store.PurgeExpired(ctx, time.Now().Add(2*time.Hour))
+	assert.NoError(t, err)
+	assert.Nil(t, query(`40`))
+	assert.NoError(t, store.Update(ctx, "/users", func(tx Tx) error {
+		ib := tx.(bboltTx).indexes[0].bucket
+		assert.Equal(t, 1, ib.Stats().KeyN, "only dan should be left in the index")
+		return nil
+	}))
+}
+
+// TestBboltIndexLifecycle verifies creating, rebuilding and dropping indexes
+func TestBboltIndexLifecycle(t *testing.T) {
+	ctx := context.Background()
+	store := newTestBbolt(t)
+	putUsers(t, store)
+	assert.NoError(t, store.PutExpiring(ctx, "/users", "old", []byte(`{"age":31}`), time.Now().Add(-time.Second)))
+
+	_, err := store.Query(ctx, "/users", "byAge", Query{})
+	assert.ErrorIs(t, err, ErrNoIndex)
+
+	assert.NoError(t, store.CreateIndex(ctx, "/users", "byAge", "$.age"))
+	res, err := store.Query(ctx, "/users", "byAge", Query{Eq: []byte(`31`)})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"ann", "cat"}, res.Keys, "expired keys are never returned")
+
+	assert.NoError(t, store.CreateIndex(ctx, "/users", "byAge", "$.age"))
+	assert.NoError(t, store.CreateIndex(ctx, "/users", "byAge", "$.email"))
+	res, err = store.Query(ctx, "/users", "byAge", Query{Eq: []byte(`"cat@x.io"`)})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"cat"}, res.Keys, "changing the field rebuilds the index")
+
+	_, err = store.Query(ctx, "/other", "byAge", Query{})
+	assert.ErrorIs(t, err, ErrNoIndex, "indexes belong to one path")
+
+	assert.NoError(t, store.DropIndex(ctx, "/users", "byAge"))
+	_, err = store.Query(ctx, "/users", "byAge", Query{})
+	assert.ErrorIs(t, err, ErrNoIndex)
+	assert.NoError(t, store.DropIndex(ctx, "/users", "byAge"))
+	assert.NoError(t, store.Put(ctx, "/users", "ann", []byte(`{"age":1}`)), "writes work after dropping")
+
+	assert.ErrorIs(t, store.CreateIndex(ctx, "/users", "", "$.age"), ErrInvalidIndex)
+	assert.ErrorIs(t, store.CreateIndex(ctx, "/users", "bad", "age"), ErrInvalidIndex)
+}
+
+// TestQueryInvalid verifies that unusable queries are rejected
+func TestQueryInvalid(t *testing.T) {
+	tt := []struct {
+		name string
+		q    Query
+	}{
+		{name: "eq with range", q: Query{Eq: []byte(`1`), Lt: []byte(`2`)}},
+		{name: "gt and gte", q: Query{Gt: []byte(`1`), Gte: []byte(`2`)}},
+		{name: "lt and lte", q: Query{Lt: []byte(`1`), Lte: []byte(`2`)}},
+		{name: "mixed types", q: Query{Gt: []byte(`1`), Lt: []byte(`"z"`)}},
+		{name: "object bound", q: Query{Eq: []byte(`{"a":1}`)}},
+		{name: "not json", q: Query{Eq: []byte(`nope`)}},
+		{name: "bad cursor", q: Query{Cursor: "!!"}},
+	}
+	ctx := context.Background()
+	store := newTestBbolt(t)
+	assert.NoError(t, store.CreateIndex(ctx, "/users", "byAge", "$.age"))
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := store.Query(ctx, "/users", "byAge", test.q)
+			assert.ErrorIs(t, err, ErrInvalidQuery)
+		})
+	}
+}
@@ -3,21 +3,43 @@
 //
 // Each path's store is fully isolated — JS at /x cannot access /y's keys.
 // All implementations must honour this isolation contract.
+//
+// JS at /x may also open the namespaces of its own subtree, /x/*, through
+// Resolve. It can never reach a parent or sibling such as / or /y, and ".."
+// is rejected outright.
 package kv
 
-import "context"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrClosed is returned by stores that keep no file handle, such as
+// MemoryKV and RedisKV, when they are used after Close.
+var ErrClosed = errors.New("kv: store is closed")
 
 // ListResult is returned by List.
 type ListResult struct {
 	Keys   []string
-	Cursor string // opaque; pass back to List to get the next page. Empty means no more pages.
+	Values [][]byte // Values[i] belongs to Keys[i]; only set when ListOptions.IncludeValues is true
+	Cursor string   // opaque; pass back to List to get the next page. Empty means no more pages.
 }
 
 // ListOptions controls filtering and pagination for List.
 type ListOptions struct {
-	Prefix string // only return keys with this prefix; empty means all keys
-	Limit  int    // max keys to return per call; 0 means no limit
-	Cursor string // resume token from a previous ListResult; empty means start from beginning
+	Prefix        string // only return keys with this prefix; empty means all keys
+	Limit         int    // max keys to return per call; 0 means no limit
+	Cursor        string // resume token from a previous ListResult; empty means start from beginning
+	IncludeValues bool   // also return each key's value, read in the same snapshot as the keys
+}
+
+// Entry is one key and value written by PutMany.
+type Entry struct {
+	Key       string
+	Value     []byte
+	ExpiresAt time.Time // zero means the value never expires
 }
 
 // KV is the interface for per-path private key-value storage.
@@ -28,15 +50,122 @@ type KV interface {
 	// Returns nil, nil if the key does not exist.
 	Get(ctx context.Context, path, key string) ([]byte, error)
 
-	// Put stores value at key within path's namespace.
+	// Put stores value at key within path's namespace. The value never
+	// expires, replacing any expiry the key had before.
 	Put(ctx context.Context, path, key string, value []byte) error
 
+	// PutExpiring stores value at key like Put, but the key reads as missing
+	// from expiresAt onwards. A zero expiresAt behaves like Put.
+	PutExpiring(ctx context.Context, path, key string, value []byte, expiresAt time.Time) error
+
 	// Delete removes the key from path's namespace. No-op if key does not exist.
 	Delete(ctx context.Context, path, key string) error
 
+	// GetMany retrieves several keys from one consistent snapshot.
+	// The result has one value per key, in order; missing keys are nil.
+	GetMany(ctx context.Context, path string, keys []string) ([][]byte, error)
+
+	// PutMany stores every entry atomically: all are written or none are.
+	PutMany(ctx context.Context, path string, entries []Entry) error
+
+	// DeleteMany removes every key atomically. Missing keys are ignored.
+	DeleteMany(ctx context.Context, path string, keys []string) error
+
 	// List returns keys in path's namespace, optionally filtered and paginated.
 	List(ctx context.Context, path string, opts ListOptions) (ListResult, error)
 
+	// CreateIndex indexes path's values by field, e.g. "$.email", under name.
+	// Existing values are indexed before it returns, and every later write is
+	// reflected in the same transaction. Recreating an index with the same
+	// field is a no-op; with a different field it is rebuilt.
+	CreateIndex(ctx context.Context, path, name, field string) error
+
+	// DropIndex removes the named index. No-op if it does not exist.
+	DropIndex(ctx context.Context, path, name string) error
+
+	// Query returns the keys whose indexed field matches q, ordered by that
+	// field and then by key. It fails with ErrNoIndex if name is not an index of path.
+	Query(ctx context.Context, path, name string, q Query) (ListResult, error)
+
+	// Update runs fn inside a single atomic read-write transaction scoped to
+	// path's namespace. Either every write made through tx is applied or, if
+	// fn returns an error, none are. Concurrent Updates on the same path must
+	// not interleave.
+	Update(ctx context.Context, path string, fn func(tx Tx) error) error
+
+	// Paths returns every path that holds at least one live key, in byte order.
+	Paths(ctx context.Context) ([]string, error)
+
+	// PurgeExpired deletes every key, in every path, whose expiry is before
+	// now, and reports how many were deleted. Expired keys are already
+	// invisible to reads; purging only reclaims their space.
+	PurgeExpired(ctx context.Context, now time.Time) (int, error)
+
 	// Close releases any resources held by the store.
 	Close() error
 }
+
+// Tx is one path's namespace as seen from inside KV.Update.
+// It is only valid until the fn passed to Update returns.
+type Tx interface {
+	// Get retrieves the value stored at key. Returns nil, nil if the key does not exist.
+	Get(key string) ([]byte, error)
+
+	// ExpiresAt returns when key expires; zero if it never does or does not exist.
+	ExpiresAt(key string) (time.Time, error)
+
+	// Put stores value at key with no expiry.
+	Put(key string, value []byte) error
+
+	// PutExpiring stores value at key, expiring at expiresAt.
+	PutExpiring(key string, value []byte, expiresAt time.Time) error
+
+	// Delete removes key. No-op if key does not exist.
+	Delete(key string) error
+}
+
+// Find returns the first store in the chain starting at store that is a T,
+// following the Unwrap method of decorators such as Notifier and Quota,
+// e.g. Find[Watcher](store).
+func Find[T any](store KV) (T, bool) {
+	for store != nil {
+		if t, ok := store.(T); ok {
+			return t, true
+		}
+		u, ok := store.(interface{ Unwrap() KV })
+		if !ok {
+			break
+		}
+		store = u.Unwrap()
+	}
+	var zero T
+	return zero, false
+}
+
+// wipeBatch is how many keys Wipe lists and deletes at a time.
+const wipeBatch = 1000
+
+// Wipe deletes every key in path's namespace, a page at a time, and reports
+// how many were deleted. Each page is deleted atomically, but the namespace
+// as a whole is not: a key written while Wipe runs may survive it. Index
+// definitions are kept.
+func Wipe(ctx context.Context, store KV, path string) (int, error) {
+	var wiped int
+	opts := ListOptions{Limit: wipeBatch}
+	for {
+		res, err := store.List(ctx, path, opts)
+		if err != nil {
+			return wiped, fmt.Errorf("kv: wiping %q: %w", path, err)
+		}
+		if len(res.Keys) > 0 {
+			if err := store.DeleteMany(ctx, path, res.Keys); err != nil {
+				return wiped, fmt.Errorf("kv: wiping %q: %w", path, err)
+			}
+			wiped += len(res.Keys)
+		}
+		if res.Cursor == "" {
+			return wiped, nil
+		}
+		opts.Cursor = res.Cursor
+	}
+}
@@ -0,0 +1,269 @@
+// Package kvtest holds tests that any kv.KV implementation can run against
+// itself to check it behaves like the built-in backends.
+package kvtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hput/kv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// RunConformance checks the behaviour every kv.KV implementation must share:
+// path isolation, Paths, Wipe, missing keys, List prefixes and cursors, batches, expiry,
+// atomic updates, indexes and Close. factory must return a new, empty store
+// each time it is called; RunConformance closes every store it gets.
+func RunConformance(t *testing.T, factory func(t *testing.T) kv.KV) {
+	ctx := context.Background()
+	newStore := func(t *testing.T) kv.KV {
+		t.Helper()
+		store := factory(t)
+		t.Cleanup(func() { store.Close() })
+		return store
+	}
+
+	t.Run("paths are isolated", func(t *testing.T) {
+		store := newStore(t)
+		assert.NoError(t, store.Put(ctx, "/a", "k", []byte(`"a"`)))
+		assert.NoError(t, store.Put(ctx, "/b", "k", []byte(`"b"`)))
+		assert.NoError(t, store.Put(ctx, "/a/sub", "k2", []byte(`"sub"`)))
+
+		a, _ := store.Get(ctx, "/a", "k")
+		b, _ := store.Get(ctx, "/b", "k")
+		assert.Equal(t, []byte(`"a"`), a)
+		assert.Equal(t, []byte(`"b"`), b)
+
+		res, err := store.List(ctx, "/a", kv.ListOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"k"}, res.Keys, "a path must not list its children's keys")
+
+		assert.NoError(t, store.Delete(ctx, "/a", "k"))
+		b, _ = store.Get(ctx, "/b", "k")
+		assert.Equal(t, []byte(`"b"`), b)
+	})
+
+	t.Run("paths", func(t *testing.T) {
+		store := newStore(t)
+		paths, err := store.Paths(ctx)
+		assert.NoError(t, err)
+		assert.Empty(t, paths)
+
+		for _, p := range []string{"/b", "/a/sub", "_shared/n", "/", "/a", "/deleted"} {
+			assert.NoError(t, store.Put(ctx, p, "k", []byte(`1`)))
+		}
+		assert.NoError(t, store.Put(ctx, "/a", "k2", []byte(`2`)))
+		assert.NoError(t, store.Delete(ctx, "/deleted", "k"))
+		assert.NoError(t, store.PutExpiring(ctx, "/expired", "k", []byte(`1`), time.Now().Add(-time.Second)))
+		assert.NoError(t, store.CreateIndex(ctx, "/indexed", "byX", "$.x"))
+
+		paths, err = store.Paths(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"/", "/a", "/a/sub", "/b", "_shared/n"}, paths, "only paths with a live key, in byte order")
+	})
+
+	t.Run("wipe", func(t *testing.T) {
+		store := newStore(t)
+		var entries []kv.Entry
+		for i := 0; i < 2500; i++ {
+			entries = append(entries, kv.Entry{Key: fmt.Sprintf("k%04d", i), Value: []byte(`{"x":1}`)})
+		}
+		assert.NoError(t, store.PutMany(ctx, "/a", entries))
+		assert.NoError(t, store.Put(ctx, "/b", "k", []byte(`1`)))
+		assert.NoError(t, store.CreateIndex(ctx, "/a", "byX", "$.x"))
+
+		n, err := kv.Wipe(ctx, store, "/a")
+		assert.NoError(t, err)
+		assert.Equal(t, 2500, n)
+		res, _ := store.List(ctx, "/a", kv.ListOptions{})
+		assert.Empty(t, res.Keys)
+		v, _ := store.Get(ctx, "/b", "k")
+		assert.Equal(t, []byte(`1`), v, "other paths are left alone")
+		_, err = store.Query(ctx, "/a", "byX", kv.Query{})
+		assert.NoError(t, err, "index definitions are kept")
+	})
+
+	t.Run("missing keys", func(t *testing.T) {
+		store := newStore(t)
+		v, err := store.Get(ctx, "/a", "missing")
+		assert.NoError(t, err)
+		assert.Nil(t, v)
+		assert.NoError(t, store.Delete(ctx, "/a", "missing"))
+		res, err := store.List(ctx, "/nowhere", kv.ListOptions{})
+		assert.NoError(t, err)
+		assert.Empty(t, res.Keys)
+		assert.Empty(t, res.Cursor)
+	})
+
+	t.Run("put overwrites", func(t *testing.T) {
+		store := newStore(t)
+		assert.NoError(t, store.Put(ctx, "/a", "k", []byte(`1`)))
+		assert.NoError(t, store.Put(ctx, "/a", "k", []byte(`2`)))
+		v, _ := store.Get(ctx, "/a", "k")
+		assert.Equal(t, []byte(`2`), v)
+	})
+
+	t.Run("list prefix and cursor", func(t *testing.T) {
+		store := newStore(t)
+		for _, k := range []string{"user:3", "user:1", "post:1", "user:2", "users", "v"} {
+			assert.NoError(t, store.Put(ctx, "/a", k, []byte(`1`)))
+		}
+		var keys []string
+		opts := kv.ListOptions{Prefix: "user:", Limit: 2}
+		for pages := 0; ; pages++ {
+			res, err := store.List(ctx, "/a", opts)
+			assert.NoError(t, err)
+			keys = append(keys, res.Keys...)
+			if res.Cursor == "" {
+				assert.Equal(t, 1, pages)
+				break
+			}
+			opts.Cursor = res.Cursor
+		}
+		assert.Equal(t, []string{"user:1", "user:2", "user:3"}, keys)
+
+		res, err := store.List(ctx, "/a", kv.ListOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"post:1", "user:1", "user:2", "user:3", "users", "v"}, res.Keys)
+
+		res, err = store.List(ctx, "/a", kv.ListOptions{Limit: 6})
+		assert.NoError(t, err)
+		assert.Len(t, res.Keys, 6)
+		assert.Empty(t, res.Cursor, "an exactly full page has no next page")
+	})
+
+	t.Run("list values", func(t *testing.T) {
+		store := newStore(t)
+		assert.NoError(t, store.PutMany(ctx, "/a", []kv.Entry{{Key: "a", Value: []byte(`1`)}, {Key: "b", Value: []byte(`2`)}}))
+		res, err := store.List(ctx, "/a", kv.ListOptions{IncludeValues: true})
+		assert.NoError(t, err)
+		assert.Equal(t, [][]byte{[]byte(`1`), []byte(`2`)}, res.Values)
+		res, _ = store.List(ctx, "/a", kv.ListOptions{})
+		assert.Nil(t, res.Values)
+	})
+
+	t.Run("batches", func(t *testing.T) {
+		store := newStore(t)
+		assert.NoError(t, store.PutMany(ctx, "/a", []kv.Entry{
+			{Key: "a", Value: []byte(`1`)},
+			{Key: "b", Value: []byte(`2`), ExpiresAt: time.Now().Add(-time.Second)},
+			{Key: "c", Value: []byte(`3`)},
+		}))
+		vals, err := store.GetMany(ctx, "/a", []string{"c", "b", "missing", "a"})
+		assert.NoError(t, err)
+		assert.Equal(t, [][]byte{[]byte(`3`), nil, nil, []byte(`1`)}, vals)
+		assert.NoError(t, store.DeleteMany(ctx, "/a", []string{"a", "missing"}))
+		res, _ := store.List(ctx, "/a", kv.ListOptions{})
+		assert.Equal(t, []string{"c"}, res.Keys)
+	})
+
+	t.Run("expiry", func(t *testing.T) {
+		store := newStore(t)
+		future := time.Now().Add(time.Hour)
+		assert.NoError(t, store.PutExpiring(ctx, "/a", "gone", []byte(`1`), time.Now().Add(-time.Second)))
+		assert.NoError(t, store.PutExpiring(ctx, "/a", "epoch", []byte(`1`), time.Unix(0, 0)))
+		assert.NoError(t, store.PutExpiring(ctx, "/a", "live", []byte(`2`), future))
+		assert.NoError(t, store.Put(ctx, "/a", "forever", []byte(`3`)))
+		assert.NoError(t, store.PutExpiring(ctx, "/b", "gone", []byte(`4`), time.Now().Add(-time.Second)))
+
+		v, _ := store.Get(ctx, "/a", "gone")
+		assert.Nil(t, v)
+		v, _ = store.Get(ctx, "/a", "epoch")
+		assert.Nil(t, v)
+		res, _ := store.List(ctx, "/a", kv.ListOptions{})
+		assert.Equal(t, []string{"forever", "live"}, res.Keys)
+
+		assert.NoError(t, store.Update(ctx, "/a", func(tx kv.Tx) error {
+			exp, err := tx.ExpiresAt("live")
+			assert.NoError(t, err)
+			assert.Equal(t, future.UnixNano(), exp.UnixNano())
+			exp, _ = tx.ExpiresAt("forever")
+			assert.True(t, exp.IsZero())
+			return nil
+		}))
+
+		n, err := store.PurgeExpired(ctx, time.Now())
+		assert.NoError(t, err)
+		assert.Equal(t, 3, n)
+		n, _ = store.PurgeExpired(ctx, future.Add(time.Second))
+		assert.Equal(t, 1, n)
+		res, _ = store.List(ctx, "/a", kv.ListOptions{})
+		assert.Equal(t, []string{"forever"}, res.Keys)
+	})
+
+	t.Run("update is atomic", func(t *testing.T) {
+		store := newStore(t)
+		assert.NoError(t, store.Put(ctx, "/a", "k", []byte(`1`)))
+		boom := errors.New("boom")
+		err := store.Update(ctx, "/a", func(tx kv.Tx) error {
+			assert.NoError(t, tx.Put("k", []byte(`2`)))
+			assert.NoError(t, tx.Put("new", []byte(`3`)))
+			v, _ := tx.Get("k")
+			assert.Equal(t, []byte(`2`), v, "a transaction reads its own writes")
+			return boom
+		})
+		assert.ErrorIs(t, err, boom)
+		v, _ := store.Get(ctx, "/a", "k")
+		assert.Equal(t, []byte(`1`), v)
+		v, _ = store.Get(ctx, "/a", "new")
+		assert.Nil(t, v)
+	})
+
+	t.Run("concurrent increments", func(t *testing.T) {
+		store := newStore(t)
+		const workers, perWorker = 8, 25
+		var wg sync.WaitGroup
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < perWorker; j++ {
+					_, err := kv.Increment(ctx, store, "/a", "n", 1, time.Time{})
+					assert.NoError(t, err)
+				}
+			}()
+		}
+		wg.Wait()
+		v, _ := store.Get(ctx, "/a", "n")
+		assert.Equal(t, fmt.Sprint(workers*perWorker), string(v))
+	})
+
+	t.Run("indexes", func(t *testing.T) {
+		store := newStore(t)
+		assert.NoError(t, store.PutMany(ctx, "/a", []kv.Entry{
+			{Key: "ann", Value: []byte(`{"age":31}`)},
+			{Key: "bob", Value: []byte(`{"age":25}`)},
+		}))
+		assert.NoError(t, store.CreateIndex(ctx, "/a", "byAge", "$.age"))
+		assert.NoError(t, store.Put(ctx, "/a", "cat", []byte(`{"age":31}`)))
+		assert.NoError(t, store.Put(ctx, "/a", "ann", []byte(`{"age":40}`)))
+		assert.NoError(t, store.PutExpiring(ctx, "/a", "old", []byte(`{"age":31}`), time.Now().Add(-time.Second)))
+
+		res, err := store.Query(ctx, "/a", "byAge", kv.Query{Gte: []byte(`30`), Limit: 1, IncludeValues: true})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"cat"}, res.Keys)
+		assert.Equal(t, [][]byte{[]byte(`{"age":31}`)}, res.Values)
+		res, err = store.Query(ctx, "/a", "byAge", kv.Query{Gte: []byte(`30`), Cursor: res.Cursor})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"ann"}, res.Keys)
+
+		_, err = store.Query(ctx, "/b", "byAge", kv.Query{})
+		assert.ErrorIs(t, err, kv.ErrNoIndex)
+		assert.NoError(t, store.DropIndex(ctx, "/a", "byAge"))
+		_, err = store.Query(ctx, "/a", "byAge", kv.Query{})
+		assert.ErrorIs(t, err, kv.ErrNoIndex)
+	})
+
+	t.Run("close", func(t *testing.T) {
+		store := factory(t)
+		assert.NoError(t, store.Put(ctx, "/a", "k", []byte(`1`)))
+		assert.NoError(t, store.Close())
+		_, err := store.Get(ctx, "/a", "k")
+		assert.Error(t, err, "a closed store must not serve reads")
+		assert.Error(t, store.Put(ctx, "/a", "k", []byte(`2`)), "a closed store must not accept writes")
+	})
+}
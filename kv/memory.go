@@ -0,0 +1,399 @@
+package kv
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryKV implements KV in memory. Nothing is written to disk, and
+// everything is lost when the process exits.
+type MemoryKV struct {
+	mu      sync.RWMutex
+	paths   map[string]map[string]memoryValue
+	indexes map[string]map[string]*memoryIndex // path → index name → index
+	closed  bool
+}
+
+type memoryValue struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+// memoryIndex holds one index of a path as indexEntry key → key.
+type memoryIndex struct {
+	field   string
+	segs    []string
+	entries map[string]string
+}
+
+// NewMemory returns an empty MemoryKV.
+func NewMemory() *MemoryKV {
+	return &MemoryKV{
+		paths:   map[string]map[string]memoryValue{},
+		indexes: map[string]map[string]*memoryIndex{},
+	}
+}
+
+func (m *MemoryKV) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	m.paths = nil
+	m.indexes = nil
+	return nil
+}
+
+// read returns a copy of the live value at key and its expiry.
+func (m *MemoryKV) read(path, key string, now time.Time) ([]byte, time.Time) {
+	v, ok := m.paths[path][key]
+	if !ok || expired(v.expiresAt, now) {
+		return nil, time.Time{}
+	}
+	return append([]byte{}, v.value...), v.expiresAt
+}
+
+// put stores a copy of value at key and updates the path's indexes.
+// The caller must hold the write lock.
+func (m *MemoryKV) put(path, key string, value []byte, expiresAt time.Time) {
+	m.unindex(path, key)
+	if m.paths[path] == nil {
+		m.paths[path] = map[string]memoryValue{}
+	}
+	value = append([]byte{}, value...)
+	m.paths[path][key] = memoryValue{value: value, expiresAt: expiresAt}
+	for _, ix := range m.indexes[path] {
+		if e, ok := indexEntry(value, ix.segs, key); ok {
+			ix.entries[string(e)] = key
+		}
+	}
+}
+
+// delete removes key and its index entries. The caller must hold the write lock.
+func (m *MemoryKV) delete(path, key string) {
+	m.unindex(path, key)
+	delete(m.paths[path], key)
+	if len(m.paths[path]) == 0 {
+		delete(m.paths, path)
+	}
+}
+
+// unindex removes the index entries of the value currently stored at key.
+func (m *MemoryKV) unindex(path, key string) {
+	old, ok := m.paths[path][key]
+	if !ok {
+		return
+	}
+	for _, ix := range m.indexes[path] {
+		if e, ok := indexEntry(old.value, ix.segs, key); ok {
+			delete(ix.entries, string(e))
+		}
+	}
+}
+
+func (m *MemoryKV) Get(_ context.Context, path, key string) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.closed {
+		return nil, ErrClosed
+	}
+	val, _ := m.read(path, key, time.Now())
+	return val, nil
+}
+
+func (m *MemoryKV) Put(ctx context.Context, path, key string, value []byte) error {
+	return m.PutExpiring(ctx, path, key, value, time.Time{})
+}
+
+func (m *MemoryKV) PutExpiring(_ context.Context, path, key string, value []byte, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return ErrClosed
+	}
+	m.put(path, key, value, expiresAt)
+	return nil
+}
+
+func (m *MemoryKV) Delete(_ context.Context, path, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return ErrClosed
+	}
+	m.delete(path, key)
+	return nil
+}
+
+func (m *MemoryKV) GetMany(_ context.Context, path string, keys []string) ([][]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.closed {
+		return nil, ErrClosed
+	}
+	now := time.Now()
+	vals := make([][]byte, len(keys))
+	for i, key := range keys {
+		vals[i], _ = m.read(path, key, now)
+	}
+	return vals, nil
+}
+
+func (m *MemoryKV) PutMany(_ context.Context, path string, entries []Entry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return ErrClosed
+	}
+	for _, e := range entries {
+		m.put(path, e.Key, e.Value, e.ExpiresAt)
+	}
+	return nil
+}
+
+func (m *MemoryKV) DeleteMany(_ context.Context, path string, keys []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return ErrClosed
+	}
+	for _, key := range keys {
+		m.delete(path, key)
+	}
+	return nil
+}
+
+// List sorts the path's keys on every call, which is fine for the sizes an
+// in-memory store is meant for.
+func (m *MemoryKV) List(_ context.Context, path string, opts ListOptions) (ListResult, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.closed {
+		return ListResult{}, ErrClosed
+	}
+	now := time.Now()
+	start := max(opts.Cursor, opts.Prefix)
+	var keys []string
+	for k, v := range m.paths[path] {
+		if k >= start && strings.HasPrefix(k, opts.Prefix) && !expired(v.expiresAt, now) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var result ListResult
+	for _, k := range keys {
+		if opts.Limit > 0 && len(result.Keys) >= opts.Limit {
+			result.Cursor = k
+			break
+		}
+		result.Keys = append(result.Keys, k)
+		if opts.IncludeValues {
+			result.Values = append(result.Values, append([]byte{}, m.paths[path][k].value...))
+		}
+	}
+	return result, nil
+}
+
+// Update runs fn under the store's write lock. Writes are buffered in the
+// Tx and only applied if fn succeeds.
+func (m *MemoryKV) Update(_ context.Context, path string, fn func(tx Tx) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return ErrClosed
+	}
+	tx := &memoryTx{store: m, path: path, now: time.Now(), writes: map[string]*memoryValue{}}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	for _, key := range tx.order {
+		if w := tx.writes[key]; w == nil {
+			m.delete(path, key)
+		} else {
+			m.put(path, key, w.value, w.expiresAt)
+		}
+	}
+	return nil
+}
+
+// memoryTx implements Tx for MemoryKV.Update. A nil write is a delete.
+type memoryTx struct {
+	store  *MemoryKV
+	path   string
+	now    time.Time
+	writes map[string]*memoryValue
+	order  []string
+}
+
+func (t *memoryTx) Get(key string) ([]byte, error) {
+	val, _ := t.read(key)
+	return val, nil
+}
+
+func (t *memoryTx) ExpiresAt(key string) (time.Time, error) {
+	_, expiresAt := t.read(key)
+	return expiresAt, nil
+}
+
+func (t *memoryTx) read(key string) ([]byte, time.Time) {
+	w, ok := t.writes[key]
+	if !ok {
+		return t.store.read(t.path, key, t.now)
+	}
+	if w == nil || expired(w.expiresAt, t.now) {
+		return nil, time.Time{}
+	}
+	return append([]byte{}, w.value...), w.expiresAt
+}
+
+func (t *memoryTx) Put(key string, value []byte) error {
+	return t.PutExpiring(key, value, time.Time{})
+}
+
+func (t *memoryTx) PutExpiring(key string, value []byte, expiresAt time.Time) error {
+	t.write(key, &memoryValue{value: append([]byte{}, value...), expiresAt: expiresAt})
+	return nil
+}
+
+func (t *memoryTx) Delete(key string) error {
+	t.write(key, nil)
+	return nil
+}
+
+func (t *memoryTx) write(key string, v *memoryValue) {
+	if _, ok := t.writes[key]; !ok {
+		t.order = append(t.order, key)
+	}
+	t.writes[key] = v
+}
+
+func (m *MemoryKV) Paths(_ context.Context) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.closed {
+		return nil, ErrClosed
+	}
+	now := time.Now()
+	var paths []string
+	for path, keys := range m.paths {
+		for _, v := range keys {
+			if !expired(v.expiresAt, now) {
+				paths = append(paths, path)
+				break
+			}
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// PurgeExpired deletes every key that expired before now.
+func (m *MemoryKV) PurgeExpired(_ context.Context, now time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return 0, ErrClosed
+	}
+	var purged int
+	for path, keys := range m.paths {
+		for key, v := range keys {
+			if expired(v.expiresAt, now) {
+				m.delete(path, key)
+				purged++
+			}
+		}
+	}
+	return purged, nil
+}
+
+// CreateIndex indexes path by field, indexing the values already stored.
+func (m *MemoryKV) CreateIndex(_ context.Context, path, name, field string) error {
+	segs, err := validIndex(name, field)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return ErrClosed
+	}
+	if ix := m.indexes[path][name]; ix != nil && ix.field == field {
+		return nil
+	}
+	ix := &memoryIndex{field: field, segs: segs, entries: map[string]string{}}
+	for key, v := range m.paths[path] {
+		if e, ok := indexEntry(v.value, segs, key); ok {
+			ix.entries[string(e)] = key
+		}
+	}
+	if m.indexes[path] == nil {
+		m.indexes[path] = map[string]*memoryIndex{}
+	}
+	m.indexes[path][name] = ix
+	return nil
+}
+
+// DropIndex removes the named index of path.
+func (m *MemoryKV) DropIndex(_ context.Context, path, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return ErrClosed
+	}
+	delete(m.indexes[path], name)
+	return nil
+}
+
+// Query sorts the index's entries and walks them like the bbolt cursor does.
+func (m *MemoryKV) Query(_ context.Context, path, name string, q Query) (ListResult, error) {
+	s, err := q.scan()
+	if err != nil {
+		return ListResult{}, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.closed {
+		return ListResult{}, ErrClosed
+	}
+	ix := m.indexes[path][name]
+	if ix == nil {
+		return ListResult{}, fmt.Errorf("kv: query: %w: %q", ErrNoIndex, name)
+	}
+	var entries []string
+	for e := range ix.entries {
+		if e >= string(s.seek) {
+			entries = append(entries, e)
+		}
+	}
+	sort.Strings(entries)
+
+	now := time.Now()
+	var result ListResult
+	for _, e := range entries {
+		ok, stop := s.match([]byte(e))
+		if stop {
+			break
+		}
+		if !ok {
+			continue
+		}
+		key := ix.entries[e]
+		val, _ := m.read(path, key, now)
+		if val == nil {
+			continue
+		}
+		if q.Limit > 0 && len(result.Keys) >= q.Limit {
+			result.Cursor = indexCursor([]byte(e))
+			break
+		}
+		result.Keys = append(result.Keys, key)
+		if q.IncludeValues {
+			result.Values = append(result.Values, val)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,32 @@
+package kv
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestMemoryCopies verifies that callers cannot change stored values through slices they passed in or got back
+func TestMemoryCopies(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemory()
+	in := []byte(`"abc"`)
+	assert.NoError(t, store.Put(ctx, "/pth", "k", in))
+	in[1] = 'x'
+	out, _ := store.Get(ctx, "/pth", "k")
+	assert.Equal(t, []byte(`"abc"`), out)
+	out[1] = 'y'
+	res, _ := store.List(ctx, "/pth", ListOptions{IncludeValues: true})
+	assert.Equal(t, [][]byte{[]byte(`"abc"`)}, res.Values)
+
+	err := store.Update(ctx, "/pth", func(tx Tx) error {
+		v := []byte(`1`)
+		assert.NoError(t, tx.Put("n", v))
+		v[0] = '2'
+		return nil
+	})
+	assert.NoError(t, err)
+	n, _ := store.Get(ctx, "/pth", "n")
+	assert.Equal(t, []byte(`1`), n)
+}
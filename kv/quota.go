@@ -0,0 +1,259 @@
+package kv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrQuotaExceeded is returned, wrapped with the limit that was hit, when a
+// write through a Quota would take its path over one of its Limits.
+var ErrQuotaExceeded = errors.New("kv: quota exceeded")
+
+// Limits caps what each path may store. Zero means unlimited.
+type Limits struct {
+	MaxValueBytes int   `json:"maxValueBytes,omitempty"` // largest single value
+	MaxKeys       int   `json:"maxKeys,omitempty"`       // most keys in one path
+	MaxBytes      int64 `json:"maxBytes,omitempty"`      // most key and value bytes in one path
+}
+
+// Usage is what one path stores: its keys, and the bytes of those keys and their values.
+type Usage struct {
+	Keys  int   `json:"keys"`
+	Bytes int64 `json:"bytes"`
+}
+
+// entryBytes is what one key and value count towards Usage.Bytes.
+func entryBytes(key string, value []byte) int64 {
+	return int64(len(key) + len(value))
+}
+
+// Quota wraps a KV and rejects writes that would take a path over its
+// Limits with ErrQuotaExceeded. Writes that shrink a path are always
+// allowed, so a path over its limits can be cleaned up.
+//
+// Each path's usage is counted from the store the first time it is written
+// or asked for, then kept up to date by the writes made through this Quota.
+// Other processes sharing the backend are not seen, and keys that expire
+// keep counting until PurgeExpired removes them, when every path is counted again.
+type Quota struct {
+	KV
+	limits Limits
+
+	mu    sync.Mutex
+	paths map[string]*pathUsage
+}
+
+// pathUsage is one path's usage. mu is held for the whole of each write,
+// so writes to one path are checked one at a time.
+type pathUsage struct {
+	mu     sync.Mutex
+	loaded bool
+	Usage
+}
+
+// NewQuota wraps store so every path is held to limits.
+func NewQuota(store KV, limits Limits) *Quota {
+	return &Quota{KV: store, limits: limits, paths: map[string]*pathUsage{}}
+}
+
+// Unwrap returns the wrapped store.
+func (q *Quota) Unwrap() KV {
+	return q.KV
+}
+
+// Limits returns the limits every path is held to.
+func (q *Quota) Limits() Limits {
+	return q.limits
+}
+
+// lock returns path's usage, counted and locked. The caller must unlock it.
+func (q *Quota) lock(ctx context.Context, path string) (*pathUsage, error) {
+	q.mu.Lock()
+	pu, ok := q.paths[path]
+	if !ok {
+		pu = &pathUsage{}
+		q.paths[path] = pu
+	}
+	q.mu.Unlock()
+
+	pu.mu.Lock()
+	if pu.loaded {
+		return pu, nil
+	}
+	u, err := q.count(ctx, path)
+	if err != nil {
+		pu.mu.Unlock()
+		return nil, err
+	}
+	pu.Usage, pu.loaded = u, true
+	return pu, nil
+}
+
+// count reads every live key of path to work out its usage.
+func (q *Quota) count(ctx context.Context, path string) (Usage, error) {
+	var u Usage
+	opts := ListOptions{IncludeValues: true, Limit: wipeBatch}
+	for {
+		res, err := q.KV.List(ctx, path, opts)
+		if err != nil {
+			return Usage{}, fmt.Errorf("kv: counting usage of %q: %w", path, err)
+		}
+		for i, key := range res.Keys {
+			u.Keys++
+			u.Bytes += entryBytes(key, res.Values[i])
+		}
+		if res.Cursor == "" {
+			return u, nil
+		}
+		opts.Cursor = res.Cursor
+	}
+}
+
+// Usage returns what path stores.
+func (q *Quota) Usage(ctx context.Context, path string) (Usage, error) {
+	pu, err := q.lock(ctx, path)
+	if err != nil {
+		return Usage{}, err
+	}
+	defer pu.mu.Unlock()
+	return pu.Usage, nil
+}
+
+// write runs fn in one Update of the wrapped store, through a Tx that
+// checks every write against the limits, and then adds up its usage.
+func (q *Quota) write(ctx context.Context, path string, fn func(tx Tx) error) error {
+	pu, err := q.lock(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer pu.mu.Unlock()
+	var qt *quotaTx
+	err = q.KV.Update(ctx, path, func(tx Tx) error {
+		qt = &quotaTx{Tx: tx, path: path, limits: q.limits, usage: pu.Usage}
+		return fn(qt)
+	})
+	if err != nil {
+		return err
+	}
+	pu.Usage = qt.usage
+	return nil
+}
+
+func (q *Quota) Put(ctx context.Context, path, key string, value []byte) error {
+	return q.PutExpiring(ctx, path, key, value, time.Time{})
+}
+
+func (q *Quota) PutExpiring(ctx context.Context, path, key string, value []byte, expiresAt time.Time) error {
+	return q.write(ctx, path, func(tx Tx) error {
+		return tx.PutExpiring(key, value, expiresAt)
+	})
+}
+
+func (q *Quota) Delete(ctx context.Context, path, key string) error {
+	return q.write(ctx, path, func(tx Tx) error {
+		return tx.Delete(key)
+	})
+}
+
+func (q *Quota) PutMany(ctx context.Context, path string, entries []Entry) error {
+	return q.write(ctx, path, func(tx Tx) error {
+		for _, e := range entries {
+			if err := tx.PutExpiring(e.Key, e.Value, e.ExpiresAt); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (q *Quota) DeleteMany(ctx context.Context, path string, keys []string) error {
+	return q.write(ctx, path, func(tx Tx) error {
+		for _, key := range keys {
+			if err := tx.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (q *Quota) Update(ctx context.Context, path string, fn func(tx Tx) error) error {
+	return q.write(ctx, path, fn)
+}
+
+// PurgeExpired purges the wrapped store, then has every path counted again
+// the next time it is used.
+func (q *Quota) PurgeExpired(ctx context.Context, now time.Time) (int, error) {
+	n, err := q.KV.PurgeExpired(ctx, now)
+	q.mu.Lock()
+	paths := make([]*pathUsage, 0, len(q.paths))
+	for _, pu := range q.paths {
+		paths = append(paths, pu)
+	}
+	q.mu.Unlock()
+	for _, pu := range paths {
+		pu.mu.Lock()
+		pu.loaded = false
+		pu.mu.Unlock()
+	}
+	return n, err
+}
+
+// quotaTx checks each write against the limits before passing it on, and
+// keeps the path's usage as it would be if the transaction committed.
+type quotaTx struct {
+	Tx
+	path   string
+	limits Limits
+	usage  Usage
+}
+
+func (t *quotaTx) Put(key string, value []byte) error {
+	return t.PutExpiring(key, value, time.Time{})
+}
+
+func (t *quotaTx) PutExpiring(key string, value []byte, expiresAt time.Time) error {
+	if t.limits.MaxValueBytes > 0 && len(value) > t.limits.MaxValueBytes {
+		return fmt.Errorf("%w: a value of %d bytes is over the limit of %d bytes", ErrQuotaExceeded, len(value), t.limits.MaxValueBytes)
+	}
+	old, err := t.Tx.Get(key)
+	if err != nil {
+		return err
+	}
+	next := t.usage
+	if old == nil {
+		next.Keys++
+	} else {
+		next.Bytes -= entryBytes(key, old)
+	}
+	next.Bytes += entryBytes(key, value)
+	if t.limits.MaxKeys > 0 && next.Keys > t.limits.MaxKeys && next.Keys > t.usage.Keys {
+		return fmt.Errorf("%w: %s would hold %d keys, over its limit of %d", ErrQuotaExceeded, t.path, next.Keys, t.limits.MaxKeys)
+	}
+	if t.limits.MaxBytes > 0 && next.Bytes > t.limits.MaxBytes && next.Bytes > t.usage.Bytes {
+		return fmt.Errorf("%w: %s would hold %d bytes, over its limit of %d", ErrQuotaExceeded, t.path, next.Bytes, t.limits.MaxBytes)
+	}
+	if err := t.Tx.PutExpiring(key, value, expiresAt); err != nil {
+		return err
+	}
+	t.usage = next
+	return nil
+}
+
+func (t *quotaTx) Delete(key string) error {
+	old, err := t.Tx.Get(key)
+	if err != nil {
+		return err
+	}
+	if err := t.Tx.Delete(key); err != nil {
+		return err
+	}
+	if old != nil {
+		t.usage.Keys--
+		t.usage.Bytes -= entryBytes(key, old)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package kv
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestQuotaLimits verifies each limit rejects the write that would cross it, and only that write
+func TestQuotaLimits(t *testing.T) {
+	ctx := context.Background()
+	tt := []struct {
+		name   string
+		limits Limits
+		setup  []Entry
+		write  func(q *Quota) error
+		err    bool
+	}{
+		{name: "value at the limit", limits: Limits{MaxValueBytes: 3}, write: func(q *Quota) error { return q.Put(ctx, "/a", "k", []byte(`123`)) }},
+		{name: "value over the limit", limits: Limits{MaxValueBytes: 3}, write: func(q *Quota) error { return q.Put(ctx, "/a", "k", []byte(`1234`)) }, err: true},
+		{name: "new key over the limit", limits: Limits{MaxKeys: 1}, setup: []Entry{{Key: "a", Value: []byte(`1`)}},
+			write: func(q *Quota) error { return q.Put(ctx, "/a", "b", []byte(`1`)) }, err: true},
+		{name: "overwrite at the key limit", limits: Limits{MaxKeys: 1}, setup: []Entry{{Key: "a", Value: []byte(`1`)}},
+			write: func(q *Quota) error { return q.Put(ctx, "/a", "a", []byte(`2`)) }},
+		{name: "other paths have their own limit", limits: Limits{MaxKeys: 1}, setup: []Entry{{Key: "a", Value: []byte(`1`)}},
+			write: func(q *Quota) error { return q.Put(ctx, "/b", "b", []byte(`1`)) }},
+		{name: "bytes over the limit", limits: Limits{MaxBytes: 4}, setup: []Entry{{Key: "a", Value: []byte(`1`)}},
+			write: func(q *Quota) error { return q.Put(ctx, "/a", "b", []byte(`123`)) }, err: true},
+		{name: "batch over the limit", limits: Limits{MaxKeys: 2},
+			write: func(q *Quota) error {
+				return q.PutMany(ctx, "/a", []Entry{{Key: "a", Value: []byte(`1`)}, {Key: "b", Value: []byte(`1`)}, {Key: "c", Value: []byte(`1`)}})
+			}, err: true},
+		{name: "update over the limit", limits: Limits{MaxKeys: 1},
+			write: func(q *Quota) error {
+				return q.Update(ctx, "/a", func(tx Tx) error {
+					tx.Put("a", []byte(`1`))
+					return tx.Put("b", []byte(`1`))
+				})
+			}, err: true},
+	}
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			store := NewMemory()
+			defer store.Close()
+			if test.setup != nil {
+				assert.NoError(t, store.PutMany(ctx, "/a", test.setup))
+			}
+			q := NewQuota(store, test.limits)
+			before, err := q.Usage(ctx, "/a")
+			assert.NoError(t, err)
+			err = test.write(q)
+			if !test.err {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, ErrQuotaExceeded)
+			after, err := q.Usage(ctx, "/a")
+			assert.NoError(t, err)
+			assert.Equal(t, before, after, "a rejected write changes nothing")
+			res, _ := store.List(ctx, "/a", ListOptions{})
+			assert.Len(t, res.Keys, len(test.setup))
+		})
+	}
+}
+
+// TestQuotaUsage verifies usage is counted from the store and kept up to date by writes
+func TestQuotaUsage(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemory()
+	defer store.Close()
+	assert.NoError(t, store.Put(ctx, "/a", "k", []byte(`12`)))
+	q := NewQuota(store, Limits{})
+
+	u, err := q.Usage(ctx, "/a")
+	assert.NoError(t, err)
+	assert.Equal(t, Usage{Keys: 1, Bytes: 3}, u, "existing keys are counted")
+
+	assert.NoError(t, q.Put(ctx, "/a", "k", []byte(`1`)))
+	assert.NoError(t, q.PutMany(ctx, "/a", []Entry{{Key: "x", Value: []byte(`123`)}, {Key: "x", Value: []byte(`1`)}}))
+	u, _ = q.Usage(ctx, "/a")
+	assert.Equal(t, Usage{Keys: 2, Bytes: 4}, u)
+
+	assert.NoError(t, q.DeleteMany(ctx, "/a", []string{"k", "missing"}))
+	u, _ = q.Usage(ctx, "/a")
+	assert.Equal(t, Usage{Keys: 1, Bytes: 2}, u)
+
+	assert.NoError(t, q.PutExpiring(ctx, "/a", "gone", []byte(`1`), time.Now().Add(time.Millisecond)))
+	time.Sleep(5 * time.Millisecond)
+	u, _ = q.Usage(ctx, "/a")
+	assert.Equal(t, Usage{Keys: 2, Bytes: 7}, u, "an expired key counts until it is purged")
+	n, err := q.PurgeExpired(ctx, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	u, _ = q.Usage(ctx, "/a")
+	assert.Equal(t, Usage{Keys: 1, Bytes: 2}, u)
+}
+
+// TestQuotaShrinkOverLimit verifies a path already over its limits can still be cleaned up
+func TestQuotaShrinkOverLimit(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemory()
+	defer store.Close()
+	assert.NoError(t, store.PutMany(ctx, "/a", []Entry{{Key: "a", Value: []byte(`"long value"`)}, {Key: "b", Value: []byte(`1`)}}))
+	q := NewQuota(store, Limits{MaxKeys: 1, MaxBytes: 4})
+
+	assert.ErrorIs(t, q.Put(ctx, "/a", "c", []byte(`1`)), ErrQuotaExceeded)
+	assert.NoError(t, q.Put(ctx, "/a", "a", []byte(`1`)), "shrinking a value is allowed")
+	assert.NoError(t, q.Delete(ctx, "/a", "b"))
+	u, _ := q.Usage(ctx, "/a")
+	assert.Equal(t, Usage{Keys: 1, Bytes: 2}, u)
+}
+
+// TestFind verifies decorators are unwrapped to find the store asked for
+func TestFind(t *testing.T) {
+	q := NewQuota(NewMemory(), Limits{})
+	n := NewNotifier(q)
+	got, ok := Find[*Quota](n)
+	assert.True(t, ok)
+	assert.Same(t, q, got)
+	w, ok := Find[Watcher](NewQuota(n, Limits{}))
+	assert.True(t, ok)
+	assert.Same(t, n, w)
+	_, ok = Find[Watcher](q)
+	assert.False(t, ok)
+}
@@ -0,0 +1,770 @@
+package kv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Redis layout. Every path is a hash of key → value (in the same expiry
+// envelope bbolt uses) plus a sorted set of its keys with equal scores, so
+// ZRANGEBYLEX lists them in the same byte order as the other backends.
+// Index definitions are a hash of name → field, and each index is a sorted
+// set of indexEntry members. Keys are binary safe, so a NUL separates a path
+// from an index name.
+const (
+	redisValuesPrefix    = "hput:kv:"
+	redisKeysPrefix      = "hput:keys:"
+	redisIndexDefsPrefix = "hput:idx-defs:"
+	redisIndexPrefix     = "hput:idx:"
+)
+
+func redisValuesKey(path string) string    { return redisValuesPrefix + path }
+func redisKeysKey(path string) string      { return redisKeysPrefix + path }
+func redisIndexDefsKey(path string) string { return redisIndexDefsPrefix + path }
+func redisIndexKey(path, name string) string {
+	return redisIndexPrefix + path + "\x00" + name
+}
+
+// maxRedisAttempts bounds how often a write is retried after another client
+// changed a watched key under it.
+const maxRedisAttempts = 100
+
+// redisBatch is how many members each ZRANGEBYLEX or SCAN asks for.
+const redisBatch = 100
+
+// errRedisConflict means EXEC was aborted because a watched key changed.
+var errRedisConflict = errors.New("kv: redis: transaction conflict")
+
+// redisBackoff waits a random, growing while before retry attempt, so
+// clients that keep conflicting with each other spread out.
+func redisBackoff(ctx context.Context, attempt int) error {
+	if attempt == 0 {
+		return ctx.Err()
+	}
+	d := time.Duration(rand.Int64N(int64(min(attempt, 20)) * int64(time.Millisecond)))
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
+}
+
+// RedisKV implements KV on any server speaking the Redis protocol, so several
+// hput instances can share one store. Writes are optimistic transactions
+// (WATCH/MULTI/EXEC) retried on conflict.
+type RedisKV struct {
+	addr     string
+	password string
+	db       int
+
+	mu     sync.Mutex
+	idle   []*respConn
+	closed bool
+}
+
+// NewRedis connects to the server at rawURL, e.g. redis://:password@host:6379/0.
+func NewRedis(rawURL string) (*RedisKV, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "redis" || u.Host == "" {
+		return nil, fmt.Errorf("kv: redis url must look like redis://[:password@]host:port[/db], got %q", rawURL)
+	}
+	r := &RedisKV{addr: u.Host}
+	if u.Port() == "" {
+		r.addr += ":6379"
+	}
+	if u.User != nil {
+		r.password, _ = u.User.Password()
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		if r.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("kv: redis url database must be a number, got %q", db)
+		}
+	}
+	c, err := r.conn()
+	if err != nil {
+		return nil, err
+	}
+	_, err = c.do("PING")
+	r.release(c)
+	if err != nil {
+		r.Close()
+		return nil, fmt.Errorf("kv: redis: ping: %w", err)
+	}
+	return r, nil
+}
+
+// conn returns an idle connection or dials a new one.
+func (r *RedisKV) conn() (*respConn, error) {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return nil, ErrClosed
+	}
+	if n := len(r.idle); n > 0 {
+		c := r.idle[n-1]
+		r.idle = r.idle[:n-1]
+		r.mu.Unlock()
+		return c, nil
+	}
+	r.mu.Unlock()
+
+	c, err := dialRESP(r.addr)
+	if err != nil {
+		return nil, fmt.Errorf("kv: redis: dial %s: %w", r.addr, err)
+	}
+	if r.password != "" {
+		if _, err := c.do("AUTH", r.password); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("kv: redis: auth: %w", err)
+		}
+	}
+	if r.db != 0 {
+		if _, err := c.do("SELECT", strconv.Itoa(r.db)); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("kv: redis: select: %w", err)
+		}
+	}
+	return c, nil
+}
+
+// release returns c to the pool, or closes it if it is broken.
+func (r *RedisKV) release(c *respConn) {
+	if c.broken {
+		c.Close()
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		c.Close()
+		return
+	}
+	r.idle = append(r.idle, c)
+}
+
+// do runs one command on a pooled connection.
+func (r *RedisKV) do(args ...string) (interface{}, error) {
+	c, err := r.conn()
+	if err != nil {
+		return nil, err
+	}
+	v, err := c.do(args...)
+	r.release(c)
+	return v, err
+}
+
+func (r *RedisKV) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	for _, c := range r.idle {
+		c.Close()
+	}
+	r.idle = nil
+	return nil
+}
+
+// liveValue decodes a stored value, treating an expired one as missing.
+func liveValue(raw []byte, now time.Time) ([]byte, time.Time) {
+	if raw == nil {
+		return nil, time.Time{}
+	}
+	v, expiresAt := decodeValue(raw)
+	if expired(expiresAt, now) {
+		return nil, time.Time{}
+	}
+	return v, expiresAt
+}
+
+func (r *RedisKV) Get(_ context.Context, path, key string) ([]byte, error) {
+	v, err := r.do("HGET", redisValuesKey(path), key)
+	if err != nil {
+		return nil, fmt.Errorf("kv: get: %w", err)
+	}
+	raw, _ := v.([]byte)
+	val, _ := liveValue(raw, time.Now())
+	return val, nil
+}
+
+func (r *RedisKV) Put(ctx context.Context, path, key string, value []byte) error {
+	return r.PutExpiring(ctx, path, key, value, time.Time{})
+}
+
+func (r *RedisKV) PutExpiring(ctx context.Context, path, key string, value []byte, expiresAt time.Time) error {
+	return r.Update(ctx, path, func(tx Tx) error {
+		return tx.PutExpiring(key, value, expiresAt)
+	})
+}
+
+func (r *RedisKV) Delete(ctx context.Context, path, key string) error {
+	return r.Update(ctx, path, func(tx Tx) error {
+		return tx.Delete(key)
+	})
+}
+
+func (r *RedisKV) GetMany(_ context.Context, path string, keys []string) ([][]byte, error) {
+	vals := make([][]byte, len(keys))
+	if len(keys) == 0 {
+		return vals, nil
+	}
+	v, err := r.do(append([]string{"HMGET", redisValuesKey(path)}, keys...)...)
+	if err != nil {
+		return nil, fmt.Errorf("kv: get many: %w", err)
+	}
+	raws, err := respBulks(v)
+	if err != nil {
+		return nil, fmt.Errorf("kv: get many: %w", err)
+	}
+	now := time.Now()
+	for i, raw := range raws {
+		vals[i], _ = liveValue(raw, now)
+	}
+	return vals, nil
+}
+
+func (r *RedisKV) PutMany(ctx context.Context, path string, entries []Entry) error {
+	return r.Update(ctx, path, func(tx Tx) error {
+		for _, e := range entries {
+			if err := tx.PutExpiring(e.Key, e.Value, e.ExpiresAt); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r *RedisKV) DeleteMany(ctx context.Context, path string, keys []string) error {
+	return r.Update(ctx, path, func(tx Tx) error {
+		for _, key := range keys {
+			if err := tx.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// List pages through the path's sorted set of keys with ZRANGEBYLEX and
+// reads their values with HMGET, skipping any that expired.
+func (r *RedisKV) List(_ context.Context, path string, opts ListOptions) (ListResult, error) {
+	from, to := "-", "+"
+	if start := max(opts.Cursor, opts.Prefix); start != "" {
+		from = "[" + start
+	}
+	if end, ok := prefixEnd(opts.Prefix); ok {
+		to = "(" + end
+	}
+
+	var result ListResult
+	now := time.Now()
+	for {
+		v, err := r.do("ZRANGEBYLEX", redisKeysKey(path), from, to, "LIMIT", "0", strconv.Itoa(redisBatch))
+		if err != nil {
+			return ListResult{}, fmt.Errorf("kv: list: %w", err)
+		}
+		members, err := respBulks(v)
+		if err != nil {
+			return ListResult{}, fmt.Errorf("kv: list: %w", err)
+		}
+		if len(members) == 0 {
+			return result, nil
+		}
+		keys := make([]string, len(members))
+		for i, m := range members {
+			keys[i] = string(m)
+		}
+		v, err = r.do(append([]string{"HMGET", redisValuesKey(path)}, keys...)...)
+		if err != nil {
+			return ListResult{}, fmt.Errorf("kv: list: %w", err)
+		}
+		raws, err := respBulks(v)
+		if err != nil {
+			return ListResult{}, fmt.Errorf("kv: list: %w", err)
+		}
+		for i, key := range keys {
+			val, _ := liveValue(raws[i], now)
+			if val == nil {
+				continue
+			}
+			if opts.Limit > 0 && len(result.Keys) >= opts.Limit {
+				result.Cursor = key
+				return result, nil
+			}
+			result.Keys = append(result.Keys, key)
+			if opts.IncludeValues {
+				result.Values = append(result.Values, val)
+			}
+		}
+		if len(members) < redisBatch {
+			return result, nil
+		}
+		from = "(" + keys[len(keys)-1]
+	}
+}
+
+// Update runs fn as an optimistic transaction: reads happen under WATCH and
+// the buffered writes are applied with MULTI/EXEC. If another client changed
+// the path meanwhile, fn runs again from the start.
+func (r *RedisKV) Update(ctx context.Context, path string, fn func(tx Tx) error) error {
+	return r.update(ctx, path, func(tx *redisTx) error { return fn(tx) })
+}
+
+func (r *RedisKV) update(ctx context.Context, path string, fn func(tx *redisTx) error) error {
+	for attempt := 0; attempt < maxRedisAttempts; attempt++ {
+		if err := redisBackoff(ctx, attempt); err != nil {
+			return err
+		}
+		c, err := r.conn()
+		if err != nil {
+			return err
+		}
+		err = r.tryUpdate(c, path, fn)
+		r.release(c)
+		if !errors.Is(err, errRedisConflict) {
+			return err
+		}
+	}
+	return fmt.Errorf("kv: update: gave up after %d attempts: %w", maxRedisAttempts, errRedisConflict)
+}
+
+func (r *RedisKV) tryUpdate(c *respConn, path string, fn func(tx *redisTx) error) error {
+	valuesKey := redisValuesKey(path)
+	if _, err := c.do("WATCH", valuesKey, redisIndexDefsKey(path)); err != nil {
+		return err
+	}
+	tx := &redisTx{conn: c, path: path, now: time.Now(), writes: map[string]*Entry{}}
+	if err := fn(tx); err != nil {
+		c.do("UNWATCH")
+		return err
+	}
+	if tx.err != nil {
+		c.do("UNWATCH")
+		return tx.err
+	}
+	if len(tx.order) == 0 {
+		_, err := c.do("UNWATCH")
+		return err
+	}
+
+	defs, err := r.indexDefs(c, path)
+	if err != nil {
+		c.do("UNWATCH")
+		return err
+	}
+	var olds [][]byte
+	if len(defs) > 0 {
+		v, err := c.do(append([]string{"HMGET", valuesKey}, tx.order...)...)
+		if err == nil {
+			olds, err = respBulks(v)
+		}
+		if err != nil {
+			c.do("UNWATCH")
+			return err
+		}
+	}
+
+	cmds := [][]string{}
+	for i, key := range tx.order {
+		w := tx.writes[key]
+		if olds != nil && olds[i] != nil {
+			old, _ := decodeValue(olds[i])
+			for name, field := range defs {
+				if e, ok := indexEntry(old, field, key); ok {
+					cmds = append(cmds, []string{"ZREM", redisIndexKey(path, name), string(e)})
+				}
+			}
+		}
+		if w == nil {
+			cmds = append(cmds,
+				[]string{"HDEL", valuesKey, key},
+				[]string{"ZREM", redisKeysKey(path), key})
+			continue
+		}
+		cmds = append(cmds,
+			[]string{"HSET", valuesKey, key, string(encodeValue(w.Value, w.ExpiresAt))},
+			[]string{"ZADD", redisKeysKey(path), "0", key})
+		for name, field := range defs {
+			if e, ok := indexEntry(w.Value, field, key); ok {
+				cmds = append(cmds, []string{"ZADD", redisIndexKey(path, name), "0", string(e)})
+			}
+		}
+	}
+	return redisExec(c, cmds)
+}
+
+// redisExec runs cmds between MULTI and EXEC on a connection that is watching keys.
+func redisExec(c *respConn, cmds [][]string) error {
+	if _, err := c.do("MULTI"); err != nil {
+		return err
+	}
+	for _, cmd := range cmds {
+		if _, err := c.do(cmd...); err != nil {
+			c.do("DISCARD")
+			return err
+		}
+	}
+	v, err := c.do("EXEC")
+	if err != nil {
+		return err
+	}
+	results, ok := v.([]interface{})
+	if !ok || results == nil {
+		return errRedisConflict
+	}
+	for _, res := range results {
+		if e, ok := res.(respError); ok {
+			return e
+		}
+	}
+	return nil
+}
+
+// indexDefs reads the index definitions of path as name → field segments.
+func (r *RedisKV) indexDefs(c *respConn, path string) (map[string][]string, error) {
+	v, err := c.do("HGETALL", redisIndexDefsKey(path))
+	if err != nil {
+		return nil, err
+	}
+	pairs, err := respBulks(v)
+	if err != nil {
+		return nil, err
+	}
+	defs := map[string][]string{}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		segs, err := parseField(string(pairs[i+1]))
+		if err != nil {
+			return nil, fmt.Errorf("kv: index %q of %q: %w", pairs[i], path, err)
+		}
+		defs[string(pairs[i])] = segs
+	}
+	return defs, nil
+}
+
+// redisTx implements Tx for RedisKV.Update. Reads go to the server on the
+// watching connection; writes are buffered, a nil entry meaning a delete.
+type redisTx struct {
+	conn   *respConn
+	path   string
+	now    time.Time
+	writes map[string]*Entry
+	order  []string
+	err    error // first read error, so fn cannot swallow it and commit anyway
+}
+
+// raw returns the stored value at key, including expired ones.
+func (t *redisTx) raw(key string) ([]byte, error) {
+	if w, ok := t.writes[key]; ok {
+		if w == nil {
+			return nil, nil
+		}
+		return encodeValue(w.Value, w.ExpiresAt), nil
+	}
+	v, err := t.conn.do("HGET", redisValuesKey(t.path), key)
+	if err != nil {
+		if t.err == nil {
+			t.err = err
+		}
+		return nil, err
+	}
+	raw, _ := v.([]byte)
+	return raw, nil
+}
+
+func (t *redisTx) Get(key string) ([]byte, error) {
+	raw, err := t.raw(key)
+	val, _ := liveValue(raw, t.now)
+	return append([]byte(nil), val...), err
+}
+
+func (t *redisTx) ExpiresAt(key string) (time.Time, error) {
+	raw, err := t.raw(key)
+	_, expiresAt := liveValue(raw, t.now)
+	return expiresAt, err
+}
+
+func (t *redisTx) Put(key string, value []byte) error {
+	return t.PutExpiring(key, value, time.Time{})
+}
+
+func (t *redisTx) PutExpiring(key string, value []byte, expiresAt time.Time) error {
+	t.write(key, &Entry{Key: key, Value: append([]byte{}, value...), ExpiresAt: expiresAt})
+	return nil
+}
+
+func (t *redisTx) Delete(key string) error {
+	t.write(key, nil)
+	return nil
+}
+
+func (t *redisTx) write(key string, e *Entry) {
+	if _, ok := t.writes[key]; !ok {
+		t.order = append(t.order, key)
+	}
+	t.writes[key] = e
+}
+
+// scanPaths SCANs for every path that has a values hash.
+func (r *RedisKV) scanPaths() ([]string, error) {
+	var paths []string
+	cursor := "0"
+	for {
+		v, err := r.do("SCAN", cursor, "MATCH", redisValuesPrefix+"*", "COUNT", strconv.Itoa(redisBatch))
+		if err != nil {
+			return nil, err
+		}
+		reply, ok := v.([]interface{})
+		if !ok || len(reply) != 2 {
+			return nil, fmt.Errorf("unexpected SCAN reply %v", v)
+		}
+		next, _ := reply[0].([]byte)
+		keys, err := respBulks(reply[1])
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range keys {
+			paths = append(paths, strings.TrimPrefix(string(k), redisValuesPrefix))
+		}
+		if cursor = string(next); cursor == "0" || cursor == "" {
+			return paths, nil
+		}
+	}
+}
+
+// Paths SCANs for every path hash and keeps those with a live key.
+func (r *RedisKV) Paths(ctx context.Context) ([]string, error) {
+	all, err := r.scanPaths()
+	if err != nil {
+		return nil, fmt.Errorf("kv: paths: %w", err)
+	}
+	var paths []string
+	for _, path := range all {
+		res, err := r.List(ctx, path, ListOptions{Limit: 1})
+		if err != nil {
+			return nil, fmt.Errorf("kv: paths: %w", err)
+		}
+		if len(res.Keys) > 0 {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// PurgeExpired SCANs for every path hash and deletes its expired keys.
+func (r *RedisKV) PurgeExpired(ctx context.Context, now time.Time) (int, error) {
+	paths, err := r.scanPaths()
+	if err != nil {
+		return 0, fmt.Errorf("kv: purge expired: %w", err)
+	}
+
+	var purged int
+	for _, path := range paths {
+		v, err := r.do("HGETALL", redisValuesKey(path))
+		if err != nil {
+			return purged, fmt.Errorf("kv: purge expired: %w", err)
+		}
+		pairs, err := respBulks(v)
+		if err != nil {
+			return purged, fmt.Errorf("kv: purge expired: %w", err)
+		}
+		var dead []string
+		for i := 0; i+1 < len(pairs); i += 2 {
+			if _, expiresAt := decodeValue(pairs[i+1]); expired(expiresAt, now) {
+				dead = append(dead, string(pairs[i]))
+			}
+		}
+		if len(dead) == 0 {
+			continue
+		}
+		var n int
+		err = r.update(ctx, path, func(tx *redisTx) error {
+			n = 0
+			for _, key := range dead {
+				// Check again: the key may have been rewritten since HGETALL.
+				raw, err := tx.raw(key)
+				if err != nil {
+					return err
+				}
+				if _, expiresAt := decodeValue(raw); raw != nil && expired(expiresAt, now) {
+					tx.Delete(key)
+					n++
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return purged, fmt.Errorf("kv: purge expired: %w", err)
+		}
+		purged += n
+	}
+	return purged, nil
+}
+
+// CreateIndex indexes path by field, indexing the values already stored.
+func (r *RedisKV) CreateIndex(ctx context.Context, path, name, field string) error {
+	segs, err := validIndex(name, field)
+	if err != nil {
+		return err
+	}
+	for attempt := 0; attempt < maxRedisAttempts; attempt++ {
+		if err := redisBackoff(ctx, attempt); err != nil {
+			return err
+		}
+		c, err := r.conn()
+		if err != nil {
+			return err
+		}
+		err = r.tryCreateIndex(c, path, name, field, segs)
+		r.release(c)
+		if !errors.Is(err, errRedisConflict) {
+			if err != nil {
+				return fmt.Errorf("kv: create index: %w", err)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("kv: create index: %w", errRedisConflict)
+}
+
+func (r *RedisKV) tryCreateIndex(c *respConn, path, name, field string, segs []string) error {
+	if _, err := c.do("WATCH", redisValuesKey(path), redisIndexDefsKey(path)); err != nil {
+		return err
+	}
+	v, err := c.do("HGET", redisIndexDefsKey(path), name)
+	if err != nil {
+		c.do("UNWATCH")
+		return err
+	}
+	if current, _ := v.([]byte); current != nil && string(current) == field {
+		_, err := c.do("UNWATCH")
+		return err
+	}
+	v, err = c.do("HGETALL", redisValuesKey(path))
+	if err != nil {
+		c.do("UNWATCH")
+		return err
+	}
+	pairs, err := respBulks(v)
+	if err != nil {
+		c.do("UNWATCH")
+		return err
+	}
+	add := []string{"ZADD", redisIndexKey(path, name)}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		val, _ := decodeValue(pairs[i+1])
+		if e, ok := indexEntry(val, segs, string(pairs[i])); ok {
+			add = append(add, "0", string(e))
+		}
+	}
+	cmds := [][]string{
+		{"HSET", redisIndexDefsKey(path), name, field},
+		{"DEL", redisIndexKey(path, name)},
+	}
+	if len(add) > 2 {
+		cmds = append(cmds, add)
+	}
+	return redisExec(c, cmds)
+}
+
+// DropIndex removes the named index of path. Writers watch the definitions,
+// so none can add to the index after it is dropped.
+func (r *RedisKV) DropIndex(_ context.Context, path, name string) error {
+	c, err := r.conn()
+	if err != nil {
+		return err
+	}
+	err = redisExec(c, [][]string{
+		{"HDEL", redisIndexDefsKey(path), name},
+		{"DEL", redisIndexKey(path, name)},
+	})
+	r.release(c)
+	if err != nil {
+		return fmt.Errorf("kv: drop index: %w", err)
+	}
+	return nil
+}
+
+// Query walks the index's sorted set with ZRANGEBYLEX and reads the matching
+// values with HMGET, skipping any that expired.
+func (r *RedisKV) Query(_ context.Context, path, name string, q Query) (ListResult, error) {
+	s, err := q.scan()
+	if err != nil {
+		return ListResult{}, err
+	}
+	v, err := r.do("HEXISTS", redisIndexDefsKey(path), name)
+	if err != nil {
+		return ListResult{}, fmt.Errorf("kv: query: %w", err)
+	}
+	if n, _ := v.(int64); n == 0 {
+		return ListResult{}, fmt.Errorf("kv: query: %w: %q", ErrNoIndex, name)
+	}
+
+	from := "-"
+	if s.seek != nil {
+		from = "[" + string(s.seek)
+	}
+	var result ListResult
+	now := time.Now()
+	for {
+		v, err := r.do("ZRANGEBYLEX", redisIndexKey(path, name), from, "+", "LIMIT", "0", strconv.Itoa(redisBatch))
+		if err != nil {
+			return ListResult{}, fmt.Errorf("kv: query: %w", err)
+		}
+		members, err := respBulks(v)
+		if err != nil {
+			return ListResult{}, fmt.Errorf("kv: query: %w", err)
+		}
+		var entries [][]byte
+		var keys []string
+		stopped := false
+		for _, e := range members {
+			ok, stop := s.match(e)
+			if stop {
+				stopped = true
+				break
+			}
+			key, valid := indexEntryKey(e)
+			if ok && valid {
+				entries = append(entries, e)
+				keys = append(keys, key)
+			}
+		}
+		if len(keys) > 0 {
+			v, err := r.do(append([]string{"HMGET", redisValuesKey(path)}, keys...)...)
+			if err != nil {
+				return ListResult{}, fmt.Errorf("kv: query: %w", err)
+			}
+			raws, err := respBulks(v)
+			if err != nil {
+				return ListResult{}, fmt.Errorf("kv: query: %w", err)
+			}
+			for i, key := range keys {
+				val, _ := liveValue(raws[i], now)
+				if val == nil {
+					continue
+				}
+				if q.Limit > 0 && len(result.Keys) >= q.Limit {
+					result.Cursor = indexCursor(entries[i])
+					return result, nil
+				}
+				result.Keys = append(result.Keys, key)
+				if q.IncludeValues {
+					result.Values = append(result.Values, val)
+				}
+			}
+		}
+		if stopped || len(members) < redisBatch {
+			return result, nil
+		}
+		from = "(" + string(members[len(members)-1])
+	}
+}
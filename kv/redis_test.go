@@ -0,0 +1,94 @@
+package kv_test
+
+import (
+	"context"
+	"testing"
+
+	"hput/kv"
+	"hput/kv/kvtest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestRedisConformance runs the shared KV behaviour tests against the Redis backend
+func TestRedisConformance(t *testing.T) {
+	kvtest.RunConformance(t, func(t *testing.T) kv.KV {
+		_, addr := startRESPServer(t, "")
+		store, err := kv.NewRedis("redis://" + addr)
+		assert.NoError(t, err)
+		return store
+	})
+}
+
+// TestRedisSharedServer verifies that two instances pointed at one server see each other's writes
+func TestRedisSharedServer(t *testing.T) {
+	ctx := context.Background()
+	_, addr := startRESPServer(t, "")
+	a, err := kv.NewRedis("redis://" + addr)
+	assert.NoError(t, err)
+	defer a.Close()
+	b, err := kv.NewRedis("redis://" + addr)
+	assert.NoError(t, err)
+	defer b.Close()
+
+	assert.NoError(t, a.Put(ctx, "/p", "k", []byte("from a")))
+	got, err := b.Get(ctx, "/p", "k")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("from a"), got)
+
+	done := make(chan error)
+	for _, store := range []kv.KV{a, b} {
+		go func() {
+			for i := 0; i < 20; i++ {
+				err := store.Update(ctx, "/p", func(tx kv.Tx) error {
+					v, err := tx.Get("n")
+					if err != nil {
+						return err
+					}
+					return tx.Put("n", append(v, 'x'))
+				})
+				if err != nil {
+					done <- err
+					return
+				}
+			}
+			done <- nil
+		}()
+	}
+	assert.NoError(t, <-done)
+	assert.NoError(t, <-done)
+	got, err = a.Get(ctx, "/p", "n")
+	assert.NoError(t, err)
+	assert.Len(t, got, 40, "no update may be lost between instances")
+}
+
+// TestNewRedis verifies URL parsing and authentication
+func TestNewRedis(t *testing.T) {
+	srv, addr := startRESPServer(t, "secret")
+	tt := []struct {
+		name string
+		url  string
+		err  bool
+	}{
+		{name: "password and db", url: "redis://:secret@" + addr + "/2"},
+		{name: "wrong password", url: "redis://:nope@" + addr, err: true},
+		{name: "no password", url: "redis://" + addr, err: true},
+		{name: "wrong scheme", url: "http://" + addr, err: true},
+		{name: "no host", url: "redis://", err: true},
+		{name: "bad db", url: "redis://:secret@" + addr + "/x", err: true},
+	}
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			store, err := kv.NewRedis(test.url)
+			if test.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			store.Close()
+		})
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	assert.Contains(t, srv.commands, "SELECT")
+}
@@ -0,0 +1,124 @@
+package kv
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// respConn is one connection speaking RESP, the Redis serialization protocol.
+// Only what RedisKV needs is implemented: commands are sent as arrays of
+// bulk strings, and replies come back as string (simple strings), int64,
+// []byte (nil for a null bulk string), []interface{} (nil for a null array)
+// or respError.
+type respConn struct {
+	conn   net.Conn
+	r      *bufio.Reader
+	w      *bufio.Writer
+	broken bool // an I/O error left the stream in an unknown state
+}
+
+// respError is an error reply from the server. The connection is still usable after one.
+type respError string
+
+func (e respError) Error() string {
+	return "kv: redis: " + string(e)
+}
+
+func dialRESP(addr string) (*respConn, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &respConn{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}, nil
+}
+
+func (c *respConn) Close() error {
+	return c.conn.Close()
+}
+
+// do sends one command and reads its reply. An error reply is returned as a respError.
+func (c *respConn) do(args ...string) (interface{}, error) {
+	fmt.Fprintf(c.w, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(c.w, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if err := c.w.Flush(); err != nil {
+		c.broken = true
+		return nil, err
+	}
+	v, err := c.read()
+	if err != nil {
+		c.broken = true
+		return nil, err
+	}
+	if e, ok := v.(respError); ok {
+		return nil, e
+	}
+	return v, nil
+}
+
+// read reads one reply. Error replies nested in arrays, as EXEC can return,
+// are kept as respError values.
+func (c *respConn) read() (interface{}, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("kv: redis: empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return respError(line[1:]), nil
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return []byte(nil), err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return []interface{}(nil), err
+		}
+		arr := make([]interface{}, n)
+		for i := range arr {
+			if arr[i], err = c.read(); err != nil {
+				return nil, err
+			}
+		}
+		return arr, nil
+	}
+	return nil, fmt.Errorf("kv: redis: unexpected reply %q", line)
+}
+
+// respBulks converts an array reply of bulk strings, keeping nil for null entries.
+func respBulks(v interface{}) ([][]byte, error) {
+	arr, ok := v.([]interface{})
+	if !ok && v != nil {
+		return nil, fmt.Errorf("kv: redis: expected an array, got %T", v)
+	}
+	out := make([][]byte, len(arr))
+	for i, e := range arr {
+		b, ok := e.([]byte)
+		if !ok && e != nil {
+			return nil, fmt.Errorf("kv: redis: expected a bulk string, got %T", e)
+		}
+		out[i] = b
+	}
+	return out, nil
+}
@@ -0,0 +1,378 @@
+package kv_test
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// respServer is an in-process stand-in for Redis. It speaks enough RESP,
+// with enough of the commands' semantics, for RedisKV: hashes, sorted sets
+// compared by lex, SCAN, and WATCH/MULTI/EXEC. Every command runs under one
+// lock, so it is as atomic as the real thing.
+type respServer struct {
+	mu       sync.Mutex
+	hashes   map[string]map[string]string
+	zsets    map[string]map[string]bool
+	versions map[string]int // bumped on every write, for WATCH
+	password string
+	commands []string // names of every command received, in order
+}
+
+// startRESPServer listens on a free local port until t ends and returns its address.
+func startRESPServer(t *testing.T, password string) (*respServer, string) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening: %v", err)
+	}
+	s := &respServer{
+		hashes:   map[string]map[string]string{},
+		zsets:    map[string]map[string]bool{},
+		versions: map[string]int{},
+		password: password,
+	}
+	var wg sync.WaitGroup
+	t.Cleanup(func() {
+		l.Close()
+		wg.Wait()
+	})
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				s.serve(conn)
+			}()
+		}
+	}()
+	return s, l.Addr().String()
+}
+
+// respSession is one client connection's state.
+type respSession struct {
+	authed  bool
+	watched map[string]int
+	queue   [][]string // commands queued after MULTI; nil when not in MULTI
+}
+
+func (s *respServer) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	sess := &respSession{authed: s.password == "", watched: map[string]int{}}
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		writeReply(w, s.handle(sess, args))
+		if err := w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(line[1:], "\r\n"))
+	if err != nil || line[0] != '*' {
+		return nil, fmt.Errorf("expected an array, got %q", line)
+	}
+	args := make([]string, n)
+	for i := range args {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSuffix(line[1:], "\r\n"))
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+// Reply values: respStatus, respErr, int, *string (nil is a null bulk), []interface{} (nil is a null array).
+type respStatus string
+type respErr string
+
+func bulk(s string) *string { return &s }
+
+func writeReply(w *bufio.Writer, v interface{}) {
+	switch v := v.(type) {
+	case respStatus:
+		fmt.Fprintf(w, "+%s\r\n", v)
+	case respErr:
+		fmt.Fprintf(w, "-%s\r\n", v)
+	case int:
+		fmt.Fprintf(w, ":%d\r\n", v)
+	case *string:
+		if v == nil {
+			w.WriteString("$-1\r\n")
+			return
+		}
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(*v), *v)
+	case []interface{}:
+		if v == nil {
+			w.WriteString("*-1\r\n")
+			return
+		}
+		fmt.Fprintf(w, "*%d\r\n", len(v))
+		for _, e := range v {
+			writeReply(w, e)
+		}
+	default:
+		panic(fmt.Sprintf("unknown reply %T", v))
+	}
+}
+
+func (s *respServer) handle(sess *respSession, args []string) interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cmd := strings.ToUpper(args[0])
+	s.commands = append(s.commands, cmd)
+	switch {
+	case cmd == "AUTH":
+		if len(args) != 2 || args[1] != s.password {
+			return respErr("WRONGPASS invalid password")
+		}
+		sess.authed = true
+		return respStatus("OK")
+	case !sess.authed:
+		return respErr("NOAUTH Authentication required.")
+	case cmd == "MULTI":
+		sess.queue = [][]string{}
+		return respStatus("OK")
+	case cmd == "DISCARD":
+		sess.queue = nil
+		sess.watched = map[string]int{}
+		return respStatus("OK")
+	case cmd == "EXEC":
+		queue := sess.queue
+		sess.queue = nil
+		watched := sess.watched
+		sess.watched = map[string]int{}
+		for key, version := range watched {
+			if s.versions[key] != version {
+				return []interface{}(nil)
+			}
+		}
+		results := []interface{}{}
+		for _, q := range queue {
+			results = append(results, s.run(sess, q))
+		}
+		return results
+	case sess.queue != nil:
+		sess.queue = append(sess.queue, args)
+		return respStatus("QUEUED")
+	}
+	return s.run(sess, args)
+}
+
+// run executes one data command. The caller holds s.mu.
+func (s *respServer) run(sess *respSession, args []string) interface{} {
+	cmd := strings.ToUpper(args[0])
+	switch cmd {
+	case "PING":
+		return respStatus("PONG")
+	case "SELECT":
+		return respStatus("OK")
+	case "WATCH":
+		for _, key := range args[1:] {
+			sess.watched[key] = s.versions[key]
+		}
+		return respStatus("OK")
+	case "UNWATCH":
+		sess.watched = map[string]int{}
+		return respStatus("OK")
+	case "HGET":
+		v, ok := s.hashes[args[1]][args[2]]
+		if !ok {
+			return (*string)(nil)
+		}
+		return bulk(v)
+	case "HEXISTS":
+		if _, ok := s.hashes[args[1]][args[2]]; ok {
+			return 1
+		}
+		return 0
+	case "HMGET":
+		out := []interface{}{}
+		for _, f := range args[2:] {
+			if v, ok := s.hashes[args[1]][f]; ok {
+				out = append(out, bulk(v))
+			} else {
+				out = append(out, (*string)(nil))
+			}
+		}
+		return out
+	case "HGETALL":
+		out := []interface{}{}
+		for f, v := range s.hashes[args[1]] {
+			out = append(out, bulk(f), bulk(v))
+		}
+		return out
+	case "HSET":
+		h := s.hashes[args[1]]
+		if h == nil {
+			h = map[string]string{}
+			s.hashes[args[1]] = h
+		}
+		added := 0
+		for i := 2; i+1 < len(args); i += 2 {
+			if _, ok := h[args[i]]; !ok {
+				added++
+			}
+			h[args[i]] = args[i+1]
+		}
+		s.versions[args[1]]++
+		return added
+	case "HDEL":
+		removed := 0
+		for _, f := range args[2:] {
+			if _, ok := s.hashes[args[1]][f]; ok {
+				delete(s.hashes[args[1]], f)
+				removed++
+			}
+		}
+		if len(s.hashes[args[1]]) == 0 {
+			delete(s.hashes, args[1])
+		}
+		if removed > 0 {
+			s.versions[args[1]]++
+		}
+		return removed
+	case "ZADD":
+		z := s.zsets[args[1]]
+		if z == nil {
+			z = map[string]bool{}
+			s.zsets[args[1]] = z
+		}
+		added := 0
+		for i := 3; i < len(args); i += 2 {
+			if !z[args[i]] {
+				added++
+			}
+			z[args[i]] = true
+		}
+		s.versions[args[1]]++
+		return added
+	case "ZREM":
+		removed := 0
+		for _, m := range args[2:] {
+			if s.zsets[args[1]][m] {
+				delete(s.zsets[args[1]], m)
+				removed++
+			}
+		}
+		if len(s.zsets[args[1]]) == 0 {
+			delete(s.zsets, args[1])
+		}
+		if removed > 0 {
+			s.versions[args[1]]++
+		}
+		return removed
+	case "ZRANGEBYLEX":
+		var members []string
+		for m := range s.zsets[args[1]] {
+			if lexAbove(m, args[2]) && lexBelow(m, args[3]) {
+				members = append(members, m)
+			}
+		}
+		sort.Strings(members)
+		if len(args) == 7 && strings.ToUpper(args[4]) == "LIMIT" {
+			offset, _ := strconv.Atoi(args[5])
+			count, _ := strconv.Atoi(args[6])
+			members = members[min(offset, len(members)):]
+			if count >= 0 && count < len(members) {
+				members = members[:count]
+			}
+		}
+		out := []interface{}{}
+		for _, m := range members {
+			out = append(out, bulk(m))
+		}
+		return out
+	case "DEL":
+		removed := 0
+		for _, key := range args[1:] {
+			_, isHash := s.hashes[key]
+			_, isZset := s.zsets[key]
+			if isHash || isZset {
+				removed++
+				s.versions[key]++
+			}
+			delete(s.hashes, key)
+			delete(s.zsets, key)
+		}
+		return removed
+	case "SCAN":
+		// Everything in one page; only "prefix*" patterns are supported.
+		prefix := ""
+		for i := 2; i+1 < len(args); i += 2 {
+			if strings.ToUpper(args[i]) == "MATCH" {
+				prefix = strings.TrimSuffix(args[i+1], "*")
+			}
+		}
+		keys := []interface{}{}
+		for key := range s.hashes {
+			if strings.HasPrefix(key, prefix) {
+				keys = append(keys, bulk(key))
+			}
+		}
+		for key := range s.zsets {
+			if strings.HasPrefix(key, prefix) {
+				keys = append(keys, bulk(key))
+			}
+		}
+		return []interface{}{bulk("0"), keys}
+	}
+	return respErr("ERR unknown command '" + args[0] + "'")
+}
+
+// lexAbove reports whether m is within a ZRANGEBYLEX min of "-", "[x" or "(x".
+func lexAbove(m, bound string) bool {
+	switch {
+	case bound == "-":
+		return true
+	case bound == "+":
+		return false
+	case bound[0] == '[':
+		return m >= bound[1:]
+	default:
+		return m > bound[1:]
+	}
+}
+
+// lexBelow reports whether m is within a ZRANGEBYLEX max of "+", "[x" or "(x".
+func lexBelow(m, bound string) bool {
+	switch {
+	case bound == "+":
+		return true
+	case bound == "-":
+		return false
+	case bound[0] == '[':
+		return m <= bound[1:]
+	default:
+		return m < bound[1:]
+	}
+}
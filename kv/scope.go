@@ -0,0 +1,42 @@
+package kv
+
+import (
+	"errors"
+	"path"
+	"strings"
+)
+
+var (
+	// ErrOutsideScope means a path resolved to somewhere outside the caller's subtree.
+	ErrOutsideScope = errors.New("kv: path is outside the caller's subtree")
+	// ErrTraversal means a path contained a ".." segment.
+	ErrTraversal = errors.New("kv: '..' is not allowed in paths")
+)
+
+// Resolve maps target onto a namespace inside root's subtree.
+// A relative target is taken relative to root; an absolute target must be
+// root itself or one of its descendants. ".." segments are always rejected,
+// even when they would resolve back inside the subtree, so a path means the
+// same thing wherever it is used.
+func Resolve(root, target string) (string, error) {
+	for _, seg := range strings.Split(target, "/") {
+		if seg == ".." {
+			return "", ErrTraversal
+		}
+	}
+	var resolved string
+	if strings.HasPrefix(target, "/") {
+		resolved = path.Clean(target)
+	} else {
+		resolved = path.Join("/", root, target)
+	}
+	base := path.Clean("/" + root)
+	if resolved == base {
+		// Keep root exactly as given so the caller's own namespace is unchanged.
+		return root, nil
+	}
+	if base != "/" && !strings.HasPrefix(resolved, base+"/") {
+		return "", ErrOutsideScope
+	}
+	return resolved, nil
+}
@@ -0,0 +1,47 @@
+package kv
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestResolve verifies the subtree isolation contract: code at a path can
+// reach that path and its descendants, and nothing else
+func TestResolve(t *testing.T) {
+	tt := []struct {
+		name   string
+		root   string
+		target string
+		want   string
+		err    error
+	}{
+		{name: "relative child", root: "/app", target: "admin", want: "/app/admin"},
+		{name: "relative grandchild", root: "/app", target: "admin/users", want: "/app/admin/users"},
+		{name: "relative with dot", root: "/app", target: "./admin", want: "/app/admin"},
+		{name: "duplicate slashes", root: "/app", target: "admin//users/", want: "/app/admin/users"},
+		{name: "empty is self", root: "/app", target: "", want: "/app"},
+		{name: "dot is self", root: "/app/", target: ".", want: "/app/"},
+		{name: "absolute child", root: "/app", target: "/app/admin", want: "/app/admin"},
+		{name: "absolute self", root: "/app", target: "/app", want: "/app"},
+		{name: "absolute sibling", root: "/app", target: "/other", err: ErrOutsideScope},
+		{name: "absolute prefix sibling", root: "/app", target: "/application", err: ErrOutsideScope},
+		{name: "absolute parent", root: "/app/admin", target: "/app", err: ErrOutsideScope},
+		{name: "absolute other branch", root: "/users/admin", target: "/users/regular/x", err: ErrOutsideScope},
+		{name: "traversal up", root: "/app", target: "../other", err: ErrTraversal},
+		{name: "traversal back inside", root: "/app", target: "admin/../admin", err: ErrTraversal},
+		{name: "absolute traversal", root: "/app", target: "/app/../other", err: ErrTraversal},
+		{name: "root reaches everything", root: "/", target: "/anything/at/all", want: "/anything/at/all"},
+		{name: "root relative", root: "/", target: "x", want: "/x"},
+	}
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := Resolve(test.root, test.target)
+			assert.ErrorIs(t, err, test.err)
+			if test.err == nil {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
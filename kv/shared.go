@@ -0,0 +1,125 @@
+package kv
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Shared namespaces hold data that many paths may use, such as feature flags.
+// Their data and their grants live in the same KV as per-path namespaces,
+// under names that never start with "/" so they cannot collide with a path
+// or be reached through Resolve.
+const (
+	sharedNamespacePrefix = "_shared/"
+	grantsNamespace       = "_grants"
+)
+
+var (
+	// ErrInvalidSharedName means a shared namespace name is not made of [A-Za-z0-9._-] or starts with a dot.
+	ErrInvalidSharedName = errors.New("kv: shared namespace names may only contain letters, digits, '.', '_' and '-'")
+	// ErrInvalidGrant means a grant prefix is not an absolute path.
+	ErrInvalidGrant = errors.New("kv: grant prefixes must be absolute paths without '..'")
+)
+
+var sharedNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]*$`)
+
+// Grants lists which path subtrees may use a shared namespace.
+// A path may read if it is inside any Read or Write prefix, and write if it
+// is inside any Write prefix. Prefixes follow the same subtree rule as
+// Resolve: "/app" covers /app and /app/*, but not /application.
+type Grants struct {
+	Read  []string `json:"read"`
+	Write []string `json:"write"`
+}
+
+// CanRead reports whether code at path may read the namespace.
+func (g Grants) CanRead(path string) bool {
+	return coveredBy(path, g.Read) || g.CanWrite(path)
+}
+
+// CanWrite reports whether code at path may write the namespace.
+func (g Grants) CanWrite(path string) bool {
+	return coveredBy(path, g.Write)
+}
+
+func coveredBy(path string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if _, err := Resolve(p, path); err == nil && strings.HasPrefix(path, "/") {
+			return true
+		}
+	}
+	return false
+}
+
+// SharedNamespace returns the namespace holding the data of shared namespace name.
+func SharedNamespace(name string) (string, error) {
+	if !sharedNamePattern.MatchString(name) {
+		return "", ErrInvalidSharedName
+	}
+	return sharedNamespacePrefix + name, nil
+}
+
+// GetGrants returns the grants of shared namespace name.
+// ok is false if the namespace has never been granted to anyone.
+func GetGrants(ctx context.Context, store KV, name string) (g Grants, ok bool, err error) {
+	if !sharedNamePattern.MatchString(name) {
+		return Grants{}, false, ErrInvalidSharedName
+	}
+	v, err := store.Get(ctx, grantsNamespace, name)
+	if err != nil {
+		return Grants{}, false, fmt.Errorf("kv: reading grants of %q: %w", name, err)
+	}
+	if v == nil {
+		return Grants{}, false, nil
+	}
+	if err := json.Unmarshal(v, &g); err != nil {
+		return Grants{}, false, fmt.Errorf("kv: decoding grants of %q: %w", name, err)
+	}
+	return g, true, nil
+}
+
+// PutGrants replaces the grants of shared namespace name.
+func PutGrants(ctx context.Context, store KV, name string, g Grants) error {
+	if !sharedNamePattern.MatchString(name) {
+		return ErrInvalidSharedName
+	}
+	for _, p := range append(append([]string{}, g.Read...), g.Write...) {
+		if _, err := Resolve("/", p); err != nil || !strings.HasPrefix(p, "/") {
+			return fmt.Errorf("%w: %q", ErrInvalidGrant, p)
+		}
+	}
+	v, err := json.Marshal(g)
+	if err != nil {
+		return fmt.Errorf("kv: encoding grants of %q: %w", name, err)
+	}
+	return store.Put(ctx, grantsNamespace, name, v)
+}
+
+// DeleteGrants revokes every grant on shared namespace name. Its data is kept.
+func DeleteGrants(ctx context.Context, store KV, name string) error {
+	if !sharedNamePattern.MatchString(name) {
+		return ErrInvalidSharedName
+	}
+	return store.Delete(ctx, grantsNamespace, name)
+}
+
+// ListShared returns the names of every shared namespace that has grants.
+func ListShared(ctx context.Context, store KV) ([]string, error) {
+	var names []string
+	opts := ListOptions{}
+	for {
+		res, err := store.List(ctx, grantsNamespace, opts)
+		if err != nil {
+			return nil, fmt.Errorf("kv: listing shared namespaces: %w", err)
+		}
+		names = append(names, res.Keys...)
+		if res.Cursor == "" {
+			return names, nil
+		}
+		opts.Cursor = res.Cursor
+	}
+}
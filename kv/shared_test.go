@@ -0,0 +1,74 @@
+package kv
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestGrants verifies that grants cover whole subtrees and nothing else
+func TestGrants(t *testing.T) {
+	g := Grants{Read: []string{"/app"}, Write: []string{"/admin"}}
+	tt := []struct {
+		path  string
+		read  bool
+		write bool
+	}{
+		{path: "/app", read: true},
+		{path: "/app/page", read: true},
+		{path: "/application"},
+		{path: "/admin", read: true, write: true},
+		{path: "/admin/tools", read: true, write: true},
+		{path: "/"},
+		{path: "/other"},
+	}
+	for _, test := range tt {
+		t.Run(test.path, func(t *testing.T) {
+			assert.Equal(t, test.read, g.CanRead(test.path))
+			assert.Equal(t, test.write, g.CanWrite(test.path))
+		})
+	}
+	everyone := Grants{Read: []string{"/"}}
+	assert.True(t, everyone.CanRead("/anything"))
+	assert.False(t, everyone.CanWrite("/anything"))
+}
+
+// TestSharedGrantsStorage verifies grants round trip through the KV store
+func TestSharedGrantsStorage(t *testing.T) {
+	ctx := context.Background()
+	store := newTestBbolt(t)
+
+	_, ok, err := GetGrants(ctx, store, "flags")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	g := Grants{Read: []string{"/"}, Write: []string{"/admin"}}
+	assert.NoError(t, PutGrants(ctx, store, "flags", g))
+	assert.NoError(t, PutGrants(ctx, store, "users", Grants{Read: []string{"/app"}}))
+	got, ok, err := GetGrants(ctx, store, "flags")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, g, got)
+
+	names, err := ListShared(ctx, store)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"flags", "users"}, names)
+
+	assert.NoError(t, DeleteGrants(ctx, store, "flags"))
+	_, ok, _ = GetGrants(ctx, store, "flags")
+	assert.False(t, ok)
+
+	assert.ErrorIs(t, PutGrants(ctx, store, "../x", g), ErrInvalidSharedName)
+	assert.ErrorIs(t, PutGrants(ctx, store, "..", g), ErrInvalidSharedName)
+	assert.ErrorIs(t, PutGrants(ctx, store, "x", Grants{Read: []string{"app"}}), ErrInvalidGrant)
+	assert.ErrorIs(t, PutGrants(ctx, store, "x", Grants{Write: []string{"/app/../x"}}), ErrInvalidGrant)
+	_, err = SharedNamespace("a/b")
+	assert.ErrorIs(t, err, ErrInvalidSharedName)
+	ns, err := SharedNamespace("flags")
+	assert.NoError(t, err)
+	_, err = Resolve("/", ns)
+	assert.NoError(t, err)
+	resolved, _ := Resolve("/", ns)
+	assert.NotEqual(t, ns, resolved, "a path can never name a shared namespace")
+}
@@ -0,0 +1,371 @@
+package kv
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	_ "modernc.org/sqlite" // pure Go, so it links alongside v8go without more cgo
+)
+
+// sqliteSchema creates one table of values keyed by (path, key), plus the
+// index definitions and entries that bbolt keeps in its index buckets.
+// expires_at is unix nanoseconds; 0 means the value never expires.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS kv (
+	path       TEXT    NOT NULL,
+	key        TEXT    NOT NULL,
+	value      BLOB    NOT NULL,
+	expires_at INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (path, key)
+) WITHOUT ROWID;
+CREATE INDEX IF NOT EXISTS kv_expires_at ON kv (expires_at) WHERE expires_at != 0;
+CREATE TABLE IF NOT EXISTS kv_index_defs (
+	path  TEXT NOT NULL,
+	name  TEXT NOT NULL,
+	field TEXT NOT NULL,
+	PRIMARY KEY (path, name)
+) WITHOUT ROWID;
+CREATE TABLE IF NOT EXISTS kv_index_entries (
+	path  TEXT NOT NULL,
+	name  TEXT NOT NULL,
+	entry BLOB NOT NULL,
+	key   TEXT NOT NULL,
+	PRIMARY KEY (path, name, entry)
+) WITHOUT ROWID;
+`
+
+// sqliteLive is the condition for a row that has not expired at the time bound to ?.
+const sqliteLive = `(expires_at = 0 OR expires_at > ?)`
+
+// SQLiteKV implements KV using SQLite in WAL mode.
+// Every path shares one table, keyed by (path, key).
+type SQLiteKV struct {
+	db *sql.DB
+	// writeMu serializes writers within this process, so a transaction that
+	// reads before it writes never loses its snapshot to another writer.
+	// busy_timeout covers writers in other processes.
+	writeMu sync.Mutex
+}
+
+// NewSQLite opens (or creates) a SQLite database at the given file path and
+// returns a SQLiteKV ready for use.
+func NewSQLite(file string) (*SQLiteKV, error) {
+	dsn := "file:" + file + "?" + url.Values{
+		"_pragma": {"journal_mode(WAL)", "busy_timeout(5000)", "synchronous(NORMAL)"},
+	}.Encode()
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("kv: opening sqlite db: %w", err)
+	}
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("kv: creating sqlite schema: %w", err)
+	}
+	return &SQLiteKV{db: db}, nil
+}
+
+func (s *SQLiteKV) Close() error {
+	return s.db.Close()
+}
+
+// write runs fn in one read-write transaction.
+func (s *SQLiteKV) write(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("kv: begin: %w", err)
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("kv: commit: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteKV) Get(ctx context.Context, path, key string) ([]byte, error) {
+	val, _, err := sqliteRead(ctx, s.db, path, key, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("kv: get: %w", err)
+	}
+	return val, nil
+}
+
+func (s *SQLiteKV) Put(ctx context.Context, path, key string, value []byte) error {
+	return s.PutExpiring(ctx, path, key, value, time.Time{})
+}
+
+func (s *SQLiteKV) PutExpiring(ctx context.Context, path, key string, value []byte, expiresAt time.Time) error {
+	return s.write(ctx, func(tx *sql.Tx) error {
+		ix, err := loadSQLiteIndexes(ctx, tx, path)
+		if err != nil {
+			return err
+		}
+		return ix.put(ctx, tx, path, key, value, expiresAt)
+	})
+}
+
+func (s *SQLiteKV) Delete(ctx context.Context, path, key string) error {
+	return s.write(ctx, func(tx *sql.Tx) error {
+		ix, err := loadSQLiteIndexes(ctx, tx, path)
+		if err != nil {
+			return err
+		}
+		return ix.delete(ctx, tx, path, key)
+	})
+}
+
+func (s *SQLiteKV) GetMany(ctx context.Context, path string, keys []string) ([][]byte, error) {
+	vals := make([][]byte, len(keys))
+	now := time.Now()
+	// A read transaction gives every Get the same snapshot.
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("kv: get many: %w", err)
+	}
+	defer tx.Rollback()
+	for i, key := range keys {
+		if vals[i], _, err = sqliteRead(ctx, tx, path, key, now); err != nil {
+			return nil, fmt.Errorf("kv: get many: %w", err)
+		}
+	}
+	return vals, nil
+}
+
+func (s *SQLiteKV) PutMany(ctx context.Context, path string, entries []Entry) error {
+	return s.write(ctx, func(tx *sql.Tx) error {
+		ix, err := loadSQLiteIndexes(ctx, tx, path)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if err := ix.put(ctx, tx, path, e.Key, e.Value, e.ExpiresAt); err != nil {
+				return fmt.Errorf("kv: put many: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+func (s *SQLiteKV) DeleteMany(ctx context.Context, path string, keys []string) error {
+	return s.write(ctx, func(tx *sql.Tx) error {
+		ix, err := loadSQLiteIndexes(ctx, tx, path)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if err := ix.delete(ctx, tx, path, key); err != nil {
+				return fmt.Errorf("kv: delete many: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+func (s *SQLiteKV) List(ctx context.Context, path string, opts ListOptions) (ListResult, error) {
+	query := `SELECT key, value FROM kv WHERE path = ? AND key >= ? AND ` + sqliteLive
+	args := []interface{}{path, max(opts.Cursor, opts.Prefix), time.Now().UnixNano()}
+	if end, ok := prefixEnd(opts.Prefix); ok {
+		query += ` AND key < ?`
+		args = append(args, end)
+	}
+	query += ` ORDER BY key`
+	if opts.Limit > 0 {
+		// One more than asked for tells us whether there is another page.
+		query += ` LIMIT ?`
+		args = append(args, opts.Limit+1)
+	}
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return ListResult{}, fmt.Errorf("kv: list: %w", err)
+	}
+	defer rows.Close()
+
+	var result ListResult
+	for rows.Next() {
+		var key string
+		var raw []byte
+		if err := rows.Scan(&key, &raw); err != nil {
+			return ListResult{}, fmt.Errorf("kv: list: %w", err)
+		}
+		if opts.Limit > 0 && len(result.Keys) >= opts.Limit {
+			result.Cursor = key
+			break
+		}
+		result.Keys = append(result.Keys, key)
+		if opts.IncludeValues {
+			result.Values = append(result.Values, raw)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return ListResult{}, fmt.Errorf("kv: list: %w", err)
+	}
+	return result, nil
+}
+
+// prefixEnd returns the smallest string greater than every string with the
+// given prefix, or false if there is none.
+func prefixEnd(prefix string) (string, bool) {
+	end := []byte(prefix)
+	for len(end) > 0 {
+		if end[len(end)-1] < 0xff {
+			end[len(end)-1]++
+			return string(end), true
+		}
+		end = end[:len(end)-1]
+	}
+	return "", false
+}
+
+// Update runs fn inside one SQLite transaction. Writers are serialized, so
+// concurrent Updates never interleave.
+func (s *SQLiteKV) Update(ctx context.Context, path string, fn func(tx Tx) error) error {
+	return s.write(ctx, func(tx *sql.Tx) error {
+		ix, err := loadSQLiteIndexes(ctx, tx, path)
+		if err != nil {
+			return err
+		}
+		return fn(&sqliteTx{ctx: ctx, tx: tx, path: path, indexes: ix, now: time.Now()})
+	})
+}
+
+// sqliteTx implements Tx over one path inside an open SQLite transaction.
+type sqliteTx struct {
+	ctx     context.Context
+	tx      *sql.Tx
+	path    string
+	indexes sqliteIndexes
+	now     time.Time
+}
+
+func (t *sqliteTx) Get(key string) ([]byte, error) {
+	val, _, err := sqliteRead(t.ctx, t.tx, t.path, key, t.now)
+	return val, err
+}
+
+func (t *sqliteTx) ExpiresAt(key string) (time.Time, error) {
+	_, expiresAt, err := sqliteRead(t.ctx, t.tx, t.path, key, t.now)
+	return expiresAt, err
+}
+
+func (t *sqliteTx) Put(key string, value []byte) error {
+	return t.PutExpiring(key, value, time.Time{})
+}
+
+func (t *sqliteTx) PutExpiring(key string, value []byte, expiresAt time.Time) error {
+	return t.indexes.put(t.ctx, t.tx, t.path, key, value, expiresAt)
+}
+
+func (t *sqliteTx) Delete(key string) error {
+	return t.indexes.delete(t.ctx, t.tx, t.path, key)
+}
+
+func (s *SQLiteKV) Paths(ctx context.Context) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT DISTINCT path FROM kv WHERE `+sqliteLive+` ORDER BY path`, time.Now().UnixNano())
+	if err != nil {
+		return nil, fmt.Errorf("kv: paths: %w", err)
+	}
+	defer rows.Close()
+	var paths []string
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return nil, fmt.Errorf("kv: paths: %w", err)
+		}
+		paths = append(paths, path)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("kv: paths: %w", err)
+	}
+	return paths, nil
+}
+
+// PurgeExpired deletes every expired row, and its index entries, in one transaction.
+func (s *SQLiteKV) PurgeExpired(ctx context.Context, now time.Time) (int, error) {
+	var purged int
+	err := s.write(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, `SELECT path, key FROM kv WHERE expires_at != 0 AND expires_at <= ?`, now.UnixNano())
+		if err != nil {
+			return err
+		}
+		dead := map[string][]string{}
+		for rows.Next() {
+			var path, key string
+			if err := rows.Scan(&path, &key); err != nil {
+				rows.Close()
+				return err
+			}
+			dead[path] = append(dead[path], key)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for path, keys := range dead {
+			ix, err := loadSQLiteIndexes(ctx, tx, path)
+			if err != nil {
+				return err
+			}
+			for _, key := range keys {
+				if err := ix.delete(ctx, tx, path, key); err != nil {
+					return err
+				}
+			}
+			purged += len(keys)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("kv: purge expired: %w", err)
+	}
+	return purged, nil
+}
+
+// sqlQuerier is what reads need from either *sql.DB or *sql.Tx.
+type sqlQuerier interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// sqliteRead returns the value at key and its expiry, treating a value that
+// expired before now as missing.
+func sqliteRead(ctx context.Context, q sqlQuerier, path, key string, now time.Time) ([]byte, time.Time, error) {
+	var val []byte
+	var expiresAt int64
+	err := q.QueryRowContext(ctx, `SELECT value, expires_at FROM kv WHERE path = ? AND key = ? AND `+sqliteLive,
+		path, key, now.UnixNano()).Scan(&val, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, time.Time{}, nil
+	}
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if val == nil {
+		val = []byte{}
+	}
+	return val, unixNanoTime(expiresAt), nil
+}
+
+// expiresAtNano stores expiresAt as unix nanoseconds, where 0 means never.
+func expiresAtNano(expiresAt time.Time) int64 {
+	if expiresAt.IsZero() {
+		return 0
+	}
+	// 0 means "never", so anything at or before the epoch is stored as 1ns after it.
+	return max(expiresAt.UnixNano(), 1)
+}
+
+// unixNanoTime reverses expiresAtNano.
+func unixNanoTime(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
@@ -0,0 +1,238 @@
+package kv
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// sqliteIndex is one index of a path.
+type sqliteIndex struct {
+	name  string
+	field []string
+}
+
+// sqliteIndexes are every index of one path. Writes to the path go through
+// put and delete so the indexes change in the same transaction as the data.
+type sqliteIndexes []sqliteIndex
+
+// loadSQLiteIndexes reads the index definitions of path inside tx.
+func loadSQLiteIndexes(ctx context.Context, tx *sql.Tx, path string) (sqliteIndexes, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT name, field FROM kv_index_defs WHERE path = ?`, path)
+	if err != nil {
+		return nil, fmt.Errorf("kv: loading indexes of %q: %w", path, err)
+	}
+	defer rows.Close()
+	var ix sqliteIndexes
+	for rows.Next() {
+		var name, field string
+		if err := rows.Scan(&name, &field); err != nil {
+			return nil, fmt.Errorf("kv: loading indexes of %q: %w", path, err)
+		}
+		segs, err := parseField(field)
+		if err != nil {
+			return nil, fmt.Errorf("kv: index %q of %q: %w", name, path, err)
+		}
+		ix = append(ix, sqliteIndex{name: name, field: segs})
+	}
+	return ix, rows.Err()
+}
+
+// put stores value at key and updates every index.
+func (ix sqliteIndexes) put(ctx context.Context, tx *sql.Tx, path, key string, value []byte, expiresAt time.Time) error {
+	if err := ix.unindex(ctx, tx, path, key); err != nil {
+		return err
+	}
+	if value == nil {
+		value = []byte{}
+	}
+	_, err := tx.ExecContext(ctx, `INSERT INTO kv (path, key, value, expires_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (path, key) DO UPDATE SET value = excluded.value, expires_at = excluded.expires_at`,
+		path, key, value, expiresAtNano(expiresAt))
+	if err != nil {
+		return err
+	}
+	for _, i := range ix {
+		if e, ok := indexEntry(value, i.field, key); ok {
+			if _, err := tx.ExecContext(ctx, `INSERT INTO kv_index_entries (path, name, entry, key) VALUES (?, ?, ?, ?)`,
+				path, i.name, e, key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// delete removes key and its index entries.
+func (ix sqliteIndexes) delete(ctx context.Context, tx *sql.Tx, path, key string) error {
+	if err := ix.unindex(ctx, tx, path, key); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, `DELETE FROM kv WHERE path = ? AND key = ?`, path, key)
+	return err
+}
+
+// unindex removes the index entries of the value currently stored at key.
+func (ix sqliteIndexes) unindex(ctx context.Context, tx *sql.Tx, path, key string) error {
+	if len(ix) == 0 {
+		return nil
+	}
+	var old []byte
+	err := tx.QueryRowContext(ctx, `SELECT value FROM kv WHERE path = ? AND key = ?`, path, key).Scan(&old)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, i := range ix {
+		if e, ok := indexEntry(old, i.field, key); ok {
+			if _, err := tx.ExecContext(ctx, `DELETE FROM kv_index_entries WHERE path = ? AND name = ? AND entry = ?`,
+				path, i.name, e); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// CreateIndex indexes path by field, indexing the values already stored.
+func (s *SQLiteKV) CreateIndex(ctx context.Context, path, name, field string) error {
+	segs, err := validIndex(name, field)
+	if err != nil {
+		return err
+	}
+	err = s.write(ctx, func(tx *sql.Tx) error {
+		var current string
+		err := tx.QueryRowContext(ctx, `SELECT field FROM kv_index_defs WHERE path = ? AND name = ?`, path, name).Scan(&current)
+		if err == nil && current == field {
+			return nil
+		}
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO kv_index_defs (path, name, field) VALUES (?, ?, ?)
+			ON CONFLICT (path, name) DO UPDATE SET field = excluded.field`, path, name, field); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM kv_index_entries WHERE path = ? AND name = ?`, path, name); err != nil {
+			return err
+		}
+
+		rows, err := tx.QueryContext(ctx, `SELECT key, value FROM kv WHERE path = ?`, path)
+		if err != nil {
+			return err
+		}
+		type entry struct {
+			e   []byte
+			key string
+		}
+		var entries []entry
+		for rows.Next() {
+			var key string
+			var val []byte
+			if err := rows.Scan(&key, &val); err != nil {
+				rows.Close()
+				return err
+			}
+			if e, ok := indexEntry(val, segs, key); ok {
+				entries = append(entries, entry{e: e, key: key})
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if _, err := tx.ExecContext(ctx, `INSERT INTO kv_index_entries (path, name, entry, key) VALUES (?, ?, ?, ?)`,
+				path, name, e.e, e.key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("kv: create index: %w", err)
+	}
+	return nil
+}
+
+// DropIndex removes the named index of path.
+func (s *SQLiteKV) DropIndex(ctx context.Context, path, name string) error {
+	err := s.write(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM kv_index_defs WHERE path = ? AND name = ?`, path, name); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, `DELETE FROM kv_index_entries WHERE path = ? AND name = ?`, path, name)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("kv: drop index: %w", err)
+	}
+	return nil
+}
+
+// Query walks the named index of path and returns the live keys in range.
+func (s *SQLiteKV) Query(ctx context.Context, path, name string, q Query) (ListResult, error) {
+	sc, err := q.scan()
+	if err != nil {
+		return ListResult{}, err
+	}
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return ListResult{}, fmt.Errorf("kv: query: %w", err)
+	}
+	defer tx.Rollback()
+
+	var exists int
+	err = tx.QueryRowContext(ctx, `SELECT 1 FROM kv_index_defs WHERE path = ? AND name = ?`, path, name).Scan(&exists)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ListResult{}, fmt.Errorf("kv: query: %w: %q", ErrNoIndex, name)
+	}
+	if err != nil {
+		return ListResult{}, fmt.Errorf("kv: query: %w", err)
+	}
+
+	seek := sc.seek
+	if seek == nil {
+		seek = []byte{}
+	}
+	rows, err := tx.QueryContext(ctx, `SELECT e.entry, e.key, kv.value FROM kv_index_entries e
+		JOIN kv ON kv.path = e.path AND kv.key = e.key
+		WHERE e.path = ? AND e.name = ? AND e.entry >= ? AND `+sqliteLive+`
+		ORDER BY e.entry`, path, name, seek, time.Now().UnixNano())
+	if err != nil {
+		return ListResult{}, fmt.Errorf("kv: query: %w", err)
+	}
+	defer rows.Close()
+
+	var result ListResult
+	for rows.Next() {
+		var e, val []byte
+		var key string
+		if err := rows.Scan(&e, &key, &val); err != nil {
+			return ListResult{}, fmt.Errorf("kv: query: %w", err)
+		}
+		ok, stop := sc.match(e)
+		if stop {
+			break
+		}
+		if !ok {
+			continue
+		}
+		if q.Limit > 0 && len(result.Keys) >= q.Limit {
+			result.Cursor = indexCursor(e)
+			break
+		}
+		result.Keys = append(result.Keys, key)
+		if q.IncludeValues {
+			result.Values = append(result.Values, val)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return ListResult{}, fmt.Errorf("kv: query: %w", err)
+	}
+	return result, nil
+}
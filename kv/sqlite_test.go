@@ -0,0 +1,33 @@
+package kv
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestSQLiteReopen verifies data and indexes survive closing the database, and that it runs in WAL mode
+func TestSQLiteReopen(t *testing.T) {
+	ctx := context.Background()
+	file := filepath.Join(t.TempDir(), "kv.sqlite")
+	store, err := NewSQLite(file)
+	assert.NoError(t, err)
+	var mode string
+	assert.NoError(t, store.db.QueryRow(`PRAGMA journal_mode`).Scan(&mode))
+	assert.Equal(t, "wal", mode)
+	assert.NoError(t, store.CreateIndex(ctx, "/a", "byAge", "$.age"))
+	assert.NoError(t, store.Put(ctx, "/a", "ann", []byte(`{"age":31}`)))
+	assert.NoError(t, store.Close())
+
+	store, err = NewSQLite(file)
+	assert.NoError(t, err)
+	defer store.Close()
+	v, err := store.Get(ctx, "/a", "ann")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte(`{"age":31}`), v)
+	res, err := store.Query(ctx, "/a", "byAge", Query{Eq: []byte(`31`)})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"ann"}, res.Keys)
+}
@@ -0,0 +1,260 @@
+package kv
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Operations an Event can report.
+const (
+	EventPut    = "put"
+	EventDelete = "delete"
+)
+
+// watchBuffer is how many events a watcher may fall behind before it is dropped.
+const watchBuffer = 256
+
+// Event is one committed change to a key.
+type Event struct {
+	Path  string
+	Key   string
+	Op    string // EventPut or EventDelete
+	Value []byte // the new value; nil for deletes
+}
+
+// MarshalJSON encodes e as {"path", "type", "key", "value"}. The value is
+// encoded by JSONValue, so a delete's value is null.
+func (e Event) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Path  string          `json:"path"`
+		Type  string          `json:"type"`
+		Key   string          `json:"key"`
+		Value json.RawMessage `json:"value"`
+	}{e.Path, e.Op, e.Key, JSONValue(e.Value)})
+}
+
+// JSONValue returns value as it is shown outside JS: as itself when it is
+// JSON, as a string when it is not, and as null when it is nil.
+func JSONValue(value []byte) json.RawMessage {
+	if value == nil {
+		return json.RawMessage("null")
+	}
+	if !json.Valid(value) {
+		s, _ := json.Marshal(string(value))
+		return s
+	}
+	return value
+}
+
+// Watcher is implemented by stores that report changes as they happen.
+type Watcher interface {
+	// Watch sends an Event for every change to a key in path starting with
+	// prefix, until ctx is done, and then closes the channel. A watcher that
+	// falls too far behind is dropped, and its channel is closed early.
+	Watch(ctx context.Context, path, prefix string) <-chan Event
+}
+
+// Notifier wraps a KV and publishes an Event to its watchers after each
+// write through it commits. Only writes made through this Notifier are
+// seen: other processes sharing the backend, and keys that expire or are
+// purged, produce no events.
+type Notifier struct {
+	KV
+
+	mu       sync.Mutex
+	watchers map[*watcher]struct{}
+	closed   bool
+}
+
+type watcher struct {
+	path    string
+	prefix  string
+	events  chan Event
+	dropped chan struct{}
+}
+
+// NewNotifier wraps store so its changes can be watched.
+func NewNotifier(store KV) *Notifier {
+	return &Notifier{KV: store, watchers: map[*watcher]struct{}{}}
+}
+
+// Unwrap returns the wrapped store.
+func (n *Notifier) Unwrap() KV {
+	return n.KV
+}
+
+func (n *Notifier) Watch(ctx context.Context, path, prefix string) <-chan Event {
+	w := &watcher{path: path, prefix: prefix, events: make(chan Event, watchBuffer), dropped: make(chan struct{})}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed {
+		close(w.events)
+		return w.events
+	}
+	n.watchers[w] = struct{}{}
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-w.dropped:
+			return
+		}
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		n.drop(w)
+	}()
+	return w.events
+}
+
+// drop closes w's channel if it is still watching. The caller must hold n.mu.
+func (n *Notifier) drop(w *watcher) {
+	if _, ok := n.watchers[w]; ok {
+		delete(n.watchers, w)
+		close(w.events)
+		close(w.dropped)
+	}
+}
+
+// publish hands events to every watcher that wants them, without blocking.
+func (n *Notifier) publish(events []Event) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for w := range n.watchers {
+		for _, e := range events {
+			if e.Path != w.path || !strings.HasPrefix(e.Key, w.prefix) {
+				continue
+			}
+			select {
+			case w.events <- e:
+			default:
+				n.drop(w)
+			}
+			if _, ok := n.watchers[w]; !ok {
+				break
+			}
+		}
+	}
+}
+
+// entryEvent describes a write of value at key. A value that is already
+// expired reads as missing, so it is reported as a delete.
+func entryEvent(path, key string, value []byte, expiresAt, now time.Time) Event {
+	if expired(expiresAt, now) {
+		return Event{Path: path, Key: key, Op: EventDelete}
+	}
+	return Event{Path: path, Key: key, Op: EventPut, Value: append([]byte{}, value...)}
+}
+
+func (n *Notifier) Put(ctx context.Context, path, key string, value []byte) error {
+	return n.PutExpiring(ctx, path, key, value, time.Time{})
+}
+
+func (n *Notifier) PutExpiring(ctx context.Context, path, key string, value []byte, expiresAt time.Time) error {
+	if err := n.KV.PutExpiring(ctx, path, key, value, expiresAt); err != nil {
+		return err
+	}
+	n.publish([]Event{entryEvent(path, key, value, expiresAt, time.Now())})
+	return nil
+}
+
+func (n *Notifier) Delete(ctx context.Context, path, key string) error {
+	if err := n.KV.Delete(ctx, path, key); err != nil {
+		return err
+	}
+	n.publish([]Event{{Path: path, Key: key, Op: EventDelete}})
+	return nil
+}
+
+func (n *Notifier) PutMany(ctx context.Context, path string, entries []Entry) error {
+	if err := n.KV.PutMany(ctx, path, entries); err != nil {
+		return err
+	}
+	now := time.Now()
+	events := make([]Event, len(entries))
+	for i, e := range entries {
+		events[i] = entryEvent(path, e.Key, e.Value, e.ExpiresAt, now)
+	}
+	n.publish(events)
+	return nil
+}
+
+func (n *Notifier) DeleteMany(ctx context.Context, path string, keys []string) error {
+	if err := n.KV.DeleteMany(ctx, path, keys); err != nil {
+		return err
+	}
+	events := make([]Event, len(keys))
+	for i, key := range keys {
+		events[i] = Event{Path: path, Key: key, Op: EventDelete}
+	}
+	n.publish(events)
+	return nil
+}
+
+// Update records the writes fn makes and publishes them once the
+// transaction commits. Backends may run fn more than once; only the last
+// run's writes are published.
+func (n *Notifier) Update(ctx context.Context, path string, fn func(tx Tx) error) error {
+	var rec *recordingTx
+	err := n.KV.Update(ctx, path, func(tx Tx) error {
+		rec = &recordingTx{Tx: tx, path: path, now: time.Now(), index: map[string]int{}}
+		return fn(rec)
+	})
+	if err != nil {
+		return err
+	}
+	if rec != nil && len(rec.events) > 0 {
+		n.publish(rec.events)
+	}
+	return nil
+}
+
+// Close closes every watcher's channel, then the wrapped store.
+func (n *Notifier) Close() error {
+	n.mu.Lock()
+	n.closed = true
+	for w := range n.watchers {
+		n.drop(w)
+	}
+	n.mu.Unlock()
+	return n.KV.Close()
+}
+
+// recordingTx passes writes through to a Tx and remembers the last write to each key.
+type recordingTx struct {
+	Tx
+	path   string
+	now    time.Time
+	events []Event
+	index  map[string]int // key → position in events
+}
+
+func (t *recordingTx) record(e Event) {
+	if i, ok := t.index[e.Key]; ok {
+		t.events[i] = e
+		return
+	}
+	t.index[e.Key] = len(t.events)
+	t.events = append(t.events, e)
+}
+
+func (t *recordingTx) Put(key string, value []byte) error {
+	return t.PutExpiring(key, value, time.Time{})
+}
+
+func (t *recordingTx) PutExpiring(key string, value []byte, expiresAt time.Time) error {
+	if err := t.Tx.PutExpiring(key, value, expiresAt); err != nil {
+		return err
+	}
+	t.record(entryEvent(t.path, key, value, expiresAt, t.now))
+	return nil
+}
+
+func (t *recordingTx) Delete(key string) error {
+	if err := t.Tx.Delete(key); err != nil {
+		return err
+	}
+	t.record(Event{Path: t.path, Key: key, Op: EventDelete})
+	return nil
+}
@@ -0,0 +1,121 @@
+package kv
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// drain collects every event already waiting on ch.
+func drain(ch <-chan Event) []Event {
+	var events []Event
+	for {
+		select {
+		case e, ok := <-ch:
+			if !ok {
+				return events
+			}
+			events = append(events, e)
+		case <-time.After(50 * time.Millisecond):
+			return events
+		}
+	}
+}
+
+// TestNotifierEvents verifies that every kind of write is published once it commits
+func TestNotifierEvents(t *testing.T) {
+	ctx := context.Background()
+	n := NewNotifier(NewMemory())
+	defer n.Close()
+	events := n.Watch(ctx, "/a", "")
+
+	assert.NoError(t, n.Put(ctx, "/a", "k", []byte(`1`)))
+	assert.NoError(t, n.PutExpiring(ctx, "/a", "gone", []byte(`2`), time.Now().Add(-time.Second)))
+	assert.NoError(t, n.PutMany(ctx, "/a", []Entry{{Key: "x", Value: []byte(`3`)}}))
+	assert.NoError(t, n.Delete(ctx, "/a", "k"))
+	assert.NoError(t, n.DeleteMany(ctx, "/a", []string{"x"}))
+	_, err := Increment(ctx, n, "/a", "n", 1, time.Time{})
+	assert.NoError(t, err)
+	assert.NoError(t, n.Update(ctx, "/a", func(tx Tx) error {
+		tx.Put("t", []byte(`1`))
+		return tx.Put("t", []byte(`2`))
+	}))
+	assert.Error(t, n.Update(ctx, "/a", func(tx Tx) error {
+		tx.Put("never", []byte(`1`))
+		return errors.New("boom")
+	}))
+
+	assert.Equal(t, []Event{
+		{Path: "/a", Key: "k", Op: EventPut, Value: []byte(`1`)},
+		{Path: "/a", Key: "gone", Op: EventDelete},
+		{Path: "/a", Key: "x", Op: EventPut, Value: []byte(`3`)},
+		{Path: "/a", Key: "k", Op: EventDelete},
+		{Path: "/a", Key: "x", Op: EventDelete},
+		{Path: "/a", Key: "n", Op: EventPut, Value: []byte(`1`)},
+		{Path: "/a", Key: "t", Op: EventPut, Value: []byte(`2`)},
+	}, drain(events))
+}
+
+// TestNotifierFilters verifies that watchers only see their path and prefix
+func TestNotifierFilters(t *testing.T) {
+	ctx := context.Background()
+	n := NewNotifier(NewMemory())
+	defer n.Close()
+	users := n.Watch(ctx, "/a", "user:")
+	all := n.Watch(ctx, "/a", "")
+
+	assert.NoError(t, n.Put(ctx, "/a", "user:1", []byte(`1`)))
+	assert.NoError(t, n.Put(ctx, "/a", "post:1", []byte(`1`)))
+	assert.NoError(t, n.Put(ctx, "/a/sub", "user:2", []byte(`1`)))
+	assert.NoError(t, n.Put(ctx, "/b", "user:3", []byte(`1`)))
+
+	assert.Equal(t, []Event{{Path: "/a", Key: "user:1", Op: EventPut, Value: []byte(`1`)}}, drain(users))
+	assert.Len(t, drain(all), 2)
+}
+
+// TestNotifierEnds verifies when a watcher's channel is closed
+func TestNotifierEnds(t *testing.T) {
+	n := NewNotifier(NewMemory())
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelled := n.Watch(ctx, "/a", "")
+	cancel()
+	_, open := <-cancelled
+	assert.False(t, open, "cancelling the context ends the watch")
+
+	slow := n.Watch(context.Background(), "/a", "")
+	for i := 0; i <= watchBuffer; i++ {
+		assert.NoError(t, n.Put(context.Background(), "/a", "k", []byte(`1`)))
+	}
+	assert.Len(t, drain(slow), watchBuffer, "a watcher that falls behind is dropped")
+
+	closing := n.Watch(context.Background(), "/a", "")
+	assert.NoError(t, n.Close())
+	_, open = <-closing
+	assert.False(t, open, "closing the store ends the watch")
+	_, open = <-n.Watch(context.Background(), "/a", "")
+	assert.False(t, open, "a closed store has nothing to watch")
+}
+
+// TestEventJSON verifies how events are encoded for scripts and the admin stream
+func TestEventJSON(t *testing.T) {
+	tt := []struct {
+		name string
+		e    Event
+		want string
+	}{
+		{name: "put", e: Event{Path: "/a", Key: "k", Op: EventPut, Value: []byte(`{"a":1}`)}, want: `{"path":"/a","type":"put","key":"k","value":{"a":1}}`},
+		{name: "delete", e: Event{Path: "/a", Key: "k", Op: EventDelete}, want: `{"path":"/a","type":"delete","key":"k","value":null}`},
+		{name: "not json", e: Event{Path: "/a", Key: "k", Op: EventPut, Value: []byte(`raw`)}, want: `{"path":"/a","type":"put","key":"k","value":"raw"}`},
+	}
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			b, err := json.Marshal(test.e)
+			assert.NoError(t, err)
+			assert.Equal(t, test.want, string(b))
+		})
+	}
+}
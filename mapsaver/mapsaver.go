@@ -3,8 +3,12 @@ package mapsaver
 import (
 	"context"
 	"hput"
+	"iter"
 	"net/url"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 )
 
 type input string
@@ -21,77 +25,214 @@ type runnable struct {
 	bytes []byte
 }
 
-var texts = make(map[string]runnable)
+// version is one saved state of a path.
+type version struct {
+	id    int
+	saved time.Time
+	runnable
+}
 
 // Logger logs out.
 type Logger interface {
 	Debugf(msg string, args ...interface{})
 }
 
+// MapSaver keeps everything in memory. Nothing is written to disk unless a
+// snapshot is taken, and everything else is lost when the process exits.
 type MapSaver struct {
-	Logger Logger
+	Logger    Logger
+	Retention hput.Retention // which earlier versions of each path to keep
+
+	mu       sync.RWMutex
+	texts    map[string]runnable
+	versions map[string][]version // kept versions of each path, oldest first
+}
+
+// New returns an empty MapSaver.
+func New(l Logger) *MapSaver {
+	return &MapSaver{
+		Logger:   l,
+		texts:    map[string]runnable{},
+		versions: map[string][]version{},
+	}
+}
+
+// save stores r at path, reports if it replaced something, and records it
+// as a version.
+func (m *MapSaver) save(path string, r runnable, res *hput.PutResult) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.texts == nil {
+		// A MapSaver made without New works too.
+		m.texts = map[string]runnable{}
+		m.versions = map[string][]version{}
+	}
+	if _, ok := m.texts[path]; ok {
+		m.Logger.Debugf("Found something where saving %s", r.Type)
+		res.Overwrote = true
+	}
+	m.texts[path] = r
+	m.addVersion(path, r)
 }
 
 func (m *MapSaver) SaveText(_ context.Context, s string, p url.URL, r *hput.PutResult) error {
 	m.Logger.Debugf("processing SaveText with string: %s and path: %s", s, p)
-	_, ok := texts[p.Path]
-	if ok {
-		m.Logger.Debugf("Found something where saving text")
-		r.Overwrote = true
-	}
-	texts[p.Path] = runnable{Type: text, val: s}
+	m.save(p.Path, runnable{Type: text, val: s}, r)
 	return nil
 }
 
 func (m *MapSaver) SaveCode(_ context.Context, s string, p url.URL, r *hput.PutResult) error {
 	m.Logger.Debugf("processing SaveCode with string: %s and path: %s", s, p.String())
-	_, ok := texts[p.Path]
-	if ok {
-		m.Logger.Debugf("Found something where saving code")
-		r.Overwrote = true
-	}
-	texts[p.Path] = runnable{Type: js, val: s}
+	m.save(p.Path, runnable{Type: js, val: s}, r)
 	return nil
 }
 
 func (m *MapSaver) SaveBinary(_ context.Context, b []byte, p url.URL, r *hput.PutResult) error {
 	m.Logger.Debugf("processing SaveBinary with length %d and path: %s", len(b), p.String())
-	_, ok := texts[p.Path]
-	if ok {
-		m.Logger.Debugf("Found something where saving binary")
-		r.Overwrote = true
-	}
-	texts[p.Path] = runnable{Type: binary, bytes: b}
+	m.save(p.Path, runnable{Type: binary, bytes: append([]byte{}, b...)}, r)
 	return nil
 }
 
+// Delete removes what is saved at a path, keeping its versions. Deleting a
+// path with nothing saved succeeds.
+func (m *MapSaver) Delete(_ context.Context, p url.URL) error {
+	m.Logger.Debugf("deleting path: %s", p.Path)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.texts, p.Path)
+	return nil
+}
+
+// toRunnable returns a copy of r as an hput.Runnable at path.
+func toRunnable(path string, r runnable) hput.Runnable {
+	ru := hput.Runnable{
+		Path: path,
+		Type: hput.Input(r.Type),
+		Text: r.val,
+	}
+	if r.bytes != nil {
+		ru.Binary = append([]byte{}, r.bytes...)
+	}
+	return ru
+}
+
 func (m *MapSaver) GetRunnable(_ context.Context, p url.URL) (hput.Runnable, error) {
 	m.Logger.Debugf("retrieving text at path %s", p.Path)
-	r, ok := texts[p.Path]
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	r, ok := m.texts[p.Path]
 	if !ok {
 		return hput.Runnable{}, nil
 	}
-	return hput.Runnable{
-		Type:   hput.Input(r.Type),
-		Text:   r.val,
-		Binary: r.bytes,
-	}, nil
-}
-
-func (m *MapSaver) SendRunnables(_ context.Context, p string, runnables chan<- hput.Runnable, done chan<- bool) error {
-	for key, runnable := range texts {
-		if strings.HasPrefix(key, p) {
-			m.Logger.Debugf("Printing key: %s, prefix: %s", key, p)
-			r := hput.Runnable{
-				Path: key,
-				Type: hput.Input(runnable.Type),
-				Text: runnable.val,
+	return toRunnable(p.Path, r), nil
+}
+
+// List yields every path with a prefix of the given one that sorts after
+// cursor, in path order. What to yield is copied out first, so saves are not
+// held up by a slow reader.
+func (m *MapSaver) List(ctx context.Context, prefix, cursor string, limit int) iter.Seq2[hput.Entry, error] {
+	return func(yield func(hput.Entry, error) bool) {
+		m.mu.RLock()
+		var out []hput.Entry
+		for key, r := range m.texts {
+			if strings.HasPrefix(key, prefix) && key > cursor {
+				out = append(out, m.entry(key, r))
+			}
+		}
+		m.mu.RUnlock()
+		sort.Slice(out, func(i, j int) bool { return out[i].Path < out[j].Path })
+		if limit > 0 && len(out) > limit {
+			out = out[:limit]
+		}
+		m.Logger.Debugf("listing %d paths with prefix: %s", len(out), prefix)
+		for _, e := range out {
+			if err := ctx.Err(); err != nil {
+				yield(hput.Entry{}, err)
+				return
+			}
+			if !yield(e, nil) {
+				return
 			}
-			runnables <- r
-		} else {
-			m.Logger.Debugf("Not printing key: %s, prefix: %s", key, p)
 		}
 	}
-	done <- true
-	return nil
+}
+
+// entry describes r at path, as saved with its newest version. The caller
+// must hold the lock.
+func (m *MapSaver) entry(path string, r runnable) hput.Entry {
+	e := hput.Entry{Path: path, Type: hput.Input(r.Type), Size: int64(len(r.val) + len(r.bytes))}
+	if v := m.versions[path]; len(v) > 0 {
+		e.Modified = v[len(v)-1].saved
+	}
+	return e
+}
+
+// addVersion records r as the newest version of path and drops the earlier
+// versions m.Retention no longer keeps. The caller must hold the write lock.
+func (m *MapSaver) addVersion(path string, r runnable) {
+	now := time.Now()
+	id := 1
+	if old := m.versions[path]; len(old) > 0 {
+		id = old[len(old)-1].id + 1
+	}
+	kept := []version{{id: id, saved: now, runnable: r}}
+	old := m.versions[path]
+	for i := len(old) - 1; i >= 0; i-- {
+		if m.Retention.Keep(len(kept)-1, old[i].saved, now) {
+			kept = append(kept, old[i])
+		}
+	}
+	// kept is newest first; store oldest first.
+	for i, j := 0, len(kept)-1; i < j; i, j = i+1, j-1 {
+		kept[i], kept[j] = kept[j], kept[i]
+	}
+	m.versions[path] = kept
+}
+
+func (m *MapSaver) Versions(_ context.Context, path string) ([]hput.Version, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	kept := m.versions[path]
+	out := make([]hput.Version, 0, len(kept))
+	for i := len(kept) - 1; i >= 0; i-- {
+		v := kept[i]
+		out = append(out, hput.Version{
+			ID:    v.id,
+			Saved: v.saved,
+			Type:  hput.Input(v.Type),
+			Size:  len(v.val) + len(v.bytes),
+		})
+	}
+	return out, nil
+}
+
+// Rollback saves version id of a path again, sharing its bytes, and
+// reports false if that version is not kept.
+func (m *MapSaver) Rollback(_ context.Context, path string, id int) (bool, error) {
+	m.mu.RLock()
+	var r runnable
+	found := false
+	for _, v := range m.versions[path] {
+		if v.id == id {
+			r, found = v.runnable, true
+		}
+	}
+	m.mu.RUnlock()
+	if !found {
+		return false, nil
+	}
+	m.Logger.Debugf("rolling back path: %s to version %d", path, id)
+	m.save(path, r, &hput.PutResult{})
+	return true, nil
+}
+
+func (m *MapSaver) GetVersion(_ context.Context, path string, id int) (hput.Runnable, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, v := range m.versions[path] {
+		if v.id == id {
+			return toRunnable(path, v.runnable), nil
+		}
+	}
+	return hput.Runnable{}, nil
 }
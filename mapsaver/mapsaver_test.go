@@ -0,0 +1,180 @@
+package mapsaver
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"hput"
+	"net/url"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type TestLogger struct{}
+
+func (t *TestLogger) Debugf(msg string, args ...interface{}) {}
+
+// collect returns what List yields for prefix p, checking each was given
+// a modified time and then clearing it, so listings can be compared.
+func collect(t *testing.T, m *MapSaver, p string) []hput.Entry {
+	var out []hput.Entry
+	for e, err := range m.List(context.Background(), p, "", 0) {
+		assert.NoError(t, err)
+		assert.False(t, e.Modified.IsZero(), e.Path)
+		e.Modified = time.Time{}
+		out = append(out, e)
+	}
+	return out
+}
+
+// TestList verifies that paths are listed in path order, a page at a time, without their contents
+func TestList(t *testing.T) {
+	ctx := context.Background()
+	m := New(&TestLogger{})
+	assert.NoError(t, m.SaveText(ctx, "b", url.URL{Path: "/b"}, &hput.PutResult{}))
+	assert.NoError(t, m.SaveBinary(ctx, []byte{0, 1}, url.URL{Path: "/a/img"}, &hput.PutResult{}))
+	assert.NoError(t, m.SaveCode(ctx, "1+1", url.URL{Path: "/a/code"}, &hput.PutResult{}))
+
+	tt := []struct {
+		name   string
+		prefix string
+		cursor string
+		limit  int
+		want   []hput.Entry
+	}{
+		{name: "everything", prefix: "/", want: []hput.Entry{
+			{Path: "/a/code", Type: hput.Js, Size: 3},
+			{Path: "/a/img", Type: hput.Binary, Size: 2},
+			{Path: "/b", Type: hput.Text, Size: 1},
+		}},
+		{name: "prefix", prefix: "/a/i", want: []hput.Entry{
+			{Path: "/a/img", Type: hput.Binary, Size: 2},
+		}},
+		{name: "first page", prefix: "/", limit: 2, want: []hput.Entry{
+			{Path: "/a/code", Type: hput.Js, Size: 3},
+			{Path: "/a/img", Type: hput.Binary, Size: 2},
+		}},
+		{name: "next page", prefix: "/", cursor: "/a/img", limit: 2, want: []hput.Entry{
+			{Path: "/b", Type: hput.Text, Size: 1},
+		}},
+		{name: "nothing", prefix: "/z", want: nil},
+	}
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			var got []hput.Entry
+			for e, err := range m.List(ctx, test.prefix, test.cursor, test.limit) {
+				assert.NoError(t, err)
+				assert.False(t, e.Modified.IsZero())
+				e.Modified = time.Time{}
+				got = append(got, e)
+			}
+			assert.Equal(t, test.want, got)
+		})
+	}
+
+	other := New(&TestLogger{})
+	assert.Empty(t, collect(t, other, "/"), "instances do not share paths")
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	var errs []error
+	for _, err := range m.List(cancelled, "/", "", 0) {
+		errs = append(errs, err)
+	}
+	assert.Equal(t, []error{context.Canceled}, errs, "a cancelled listing stops with the context's error")
+}
+
+// TestConcurrentSaves verifies that saves and reads can run at once; run with -race
+func TestConcurrentSaves(t *testing.T) {
+	ctx := context.Background()
+	m := New(&TestLogger{})
+	m.Retention = hput.Retention{Count: 3}
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p := url.URL{Path: fmt.Sprintf("/p%d", i%4)}
+			assert.NoError(t, m.SaveText(ctx, "x", p, &hput.PutResult{}))
+			_, err := m.GetRunnable(ctx, p)
+			assert.NoError(t, err)
+			_, err = m.Versions(ctx, p.Path)
+			assert.NoError(t, err)
+			collect(t, m, "/")
+		}()
+	}
+	wg.Wait()
+	assert.Len(t, collect(t, m, "/"), 4)
+	versions, err := m.Versions(ctx, "/p0")
+	assert.NoError(t, err)
+	assert.Len(t, versions, 4)
+	assert.Equal(t, 5, versions[0].ID)
+}
+
+// TestSnapshot verifies that paths and versions survive a snapshot and restore
+func TestSnapshot(t *testing.T) {
+	ctx := context.Background()
+	m := New(&TestLogger{})
+	m.Retention = hput.Retention{Count: 10}
+	assert.NoError(t, m.SaveText(ctx, "one", url.URL{Path: "/t"}, &hput.PutResult{}))
+	assert.NoError(t, m.SaveText(ctx, "two", url.URL{Path: "/t"}, &hput.PutResult{}))
+	assert.NoError(t, m.SaveBinary(ctx, []byte{0xff, 0}, url.URL{Path: "/bin"}, &hput.PutResult{}))
+	name := filepath.Join(t.TempDir(), "snapshot.json")
+	assert.NoError(t, m.SnapshotFile(name))
+
+	restored := New(&TestLogger{})
+	assert.NoError(t, restored.RestoreFile(name))
+	assert.Equal(t, collect(t, m, "/"), collect(t, restored, "/"))
+	v, err := restored.GetVersion(ctx, "/t", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "one", v.Text)
+	res := hput.PutResult{}
+	assert.NoError(t, restored.SaveText(ctx, "three", url.URL{Path: "/t"}, &res))
+	assert.True(t, res.Overwrote)
+	versions, err := restored.Versions(ctx, "/t")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, versions[0].ID)
+
+	empty := New(&TestLogger{})
+	assert.NoError(t, empty.RestoreFile(filepath.Join(t.TempDir(), "missing.json")))
+	assert.Empty(t, collect(t, empty, "/"))
+	assert.Error(t, empty.Restore(bytes.NewReader([]byte("not json"))))
+}
+
+// TestDelete verifies that deleting a path removes it but keeps its versions
+func TestDelete(t *testing.T) {
+	ctx := context.Background()
+	m := New(&TestLogger{})
+	m.Retention = hput.Retention{Count: 1}
+	p := url.URL{Path: "/gone"}
+	assert.NoError(t, m.SaveText(ctx, "text", p, &hput.PutResult{}))
+	assert.NoError(t, m.Delete(ctx, p))
+	r, err := m.GetRunnable(ctx, p)
+	assert.NoError(t, err)
+	assert.Equal(t, hput.Runnable{}, r)
+	assert.Empty(t, collect(t, m, "/"))
+	versions, err := m.Versions(ctx, "/gone")
+	assert.NoError(t, err)
+	assert.Len(t, versions, 1)
+}
+
+// TestZeroValue verifies that a MapSaver made without New can be saved to
+func TestZeroValue(t *testing.T) {
+	ctx := context.Background()
+	m := &MapSaver{Logger: &TestLogger{}}
+	p := url.URL{Path: "/pth"}
+	r, err := m.GetRunnable(ctx, p)
+	assert.NoError(t, err)
+	assert.Equal(t, hput.Runnable{}, r)
+	assert.NoError(t, m.SaveText(ctx, "text", p, &hput.PutResult{}))
+	r, err = m.GetRunnable(ctx, p)
+	assert.NoError(t, err)
+	assert.Equal(t, "text", r.Text)
+	versions, err := m.Versions(ctx, "/pth")
+	assert.NoError(t, err)
+	assert.Len(t, versions, 1)
+}
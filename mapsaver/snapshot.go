@@ -0,0 +1,125 @@
+package mapsaver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// snapshot is everything a MapSaver holds, as written by Snapshot.
+type snapshot struct {
+	Paths    map[string]snapshotRunnable  `json:"paths"`
+	Versions map[string][]snapshotVersion `json:"versions"` // oldest first
+}
+
+type snapshotRunnable struct {
+	Type   input  `json:"type"`
+	Text   string `json:"text,omitempty"`
+	Binary []byte `json:"binary,omitempty"`
+}
+
+type snapshotVersion struct {
+	ID    int       `json:"id"`
+	Saved time.Time `json:"saved"`
+	snapshotRunnable
+}
+
+func toSnapshot(r runnable) snapshotRunnable {
+	return snapshotRunnable{Type: r.Type, Text: r.val, Binary: r.bytes}
+}
+
+func fromSnapshot(r snapshotRunnable) runnable {
+	return runnable{Type: r.Type, val: r.Text, bytes: r.Binary}
+}
+
+// Snapshot writes every path and kept version to w as JSON.
+func (m *MapSaver) Snapshot(w io.Writer) error {
+	m.mu.RLock()
+	s := snapshot{
+		Paths:    make(map[string]snapshotRunnable, len(m.texts)),
+		Versions: make(map[string][]snapshotVersion, len(m.versions)),
+	}
+	for p, r := range m.texts {
+		s.Paths[p] = toSnapshot(r)
+	}
+	for p, kept := range m.versions {
+		for _, v := range kept {
+			s.Versions[p] = append(s.Versions[p], snapshotVersion{ID: v.id, Saved: v.saved, snapshotRunnable: toSnapshot(v.runnable)})
+		}
+	}
+	// Bytes are never changed once saved, so they can be encoded unlocked.
+	m.mu.RUnlock()
+	return json.NewEncoder(w).Encode(s)
+}
+
+// Restore replaces everything held with a snapshot read from r.
+func (m *MapSaver) Restore(r io.Reader) error {
+	var s snapshot
+	if err := json.NewDecoder(r).Decode(&s); err != nil {
+		return fmt.Errorf("could not read snapshot: %w", err)
+	}
+	texts := make(map[string]runnable, len(s.Paths))
+	for p, r := range s.Paths {
+		texts[p] = fromSnapshot(r)
+	}
+	versions := make(map[string][]version, len(s.Versions))
+	for p, kept := range s.Versions {
+		for _, v := range kept {
+			versions[p] = append(versions[p], version{id: v.ID, saved: v.Saved, runnable: fromSnapshot(v.snapshotRunnable)})
+		}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.texts = texts
+	m.versions = versions
+	return nil
+}
+
+// SnapshotFile writes a snapshot to the named file, replacing it only once
+// the whole snapshot is written.
+func (m *MapSaver) SnapshotFile(name string) error {
+	f, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("could not create snapshot: %w", err)
+	}
+	err = m.Snapshot(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), name)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("could not write snapshot: %w", err)
+	}
+	m.Logger.Debugf("wrote snapshot to %s", name)
+	return nil
+}
+
+// RestoreFile restores a snapshot from the named file. A missing file
+// leaves the MapSaver empty.
+func (m *MapSaver) RestoreFile(name string) error {
+	f, err := os.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		m.Logger.Debugf("no snapshot at %s to restore", name)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not open snapshot: %w", err)
+	}
+	defer f.Close()
+	if err := m.Restore(f); err != nil {
+		return err
+	}
+	m.Logger.Debugf("restored snapshot from %s", name)
+	return nil
+}
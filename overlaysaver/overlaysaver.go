@@ -0,0 +1,232 @@
+// Package overlaysaver serves paths from a writable Saver, falling back to
+// a read-only base, such as a site baked into an image, for paths that were
+// never saved over.
+package overlaysaver
+
+import (
+	"context"
+	"errors"
+	"hput"
+	"io"
+	"iter"
+	"net/url"
+)
+
+// Logger logs out.
+type Logger interface {
+	Debugf(msg string, args ...interface{})
+}
+
+// Saver is the writable backend, which every save goes to.
+type Saver interface {
+	SaveText(ctx context.Context, s string, p url.URL, r *hput.PutResult) error
+	SaveCode(ctx context.Context, s string, p url.URL, r *hput.PutResult) error
+	SaveBinary(ctx context.Context, b []byte, p url.URL, r *hput.PutResult) error
+	GetRunnable(ctx context.Context, p url.URL) (hput.Runnable, error)
+	List(ctx context.Context, prefix, cursor string, limit int) iter.Seq2[hput.Entry, error]
+}
+
+// Base is the read-only backend. Nothing is ever saved to it.
+type Base interface {
+	GetRunnable(ctx context.Context, p url.URL) (hput.Runnable, error)
+	List(ctx context.Context, prefix, cursor string, limit int) iter.Seq2[hput.Entry, error]
+}
+
+// streamSaver is a Saver that can store and serve binaries without holding
+// them in memory.
+type streamSaver interface {
+	SaveStream(ctx context.Context, b io.Reader, p url.URL, r *hput.PutResult) error
+	GetStream(ctx context.Context, p url.URL) (io.ReadSeekCloser, error)
+}
+
+// streamer is a Base that can serve binaries without holding them in memory.
+type streamer interface {
+	GetStream(ctx context.Context, p url.URL) (io.ReadSeekCloser, error)
+}
+
+// versioner is a Saver that keeps versions.
+type versioner interface {
+	Versions(ctx context.Context, path string) ([]hput.Version, error)
+	GetVersion(ctx context.Context, path string, id int) (hput.Runnable, error)
+	Rollback(ctx context.Context, path string, id int) (bool, error)
+}
+
+// deleter is a Saver that can remove what is saved at a path.
+type deleter interface {
+	Delete(ctx context.Context, p url.URL) error
+}
+
+var (
+	// errNoVersions is returned for versions when the writable Saver keeps none.
+	errNoVersions = errors.New("storage does not keep versions")
+	// errNoDelete is returned by Delete when the writable Saver cannot delete.
+	errNoDelete = errors.New("storage cannot delete paths")
+)
+
+// Overlay is a Saver that saves to Upper and reads from it first, serving
+// Base for any path Upper holds nothing at. Versions are Upper's only.
+type Overlay struct {
+	Upper  Saver
+	Base   Base
+	Logger Logger
+}
+
+// New overlays upper on base.
+func New(upper Saver, base Base, l Logger) *Overlay {
+	return &Overlay{Upper: upper, Base: base, Logger: l}
+}
+
+// overwrote sets r.Overwrote if Upper had nothing at p but Base did, since
+// the save hides what was served there.
+func (o *Overlay) overwrote(ctx context.Context, p url.URL, r *hput.PutResult) error {
+	if r.Overwrote {
+		return nil
+	}
+	b, err := o.Base.GetRunnable(ctx, p)
+	if err != nil {
+		return err
+	}
+	r.Overwrote = b.Type != ""
+	return nil
+}
+
+// SaveText saves a text value to a path in Upper
+func (o *Overlay) SaveText(ctx context.Context, s string, p url.URL, r *hput.PutResult) error {
+	if err := o.Upper.SaveText(ctx, s, p, r); err != nil {
+		return err
+	}
+	return o.overwrote(ctx, p, r)
+}
+
+// SaveCode saves code to a path in Upper
+func (o *Overlay) SaveCode(ctx context.Context, s string, p url.URL, r *hput.PutResult) error {
+	if err := o.Upper.SaveCode(ctx, s, p, r); err != nil {
+		return err
+	}
+	return o.overwrote(ctx, p, r)
+}
+
+// SaveBinary saves a binary value to a path in Upper
+func (o *Overlay) SaveBinary(ctx context.Context, b []byte, p url.URL, r *hput.PutResult) error {
+	if err := o.Upper.SaveBinary(ctx, b, p, r); err != nil {
+		return err
+	}
+	return o.overwrote(ctx, p, r)
+}
+
+// SaveStream streams a binary to a path in Upper if it can, otherwise
+// reads the binary and saves it.
+func (o *Overlay) SaveStream(ctx context.Context, b io.Reader, p url.URL, r *hput.PutResult) error {
+	if ss, ok := o.Upper.(streamSaver); ok {
+		if err := ss.SaveStream(ctx, b, p, r); err != nil {
+			return err
+		}
+		return o.overwrote(ctx, p, r)
+	}
+	bts, err := io.ReadAll(b)
+	if err != nil {
+		return err
+	}
+	return o.SaveBinary(ctx, bts, p, r)
+}
+
+// Delete removes what is saved at a path in Upper, so Base's version is
+// served there again.
+func (o *Overlay) Delete(ctx context.Context, p url.URL) error {
+	d, ok := o.Upper.(deleter)
+	if !ok {
+		return errNoDelete
+	}
+	o.Logger.Debugf("resetting %s to its base version", p.Path)
+	return d.Delete(ctx, p)
+}
+
+// GetRunnable returns the runnable at a path in Upper, or in Base if Upper
+// holds nothing there.
+func (o *Overlay) GetRunnable(ctx context.Context, p url.URL) (hput.Runnable, error) {
+	r, err := o.Upper.GetRunnable(ctx, p)
+	if err != nil || r.Type != "" {
+		return r, err
+	}
+	return o.Base.GetRunnable(ctx, p)
+}
+
+// GetStream returns the binary at a path in Upper, or in Base if Upper
+// holds nothing there, or nil if that does not hold a binary. Where a
+// backend cannot stream, it returns nil so the binary is read with
+// GetRunnable.
+func (o *Overlay) GetStream(ctx context.Context, p url.URL) (io.ReadSeekCloser, error) {
+	if ss, ok := o.Upper.(streamSaver); ok {
+		rs, err := ss.GetStream(ctx, p)
+		if err != nil || rs != nil {
+			return rs, err
+		}
+	}
+	r, err := o.Upper.GetRunnable(ctx, p)
+	if err != nil || r.Type != "" {
+		return nil, err
+	}
+	if s, ok := o.Base.(streamer); ok {
+		return s.GetStream(ctx, p)
+	}
+	return nil, nil
+}
+
+// List yields the paths in Upper with a prefix of the given one that sort
+// after cursor, merged in path order with those in Base that Upper does not
+// hide.
+func (o *Overlay) List(ctx context.Context, prefix, cursor string, limit int) iter.Seq2[hput.Entry, error] {
+	return func(yield func(hput.Entry, error) bool) {
+		upper, stopUpper := iter.Pull2(o.Upper.List(ctx, prefix, cursor, limit))
+		defer stopUpper()
+		base, stopBase := iter.Pull2(o.Base.List(ctx, prefix, cursor, limit))
+		defer stopBase()
+		u, uErr, uOK := upper()
+		b, bErr, bOK := base()
+		// An error comes with an empty path, so it sorts first and ends the list.
+		for n := 0; (uOK || bOK) && (limit <= 0 || n < limit); n++ {
+			var e hput.Entry
+			var err error
+			if uOK && (!bOK || u.Path <= b.Path) {
+				if bOK && b.Path == u.Path {
+					b, bErr, bOK = base()
+				}
+				e, err = u, uErr
+				u, uErr, uOK = upper()
+			} else {
+				e, err = b, bErr
+				b, bErr, bOK = base()
+			}
+			if !yield(e, err) || err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Versions lists the kept versions of a path in Upper
+func (o *Overlay) Versions(ctx context.Context, path string) ([]hput.Version, error) {
+	v, ok := o.Upper.(versioner)
+	if !ok {
+		return nil, errNoVersions
+	}
+	return v.Versions(ctx, path)
+}
+
+// GetVersion returns one version of a path in Upper
+func (o *Overlay) GetVersion(ctx context.Context, path string, id int) (hput.Runnable, error) {
+	v, ok := o.Upper.(versioner)
+	if !ok {
+		return hput.Runnable{}, errNoVersions
+	}
+	return v.GetVersion(ctx, path, id)
+}
+
+// Rollback rolls a path back in Upper
+func (o *Overlay) Rollback(ctx context.Context, path string, id int) (bool, error) {
+	v, ok := o.Upper.(versioner)
+	if !ok {
+		return false, errNoVersions
+	}
+	return v.Rollback(ctx, path, id)
+}
@@ -0,0 +1,169 @@
+package overlaysaver
+
+import (
+	"context"
+	"errors"
+	"hput"
+	"hput/dirsaver"
+	"hput/mapsaver"
+	"io"
+	"iter"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type TestLogger struct{}
+
+func (t *TestLogger) Debug(msg string) {}
+
+func (t *TestLogger) Debugf(msg string, args ...interface{}) {}
+
+func (t *TestLogger) Errorf(msg string, args ...interface{}) {}
+
+// newOverlay overlays a memory saver on a dir saver holding /index.html,
+// /about and the binary /logo.
+func newOverlay(t *testing.T) (*Overlay, *mapsaver.MapSaver) {
+	dir := t.TempDir()
+	for name, body := range map[string]string{"index.html": "<h1>base</h1>", "about": "base about", "logo": "\xff\x00\xfe"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(body), 0o644))
+	}
+	base, err := dirsaver.New(&TestLogger{}, dir)
+	assert.NoError(t, err)
+	t.Cleanup(base.Shutdown)
+	upper := mapsaver.New(&TestLogger{})
+	return New(upper, base, &TestLogger{}), upper
+}
+
+// collect returns what List yields for prefix p from cursor on, with
+// modified times cleared so listings can be compared.
+func collect(t *testing.T, o *Overlay, p, cursor string, limit int) []hput.Entry {
+	var out []hput.Entry
+	for e, err := range o.List(context.Background(), p, cursor, limit) {
+		assert.NoError(t, err)
+		e.Modified = time.Time{}
+		out = append(out, e)
+	}
+	return out
+}
+
+// TestGetRunnable verifies saved paths hide the base, and other paths fall back to it
+func TestGetRunnable(t *testing.T) {
+	ctx := context.Background()
+	o, _ := newOverlay(t)
+	res := hput.PutResult{}
+	assert.NoError(t, o.SaveText(ctx, "saved about", url.URL{Path: "/about"}, &res))
+	assert.True(t, res.Overwrote, "saving over the base hides it")
+	res = hput.PutResult{}
+	assert.NoError(t, o.SaveCode(ctx, "1+1", url.URL{Path: "/new"}, &res))
+	assert.False(t, res.Overwrote)
+
+	tt := []struct {
+		name string
+		path string
+		want hput.Runnable
+	}{
+		{name: "saved over the base", path: "/about", want: hput.Runnable{Path: "/about", Type: hput.Text, Text: "saved about"}},
+		{name: "only saved", path: "/new", want: hput.Runnable{Path: "/new", Type: hput.Js, Text: "1+1"}},
+		{name: "only in the base", path: "/", want: hput.Runnable{Path: "/", Type: hput.Text, Text: "<h1>base</h1>"}},
+		{name: "nowhere", path: "/missing", want: hput.Runnable{}},
+	}
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			r, err := o.GetRunnable(ctx, url.URL{Path: test.path})
+			assert.NoError(t, err)
+			assert.Equal(t, test.want, r)
+		})
+	}
+
+}
+
+// TestList verifies that saved paths and the base paths they do not hide are merged in path order, a page at a time
+func TestList(t *testing.T) {
+	ctx := context.Background()
+	o, _ := newOverlay(t)
+	assert.NoError(t, o.SaveText(ctx, "saved about", url.URL{Path: "/about"}, &hput.PutResult{}))
+	assert.NoError(t, o.SaveCode(ctx, "1+1", url.URL{Path: "/new"}, &hput.PutResult{}))
+
+	all := []hput.Entry{
+		{Path: "/", Type: hput.Text, Size: 13},
+		{Path: "/about", Type: hput.Text, Size: 11},
+		{Path: "/logo", Type: hput.Binary, Size: 3},
+		{Path: "/new", Type: hput.Js, Size: 3},
+	}
+	tt := []struct {
+		name   string
+		cursor string
+		limit  int
+		want   []hput.Entry
+	}{
+		{name: "everything", want: all},
+		{name: "first page", limit: 2, want: all[:2]},
+		{name: "next page", cursor: "/about", limit: 2, want: all[2:]},
+	}
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, collect(t, o, "/", test.cursor, test.limit))
+		})
+	}
+
+	failing := New(o.Upper, errBase{}, &TestLogger{})
+	var errs []error
+	for _, err := range failing.List(ctx, "/", "", 0) {
+		errs = append(errs, err)
+	}
+	assert.Len(t, errs, 1, "a base error ends the list")
+	assert.Error(t, errs[0])
+}
+
+// errBase is a Base that cannot be listed.
+type errBase struct{ Base }
+
+func (errBase) List(ctx context.Context, prefix, cursor string, limit int) iter.Seq2[hput.Entry, error] {
+	return func(yield func(hput.Entry, error) bool) {
+		yield(hput.Entry{}, errors.New("unreachable"))
+	}
+}
+
+// TestDelete verifies that deleting a saved path serves the base version again
+func TestDelete(t *testing.T) {
+	ctx := context.Background()
+	o, upper := newOverlay(t)
+	p := url.URL{Path: "/about"}
+	assert.NoError(t, o.SaveText(ctx, "saved about", p, &hput.PutResult{}))
+	assert.NoError(t, o.Delete(ctx, p))
+	r, err := o.GetRunnable(ctx, p)
+	assert.NoError(t, err)
+	assert.Equal(t, "base about", r.Text)
+	versions, err := o.Versions(ctx, "/about")
+	assert.NoError(t, err)
+	assert.Len(t, versions, 1, "the saved version is kept")
+	saved, err := upper.GetRunnable(ctx, p)
+	assert.NoError(t, err)
+	assert.Equal(t, hput.Runnable{}, saved)
+
+	noDelete := New(struct{ Saver }{upper}, o.Base, &TestLogger{})
+	assert.ErrorIs(t, noDelete.Delete(ctx, p), errNoDelete)
+}
+
+// TestGetStream verifies that binaries are streamed from the base unless a saved path hides them
+func TestGetStream(t *testing.T) {
+	ctx := context.Background()
+	o, _ := newOverlay(t)
+	p := url.URL{Path: "/logo"}
+	rs, err := o.GetStream(ctx, p)
+	assert.NoError(t, err)
+	b, err := io.ReadAll(rs)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0xff, 0, 0xfe}, b)
+	rs.Close()
+
+	assert.NoError(t, o.SaveText(ctx, "not a logo", p, &hput.PutResult{}))
+	rs, err = o.GetStream(ctx, p)
+	assert.NoError(t, err)
+	assert.Nil(t, rs, "saved text hides the base binary")
+}
@@ -0,0 +1,337 @@
+package s3saver
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hput"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeServer speaks enough of the S3 REST API, path-style, to run S3Saver
+// and S3KV through the real client. Objects are kept in a fakeBucket.
+type fakeServer struct {
+	bucket *fakeBucket
+	name   string
+
+	mu   sync.Mutex
+	auth []string // Authorization header of each request
+}
+
+type xmlError struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string
+	Message string
+}
+
+type xmlListResult struct {
+	XMLName               xml.Name `xml:"ListBucketResult"`
+	Name                  string
+	Prefix                string
+	IsTruncated           bool
+	NextContinuationToken string      `xml:",omitempty"`
+	Contents              []xmlObject `xml:",omitempty"`
+	CommonPrefixes        []xmlPrefix `xml:",omitempty"`
+}
+
+type xmlObject struct {
+	Key string
+}
+
+type xmlPrefix struct {
+	Prefix string
+}
+
+type xmlInitiateResult struct {
+	XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+	Bucket   string
+	Key      string
+	UploadId string
+}
+
+type xmlComplete struct {
+	Parts []struct {
+		PartNumber int32
+		ETag       string
+	} `xml:"Part"`
+}
+
+func (f *fakeServer) writeXML(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(code)
+	xml.NewEncoder(w).Encode(v)
+}
+
+func (f *fakeServer) fail(w http.ResponseWriter, err error) {
+	var notFound *types.NoSuchKey
+	var headNotFound *types.NotFound
+	switch {
+	case errors.As(err, &notFound), errors.As(err, &headNotFound):
+		f.writeXML(w, http.StatusNotFound, xmlError{Code: "NoSuchKey", Message: "no such key"})
+	case strings.Contains(err.Error(), "precondition failed"):
+		f.writeXML(w, http.StatusPreconditionFailed, xmlError{Code: "PreconditionFailed", Message: err.Error()})
+	default:
+		f.writeXML(w, http.StatusInternalServerError, xmlError{Code: "InternalError", Message: err.Error()})
+	}
+}
+
+// metadata returns the x-amz-meta- headers of r, by lowercase name.
+func metadata(h http.Header) map[string]string {
+	m := map[string]string{}
+	for k, v := range h {
+		if name, ok := strings.CutPrefix(strings.ToLower(k), "x-amz-meta-"); ok {
+			m[name] = v[0]
+		}
+	}
+	return m
+}
+
+func (f *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.auth = append(f.auth, r.Header.Get("Authorization"))
+	f.mu.Unlock()
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != f.name {
+		f.writeXML(w, http.StatusNotFound, xmlError{Code: "NoSuchBucket", Message: "requests must be path-style, to bucket " + f.name})
+		return
+	}
+	ctx := r.Context()
+	q := r.URL.Query()
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		f.fail(w, err)
+		return
+	}
+	switch {
+	case key == "" && r.Method == http.MethodGet:
+		in := &s3.ListObjectsV2Input{Prefix: aws.String(q.Get("prefix"))}
+		if d := q.Get("delimiter"); d != "" {
+			in.Delimiter = aws.String(d)
+		}
+		if t := q.Get("continuation-token"); t != "" {
+			in.ContinuationToken = aws.String(t)
+		}
+		if a := q.Get("start-after"); a != "" {
+			in.StartAfter = aws.String(a)
+		}
+		if m := q.Get("max-keys"); m != "" {
+			n, _ := strconv.Atoi(m)
+			in.MaxKeys = aws.Int32(int32(n))
+		}
+		out, err := f.bucket.ListObjectsV2(ctx, in)
+		if err != nil {
+			f.fail(w, err)
+			return
+		}
+		res := xmlListResult{Name: f.name, Prefix: q.Get("prefix"), IsTruncated: aws.ToBool(out.IsTruncated), NextContinuationToken: aws.ToString(out.NextContinuationToken)}
+		for _, o := range out.Contents {
+			res.Contents = append(res.Contents, xmlObject{Key: aws.ToString(o.Key)})
+		}
+		for _, p := range out.CommonPrefixes {
+			res.CommonPrefixes = append(res.CommonPrefixes, xmlPrefix{Prefix: aws.ToString(p.Prefix)})
+		}
+		f.writeXML(w, http.StatusOK, res)
+	case r.Method == http.MethodHead:
+		out, err := f.bucket.HeadObject(ctx, &s3.HeadObjectInput{Bucket: &f.name, Key: &key})
+		if err != nil {
+			f.fail(w, err)
+			return
+		}
+		for k, v := range out.Metadata {
+			w.Header().Set("X-Amz-Meta-"+k, v)
+		}
+		w.Header().Set("ETag", aws.ToString(out.ETag))
+		w.Header().Set("Content-Length", strconv.FormatInt(aws.ToInt64(out.ContentLength), 10))
+	case r.Method == http.MethodGet:
+		in := &s3.GetObjectInput{Bucket: &f.name, Key: &key}
+		if rg := r.Header.Get("Range"); rg != "" {
+			in.Range = aws.String(rg)
+		}
+		if m := r.Header.Get("If-Match"); m != "" {
+			in.IfMatch = aws.String(m)
+		}
+		out, err := f.bucket.GetObject(ctx, in)
+		if err != nil {
+			f.fail(w, err)
+			return
+		}
+		for k, v := range out.Metadata {
+			w.Header().Set("X-Amz-Meta-"+k, v)
+		}
+		w.Header().Set("ETag", aws.ToString(out.ETag))
+		w.Header().Set("Content-Length", strconv.FormatInt(aws.ToInt64(out.ContentLength), 10))
+		code := http.StatusOK
+		if in.Range != nil {
+			code = http.StatusPartialContent
+		}
+		w.WriteHeader(code)
+		io.Copy(w, out.Body)
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		in := &s3.CopyObjectInput{
+			Bucket:            &f.name,
+			Key:               &key,
+			CopySource:        aws.String(strings.TrimPrefix(r.Header.Get("X-Amz-Copy-Source"), "/")),
+			MetadataDirective: types.MetadataDirective(r.Header.Get("X-Amz-Metadata-Directive")),
+			Metadata:          metadata(r.Header),
+		}
+		if _, err := f.bucket.CopyObject(ctx, in); err != nil {
+			f.fail(w, err)
+			return
+		}
+		f.writeXML(w, http.StatusOK, struct {
+			XMLName xml.Name `xml:"CopyObjectResult"`
+			ETag    string
+		}{ETag: `"copy"`})
+	case r.Method == http.MethodPut && q.Has("uploadId"):
+		n, _ := strconv.Atoi(q.Get("partNumber"))
+		out, err := f.bucket.UploadPart(ctx, &s3.UploadPartInput{
+			Bucket:     &f.name,
+			Key:        &key,
+			UploadId:   aws.String(q.Get("uploadId")),
+			PartNumber: aws.Int32(int32(n)),
+			Body:       bytes.NewReader(body),
+		})
+		if err != nil {
+			f.fail(w, err)
+			return
+		}
+		w.Header().Set("ETag", aws.ToString(out.ETag))
+	case r.Method == http.MethodPut:
+		in := &s3.PutObjectInput{Bucket: &f.name, Key: &key, Metadata: metadata(r.Header), Body: bytes.NewReader(body)}
+		if m := r.Header.Get("If-None-Match"); m != "" {
+			in.IfNoneMatch = aws.String(m)
+		}
+		_, err := f.bucket.PutObject(ctx, in)
+		if err != nil {
+			f.fail(w, err)
+			return
+		}
+	case r.Method == http.MethodPost && q.Has("uploads"):
+		out, err := f.bucket.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{Bucket: &f.name, Key: &key, Metadata: metadata(r.Header)})
+		if err != nil {
+			f.fail(w, err)
+			return
+		}
+		f.writeXML(w, http.StatusOK, xmlInitiateResult{Bucket: f.name, Key: key, UploadId: aws.ToString(out.UploadId)})
+	case r.Method == http.MethodPost && q.Has("uploadId"):
+		var c xmlComplete
+		if err := xml.Unmarshal(body, &c); err != nil {
+			f.fail(w, err)
+			return
+		}
+		in := &s3.CompleteMultipartUploadInput{Bucket: &f.name, Key: &key, UploadId: aws.String(q.Get("uploadId")), MultipartUpload: &types.CompletedMultipartUpload{}}
+		if m := r.Header.Get("If-None-Match"); m != "" {
+			in.IfNoneMatch = aws.String(m)
+		}
+		for _, p := range c.Parts {
+			in.MultipartUpload.Parts = append(in.MultipartUpload.Parts, types.CompletedPart{PartNumber: aws.Int32(p.PartNumber), ETag: aws.String(p.ETag)})
+		}
+		if _, err := f.bucket.CompleteMultipartUpload(ctx, in); err != nil {
+			f.fail(w, err)
+			return
+		}
+		f.writeXML(w, http.StatusOK, struct {
+			XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+			Key     string
+		}{Key: key})
+	case r.Method == http.MethodDelete && q.Has("uploadId"):
+		f.bucket.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{Bucket: &f.name, Key: &key, UploadId: aws.String(q.Get("uploadId"))})
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodDelete:
+		f.bucket.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: &f.name, Key: &key})
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		f.writeXML(w, http.StatusNotImplemented, xmlError{Code: "NotImplemented", Message: fmt.Sprintf("%s %s", r.Method, r.URL)})
+	}
+}
+
+// endpointOptions returns the options to reach srv as an S3-compatible store.
+func endpointOptions(srv *httptest.Server) []option {
+	return []option{
+		EndpointOption{URL: srv.URL},
+		PathStyleOption{PathStyle: true},
+		RegionOption{Region: "local"},
+		CredentialsOption{AccessKeyID: "hputkey", SecretAccessKey: "hputsecret"},
+		PrefixOption{Prefix: "pre"},
+	}
+}
+
+// TestEndpoint runs S3Saver and S3KV against an S3-compatible server
+func TestEndpoint(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakeServer{bucket: newFakeBucket(), name: "bucket"}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	sa, err := New(ctx, &testLogger{}, "bucket", endpointOptions(srv)...)
+	assert.NoError(t, err)
+	sa.Retention = hput.Retention{Count: 10}
+	u := url.URL{Path: "/hello"}
+	res := hput.PutResult{}
+	assert.NoError(t, sa.SaveText(ctx, "hi", u, &res))
+	assert.False(t, res.Overwrote)
+	assert.NoError(t, sa.SaveCode(ctx, "1+1", u, &res))
+	assert.True(t, res.Overwrote)
+	r, err := sa.GetRunnable(ctx, u)
+	assert.NoError(t, err)
+	assert.Equal(t, hput.Runnable{Path: "/hello", Type: hput.Js, Text: "1+1"}, r)
+	versions, err := sa.Versions(ctx, "/hello")
+	assert.NoError(t, err)
+	assert.Len(t, versions, 2)
+
+	big := bytes.Repeat([]byte{0xff, 0, 1}, partSize/3+100)
+	assert.NoError(t, sa.SaveStream(ctx, bytes.NewReader(big), url.URL{Path: "/big"}, &hput.PutResult{}))
+	rs, err := sa.GetStream(ctx, url.URL{Path: "/big"})
+	assert.NoError(t, err)
+	_, err = rs.Seek(-3, io.SeekEnd)
+	assert.NoError(t, err)
+	tail, err := io.ReadAll(rs)
+	assert.NoError(t, err)
+	assert.Equal(t, big[len(big)-3:], tail)
+	rs.Close()
+
+	store, err := NewKV(ctx, &testLogger{}, "bucket", endpointOptions(srv)...)
+	assert.NoError(t, err)
+	assert.NoError(t, store.Put(ctx, "/hello", "count", []byte("1")))
+	v, err := store.Get(ctx, "/hello", "count")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("1"), v)
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	for _, a := range fake.auth {
+		assert.Contains(t, a, "Credential=hputkey/")
+		assert.Contains(t, a, "/local/s3/aws4_request")
+	}
+}
+
+// TestEndpointOptions verifies bad endpoint options are rejected
+func TestEndpointOptions(t *testing.T) {
+	tt := []struct {
+		name string
+		opt  option
+	}{
+		{name: "endpoint without a scheme", opt: EndpointOption{URL: "localhost:9000"}},
+		{name: "credentials without a secret", opt: CredentialsOption{AccessKeyID: "key"}},
+	}
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := New(context.Background(), &testLogger{}, "bucket", test.opt)
+			assert.Error(t, err)
+		})
+	}
+}
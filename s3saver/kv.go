@@ -0,0 +1,606 @@
+package s3saver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hput/kv"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// kvDir is where KV objects live under the prefix, beside saved paths.
+const kvDir = "/_kv/"
+
+// metadataExpiresAt holds a KV value's expiry as Unix nanoseconds.
+const metadataExpiresAt = "expires-at"
+
+// S3KV implements kv.KV in the same bucket and prefix as S3Saver, so a
+// server using -storage s3 keeps nothing on local disk. Each value is one
+// object at <prefix>/_kv/<escaped path>/<key>, and each path's index
+// definitions are one JSON object at <prefix>/_kv/<escaped path>.
+//
+// S3 has no multi-object transactions, so writes are serialized by a lock
+// in this process and a failed batch is rolled back on a best-effort basis.
+// Only one hput instance should use a prefix at a time. Indexes keep no
+// entries of their own: Query reads every value of the path.
+type S3KV struct {
+	saver S3Saver
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewKV returns an S3KV for bucket b. It takes the same options as New.
+func NewKV(ctx context.Context, l Logger, b string, options ...option) (*S3KV, error) {
+	sa, err := New(ctx, l, b, options...)
+	if err != nil {
+		return nil, err
+	}
+	return &S3KV{saver: sa}, nil
+}
+
+// isKV reports whether an object key belongs to the KV store rather than a saved path.
+func (sa S3Saver) isKV(key string) bool {
+	return strings.HasPrefix(key, sa.Prefix+kvDir)
+}
+
+// kvPathKey is the object holding path's index definitions. Escaping the
+// path keeps "/" out of it, so /a's keys can never be mistaken for /a/b's.
+func (s *S3KV) kvPathKey(path string) string {
+	return s.saver.Prefix + kvDir + url.PathEscape(path)
+}
+
+func (s *S3KV) kvKey(path, key string) string {
+	return s.kvPathKey(path) + "/" + key
+}
+
+func (s *S3KV) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+// read returns the live value of an object and its expiry, or nil if it is missing or expired.
+func (s *S3KV) read(ctx context.Context, objectKey string, now time.Time) ([]byte, time.Time, error) {
+	o, err := s.saver.Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: &s.saver.Bucket,
+		Key:    &objectKey,
+	})
+	if err != nil {
+		var notFoundErr *types.NoSuchKey
+		if errors.As(err, &notFoundErr) {
+			return nil, time.Time{}, nil
+		}
+		return nil, time.Time{}, fmt.Errorf("kv: s3: get: %w", err)
+	}
+	defer o.Body.Close()
+	expiresAt := readExpiry(o.Metadata)
+	if !expiresAt.IsZero() && !expiresAt.After(now) {
+		return nil, time.Time{}, nil
+	}
+	value, err := io.ReadAll(o.Body)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("kv: s3: read: %w", err)
+	}
+	return value, expiresAt, nil
+}
+
+// live reports whether an object is there and not expired, from its
+// metadata alone, so its value is not downloaded.
+func (s *S3KV) live(ctx context.Context, objectKey string, now time.Time) (bool, error) {
+	o, err := s.saver.headObject(ctx, objectKey)
+	if err != nil {
+		return false, fmt.Errorf("kv: s3: head: %w", err)
+	}
+	if o == nil {
+		return false, nil
+	}
+	expiresAt := readExpiry(o.Metadata)
+	return expiresAt.IsZero() || expiresAt.After(now), nil
+}
+
+// readExpiry returns when an object's key expires, or zero if it does not.
+func readExpiry(meta map[string]string) time.Time {
+	n, err := strconv.ParseInt(meta[metadataExpiresAt], 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
+
+// write stores e at its key, or deletes the key if e is nil.
+func (s *S3KV) write(ctx context.Context, path, key string, e *kv.Entry) error {
+	objectKey := s.kvKey(path, key)
+	if e == nil {
+		_, err := s.saver.Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+			Bucket: &s.saver.Bucket,
+			Key:    &objectKey,
+		})
+		if err != nil {
+			return fmt.Errorf("kv: s3: delete: %w", err)
+		}
+		return nil
+	}
+	in := s3.PutObjectInput{
+		Bucket:   &s.saver.Bucket,
+		Key:      &objectKey,
+		Metadata: map[string]string{},
+		Body:     bytes.NewReader(e.Value),
+	}
+	if !e.ExpiresAt.IsZero() {
+		in.Metadata[metadataExpiresAt] = strconv.FormatInt(e.ExpiresAt.UnixNano(), 10)
+	}
+	if _, err := s.saver.Client.PutObject(ctx, &in); err != nil {
+		return fmt.Errorf("kv: s3: put: %w", err)
+	}
+	return nil
+}
+
+// apply writes a batch in order. A nil entry is a delete. If a write
+// fails, the keys already written are put back as they were.
+// The caller must hold the write lock.
+func (s *S3KV) apply(ctx context.Context, path string, order []string, writes map[string]*kv.Entry, before map[string]*kv.Entry) error {
+	for i, key := range order {
+		err := s.write(ctx, path, key, writes[key])
+		if err == nil {
+			continue
+		}
+		for _, done := range order[:i] {
+			if rerr := s.write(ctx, path, done, before[done]); rerr != nil {
+				s.saver.Logger.Errorf("kv: s3: could not roll back %q at %s: %v", done, path, rerr)
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+// batch reads the current value of every key in order, then applies
+// writes, so a failed batch can be rolled back. The caller must hold the write lock.
+func (s *S3KV) batch(ctx context.Context, path string, order []string, writes map[string]*kv.Entry) error {
+	before := map[string]*kv.Entry{}
+	now := time.Now()
+	if len(order) > 1 {
+		for _, key := range order {
+			v, expiresAt, err := s.read(ctx, s.kvKey(path, key), now)
+			if err != nil {
+				return err
+			}
+			if v != nil {
+				before[key] = &kv.Entry{Key: key, Value: v, ExpiresAt: expiresAt}
+			}
+		}
+	}
+	return s.apply(ctx, path, order, writes, before)
+}
+
+func (s *S3KV) Get(ctx context.Context, path, key string) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return nil, kv.ErrClosed
+	}
+	v, _, err := s.read(ctx, s.kvKey(path, key), time.Now())
+	return v, err
+}
+
+func (s *S3KV) Put(ctx context.Context, path, key string, value []byte) error {
+	return s.PutExpiring(ctx, path, key, value, time.Time{})
+}
+
+func (s *S3KV) PutExpiring(ctx context.Context, path, key string, value []byte, expiresAt time.Time) error {
+	return s.PutMany(ctx, path, []kv.Entry{{Key: key, Value: value, ExpiresAt: expiresAt}})
+}
+
+func (s *S3KV) Delete(ctx context.Context, path, key string) error {
+	return s.DeleteMany(ctx, path, []string{key})
+}
+
+func (s *S3KV) GetMany(ctx context.Context, path string, keys []string) ([][]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return nil, kv.ErrClosed
+	}
+	now := time.Now()
+	vals := make([][]byte, len(keys))
+	for i, key := range keys {
+		v, _, err := s.read(ctx, s.kvKey(path, key), now)
+		if err != nil {
+			return nil, err
+		}
+		vals[i] = v
+	}
+	return vals, nil
+}
+
+func (s *S3KV) PutMany(ctx context.Context, path string, entries []kv.Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return kv.ErrClosed
+	}
+	var order []string
+	writes := map[string]*kv.Entry{}
+	for i := range entries {
+		if _, ok := writes[entries[i].Key]; !ok {
+			order = append(order, entries[i].Key)
+		}
+		writes[entries[i].Key] = &entries[i]
+	}
+	return s.batch(ctx, path, order, writes)
+}
+
+func (s *S3KV) DeleteMany(ctx context.Context, path string, keys []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return kv.ErrClosed
+	}
+	var order []string
+	writes := map[string]*kv.Entry{}
+	for _, key := range keys {
+		if _, ok := writes[key]; !ok {
+			order = append(order, key)
+			writes[key] = nil
+		}
+	}
+	return s.batch(ctx, path, order, writes)
+}
+
+// List maps onto ListObjectsV2: the cursor is its continuation token and
+// Limit is MaxKeys. Expired keys are left out, so a page may hold fewer
+// than Limit keys even when there are more pages. Expiry is read from each
+// key's metadata with HeadObject, and values are only downloaded when
+// IncludeValues is set.
+func (s *S3KV) List(ctx context.Context, path string, opts kv.ListOptions) (kv.ListResult, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return kv.ListResult{}, kv.ErrClosed
+	}
+	dir := s.kvPathKey(path) + "/"
+	prefix := dir + opts.Prefix
+	in := s3.ListObjectsV2Input{
+		Bucket: &s.saver.Bucket,
+		Prefix: &prefix,
+	}
+	if opts.Limit > 0 {
+		in.MaxKeys = aws.Int32(int32(opts.Limit))
+	}
+	if opts.Cursor != "" {
+		in.ContinuationToken = aws.String(opts.Cursor)
+	}
+	now := time.Now()
+	var result kv.ListResult
+	for {
+		res, err := s.saver.Client.ListObjectsV2(ctx, &in)
+		if err != nil {
+			return kv.ListResult{}, fmt.Errorf("kv: s3: list: %w", err)
+		}
+		for _, obj := range res.Contents {
+			if !opts.IncludeValues {
+				ok, err := s.live(ctx, *obj.Key, now)
+				if err != nil {
+					return kv.ListResult{}, err
+				}
+				if ok {
+					result.Keys = append(result.Keys, strings.TrimPrefix(*obj.Key, dir))
+				}
+				continue
+			}
+			v, _, err := s.read(ctx, *obj.Key, now)
+			if err != nil {
+				return kv.ListResult{}, err
+			}
+			if v == nil {
+				continue
+			}
+			result.Keys = append(result.Keys, strings.TrimPrefix(*obj.Key, dir))
+			result.Values = append(result.Values, v)
+		}
+		if res.NextContinuationToken == nil {
+			return result, nil
+		}
+		if opts.Limit > 0 {
+			result.Cursor = *res.NextContinuationToken
+			return result, nil
+		}
+		in.ContinuationToken = res.NextContinuationToken
+	}
+}
+
+// Update runs fn under the store's write lock. Writes are buffered in the
+// Tx and only applied if fn succeeds.
+func (s *S3KV) Update(ctx context.Context, path string, fn func(tx kv.Tx) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return kv.ErrClosed
+	}
+	tx := &s3Tx{ctx: ctx, store: s, path: path, now: time.Now(), writes: map[string]*kv.Entry{}}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if tx.err != nil {
+		return tx.err
+	}
+	return s.batch(ctx, path, tx.order, tx.writes)
+}
+
+// s3Tx implements kv.Tx for S3KV.Update. A nil write is a delete. The
+// first read error is kept in err and fails the Update.
+type s3Tx struct {
+	ctx    context.Context
+	store  *S3KV
+	path   string
+	now    time.Time
+	writes map[string]*kv.Entry
+	order  []string
+	err    error
+}
+
+func (t *s3Tx) Get(key string) ([]byte, error) {
+	v, _, err := t.read(key)
+	return v, err
+}
+
+func (t *s3Tx) ExpiresAt(key string) (time.Time, error) {
+	_, expiresAt, err := t.read(key)
+	return expiresAt, err
+}
+
+func (t *s3Tx) read(key string) ([]byte, time.Time, error) {
+	w, ok := t.writes[key]
+	if !ok {
+		v, expiresAt, err := t.store.read(t.ctx, t.store.kvKey(t.path, key), t.now)
+		if err != nil && t.err == nil {
+			t.err = err
+		}
+		return v, expiresAt, err
+	}
+	if w == nil || (!w.ExpiresAt.IsZero() && !w.ExpiresAt.After(t.now)) {
+		return nil, time.Time{}, nil
+	}
+	return append([]byte{}, w.Value...), w.ExpiresAt, nil
+}
+
+func (t *s3Tx) Put(key string, value []byte) error {
+	return t.PutExpiring(key, value, time.Time{})
+}
+
+func (t *s3Tx) PutExpiring(key string, value []byte, expiresAt time.Time) error {
+	t.write(key, &kv.Entry{Key: key, Value: append([]byte{}, value...), ExpiresAt: expiresAt})
+	return nil
+}
+
+func (t *s3Tx) Delete(key string) error {
+	t.write(key, nil)
+	return nil
+}
+
+func (t *s3Tx) write(key string, e *kv.Entry) {
+	if _, ok := t.writes[key]; !ok {
+		t.order = append(t.order, key)
+	}
+	t.writes[key] = e
+}
+
+// Paths lists the escaped path "directories" under the KV root and keeps
+// those with a live key.
+func (s *S3KV) Paths(ctx context.Context) ([]string, error) {
+	s.mu.RLock()
+	closed := s.closed
+	s.mu.RUnlock()
+	if closed {
+		return nil, kv.ErrClosed
+	}
+	root := s.saver.Prefix + kvDir
+	in := s3.ListObjectsV2Input{
+		Bucket:    &s.saver.Bucket,
+		Prefix:    &root,
+		Delimiter: aws.String("/"),
+	}
+	var paths []string
+	for {
+		res, err := s.saver.Client.ListObjectsV2(ctx, &in)
+		if err != nil {
+			return nil, fmt.Errorf("kv: s3: list: %w", err)
+		}
+		for _, cp := range res.CommonPrefixes {
+			path, err := url.PathUnescape(strings.TrimSuffix(strings.TrimPrefix(*cp.Prefix, root), "/"))
+			if err != nil {
+				continue // not written by S3KV
+			}
+			live, err := s.hasLive(ctx, path)
+			if err != nil {
+				return nil, err
+			}
+			if live {
+				paths = append(paths, path)
+			}
+		}
+		if res.NextContinuationToken == nil {
+			sort.Strings(paths)
+			return paths, nil
+		}
+		in.ContinuationToken = res.NextContinuationToken
+	}
+}
+
+// hasLive reports whether path holds at least one key that has not expired.
+func (s *S3KV) hasLive(ctx context.Context, path string) (bool, error) {
+	opts := kv.ListOptions{Limit: 100}
+	for {
+		res, err := s.List(ctx, path, opts)
+		if err != nil {
+			return false, err
+		}
+		if len(res.Keys) > 0 {
+			return true, nil
+		}
+		if res.Cursor == "" {
+			return false, nil
+		}
+		opts.Cursor = res.Cursor
+	}
+}
+
+// PurgeExpired reads every KV object in the prefix and deletes the expired ones.
+func (s *S3KV) PurgeExpired(ctx context.Context, now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return 0, kv.ErrClosed
+	}
+	root := s.saver.Prefix + kvDir
+	in := s3.ListObjectsV2Input{
+		Bucket: &s.saver.Bucket,
+		Prefix: &root,
+	}
+	var purged int
+	for {
+		res, err := s.saver.Client.ListObjectsV2(ctx, &in)
+		if err != nil {
+			return purged, fmt.Errorf("kv: s3: list: %w", err)
+		}
+		for _, obj := range res.Contents {
+			if !strings.Contains(strings.TrimPrefix(*obj.Key, root), "/") {
+				continue // index definitions
+			}
+			v, _, err := s.read(ctx, *obj.Key, now)
+			if err != nil {
+				return purged, err
+			}
+			if v != nil {
+				continue
+			}
+			_, err = s.saver.Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+				Bucket: &s.saver.Bucket,
+				Key:    obj.Key,
+			})
+			if err != nil {
+				return purged, fmt.Errorf("kv: s3: delete: %w", err)
+			}
+			purged++
+		}
+		if res.NextContinuationToken == nil {
+			return purged, nil
+		}
+		in.ContinuationToken = res.NextContinuationToken
+	}
+}
+
+// indexDefs reads path's index definitions as name → field.
+func (s *S3KV) indexDefs(ctx context.Context, path string) (map[string]string, error) {
+	raw, _, err := s.read(ctx, s.kvPathKey(path), time.Now())
+	if err != nil || raw == nil {
+		return map[string]string{}, err
+	}
+	defs := map[string]string{}
+	if err := json.Unmarshal(raw, &defs); err != nil {
+		return nil, fmt.Errorf("kv: s3: index definitions of %s: %w", path, err)
+	}
+	return defs, nil
+}
+
+func (s *S3KV) putIndexDefs(ctx context.Context, path string, defs map[string]string) error {
+	key := s.kvPathKey(path)
+	if len(defs) == 0 {
+		_, err := s.saver.Client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: &s.saver.Bucket, Key: &key})
+		if err != nil {
+			return fmt.Errorf("kv: s3: delete: %w", err)
+		}
+		return nil
+	}
+	raw, err := json.Marshal(defs)
+	if err != nil {
+		return err
+	}
+	_, err = s.saver.Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: &s.saver.Bucket,
+		Key:    &key,
+		Body:   bytes.NewReader(raw),
+	})
+	if err != nil {
+		return fmt.Errorf("kv: s3: put: %w", err)
+	}
+	return nil
+}
+
+// CreateIndex records the index definition. There is nothing to build,
+// because Query reads the values themselves.
+func (s *S3KV) CreateIndex(ctx context.Context, path, name, field string) error {
+	if err := kv.ValidIndex(name, field); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return kv.ErrClosed
+	}
+	defs, err := s.indexDefs(ctx, path)
+	if err != nil {
+		return err
+	}
+	if defs[name] == field {
+		return nil
+	}
+	defs[name] = field
+	return s.putIndexDefs(ctx, path, defs)
+}
+
+// DropIndex removes the named index of path.
+func (s *S3KV) DropIndex(ctx context.Context, path, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return kv.ErrClosed
+	}
+	defs, err := s.indexDefs(ctx, path)
+	if err != nil {
+		return err
+	}
+	if _, ok := defs[name]; !ok {
+		return nil
+	}
+	delete(defs, name)
+	return s.putIndexDefs(ctx, path, defs)
+}
+
+// Query lists every live value of path and hands them to kv.QueryValues.
+func (s *S3KV) Query(ctx context.Context, path, name string, q kv.Query) (kv.ListResult, error) {
+	defs, err := func() (map[string]string, error) {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		if s.closed {
+			return nil, kv.ErrClosed
+		}
+		return s.indexDefs(ctx, path)
+	}()
+	if err != nil {
+		return kv.ListResult{}, err
+	}
+	field, ok := defs[name]
+	if !ok {
+		return kv.ListResult{}, fmt.Errorf("kv: query: %w: %q", kv.ErrNoIndex, name)
+	}
+	all, err := s.List(ctx, path, kv.ListOptions{IncludeValues: true})
+	if err != nil {
+		return kv.ListResult{}, err
+	}
+	return kv.QueryValues(all.Keys, all.Values, field, q)
+}
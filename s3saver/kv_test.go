@@ -0,0 +1,368 @@
+package s3saver
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"hput"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"hput/kv"
+	"hput/kv/kvtest"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeBucket is a client that keeps objects in memory, so S3KV can be
+// tested end to end. ListObjectsV2 pages like S3: in key order, up to
+// MaxKeys (1000 by default), with an opaque continuation token.
+type fakeBucket struct {
+	mu      sync.Mutex
+	objects map[string]fakeObject
+	uploads map[string]*fakeUpload // multipart uploads in progress, by ID
+	lists   int                    // ListObjectsV2 calls
+	gets    int                    // GetObject calls
+	heads   int                    // HeadObject calls
+	failKey string                 // PutObject fails for this key
+}
+
+// errPreconditionFailed is what S3 answers a write conditional on there
+// being no object when there is one.
+var errPreconditionFailed = &smithy.GenericAPIError{Code: "PreconditionFailed", Message: "precondition failed"}
+
+type fakeUpload struct {
+	key      string
+	metadata map[string]string
+	parts    map[int32][]byte
+}
+
+type fakeObject struct {
+	body     []byte
+	metadata map[string]string
+}
+
+func newFakeBucket() *fakeBucket {
+	return &fakeBucket{objects: map[string]fakeObject{}, uploads: map[string]*fakeUpload{}}
+}
+
+func (b *fakeBucket) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if *params.Key == b.failKey {
+		return nil, errors.New("boom")
+	}
+	if _, ok := b.objects[*params.Key]; ok && aws.ToString(params.IfNoneMatch) == "*" {
+		return nil, errPreconditionFailed
+	}
+	body, err := io.ReadAll(params.Body)
+	if err != nil {
+		return nil, err
+	}
+	b.objects[*params.Key] = fakeObject{body: body, metadata: params.Metadata}
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (b *fakeBucket) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.gets++
+	o, ok := b.objects[*params.Key]
+	if !ok {
+		return nil, &types.NoSuchKey{}
+	}
+	etag := fmt.Sprintf(`"%x"`, md5.Sum(o.body))
+	if m := aws.ToString(params.IfMatch); m != "" && m != etag {
+		return nil, errors.New("precondition failed")
+	}
+	body := o.body
+	if r := aws.ToString(params.Range); r != "" {
+		var start int
+		if _, err := fmt.Sscanf(r, "bytes=%d-", &start); err != nil || start > len(body) {
+			return nil, fmt.Errorf("bad range %q", r)
+		}
+		body = body[start:]
+	}
+	return &s3.GetObjectOutput{
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: aws.Int64(int64(len(body))),
+		ETag:          aws.String(etag),
+		Metadata:      o.metadata,
+	}, nil
+}
+
+func (b *fakeBucket) HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.heads++
+	o, ok := b.objects[*params.Key]
+	if !ok {
+		return nil, &types.NotFound{}
+	}
+	return &s3.HeadObjectOutput{
+		ContentLength: aws.Int64(int64(len(o.body))),
+		ETag:          aws.String(fmt.Sprintf(`"%x"`, md5.Sum(o.body))),
+		Metadata:      o.metadata,
+	}, nil
+}
+
+func (b *fakeBucket) CopyObject(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	src, err := url.PathUnescape(*params.CopySource)
+	if err != nil {
+		return nil, err
+	}
+	o, ok := b.objects[strings.TrimPrefix(src, *params.Bucket+"/")]
+	if !ok {
+		return nil, &types.NoSuchKey{}
+	}
+	if params.MetadataDirective == types.MetadataDirectiveReplace {
+		o.metadata = params.Metadata
+	}
+	b.objects[*params.Key] = o
+	return &s3.CopyObjectOutput{}, nil
+}
+
+func (b *fakeBucket) CreateMultipartUpload(ctx context.Context, params *s3.CreateMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	id := fmt.Sprintf("upload-%d", len(b.uploads)+1)
+	b.uploads[id] = &fakeUpload{key: *params.Key, metadata: params.Metadata, parts: map[int32][]byte{}}
+	return &s3.CreateMultipartUploadOutput{UploadId: aws.String(id)}, nil
+}
+
+func (b *fakeBucket) UploadPart(ctx context.Context, params *s3.UploadPartInput, optFns ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+	body, err := io.ReadAll(params.Body)
+	if err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	u, ok := b.uploads[*params.UploadId]
+	if !ok {
+		return nil, errors.New("no such upload")
+	}
+	u.parts[*params.PartNumber] = body
+	return &s3.UploadPartOutput{ETag: aws.String(fmt.Sprintf("etag-%d", *params.PartNumber))}, nil
+}
+
+func (b *fakeBucket) CompleteMultipartUpload(ctx context.Context, params *s3.CompleteMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	u, ok := b.uploads[*params.UploadId]
+	if !ok {
+		return nil, errors.New("no such upload")
+	}
+	if _, ok := b.objects[u.key]; ok && aws.ToString(params.IfNoneMatch) == "*" {
+		return nil, errPreconditionFailed
+	}
+	var body []byte
+	for _, p := range params.MultipartUpload.Parts {
+		body = append(body, u.parts[*p.PartNumber]...)
+	}
+	b.objects[u.key] = fakeObject{body: body, metadata: u.metadata}
+	delete(b.uploads, *params.UploadId)
+	return &s3.CompleteMultipartUploadOutput{}, nil
+}
+
+func (b *fakeBucket) AbortMultipartUpload(ctx context.Context, params *s3.AbortMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.uploads, *params.UploadId)
+	return &s3.AbortMultipartUploadOutput{}, nil
+}
+
+func (b *fakeBucket) DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.objects, *params.Key)
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func (b *fakeBucket) ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.lists++
+	start := ""
+	if params.ContinuationToken != nil {
+		var ok bool
+		if start, ok = strings.CutPrefix(*params.ContinuationToken, "token:"); !ok {
+			return nil, errors.New("bad continuation token")
+		}
+	}
+	var keys []string
+	for k := range b.objects {
+		if strings.HasPrefix(k, aws.ToString(params.Prefix)) && k >= start && k > aws.ToString(params.StartAfter) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	max := 1000
+	if params.MaxKeys != nil {
+		max = int(*params.MaxKeys)
+	}
+	out := &s3.ListObjectsV2Output{IsTruncated: aws.Bool(false)}
+	var n int
+	var lastPrefix string
+	for _, k := range keys {
+		// With a delimiter, keys that share a "directory" roll up into one common prefix.
+		if d := aws.ToString(params.Delimiter); d != "" {
+			rest := strings.TrimPrefix(k, aws.ToString(params.Prefix))
+			if i := strings.Index(rest, d); i >= 0 {
+				cp := aws.ToString(params.Prefix) + rest[:i+len(d)]
+				if cp == lastPrefix {
+					continue
+				}
+				if n == max {
+					out.IsTruncated = aws.Bool(true)
+					out.NextContinuationToken = aws.String("token:" + k)
+					break
+				}
+				lastPrefix = cp
+				out.CommonPrefixes = append(out.CommonPrefixes, types.CommonPrefix{Prefix: aws.String(cp)})
+				n++
+				continue
+			}
+		}
+		if n == max {
+			out.IsTruncated = aws.Bool(true)
+			out.NextContinuationToken = aws.String("token:" + k)
+			break
+		}
+		out.Contents = append(out.Contents, types.Object{Key: aws.String(k)})
+		n++
+	}
+	return out, nil
+}
+
+func newTestKV(t *testing.T, bucket *fakeBucket) *S3KV {
+	t.Helper()
+	store, err := NewKV(context.Background(), &testLogger{}, "bucket", S3ClientOption{client: bucket}, PrefixOption{Prefix: "pre"})
+	assert.NoError(t, err)
+	return store
+}
+
+// TestKVConformance runs the shared KV behaviour tests against S3KV
+func TestKVConformance(t *testing.T) {
+	kvtest.RunConformance(t, func(t *testing.T) kv.KV {
+		return newTestKV(t, newFakeBucket())
+	})
+}
+
+// TestKVLayout verifies where values and index definitions are stored
+func TestKVLayout(t *testing.T) {
+	ctx := context.Background()
+	bucket := newFakeBucket()
+	store := newTestKV(t, bucket)
+	assert.NoError(t, store.Put(ctx, "/a", "b/c", []byte(`1`)))
+	assert.NoError(t, store.Put(ctx, "/a/b", "c", []byte(`2`)))
+	assert.NoError(t, store.CreateIndex(ctx, "/a", "byX", "$.x"))
+
+	var keys []string
+	for k := range bucket.objects {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	assert.Equal(t, []string{"pre/_kv/%2Fa", "pre/_kv/%2Fa%2Fb/c", "pre/_kv/%2Fa/b/c"}, keys)
+
+	res, err := store.List(ctx, "/a", kv.ListOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"b/c"}, res.Keys, "a key containing / stays in its own path")
+}
+
+// TestKVListPages verifies that a listing without a limit follows every continuation token
+func TestKVListPages(t *testing.T) {
+	ctx := context.Background()
+	bucket := newFakeBucket()
+	store := newTestKV(t, bucket)
+	var want []string
+	for i := 0; i < 2500; i++ {
+		k := fmt.Sprintf("k%04d", i)
+		want = append(want, k)
+		bucket.objects[store.kvKey("/a", k)] = fakeObject{body: []byte(`1`)}
+	}
+	bucket.lists = 0
+	res, err := store.List(ctx, "/a", kv.ListOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, want, res.Keys)
+	assert.Empty(t, res.Cursor)
+	assert.Equal(t, 3, bucket.lists)
+}
+
+// TestKVListMetadata verifies that listing keys reads only their metadata, and leaves out expired ones
+func TestKVListMetadata(t *testing.T) {
+	ctx := context.Background()
+	bucket := newFakeBucket()
+	store := newTestKV(t, bucket)
+	assert.NoError(t, store.Put(ctx, "/a", "live", []byte(`1`)))
+	assert.NoError(t, store.PutMany(ctx, "/a", []kv.Entry{{Key: "later", Value: []byte(`2`), ExpiresAt: time.Now().Add(time.Hour)}}))
+	bucket.objects[store.kvKey("/a", "gone")] = fakeObject{
+		body:     []byte(`3`),
+		metadata: map[string]string{metadataExpiresAt: strconv.FormatInt(time.Now().Add(-time.Hour).UnixNano(), 10)},
+	}
+
+	bucket.gets = 0
+	res, err := store.List(ctx, "/a", kv.ListOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"later", "live"}, res.Keys)
+	assert.Zero(t, bucket.gets, "values are not downloaded")
+
+	res, err = store.List(ctx, "/a", kv.ListOptions{IncludeValues: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"later", "live"}, res.Keys)
+	assert.Equal(t, [][]byte{[]byte(`2`), []byte(`1`)}, res.Values)
+}
+
+// TestKVRollback verifies that a failed batch leaves earlier values in place
+func TestKVRollback(t *testing.T) {
+	ctx := context.Background()
+	bucket := newFakeBucket()
+	store := newTestKV(t, bucket)
+	assert.NoError(t, store.Put(ctx, "/a", "k", []byte(`1`)))
+	bucket.failKey = store.kvKey("/a", "new")
+	assert.Error(t, store.PutMany(ctx, "/a", []kv.Entry{
+		{Key: "k", Value: []byte(`2`)},
+		{Key: "other", Value: []byte(`3`)},
+		{Key: "new", Value: []byte(`4`)},
+	}))
+	vals, err := store.GetMany(ctx, "/a", []string{"k", "other", "new"})
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte(`1`), nil, nil}, vals)
+}
+
+// TestSaverSkipsKV verifies that saved paths and KV objects stay apart
+func TestSaverSkipsKV(t *testing.T) {
+	ctx := context.Background()
+	bucket := newFakeBucket()
+	store := newTestKV(t, bucket)
+	assert.NoError(t, store.Put(ctx, "/a", "k", []byte(`1`)))
+	sa := store.saver
+
+	u, _ := url.Parse("http://localhost/_kv/%252Fa/k")
+	assert.ErrorIs(t, sa.SaveText(ctx, "overwrite", *u, &hput.PutResult{}), errKVPath)
+	r, err := sa.GetRunnable(ctx, *u)
+	assert.NoError(t, err)
+	assert.Equal(t, hput.Runnable{}, r)
+
+	u, _ = url.Parse("http://localhost/page")
+	assert.NoError(t, sa.SaveText(ctx, "hello", *u, &hput.PutResult{}))
+	var paths []string
+	for e, err := range sa.List(ctx, "/", "", 0) {
+		assert.NoError(t, err)
+		paths = append(paths, e.Path)
+	}
+	assert.Equal(t, []string{"/page"}, paths)
+}
@@ -6,13 +6,19 @@ import (
 	"errors"
 	"fmt"
 	"hput"
+	"io"
 	"io/ioutil"
+	"iter"
+	"net/http"
 	"net/url"
+	"strings"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
-	ssotypes "github.com/aws/aws-sdk-go-v2/service/sso/types"
+	"github.com/aws/smithy-go"
 )
 
 const metadataInput = "input"
@@ -24,10 +30,11 @@ type Logger interface {
 }
 
 type S3Saver struct {
-	Logger Logger
-	Client client
-	Prefix string
-	Bucket string
+	Logger    Logger
+	Client    client
+	Prefix    string
+	Bucket    string
+	Retention hput.Retention // which earlier versions of each path to keep
 }
 
 // client models the s3 client
@@ -35,10 +42,29 @@ type client interface {
 	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
 	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
 	ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error)
+	DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
+	CopyObject(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error)
+	CreateMultipartUpload(ctx context.Context, params *s3.CreateMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error)
+	UploadPart(ctx context.Context, params *s3.UploadPartInput, optFns ...func(*s3.Options)) (*s3.UploadPartOutput, error)
+	CompleteMultipartUpload(ctx context.Context, params *s3.CompleteMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error)
+	AbortMultipartUpload(ctx context.Context, params *s3.AbortMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error)
+	HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
 }
 
+// errKVPath is returned when a path would overwrite objects kept by S3KV.
+var errKVPath = errors.New("paths under /_kv/ are reserved for KV storage")
+
 type option interface {
-	apply(s *S3Saver) error
+	apply(s *S3Saver, c *clientConfig) error
+}
+
+// clientConfig is how New creates the S3 client, unless S3ClientOption
+// gives one.
+type clientConfig struct {
+	endpoint  string
+	region    string
+	pathStyle bool
+	creds     aws.CredentialsProvider
 }
 
 // PrefixOption sets the prefix for the S3 bucket
@@ -46,7 +72,7 @@ type PrefixOption struct {
 	Prefix string
 }
 
-func (p PrefixOption) apply(s *S3Saver) error {
+func (p PrefixOption) apply(s *S3Saver, _ *clientConfig) error {
 	s.Prefix = p.Prefix
 	return nil
 }
@@ -56,39 +82,127 @@ type S3ClientOption struct {
 	client client
 }
 
-func (o S3ClientOption) apply(s *S3Saver) error {
+func (o S3ClientOption) apply(s *S3Saver, _ *clientConfig) error {
 	s.Client = o.client
 	return nil
 }
 
-func New(ctx context.Context, l Logger, b string, options ...option) (S3Saver, error) {
-	cfg, err := config.LoadDefaultConfig(ctx)
-	if err != nil {
-		l.Errorf("failed to load config: %v", err)
-		return S3Saver{}, err
+// EndpointOption sends requests to an S3-compatible store, such as MinIO,
+// Ceph or LocalStack, rather than AWS. Most of these also need
+// PathStyleOption.
+type EndpointOption struct {
+	URL string // e.g. http://localhost:9000; empty means AWS
+}
+
+func (o EndpointOption) apply(_ *S3Saver, c *clientConfig) error {
+	if o.URL == "" {
+		return nil
+	}
+	u, err := url.Parse(o.URL)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("endpoint %q must be a URL like http://localhost:9000", o.URL)
+	}
+	c.endpoint = o.URL
+	return nil
+}
+
+// RegionOption sets the region, overriding AWS_REGION and the shared config.
+type RegionOption struct {
+	Region string // empty leaves the region to AWS_REGION and the shared config
+}
+
+func (o RegionOption) apply(_ *S3Saver, c *clientConfig) error {
+	c.region = o.Region
+	return nil
+}
+
+// PathStyleOption addresses the bucket in the URL path, as in
+// http://host/bucket/key, rather than as a subdomain.
+type PathStyleOption struct {
+	PathStyle bool
+}
+
+func (o PathStyleOption) apply(_ *S3Saver, c *clientConfig) error {
+	c.pathStyle = o.PathStyle
+	return nil
+}
+
+// CredentialsOption signs requests with a fixed access key, rather than
+// looking one up in the environment and shared config.
+type CredentialsOption struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+func (o CredentialsOption) apply(_ *S3Saver, c *clientConfig) error {
+	if o.AccessKeyID == "" || o.SecretAccessKey == "" {
+		return errors.New("credentials need an access key ID and a secret access key")
 	}
-	// Create Session
-	client := s3.NewFromConfig(cfg)
+	c.creds = credentials.NewStaticCredentialsProvider(o.AccessKeyID, o.SecretAccessKey, o.SessionToken)
+	return nil
+}
+
+func New(ctx context.Context, l Logger, b string, options ...option) (S3Saver, error) {
 	if b == "" {
 		return S3Saver{}, errors.New("bucket must be provided")
 	}
 	sa := S3Saver{
 		Logger: l,
-		Client: client,
 		Bucket: b,
 	}
+	var c clientConfig
 	for _, o := range options {
-		err = o.apply(&sa)
+		err := o.apply(&sa, &c)
+		if err != nil {
+			return S3Saver{}, err
+		}
+	}
+	if sa.Client == nil {
+		client, err := newClient(ctx, c)
 		if err != nil {
+			l.Errorf("failed to load config: %v", err)
 			return S3Saver{}, err
 		}
+		sa.Client = client
 	}
 	return sa, nil
 }
 
+// newClient creates an S3 client from the default config and c.
+func newClient(ctx context.Context, c clientConfig) (*s3.Client, error) {
+	var opts []func(*config.LoadOptions) error
+	if c.region == "" && c.endpoint != "" {
+		// S3-compatible stores mostly ignore the region, but requests must be signed with one.
+		c.region = "us-east-1"
+	}
+	if c.region != "" {
+		opts = append(opts, config.WithRegion(c.region))
+	}
+	if c.creds != nil {
+		opts = append(opts, config.WithCredentialsProvider(c.creds))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if c.endpoint != "" {
+			o.BaseEndpoint = aws.String(c.endpoint)
+			// Not every S3-compatible store accepts the checksums AWS now adds by default.
+			o.RequestChecksumCalculation = aws.RequestChecksumCalculationWhenRequired
+			o.ResponseChecksumValidation = aws.ResponseChecksumValidationWhenRequired
+		}
+		o.UsePathStyle = c.pathStyle
+	}), nil
+}
+
 // SaveText saves text to the configured bucket and prefix at the provided path
 func (sa S3Saver) SaveText(ctx context.Context, s string, p url.URL, r *hput.PutResult) error {
 	key := sa.getKey(p.Path)
+	if err := sa.reserved(key); err != nil {
+		return err
+	}
 	exists, err := sa.checkExists(ctx, key)
 	if err != nil {
 		return fmt.Errorf("failed to check if text exists: %w", err)
@@ -100,12 +214,20 @@ func (sa S3Saver) SaveText(ctx context.Context, s string, p url.URL, r *hput.Put
 		Metadata: map[string]string{
 			metadataInput: string(hput.Text),
 		},
-		Body: bytes.NewBufferString(s),
+		Body: strings.NewReader(s),
 	}
-	_, err = sa.Client.PutObject(ctx, &i)
+	ru := hput.Runnable{Path: p.Path, Type: hput.Text, Text: s}
+	err = sa.saveRunnable(ctx, key, ru, func() error {
+		var err error
+		exists, err = sa.putObject(ctx, &i, exists)
+		if err != nil {
+			sa.Logger.Errorf("failed to put string: %v", err)
+			return fmt.Errorf("failed to put string: %w", err)
+		}
+		return nil
+	})
 	if err != nil {
-		sa.Logger.Errorf("failed to put string: %v", err)
-		return fmt.Errorf("failed to put string: %w", err)
+		return err
 	}
 	r.Overwrote = exists
 	return nil
@@ -114,6 +236,9 @@ func (sa S3Saver) SaveText(ctx context.Context, s string, p url.URL, r *hput.Put
 // SaveCode saves code as text to the configured bucket and prefix at the provided path
 func (sa S3Saver) SaveCode(ctx context.Context, c string, p url.URL, r *hput.PutResult) error {
 	key := sa.getKey(p.Path)
+	if err := sa.reserved(key); err != nil {
+		return err
+	}
 	exists, err := sa.checkExists(ctx, key)
 	if err != nil {
 		return fmt.Errorf("failed to check if code exists: %w", err)
@@ -125,12 +250,20 @@ func (sa S3Saver) SaveCode(ctx context.Context, c string, p url.URL, r *hput.Put
 		Metadata: map[string]string{
 			metadataInput: string(hput.Js),
 		},
-		Body: bytes.NewBufferString(c),
+		Body: strings.NewReader(c),
 	}
-	_, err = sa.Client.PutObject(ctx, &i)
+	ru := hput.Runnable{Path: p.Path, Type: hput.Js, Text: c}
+	err = sa.saveRunnable(ctx, key, ru, func() error {
+		var err error
+		exists, err = sa.putObject(ctx, &i, exists)
+		if err != nil {
+			sa.Logger.Errorf("failed to put code: %v", err)
+			return fmt.Errorf("failed to put code: %w", err)
+		}
+		return nil
+	})
 	if err != nil {
-		sa.Logger.Errorf("failed to put code: %v", err)
-		return fmt.Errorf("failed to put code: %w", err)
+		return err
 	}
 	r.Overwrote = exists
 	return nil
@@ -139,6 +272,9 @@ func (sa S3Saver) SaveCode(ctx context.Context, c string, p url.URL, r *hput.Put
 // SaveBinary saves code as text to the configured bucket and prefix at the provided path
 func (sa S3Saver) SaveBinary(ctx context.Context, b []byte, p url.URL, r *hput.PutResult) error {
 	key := sa.getKey(p.Path)
+	if err := sa.reserved(key); err != nil {
+		return err
+	}
 	exists, err := sa.checkExists(ctx, key)
 	if err != nil {
 		return fmt.Errorf("failed to check if binary exists: %w", err)
@@ -150,39 +286,104 @@ func (sa S3Saver) SaveBinary(ctx context.Context, b []byte, p url.URL, r *hput.P
 		Metadata: map[string]string{
 			metadataInput: string(hput.Binary),
 		},
-		Body: bytes.NewBuffer(b),
+		Body: bytes.NewReader(b),
 	}
-	_, err = sa.Client.PutObject(ctx, &i)
+	ru := hput.Runnable{Path: p.Path, Type: hput.Binary, Binary: b}
+	err = sa.saveRunnable(ctx, key, ru, func() error {
+		var err error
+		exists, err = sa.putObject(ctx, &i, exists)
+		if err != nil {
+			sa.Logger.Errorf("failed to put binary: %v", err)
+			return fmt.Errorf("failed to put binary: %w", err)
+		}
+		return nil
+	})
 	if err != nil {
-		sa.Logger.Errorf("failed to put binary: %v", err)
-		return fmt.Errorf("failed to put binary: %w", err)
+		return err
 	}
 	r.Overwrote = exists
 	return nil
 }
 
+// checkExists reports if there is an object at key.
 func (sa S3Saver) checkExists(ctx context.Context, key string) (bool, error) {
-	exists := false
-	checkI := s3.GetObjectInput{
+	o, err := sa.headObject(ctx, key)
+	if err != nil {
+		sa.Logger.Errorf("failed to check if object exists: %v", err)
+		return false, fmt.Errorf("failed to check if object exists: %w", err)
+	}
+	return o != nil, nil
+}
+
+// headObject returns the metadata and size of the object at key, without
+// its body, or nil if there is no object.
+func (sa S3Saver) headObject(ctx context.Context, key string) (*s3.HeadObjectOutput, error) {
+	o, err := sa.Client.HeadObject(ctx, &s3.HeadObjectInput{
 		Bucket: &sa.Bucket,
 		Key:    &key,
-	}
-	_, err := sa.Client.GetObject(ctx, &checkI)
+	})
 	if err != nil {
-		var notFoundErr *types.NoSuchKey
-		var noAccessErr *ssotypes.UnauthorizedException
-		if !errors.As(err, &notFoundErr) && !errors.As(err, &noAccessErr) {
-			// If you don't have listBucket and the object isn't there, you get UnauthorizedException
-			sa.Logger.Errorf("failed to check if object exists: %v", err)
-			return exists, fmt.Errorf("failed to check if object exists: %w", err)
+		var notFoundErr *types.NotFound
+		var noSuchKeyErr *types.NoSuchKey
+		if errors.As(err, &notFoundErr) || errors.As(err, &noSuchKeyErr) {
+			return nil, nil
 		}
-		exists = true
+		return nil, err
 	}
-	return exists, nil
+	return o, nil
+}
+
+// apiErrorCode returns the S3 error code of err, or "" if S3 did not answer with one.
+func apiErrorCode(err error) string {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorCode()
+	}
+	return ""
+}
+
+// conditionFailed reports, for an error from a write conditional on there
+// being no object, if it should be retried unconditionally and if that is
+// because an object was written first. Stores that do not support
+// conditional writes answer NotImplemented.
+func conditionFailed(err error) (retry, exists bool) {
+	switch apiErrorCode(err) {
+	case "PreconditionFailed", "ConditionalRequestConflict":
+		return true, true
+	case "NotImplemented":
+		return true, false
+	}
+	return false, false
+}
+
+// putObject puts in and reports if it replaced an object. If exists is
+// false, the put is conditional on there still being no object, so a save
+// racing this one to create the key is still seen as overwriting.
+func (sa S3Saver) putObject(ctx context.Context, in *s3.PutObjectInput, exists bool) (bool, error) {
+	if !exists {
+		in.IfNoneMatch = aws.String("*")
+		_, err := sa.Client.PutObject(ctx, in)
+		retry, overwrote := conditionFailed(err)
+		if !retry {
+			return false, err
+		}
+		if s, ok := in.Body.(io.Seeker); ok {
+			if _, err := s.Seek(0, io.SeekStart); err != nil {
+				return false, err
+			}
+		}
+		in.IfNoneMatch = nil
+		exists = overwrote
+	}
+	_, err := sa.Client.PutObject(ctx, in)
+	return exists, err
 }
 
 // getRunnableFromKey returns the runnable associated with the exact key
 func (sa S3Saver) getRunnableFromKey(ctx context.Context, key string) (hput.Runnable, error) {
+	if sa.reserved(key) != nil {
+		return hput.Runnable{}, nil
+	}
 	i := s3.GetObjectInput{
 		Bucket: &sa.Bucket,
 		Key:    &key,
@@ -190,9 +391,7 @@ func (sa S3Saver) getRunnableFromKey(ctx context.Context, key string) (hput.Runn
 	o, err := sa.Client.GetObject(ctx, &i)
 	if err != nil {
 		var notFoundErr *types.NoSuchKey
-		var noAccessErr *ssotypes.UnauthorizedException
-		// If you don't have listBucket and the object isn't there, you get ResourceNotFoundException
-		if !errors.As(err, &notFoundErr) && !errors.As(err, &noAccessErr) {
+		if !errors.As(err, &notFoundErr) {
 			sa.Logger.Errorf("failed access runnable: %v", err)
 			return hput.Runnable{}, fmt.Errorf("failed access runnable: %w", err)
 		}
@@ -201,28 +400,99 @@ func (sa S3Saver) getRunnableFromKey(ctx context.Context, key string) (hput.Runn
 		return hput.Runnable{}, nil
 	}
 	sa.Logger.Debugf("s3 object found: %#v with metadata: %+v", o, o.Metadata)
-	r := hput.Runnable{
-		Path: key[len(sa.Prefix):],
-		Type: hput.Input(o.Metadata[metadataInput]),
-	}
 	bts, err := ioutil.ReadAll(o.Body)
 	if err != nil {
 		sa.Logger.Errorf("failed to read runnable: %v", err)
 		return hput.Runnable{}, fmt.Errorf("failed to read runnable: %w", err)
 	}
-	sa.Logger.Debugf("runnable type found: %s", o.Metadata[metadataInput])
-	switch o.Metadata[metadataInput] {
-	case string(hput.Text), string(hput.Js):
+	typ := hput.Input(o.Metadata[metadataInput])
+	switch typ {
+	case hput.Text, hput.Js, hput.Binary:
+		sa.Logger.Debugf("runnable type found: %s", typ)
+	default:
+		typ = sniffType(bts)
+		sa.Logger.Debugf("runnable type sniffed: %s", typ)
+	}
+	r := hput.Runnable{Path: key[len(sa.Prefix):], Type: typ}
+	switch typ {
+	case hput.Text, hput.Js:
 		r.Text = string(bts)
-	case string(hput.Binary):
+	case hput.Binary:
 		r.Binary = bts
 	default:
-		sa.Logger.Errorf("unknown runnable type: %v", o.Metadata[metadataInput])
-		return hput.Runnable{}, fmt.Errorf("unknown runnable type: %v", o.Metadata[metadataInput])
+		return hput.Runnable{}, nil
 	}
 	return r, nil
 }
 
+// sniffHead is how much of an object sniffType reads.
+const sniffHead = 512
+
+// sniffType types an object S3Saver did not write, such as one put under
+// a base prefix by another tool, from head, the start of its body: text if
+// it looks like text and binary otherwise. It is never code, so nothing
+// uploaded elsewhere is run. An empty object has no type.
+func sniffType(head []byte) hput.Input {
+	if len(head) == 0 {
+		return ""
+	}
+	if strings.HasPrefix(http.DetectContentType(head), "text/") {
+		return hput.Text
+	}
+	return hput.Binary
+}
+
+// readHead reads up to sniffHead bytes from the start of r.
+func readHead(r io.Reader) ([]byte, error) {
+	head := make([]byte, sniffHead)
+	n, err := io.ReadFull(r, head)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, err
+	}
+	return head[:n], nil
+}
+
+// sniffObject types the object at key, as sniffType does, downloading only
+// the start of it.
+func (sa S3Saver) sniffObject(ctx context.Context, key string) (hput.Input, error) {
+	o, err := sa.Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: &sa.Bucket,
+		Key:    &key,
+		Range:  aws.String(fmt.Sprintf("bytes=0-%d", sniffHead-1)),
+	})
+	if err != nil {
+		var notFoundErr *types.NoSuchKey
+		if errors.As(err, &notFoundErr) {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to get object: %w", err)
+	}
+	defer o.Body.Close()
+	head, err := readHead(o.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read object: %w", err)
+	}
+	return sniffType(head), nil
+}
+
+// Delete removes the object at a path, keeping its versions. Deleting a
+// path with nothing saved succeeds.
+func (sa S3Saver) Delete(ctx context.Context, p url.URL) error {
+	key := sa.getKey(p.Path)
+	if err := sa.reserved(key); err != nil {
+		return err
+	}
+	_, err := sa.Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: &sa.Bucket,
+		Key:    &key,
+	})
+	if err != nil {
+		sa.Logger.Errorf("failed to delete object: %v", err)
+		return fmt.Errorf("failed to delete object: %w", err)
+	}
+	return nil
+}
+
 // GetRunnable returns a runnable from an S3 location associated with the path
 func (sa S3Saver) GetRunnable(ctx context.Context, p url.URL) (hput.Runnable, error) {
 	key := sa.getKey(p.Path)
@@ -233,35 +503,101 @@ func (sa S3Saver) getKey(path string) string {
 	return sa.Prefix + path
 }
 
-// SendRunnables stream out a list of runnables associated with a path
-func (sa S3Saver) SendRunnables(ctx context.Context, p string, runnables chan<- hput.Runnable, done chan<- bool) error {
-	prefix := sa.getKey(p)
+// listParallelism is how many objects List reads the metadata of at once.
+const listParallelism = 8
+
+// statted is an object List read the metadata of.
+type statted struct {
+	e   hput.Entry
+	err error
+}
+
+// List yields every path in the bucket with a prefix of the given one that
+// sorts after cursor, in key order. Types and save times are in each
+// object's metadata, which is read with HeadObject listParallelism objects
+// at a time, so no body is downloaded. Objects hput did not write have only
+// their start downloaded, to type them as sniffType does, and empty ones
+// are skipped.
+func (sa S3Saver) List(ctx context.Context, prefix, cursor string, limit int) iter.Seq2[hput.Entry, error] {
+	return func(yield func(hput.Entry, error) bool) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		pending := make(chan chan statted, listParallelism)
+		listErr := make(chan error, 1)
+		go func() {
+			defer close(pending)
+			listErr <- sa.statObjects(ctx, prefix, cursor, limit, pending)
+		}()
+		n := 0
+		for s := range pending {
+			res := <-s
+			if res.err != nil {
+				sa.Logger.Errorf("failed to read object for list: %v", res.err)
+				yield(hput.Entry{}, fmt.Errorf("failed to read object for list: %w", res.err))
+				return
+			}
+			if res.e.Type == "" {
+				continue
+			}
+			if limit > 0 && n == limit {
+				return
+			}
+			if !yield(res.e, nil) {
+				return
+			}
+			n++
+		}
+		if err := <-listErr; err != nil {
+			sa.Logger.Errorf("failed to list objects: %v", err)
+			yield(hput.Entry{}, fmt.Errorf("failed to list objects: %w", err))
+		}
+	}
+}
+
+// statObjects lists the keys of paths with prefix after cursor and reads
+// the metadata of each in its own goroutine, queuing where its entry will
+// arrive on pending in key order. The queue's capacity bounds how many are
+// read ahead of being yielded.
+func (sa S3Saver) statObjects(ctx context.Context, prefix, cursor string, limit int, pending chan<- chan statted) error {
+	keyPrefix := sa.getKey(prefix)
 	in := s3.ListObjectsV2Input{
 		Bucket: &sa.Bucket,
-		Prefix: &prefix,
+		Prefix: &keyPrefix,
+	}
+	if cursor != "" {
+		in.StartAfter = aws.String(sa.getKey(cursor))
+	}
+	if limit > 0 && limit < 1000 {
+		in.MaxKeys = aws.Int32(int32(limit))
 	}
 	for {
 		res, err := sa.Client.ListObjectsV2(ctx, &in)
 		if err != nil {
-			sa.Logger.Errorf("failed to list objects: %v", err)
-			done <- true
-			return fmt.Errorf("failed to list objects: %w", err)
+			return err
 		}
 		for _, obj := range res.Contents {
 			key := *obj.Key
-			r, err := sa.getRunnableFromKey(ctx, key)
-			if err != nil {
-				sa.Logger.Errorf("failed to get runnable for list: %v", err)
-				done <- true
-				return fmt.Errorf("failed to get runnable for list: %w", err)
+			if sa.reserved(key) != nil {
+				continue
+			}
+			s := make(chan statted, 1)
+			select {
+			case pending <- s:
+			case <-ctx.Done():
+				return ctx.Err()
 			}
-			runnables <- r
+			go func() {
+				info, err := sa.statObject(ctx, key)
+				e := hput.Entry{Path: key[len(sa.Prefix):], Type: info.Type, Size: info.Size, Modified: info.Saved}
+				if e.Modified.IsZero() {
+					e.Modified = aws.ToTime(obj.LastModified)
+				}
+				s <- statted{e: e, err: err}
+			}()
 		}
 		if res.NextContinuationToken == nil {
-			done <- true
 			return nil
 		}
 		in.ContinuationToken = res.NextContinuationToken
 	}
-
 }
@@ -9,11 +9,15 @@ import (
 	"io"
 	"io/ioutil"
 	"net/url"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -29,8 +33,13 @@ type testS3Client struct {
 	GetObjectInput      []*s3.GetObjectInput
 	GetObjectOutput     *s3.GetObjectOutput
 	GetObjectError      error
+	HeadObjectInput     []*s3.HeadObjectInput
+	HeadObjectOutput    *s3.HeadObjectOutput
+	HeadObjectError     error
 	ListObjectsV2Output map[string]*s3.ListObjectsV2Output
+	DeleteObjectInput   []*s3.DeleteObjectInput
 	outputBodyBytes     *[]byte
+	mu                  sync.Mutex // HeadObject is called concurrently by List
 }
 
 func (c *testS3Client) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
@@ -40,24 +49,67 @@ func (c *testS3Client) PutObject(ctx context.Context, params *s3.PutObjectInput,
 
 func (c *testS3Client) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
 	c.GetObjectInput = append(c.GetObjectInput, params)
+	if c.GetObjectOutput == nil && c.GetObjectError == nil {
+		// an object hput did not write
+		return &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(nil))}, nil
+	}
 	if c.GetObjectOutput != nil && c.GetObjectOutput.Body != nil {
 		// preserve outgoing body bytes so they can be resent
 		if c.outputBodyBytes == nil {
 			outputBodyBytes, _ := io.ReadAll(c.GetObjectOutput.Body)
 			c.outputBodyBytes = &outputBodyBytes
 		}
-		if c.outputBodyBytes != nil {
-			c.GetObjectOutput.Body = ioutil.NopCloser(bytes.NewBuffer(*c.outputBodyBytes))
-		}
+		o := *c.GetObjectOutput
+		o.Body = ioutil.NopCloser(bytes.NewBuffer(*c.outputBodyBytes))
+		return &o, c.GetObjectError
 	}
 	return c.GetObjectOutput, c.GetObjectError
 }
 
+func (c *testS3Client) HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.HeadObjectInput = append(c.HeadObjectInput, params)
+	if c.HeadObjectOutput == nil && c.HeadObjectError == nil {
+		return nil, &types.NotFound{}
+	}
+	return c.HeadObjectOutput, c.HeadObjectError
+}
+
+func (c *testS3Client) DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	c.DeleteObjectInput = append(c.DeleteObjectInput, params)
+	return nil, nil
+}
+
+func (c *testS3Client) CopyObject(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
+	return &s3.CopyObjectOutput{}, nil
+}
+
+func (c *testS3Client) CreateMultipartUpload(ctx context.Context, params *s3.CreateMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (c *testS3Client) UploadPart(ctx context.Context, params *s3.UploadPartInput, optFns ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (c *testS3Client) CompleteMultipartUpload(ctx context.Context, params *s3.CompleteMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (c *testS3Client) AbortMultipartUpload(ctx context.Context, params *s3.AbortMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error) {
+	return nil, errors.New("not implemented")
+}
+
 func (c *testS3Client) ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
-	if params.ContinuationToken == nil {
-		return c.ListObjectsV2Output[""], nil
+	token := ""
+	if params.ContinuationToken != nil {
+		token = *params.ContinuationToken
 	}
-	return c.ListObjectsV2Output[*params.ContinuationToken], nil
+	if out, ok := c.ListObjectsV2Output[token]; ok {
+		return out, nil
+	}
+	return &s3.ListObjectsV2Output{}, nil
 }
 
 // TestSaveText test that texts can be saved to s3
@@ -74,10 +126,11 @@ func TestSaveText(t *testing.T) {
 			res:  &hput.PutResult{},
 			c:    &testS3Client{},
 			in: []*s3.PutObjectInput{{
-				Bucket:   aws.String("bucket"),
-				Body:     bytes.NewBufferString("text"),
-				Key:      aws.String("/path"),
-				Metadata: map[string]string{"input": "Text"},
+				Bucket:      aws.String("bucket"),
+				Body:        strings.NewReader("text"),
+				Key:         aws.String("/path"),
+				IfNoneMatch: aws.String("*"),
+				Metadata:    map[string]string{"input": "Text"},
 			}},
 		},
 		{
@@ -86,11 +139,11 @@ func TestSaveText(t *testing.T) {
 				Overwrote: true,
 			},
 			c: &testS3Client{
-				GetObjectError: &types.NoSuchKey{},
+				HeadObjectOutput: &s3.HeadObjectOutput{},
 			},
 			in: []*s3.PutObjectInput{{
 				Bucket:   aws.String("bucket"),
-				Body:     bytes.NewBufferString("text"),
+				Body:     strings.NewReader("text"),
 				Key:      aws.String("/path"),
 				Metadata: map[string]string{"input": "Text"},
 			}},
@@ -101,10 +154,11 @@ func TestSaveText(t *testing.T) {
 			c:    &testS3Client{PutObjectInputError: errors.New("error")},
 			err:  fmt.Errorf("failed to put string: %w", errors.New("error")),
 			in: []*s3.PutObjectInput{{
-				Bucket:   aws.String("bucket"),
-				Body:     bytes.NewBufferString("text"),
-				Key:      aws.String("/path"),
-				Metadata: map[string]string{"input": "Text"},
+				Bucket:      aws.String("bucket"),
+				Body:        strings.NewReader("text"),
+				Key:         aws.String("/path"),
+				IfNoneMatch: aws.String("*"),
+				Metadata:    map[string]string{"input": "Text"},
 			}},
 		},
 	}
@@ -118,7 +172,8 @@ func TestSaveText(t *testing.T) {
 			err = s.SaveText(ctx, "text", *url, r)
 			assert.Equal(t, test.err, err)
 			assert.Equal(t, test.res, r)
-			assert.Equal(t, test.in, test.c.PutObjectInput)
+			// later puts keep the text as a version
+			assert.Equal(t, test.in, test.c.PutObjectInput[:len(test.in)])
 		})
 	}
 }
@@ -137,10 +192,11 @@ func TestSaveCode(t *testing.T) {
 			res:  &hput.PutResult{},
 			c:    &testS3Client{},
 			in: &s3.PutObjectInput{
-				Bucket:   aws.String("bucket"),
-				Body:     bytes.NewBufferString("code"),
-				Key:      aws.String("/path"),
-				Metadata: map[string]string{"input": "Javascript"},
+				Bucket:      aws.String("bucket"),
+				Body:        strings.NewReader("code"),
+				Key:         aws.String("/path"),
+				IfNoneMatch: aws.String("*"),
+				Metadata:    map[string]string{"input": "Javascript"},
 			},
 		},
 		{
@@ -149,11 +205,11 @@ func TestSaveCode(t *testing.T) {
 				Overwrote: true,
 			},
 			c: &testS3Client{
-				GetObjectError: &types.NoSuchKey{},
+				HeadObjectOutput: &s3.HeadObjectOutput{},
 			},
 			in: &s3.PutObjectInput{
 				Bucket:   aws.String("bucket"),
-				Body:     bytes.NewBufferString("code"),
+				Body:     strings.NewReader("code"),
 				Key:      aws.String("/path"),
 				Metadata: map[string]string{"input": "Javascript"},
 			},
@@ -164,10 +220,11 @@ func TestSaveCode(t *testing.T) {
 			c:    &testS3Client{PutObjectInputError: errors.New("error")},
 			err:  fmt.Errorf("failed to put code: %w", errors.New("error")),
 			in: &s3.PutObjectInput{
-				Bucket:   aws.String("bucket"),
-				Body:     bytes.NewBufferString("code"),
-				Key:      aws.String("/path"),
-				Metadata: map[string]string{"input": "Javascript"},
+				Bucket:      aws.String("bucket"),
+				Body:        strings.NewReader("code"),
+				Key:         aws.String("/path"),
+				IfNoneMatch: aws.String("*"),
+				Metadata:    map[string]string{"input": "Javascript"},
 			},
 		},
 	}
@@ -200,10 +257,11 @@ func TestSaveBinary(t *testing.T) {
 			res:  &hput.PutResult{},
 			c:    &testS3Client{},
 			in: &s3.PutObjectInput{
-				Bucket:   aws.String("bucket"),
-				Body:     bytes.NewBuffer([]byte{255, 255, 255}),
-				Key:      aws.String("/path"),
-				Metadata: map[string]string{"input": "Binary"},
+				Bucket:      aws.String("bucket"),
+				Body:        bytes.NewReader([]byte{255, 255, 255}),
+				Key:         aws.String("/path"),
+				IfNoneMatch: aws.String("*"),
+				Metadata:    map[string]string{"input": "Binary"},
 			},
 		},
 		{
@@ -212,11 +270,11 @@ func TestSaveBinary(t *testing.T) {
 				Overwrote: true,
 			},
 			c: &testS3Client{
-				GetObjectError: &types.NoSuchKey{},
+				HeadObjectOutput: &s3.HeadObjectOutput{},
 			},
 			in: &s3.PutObjectInput{
 				Bucket:   aws.String("bucket"),
-				Body:     bytes.NewBuffer([]byte{255, 255, 255}),
+				Body:     bytes.NewReader([]byte{255, 255, 255}),
 				Key:      aws.String("/path"),
 				Metadata: map[string]string{"input": "Binary"},
 			},
@@ -227,10 +285,11 @@ func TestSaveBinary(t *testing.T) {
 			c:    &testS3Client{PutObjectInputError: errors.New("error")},
 			err:  fmt.Errorf("failed to put binary: %w", errors.New("error")),
 			in: &s3.PutObjectInput{
-				Bucket:   aws.String("bucket"),
-				Body:     bytes.NewBuffer([]byte{255, 255, 255}),
-				Key:      aws.String("/path"),
-				Metadata: map[string]string{"input": "Binary"},
+				Bucket:      aws.String("bucket"),
+				Body:        bytes.NewReader([]byte{255, 255, 255}),
+				Key:         aws.String("/path"),
+				IfNoneMatch: aws.String("*"),
+				Metadata:    map[string]string{"input": "Binary"},
 			},
 		},
 	}
@@ -262,7 +321,7 @@ func TestGetRunnable(t *testing.T) {
 			name: "get text that exists",
 			c: &testS3Client{
 				GetObjectOutput: &s3.GetObjectOutput{
-					Body:     ioutil.NopCloser(bytes.NewBufferString("text")),
+					Body:     ioutil.NopCloser(strings.NewReader("text")),
 					Metadata: map[string]string{"input": "Text"},
 				},
 			},
@@ -280,7 +339,7 @@ func TestGetRunnable(t *testing.T) {
 			name: "get binary that exists",
 			c: &testS3Client{
 				GetObjectOutput: &s3.GetObjectOutput{
-					Body:     ioutil.NopCloser(bytes.NewBuffer([]byte{255, 255, 255})),
+					Body:     ioutil.NopCloser(bytes.NewReader([]byte{255, 255, 255})),
 					Metadata: map[string]string{"input": "Binary"},
 				},
 			},
@@ -320,51 +379,66 @@ func TestGetRunnable(t *testing.T) {
 	}
 }
 
-func TestSendRunnables(t *testing.T) {
+// TestList verifies that paths are listed across pages from object metadata, without downloading them
+func TestList(t *testing.T) {
+	listed := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	saved := listed.Add(-time.Hour)
 	tt := []struct {
-		name string
-		c    *testS3Client
-		r    []hput.Runnable
+		name    string
+		c       *testS3Client
+		cursor  string
+		limit   int
+		entries []hput.Entry
 	}{
 		{
-			name: "3 runnables to send from 2 pages",
+			name: "3 entries from 2 pages",
 			c: &testS3Client{
-				GetObjectOutput: &s3.GetObjectOutput{
-					Body:     ioutil.NopCloser(bytes.NewBufferString("text")),
-					Metadata: map[string]string{"input": "Text"},
+				HeadObjectOutput: &s3.HeadObjectOutput{
+					ContentLength: aws.Int64(4),
+					Metadata:      map[string]string{"input": "Text"},
 				},
 				ListObjectsV2Output: map[string]*s3.ListObjectsV2Output{
 					"": {
 						Contents: []types.Object{
-							{Key: aws.String("/path1")},
-							{Key: aws.String("/path2")},
+							{Key: aws.String("/path1"), LastModified: &listed},
+							{Key: aws.String("/path2"), LastModified: &listed},
 						},
 						NextContinuationToken: aws.String("token"),
 					},
 					"token": {
 						Contents: []types.Object{
-							{Key: aws.String("/path3")},
+							{Key: aws.String("/path3"), LastModified: &listed},
 						},
 					},
 				},
 			},
-			r: []hput.Runnable{
-				{
-					Path: "/path1",
-					Text: "text",
-					Type: hput.Text,
-				},
-				{
-					Path: "/path2",
-					Text: "text",
-					Type: hput.Text,
+			entries: []hput.Entry{
+				{Path: "/path1", Type: hput.Text, Size: 4, Modified: listed},
+				{Path: "/path2", Type: hput.Text, Size: 4, Modified: listed},
+				{Path: "/path3", Type: hput.Text, Size: 4, Modified: listed},
+			},
+		},
+		{
+			name: "a page after a cursor, with the save time from metadata",
+			c: &testS3Client{
+				HeadObjectOutput: &s3.HeadObjectOutput{
+					ContentLength: aws.Int64(2),
+					Metadata:      map[string]string{"input": "Binary", "saved": saved.Format(time.RFC3339Nano)},
 				},
-				{
-					Path: "/path3",
-					Text: "text",
-					Type: hput.Text,
+				ListObjectsV2Output: map[string]*s3.ListObjectsV2Output{
+					"": {
+						Contents: []types.Object{
+							{Key: aws.String("/path2"), LastModified: &listed},
+							{Key: aws.String("/path3"), LastModified: &listed},
+						},
+					},
 				},
 			},
+			cursor: "/path1",
+			limit:  1,
+			entries: []hput.Entry{
+				{Path: "/path2", Type: hput.Binary, Size: 2, Modified: saved},
+			},
 		},
 	}
 	for _, test := range tt {
@@ -372,22 +446,193 @@ func TestSendRunnables(t *testing.T) {
 			ctx := context.Background()
 			s, err := New(ctx, &testLogger{}, "bucket", S3ClientOption{client: test.c})
 			assert.NoError(t, err)
-			runnablesChan := make(chan hput.Runnable)
-			doneChan := make(chan bool)
-			sentRunnables := []hput.Runnable{}
-			go func() {
-				err = s.SendRunnables(ctx, "/path", runnablesChan, doneChan)
-			}()
+			var entries []hput.Entry
+			for e, err := range s.List(ctx, "/path", test.cursor, test.limit) {
+				assert.NoError(t, err)
+				entries = append(entries, e)
+			}
+			assert.Equal(t, test.entries, entries)
+			assert.Empty(t, test.c.GetObjectInput, "bodies are not downloaded")
+		})
+	}
+}
+
+// racingBucket is a fakeBucket where every object looks missing until it
+// is written, as if another save created it after the existence check.
+// Without conditional, it answers conditional writes with NotImplemented.
+type racingBucket struct {
+	*fakeBucket
+	conditional bool
+}
+
+func (b racingBucket) HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	return nil, &types.NotFound{}
+}
+
+func (b racingBucket) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	if !b.conditional && params.IfNoneMatch != nil {
+		return nil, &smithy.GenericAPIError{Code: "NotImplemented"}
+	}
+	return b.fakeBucket.PutObject(ctx, params, optFns...)
+}
+
+func (b racingBucket) CompleteMultipartUpload(ctx context.Context, params *s3.CompleteMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error) {
+	if !b.conditional && params.IfNoneMatch != nil {
+		return nil, &smithy.GenericAPIError{Code: "NotImplemented"}
+	}
+	return b.fakeBucket.CompleteMultipartUpload(ctx, params, optFns...)
+}
+
+// TestConditionalWrites verifies that a save creating a path only
+// overwrites what another save created meanwhile if it reports so
+func TestConditionalWrites(t *testing.T) {
+	tt := []struct {
+		name        string
+		conditional bool
+		existing    bool
+		overwrote   bool
+	}{
+		{name: "new path", conditional: true},
+		{name: "created meanwhile", conditional: true, existing: true, overwrote: true},
+		{name: "store without conditional writes", existing: true},
+	}
+	big := bytes.Repeat([]byte{1}, partSize+1)
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			bucket := newFakeBucket()
+			sa, err := New(ctx, &testLogger{}, "bucket", S3ClientOption{client: racingBucket{fakeBucket: bucket, conditional: test.conditional}})
+			assert.NoError(t, err)
+			if test.existing {
+				bucket.objects["/text"] = fakeObject{body: []byte("theirs")}
+				bucket.objects["/big"] = fakeObject{body: []byte("theirs")}
+			}
+
+			res := hput.PutResult{}
+			assert.NoError(t, sa.SaveText(ctx, "ours", url.URL{Path: "/text"}, &res))
+			assert.Equal(t, test.overwrote, res.Overwrote)
+			assert.Equal(t, []byte("ours"), bucket.objects["/text"].body)
+
+			res = hput.PutResult{}
+			assert.NoError(t, sa.SaveStream(ctx, bytes.NewReader(big), url.URL{Path: "/big"}, &res))
+			assert.Equal(t, test.overwrote, res.Overwrote)
+			assert.Equal(t, big, bucket.objects["/big"].body)
+		})
+	}
+}
+
+// failingBucket is a fakeBucket whose HeadObject fails for one key.
+type failingBucket struct {
+	*fakeBucket
+	failHead string
+}
+
+func (b failingBucket) HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	if *params.Key == b.failHead {
+		return nil, errors.New("boom")
+	}
+	return b.fakeBucket.HeadObject(ctx, params, optFns...)
+}
+
+// TestListConcurrently verifies that entries read concurrently are still
+// yielded in key order, and a failed read ends the list
+func TestListConcurrently(t *testing.T) {
+	tt := []struct {
+		name     string
+		failHead string
+		err      bool
+	}{
+		{name: "all read"},
+		{name: "one fails", failHead: "/p037", err: true},
+	}
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			bucket := newFakeBucket()
+			var want []hput.Entry
+			for i := range 5 * listParallelism {
+				p := fmt.Sprintf("/p%03d", i)
+				bucket.objects[p] = fakeObject{body: []byte(p), metadata: map[string]string{metadataInput: string(hput.Text)}}
+				want = append(want, hput.Entry{Path: p, Type: hput.Text, Size: int64(len(p))})
+			}
+			sa, err := New(ctx, &testLogger{}, "bucket", S3ClientOption{client: failingBucket{fakeBucket: bucket, failHead: test.failHead}})
 			assert.NoError(t, err)
-			for done := false; !done; {
-				select {
-				case r := <-runnablesChan:
-					sentRunnables = append(sentRunnables, r)
-				case <-doneChan:
-					done = true
+
+			var got []hput.Entry
+			for e, lerr := range sa.List(ctx, "/", "", 0) {
+				if lerr != nil {
+					err = lerr
+					break
 				}
+				got = append(got, e)
+			}
+			if test.err {
+				assert.Error(t, err)
+				assert.Equal(t, want[:37], got)
+				return
 			}
-			assert.Equal(t, test.r, sentRunnables)
+			assert.NoError(t, err)
+			assert.Equal(t, want, got)
+
+			got = nil
+			for e, err := range sa.List(ctx, "/", want[9].Path, 5) {
+				assert.NoError(t, err)
+				got = append(got, e)
+			}
+			assert.Equal(t, want[10:15], got, "a page starts after the cursor")
 		})
 	}
 }
+
+// TestSniffedObjects verifies objects hput did not write, such as those under a base prefix, are
+// typed by their content, and never as code
+func TestSniffedObjects(t *testing.T) {
+	ctx := context.Background()
+	bucket := newFakeBucket()
+	bucket.objects["base/page"] = fakeObject{body: []byte("<p>hello</p>")}
+	bucket.objects["base/script"] = fakeObject{body: []byte("1+1")}
+	bucket.objects["base/logo"] = fakeObject{body: []byte{0x89, 'P', 'N', 'G', 0, 0xff}}
+	bucket.objects["base/empty"] = fakeObject{}
+	sa, err := New(ctx, &testLogger{}, "bucket", S3ClientOption{client: bucket}, PrefixOption{Prefix: "base"})
+	assert.NoError(t, err)
+
+	tt := []struct {
+		path string
+		want hput.Runnable
+	}{
+		{path: "/page", want: hput.Runnable{Path: "/page", Type: hput.Text, Text: "<p>hello</p>"}},
+		{path: "/script", want: hput.Runnable{Path: "/script", Type: hput.Text, Text: "1+1"}},
+		{path: "/logo", want: hput.Runnable{Path: "/logo", Type: hput.Binary, Binary: []byte{0x89, 'P', 'N', 'G', 0, 0xff}}},
+		{path: "/empty"},
+	}
+	for _, test := range tt {
+		t.Run(test.path, func(t *testing.T) {
+			r, err := sa.GetRunnable(ctx, url.URL{Path: test.path})
+			assert.NoError(t, err)
+			assert.Equal(t, test.want, r)
+
+			rs, err := sa.GetStream(ctx, url.URL{Path: test.path})
+			assert.NoError(t, err)
+			if test.want.Type != hput.Binary {
+				assert.Nil(t, rs)
+				return
+			}
+			defer rs.Close()
+			b, err := io.ReadAll(rs)
+			assert.NoError(t, err)
+			assert.Equal(t, test.want.Binary, b)
+		})
+	}
+
+	var entries []hput.Entry
+	for e, err := range sa.List(ctx, "/", "", 0) {
+		assert.NoError(t, err)
+		e.Modified = time.Time{}
+		entries = append(entries, e)
+	}
+	assert.Equal(t, []hput.Entry{
+		{Path: "/logo", Type: hput.Binary, Size: 6},
+		{Path: "/page", Type: hput.Text, Size: 12},
+		{Path: "/script", Type: hput.Text, Size: 3},
+	}, entries)
+}
@@ -0,0 +1,267 @@
+package s3saver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hput"
+	"io"
+	"net/url"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// partSize is how much of a streamed binary is uploaded, and held in
+// memory, at a time. S3 needs every part but the last to be at least 5 MiB.
+const partSize = 8 << 20
+
+// SaveStream saves a binary read from b to the configured bucket and prefix
+// at the provided path. A binary larger than one part is sent as a
+// multipart upload, so it is never held in memory whole. Its version is a
+// server-side copy, which S3 limits to 5 GB.
+func (sa S3Saver) SaveStream(ctx context.Context, b io.Reader, p url.URL, r *hput.PutResult) error {
+	key := sa.getKey(p.Path)
+	if err := sa.reserved(key); err != nil {
+		return err
+	}
+	exists, err := sa.checkExists(ctx, key)
+	if err != nil {
+		return fmt.Errorf("failed to check if binary exists: %w", err)
+	}
+	err = sa.saveVersioned(ctx, key, p.Path, func() error {
+		var err error
+		exists, err = sa.upload(ctx, key, b, exists)
+		return err
+	}, func(id int, saved time.Time) error {
+		return sa.copyVersion(ctx, key, p.Path, id, hput.Binary, saved)
+	})
+	if err != nil {
+		return err
+	}
+	r.Overwrote = exists
+	return nil
+}
+
+// upload puts the binary read from b at key, in parts if it is larger than
+// one, and reports if it replaced an object. Like putObject, it only
+// creates the object if exists is false and there is still none.
+func (sa S3Saver) upload(ctx context.Context, key string, b io.Reader, exists bool) (bool, error) {
+	meta := map[string]string{metadataInput: string(hput.Binary)}
+	part := make([]byte, partSize)
+	n, err := io.ReadFull(b, part)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		exists, err = sa.putObject(ctx, &s3.PutObjectInput{
+			Bucket:   &sa.Bucket,
+			Key:      &key,
+			Metadata: meta,
+			Body:     bytes.NewReader(part[:n]),
+		}, exists)
+		if err != nil {
+			sa.Logger.Errorf("failed to put binary: %v", err)
+			return false, fmt.Errorf("failed to put binary: %w", err)
+		}
+		return exists, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read binary: %w", err)
+	}
+
+	mu, err := sa.Client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:   &sa.Bucket,
+		Key:      &key,
+		Metadata: meta,
+	})
+	if err != nil {
+		sa.Logger.Errorf("failed to start upload: %v", err)
+		return false, fmt.Errorf("failed to start upload: %w", err)
+	}
+	var parts []types.CompletedPart
+	for num := int32(1); n > 0; num++ {
+		out, err := sa.Client.UploadPart(ctx, &s3.UploadPartInput{
+			Bucket:     &sa.Bucket,
+			Key:        &key,
+			UploadId:   mu.UploadId,
+			PartNumber: aws.Int32(num),
+			Body:       bytes.NewReader(part[:n]),
+		})
+		if err != nil {
+			sa.abort(ctx, key, mu.UploadId)
+			sa.Logger.Errorf("failed to upload part %d: %v", num, err)
+			return false, fmt.Errorf("failed to upload part %d: %w", num, err)
+		}
+		parts = append(parts, types.CompletedPart{ETag: out.ETag, PartNumber: aws.Int32(num)})
+		n, err = io.ReadFull(b, part)
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			sa.abort(ctx, key, mu.UploadId)
+			return false, fmt.Errorf("failed to read binary: %w", err)
+		}
+	}
+	complete := s3.CompleteMultipartUploadInput{
+		Bucket:          &sa.Bucket,
+		Key:             &key,
+		UploadId:        mu.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	}
+	if !exists {
+		complete.IfNoneMatch = aws.String("*")
+	}
+	_, err = sa.Client.CompleteMultipartUpload(ctx, &complete)
+	if retry, overwrote := conditionFailed(err); !exists && retry {
+		complete.IfNoneMatch = nil
+		exists = overwrote
+		_, err = sa.Client.CompleteMultipartUpload(ctx, &complete)
+	}
+	if err != nil {
+		sa.abort(ctx, key, mu.UploadId)
+		sa.Logger.Errorf("failed to complete upload: %v", err)
+		return false, fmt.Errorf("failed to complete upload: %w", err)
+	}
+	return exists, nil
+}
+
+// abort cancels a multipart upload, so its parts are not kept or billed.
+func (sa S3Saver) abort(ctx context.Context, key string, uploadID *string) {
+	_, err := sa.Client.AbortMultipartUpload(context.WithoutCancel(ctx), &s3.AbortMultipartUploadInput{
+		Bucket:   &sa.Bucket,
+		Key:      &key,
+		UploadId: uploadID,
+	})
+	if err != nil {
+		sa.Logger.Errorf("failed to abort upload %s: %v", aws.ToString(uploadID), err)
+	}
+}
+
+// GetStream returns the binary at a path, or nil if the path does not hold
+// a binary. After a Seek, the next Read fetches the rest of the object from
+// the new position.
+func (sa S3Saver) GetStream(ctx context.Context, p url.URL) (io.ReadSeekCloser, error) {
+	key := sa.getKey(p.Path)
+	if sa.reserved(key) != nil {
+		return nil, nil
+	}
+	o, err := sa.Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: &sa.Bucket,
+		Key:    &key,
+	})
+	if err != nil {
+		var notFoundErr *types.NoSuchKey
+		if errors.As(err, &notFoundErr) {
+			return nil, nil
+		}
+		sa.Logger.Errorf("failed access binary: %v", err)
+		return nil, fmt.Errorf("failed access binary: %w", err)
+	}
+	body := o.Body
+	switch hput.Input(o.Metadata[metadataInput]) {
+	case hput.Binary:
+	case hput.Text, hput.Js:
+		o.Body.Close()
+		return nil, nil
+	default:
+		head, err := readHead(o.Body)
+		if err != nil || sniffType(head) != hput.Binary {
+			o.Body.Close()
+			if err != nil {
+				sa.Logger.Errorf("failed to read binary: %v", err)
+				return nil, fmt.Errorf("failed to read binary: %w", err)
+			}
+			return nil, nil
+		}
+		// Read on from the sniffed start.
+		body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(head), o.Body), o.Body}
+	}
+	return &objectReader{
+		ctx:     ctx,
+		sa:      sa,
+		key:     key,
+		size:    aws.ToInt64(o.ContentLength),
+		etag:    aws.ToString(o.ETag),
+		modTime: aws.ToTime(o.LastModified),
+		body:    body,
+	}, nil
+}
+
+// objectReader reads an object, fetching it again from the read position
+// after each Seek.
+type objectReader struct {
+	ctx     context.Context
+	sa      S3Saver
+	key     string
+	size    int64
+	etag    string
+	modTime time.Time
+	pos     int64
+	body    io.ReadCloser // nil until the next Read after a Seek
+}
+
+func (o *objectReader) Read(p []byte) (int, error) {
+	if o.pos >= o.size {
+		return 0, io.EOF
+	}
+	if o.body == nil {
+		in := &s3.GetObjectInput{
+			Bucket: &o.sa.Bucket,
+			Key:    &o.key,
+			Range:  aws.String(fmt.Sprintf("bytes=%d-", o.pos)),
+		}
+		if o.etag != "" {
+			// Fail rather than mix bytes from an object saved since.
+			in.IfMatch = &o.etag
+		}
+		out, err := o.sa.Client.GetObject(o.ctx, in)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read binary: %w", err)
+		}
+		o.body = out.Body
+	}
+	n, err := o.body.Read(p)
+	o.pos += int64(n)
+	return n, err
+}
+
+func (o *objectReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += o.pos
+	case io.SeekEnd:
+		offset += o.size
+	default:
+		return 0, errors.New("s3saver: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("s3saver: negative position")
+	}
+	if offset != o.pos && o.body != nil {
+		o.body.Close()
+		o.body = nil
+	}
+	o.pos = offset
+	return offset, nil
+}
+
+// ETag is the object's entity tag.
+func (o *objectReader) ETag() string {
+	return o.etag
+}
+
+// ModTime is when the object was last written.
+func (o *objectReader) ModTime() time.Time {
+	return o.modTime
+}
+
+func (o *objectReader) Close() error {
+	if o.body == nil {
+		return nil
+	}
+	err := o.body.Close()
+	o.body = nil
+	return err
+}
@@ -0,0 +1,91 @@
+package s3saver
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"hput"
+	"io"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestSaveStream verifies that streamed binaries are uploaded whole or in parts, and read back
+func TestSaveStream(t *testing.T) {
+	tt := []struct {
+		name string
+		size int
+	}{
+		{name: "empty", size: 0},
+		{name: "one part", size: 1000},
+		{name: "exactly one part", size: partSize},
+		{name: "several parts", size: 2*partSize + 10},
+	}
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			bucket := newFakeBucket()
+			sa, err := New(ctx, &testLogger{}, "bucket", S3ClientOption{client: bucket}, PrefixOption{Prefix: "pre"})
+			assert.NoError(t, err)
+			want := bytes.Repeat([]byte{0xff, 1, 2}, test.size/3+1)[:test.size]
+			u, _ := url.Parse("http://localhost/video")
+			assert.NoError(t, sa.SaveStream(ctx, bytes.NewReader(want), *u, &hput.PutResult{}))
+			assert.Empty(t, bucket.uploads, "no upload is left open")
+
+			r, err := sa.GetRunnable(ctx, *u)
+			assert.NoError(t, err)
+			assert.Equal(t, hput.Input(hput.Binary), r.Type)
+			assert.Equal(t, len(want), len(r.Binary))
+
+			rs, err := sa.GetStream(ctx, *u)
+			assert.NoError(t, err)
+			defer rs.Close()
+			got, err := io.ReadAll(rs)
+			assert.NoError(t, err)
+			assert.True(t, bytes.Equal(want, got))
+
+			versions, err := sa.Versions(ctx, "/video")
+			assert.NoError(t, err)
+			assert.Len(t, versions, 1)
+			assert.Equal(t, len(want), versions[0].Size)
+		})
+	}
+}
+
+// TestGetStreamSeek verifies that a stream can be read from any position
+func TestGetStreamSeek(t *testing.T) {
+	ctx := context.Background()
+	bucket := newFakeBucket()
+	sa, err := New(ctx, &testLogger{}, "bucket", S3ClientOption{client: bucket})
+	assert.NoError(t, err)
+	u, _ := url.Parse("http://localhost/file")
+	assert.NoError(t, sa.SaveStream(ctx, bytes.NewReader([]byte("\xff0123456789")), *u, &hput.PutResult{}))
+
+	rs, err := sa.GetStream(ctx, *u)
+	assert.NoError(t, err)
+	size, err := rs.Seek(0, io.SeekEnd)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(11), size)
+	_, err = rs.Seek(6, io.SeekStart)
+	assert.NoError(t, err)
+	got, err := io.ReadAll(rs)
+	assert.NoError(t, err)
+	assert.Equal(t, "56789", string(got))
+
+	// Reads after a Seek fail once the object has changed.
+	assert.Equal(t, fmt.Sprintf(`"%x"`, md5.Sum([]byte("\xff0123456789"))), rs.(interface{ ETag() string }).ETag())
+	assert.NoError(t, sa.SaveStream(ctx, bytes.NewReader([]byte("\xffchanged")), *u, &hput.PutResult{}))
+	_, err = rs.Seek(1, io.SeekStart)
+	assert.NoError(t, err)
+	_, err = io.ReadAll(rs)
+	assert.Error(t, err)
+
+	u, _ = url.Parse("http://localhost/text")
+	assert.NoError(t, sa.SaveText(ctx, "hello", *u, &hput.PutResult{}))
+	rs, err = sa.GetStream(ctx, *u)
+	assert.NoError(t, err)
+	assert.Nil(t, rs, "text is not streamed")
+}
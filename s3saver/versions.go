@@ -0,0 +1,374 @@
+package s3saver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hput"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// versionsDir is where earlier versions of saved paths live under the prefix.
+const versionsDir = "/_versions/"
+
+// metadataSaved holds when a version was saved, in RFC 3339. It is empty
+// for a version saved before versions were kept.
+const metadataSaved = "saved"
+
+// errVersionsPath is returned when a path would overwrite kept versions.
+var errVersionsPath = errors.New("paths under /_versions/ are reserved for version history")
+
+// isVersion reports whether an object key holds a kept version rather than a saved path.
+func (sa S3Saver) isVersion(key string) bool {
+	return strings.HasPrefix(key, sa.Prefix+versionsDir)
+}
+
+// reserved returns an error if key belongs to the KV store or version
+// history rather than a saved path.
+func (sa S3Saver) reserved(key string) error {
+	if sa.isKV(key) {
+		return errKVPath
+	}
+	if sa.isVersion(key) {
+		return errVersionsPath
+	}
+	return nil
+}
+
+// versionsPrefix is the "directory" of path's versions. Escaping the path
+// keeps /a's versions apart from /a/b's.
+func (sa S3Saver) versionsPrefix(path string) string {
+	return sa.Prefix + versionsDir + url.PathEscape(path) + "/"
+}
+
+// versionKey pads the ID so versions list oldest first.
+func (sa S3Saver) versionKey(path string, id int) string {
+	return fmt.Sprintf("%s%020d", sa.versionsPrefix(path), id)
+}
+
+// versionIDs lists the IDs of path's kept versions, oldest first.
+func (sa S3Saver) versionIDs(ctx context.Context, path string) ([]int, error) {
+	prefix := sa.versionsPrefix(path)
+	in := s3.ListObjectsV2Input{
+		Bucket: &sa.Bucket,
+		Prefix: &prefix,
+	}
+	var ids []int
+	for {
+		res, err := sa.Client.ListObjectsV2(ctx, &in)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list versions: %w", err)
+		}
+		for _, obj := range res.Contents {
+			id, err := strconv.Atoi(strings.TrimPrefix(*obj.Key, prefix))
+			if err != nil {
+				continue // not written by S3Saver
+			}
+			ids = append(ids, id)
+		}
+		if res.NextContinuationToken == nil {
+			return ids, nil
+		}
+		in.ContinuationToken = res.NextContinuationToken
+	}
+}
+
+// putVersion stores r as version id of its path.
+func (sa S3Saver) putVersion(ctx context.Context, id int, r hput.Runnable, saved time.Time) error {
+	key := sa.versionKey(r.Path, id)
+	meta := map[string]string{metadataInput: string(r.Type)}
+	if !saved.IsZero() {
+		meta[metadataSaved] = saved.UTC().Format(time.RFC3339Nano)
+	}
+	body := r.Binary
+	if r.Type != hput.Binary {
+		body = []byte(r.Text)
+	}
+	_, err := sa.Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:   &sa.Bucket,
+		Key:      &key,
+		Metadata: meta,
+		Body:     bytes.NewReader(body),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to put version: %w", err)
+	}
+	return nil
+}
+
+// readObject returns the runnable at key and when it was saved as a
+// version. The runnable is empty if there is no object or it was not written
+// by S3Saver.
+func (sa S3Saver) readObject(ctx context.Context, key string) (hput.Runnable, time.Time, error) {
+	o, err := sa.Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: &sa.Bucket,
+		Key:    &key,
+	})
+	if err != nil {
+		var notFoundErr *types.NoSuchKey
+		if errors.As(err, &notFoundErr) {
+			return hput.Runnable{}, time.Time{}, nil
+		}
+		return hput.Runnable{}, time.Time{}, fmt.Errorf("failed to get object: %w", err)
+	}
+	defer o.Body.Close()
+	in, saved, err := parseMetadata(o.Metadata)
+	if in == "" || err != nil {
+		return hput.Runnable{}, time.Time{}, err
+	}
+	r := hput.Runnable{Type: in}
+	bts, err := io.ReadAll(o.Body)
+	if err != nil {
+		return hput.Runnable{}, time.Time{}, fmt.Errorf("failed to read object: %w", err)
+	}
+	if r.Type == hput.Binary {
+		r.Binary = bts
+	} else {
+		r.Text = string(bts)
+	}
+	return r, saved, nil
+}
+
+// parseMetadata returns the type of runnable an object holds and when it was
+// saved as a version. The type is empty if it was not written by S3Saver.
+func parseMetadata(meta map[string]string) (hput.Input, time.Time, error) {
+	in := hput.Input(meta[metadataInput])
+	switch in {
+	case hput.Text, hput.Js, hput.Binary:
+	default:
+		return "", time.Time{}, nil
+	}
+	var saved time.Time
+	if s := meta[metadataSaved]; s != "" {
+		var err error
+		if saved, err = time.Parse(time.RFC3339Nano, s); err != nil {
+			return "", time.Time{}, fmt.Errorf("failed to read version time: %w", err)
+		}
+	}
+	return in, saved, nil
+}
+
+// objectInfo describes an object written by S3Saver without its body.
+type objectInfo struct {
+	Type  hput.Input
+	Saved time.Time
+	Size  int64
+}
+
+// statObject reads what is at key from its metadata, without downloading
+// it, unless S3Saver did not write it, when its start is downloaded to
+// sniff its type. The type is empty if there is no object or it is empty.
+func (sa S3Saver) statObject(ctx context.Context, key string) (objectInfo, error) {
+	o, err := sa.headObject(ctx, key)
+	if err != nil {
+		return objectInfo{}, fmt.Errorf("failed to head object: %w", err)
+	}
+	if o == nil {
+		return objectInfo{}, nil
+	}
+	in, saved, err := parseMetadata(o.Metadata)
+	if err != nil {
+		return objectInfo{}, err
+	}
+	size := aws.ToInt64(o.ContentLength)
+	if in == "" && size > 0 {
+		in, err = sa.sniffObject(ctx, key)
+	}
+	if in == "" || err != nil {
+		return objectInfo{}, err
+	}
+	return objectInfo{Type: in, Saved: saved, Size: size}, nil
+}
+
+// readVersion returns version id of path and when it was saved. The
+// runnable is empty if the version is not kept.
+func (sa S3Saver) readVersion(ctx context.Context, path string, id int) (hput.Runnable, time.Time, error) {
+	r, saved, err := sa.readObject(ctx, sa.versionKey(path, id))
+	if r.Type != "" {
+		r.Path = path
+	}
+	return r, saved, err
+}
+
+// copyVersion copies the runnable of type in at key to version id of path,
+// without it leaving S3.
+func (sa S3Saver) copyVersion(ctx context.Context, key, path string, id int, in hput.Input, saved time.Time) error {
+	if err := sa.copyObject(ctx, key, sa.versionKey(path, id), in, saved); err != nil {
+		return fmt.Errorf("failed to copy version: %w", err)
+	}
+	return nil
+}
+
+// copyObject copies the runnable of type in at key src to key dst, with
+// saved as when it was saved unless that is zero, without it leaving S3.
+func (sa S3Saver) copyObject(ctx context.Context, src, dst string, in hput.Input, saved time.Time) error {
+	meta := map[string]string{metadataInput: string(in)}
+	if !saved.IsZero() {
+		meta[metadataSaved] = saved.UTC().Format(time.RFC3339Nano)
+	}
+	_, err := sa.Client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:            &sa.Bucket,
+		Key:               &dst,
+		CopySource:        aws.String((&url.URL{Path: sa.Bucket + "/" + src}).EscapedPath()),
+		MetadataDirective: types.MetadataDirectiveReplace,
+		Metadata:          meta,
+	})
+	return err
+}
+
+// addVersion stores the newest version of path with keep, after the
+// versions in ids, and deletes the earlier versions sa.Retention no longer
+// keeps. Concurrent saves to one path may race for an ID; the last wins.
+func (sa S3Saver) addVersion(ctx context.Context, path string, ids []int, keep func(id int, saved time.Time) error) error {
+	now := time.Now()
+	id := 1
+	if len(ids) > 0 {
+		id = ids[len(ids)-1] + 1
+	}
+	if err := keep(id, now); err != nil {
+		return err
+	}
+	for n, i := 0, len(ids)-1; i >= 0; n, i = n+1, i-1 {
+		kept := n < sa.Retention.Count
+		if kept && sa.Retention.Age > 0 {
+			info, err := sa.statObject(ctx, sa.versionKey(path, ids[i]))
+			if err != nil {
+				return err
+			}
+			kept = sa.Retention.Keep(n, info.Saved, now)
+		}
+		if kept {
+			continue
+		}
+		key := sa.versionKey(path, ids[i])
+		_, err := sa.Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+			Bucket: &sa.Bucket,
+			Key:    &key,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to delete version: %w", err)
+		}
+	}
+	return nil
+}
+
+// saveVersioned runs put to store what path holds at key, then keeps it as
+// a version with keep. If the path has no versions yet, what key held
+// before is first copied to version 1, so saving over content written
+// before versions were kept does not lose it.
+func (sa S3Saver) saveVersioned(ctx context.Context, key, path string, put func() error, keep func(id int, saved time.Time) error) error {
+	ids, err := sa.versionIDs(ctx, path)
+	if err != nil {
+		sa.Logger.Errorf("failed to list versions: %v", err)
+		return err
+	}
+	if len(ids) == 0 {
+		old, err := sa.statObject(ctx, key)
+		if err != nil {
+			sa.Logger.Errorf("failed to read earlier version: %v", err)
+			return err
+		}
+		if old.Type != "" {
+			if err := sa.copyVersion(ctx, key, path, 1, old.Type, time.Time{}); err != nil {
+				sa.Logger.Errorf("failed to keep earlier version: %v", err)
+				return err
+			}
+			ids = []int{1}
+		}
+	}
+	if err := put(); err != nil {
+		return err
+	}
+	if err := sa.addVersion(ctx, path, ids, keep); err != nil {
+		sa.Logger.Errorf("failed to add version: %v", err)
+		return err
+	}
+	return nil
+}
+
+// saveRunnable puts r at key, keeping it and what it replaces as versions.
+func (sa S3Saver) saveRunnable(ctx context.Context, key string, r hput.Runnable, put func() error) error {
+	return sa.saveVersioned(ctx, key, r.Path, put, func(id int, saved time.Time) error {
+		return sa.putVersion(ctx, id, r, saved)
+	})
+}
+
+// Versions lists the kept versions of a path, newest first
+func (sa S3Saver) Versions(ctx context.Context, path string) ([]hput.Version, error) {
+	ids, err := sa.versionIDs(ctx, path)
+	if err != nil {
+		sa.Logger.Errorf("failed to list versions: %v", err)
+		return nil, err
+	}
+	versions := make([]hput.Version, 0, len(ids))
+	for i := len(ids) - 1; i >= 0; i-- {
+		info, err := sa.statObject(ctx, sa.versionKey(path, ids[i]))
+		if err != nil {
+			sa.Logger.Errorf("failed to read version: %v", err)
+			return nil, err
+		}
+		if info.Type == "" {
+			continue // pruned since listing
+		}
+		versions = append(versions, hput.Version{
+			ID:    ids[i],
+			Saved: info.Saved,
+			Type:  info.Type,
+			Size:  int(info.Size),
+		})
+	}
+	return versions, nil
+}
+
+// Rollback saves version id of a path again by copying it within the
+// bucket, so it is never downloaded, and reports false if that version is
+// not kept. As with SaveStream's versions, S3 limits the copy to 5 GB.
+func (sa S3Saver) Rollback(ctx context.Context, path string, id int) (bool, error) {
+	key := sa.getKey(path)
+	if err := sa.reserved(key); err != nil {
+		return false, err
+	}
+	if id < 1 {
+		return false, nil
+	}
+	vkey := sa.versionKey(path, id)
+	info, err := sa.statObject(ctx, vkey)
+	if err != nil {
+		sa.Logger.Errorf("failed to read version: %v", err)
+		return false, err
+	}
+	if info.Type == "" {
+		return false, nil
+	}
+	err = sa.saveVersioned(ctx, key, path, func() error {
+		if err := sa.copyObject(ctx, vkey, key, info.Type, time.Time{}); err != nil {
+			sa.Logger.Errorf("failed to roll back: %v", err)
+			return fmt.Errorf("failed to roll back: %w", err)
+		}
+		return nil
+	}, func(newID int, saved time.Time) error {
+		return sa.copyVersion(ctx, key, path, newID, info.Type, saved)
+	})
+	return err == nil, err
+}
+
+// GetVersion returns one version of a path, or an empty runnable if it is not kept
+func (sa S3Saver) GetVersion(ctx context.Context, path string, id int) (hput.Runnable, error) {
+	if id < 1 {
+		return hput.Runnable{}, nil
+	}
+	r, _, err := sa.readVersion(ctx, path, id)
+	if err != nil {
+		sa.Logger.Errorf("failed to get version: %v", err)
+	}
+	return r, err
+}
@@ -0,0 +1,142 @@
+package s3saver
+
+import (
+	"bytes"
+	"context"
+	"hput"
+	"net/url"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestVersions verifies that saves are kept as versions within the retention
+func TestVersions(t *testing.T) {
+	tt := []struct {
+		name      string
+		retention hput.Retention
+		ids       []int
+	}{
+		{name: "keep none", ids: []int{3}},
+		{name: "keep one", retention: hput.Retention{Count: 1}, ids: []int{3, 2}},
+		{name: "keep more than saved", retention: hput.Retention{Count: 10}, ids: []int{3, 2, 1}},
+	}
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			bucket := newFakeBucket()
+			sa, err := New(ctx, &testLogger{}, "bucket", S3ClientOption{client: bucket}, PrefixOption{Prefix: "pre"})
+			assert.NoError(t, err)
+			sa.Retention = test.retention
+			u, _ := url.Parse("http://localhost/a/b")
+			assert.NoError(t, sa.SaveText(ctx, "one", *u, &hput.PutResult{}))
+			assert.NoError(t, sa.SaveCode(ctx, "'two'", *u, &hput.PutResult{}))
+			assert.NoError(t, sa.SaveBinary(ctx, []byte{255, 0}, *u, &hput.PutResult{}))
+
+			versions, err := sa.Versions(ctx, "/a/b")
+			assert.NoError(t, err)
+			var ids []int
+			for _, v := range versions {
+				ids = append(ids, v.ID)
+			}
+			assert.Equal(t, test.ids, ids)
+			assert.Equal(t, hput.Input(hput.Binary), versions[0].Type)
+			assert.Equal(t, 2, versions[0].Size)
+			assert.False(t, versions[0].Saved.IsZero())
+
+			r, err := sa.GetVersion(ctx, "/a/b", 3)
+			assert.NoError(t, err)
+			assert.Equal(t, hput.Runnable{Path: "/a/b", Type: hput.Binary, Binary: []byte{255, 0}}, r)
+
+			// versions are not saved paths
+			var paths []string
+			for e, err := range sa.List(ctx, "", "", 0) {
+				assert.NoError(t, err)
+				paths = append(paths, e.Path)
+			}
+			assert.Equal(t, []string{"/a/b"}, paths)
+		})
+	}
+}
+
+// TestVersionsBeforeHistory verifies that content saved before versions
+// were kept becomes version 1 when it is overwritten
+func TestVersionsBeforeHistory(t *testing.T) {
+	ctx := context.Background()
+	bucket := newFakeBucket()
+	sa, err := New(ctx, &testLogger{}, "bucket", S3ClientOption{client: bucket}, PrefixOption{Prefix: "pre"})
+	assert.NoError(t, err)
+	sa.Retention = hput.Retention{Count: 5}
+	_, err = bucket.PutObject(ctx, &s3.PutObjectInput{
+		Key:      aws.String("pre/page"),
+		Metadata: map[string]string{metadataInput: string(hput.Text)},
+		Body:     bytes.NewBufferString("old"),
+	})
+	assert.NoError(t, err)
+
+	u, _ := url.Parse("http://localhost/page")
+	res := hput.PutResult{}
+	assert.NoError(t, sa.SaveText(ctx, "new", *u, &res))
+	assert.True(t, res.Overwrote)
+	assert.Zero(t, bucket.gets, "the earlier version is copied, not downloaded")
+	versions, err := sa.Versions(ctx, "/page")
+	assert.NoError(t, err)
+	assert.Len(t, versions, 2)
+	assert.Equal(t, 1, versions[1].ID)
+	assert.True(t, versions[1].Saved.IsZero())
+	r, err := sa.GetVersion(ctx, "/page", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "old", r.Text)
+
+	u, _ = url.Parse("http://localhost/_versions/x")
+	assert.ErrorIs(t, sa.SaveText(ctx, "overwrite", *u, &hput.PutResult{}), errVersionsPath)
+}
+
+// TestDelete verifies that deleting a path removes its object but keeps its versions
+func TestDelete(t *testing.T) {
+	ctx := context.Background()
+	bucket := newFakeBucket()
+	sa, err := New(ctx, &testLogger{}, "bucket", S3ClientOption{client: bucket}, PrefixOption{Prefix: "pre"})
+	assert.NoError(t, err)
+	p := url.URL{Path: "/gone"}
+	assert.NoError(t, sa.SaveText(ctx, "text", p, &hput.PutResult{}))
+	assert.NoError(t, sa.Delete(ctx, p))
+	assert.NotContains(t, bucket.objects, "pre/gone")
+	versions, err := sa.Versions(ctx, "/gone")
+	assert.NoError(t, err)
+	assert.Len(t, versions, 1)
+	assert.ErrorIs(t, sa.Delete(ctx, url.URL{Path: "/_versions/x"}), errVersionsPath)
+}
+
+// TestRollback verifies a version is saved again by copying it within the bucket
+func TestRollback(t *testing.T) {
+	ctx := context.Background()
+	bucket := newFakeBucket()
+	sa, err := New(ctx, &testLogger{}, "bucket", S3ClientOption{client: bucket}, PrefixOption{Prefix: "pre"})
+	assert.NoError(t, err)
+	sa.Retention = hput.Retention{Count: 5}
+	u, _ := url.Parse("http://localhost/a/b")
+	assert.NoError(t, sa.SaveBinary(ctx, []byte{255, 0}, *u, &hput.PutResult{}))
+	assert.NoError(t, sa.SaveText(ctx, "two", *u, &hput.PutResult{}))
+
+	found, err := sa.Rollback(ctx, "/a/b", 9)
+	assert.NoError(t, err)
+	assert.False(t, found)
+
+	bucket.gets = 0
+	found, err = sa.Rollback(ctx, "/a/b", 1)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Zero(t, bucket.gets, "the version is not downloaded")
+
+	r, err := sa.GetRunnable(ctx, *u)
+	assert.NoError(t, err)
+	assert.Equal(t, hput.Runnable{Path: "/a/b", Type: hput.Binary, Binary: []byte{255, 0}}, r)
+	versions, err := sa.Versions(ctx, "/a/b")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, versions[0].ID)
+	assert.Equal(t, hput.Input(hput.Binary), versions[0].Type)
+	assert.False(t, versions[0].Saved.IsZero())
+}
@@ -0,0 +1,214 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// Listings decides under which prefixes directory listings are served. The
+// rule with the longest prefix of a directory's path applies, and where no
+// rule does, listings are not served, so saved paths stay unlisted unless a
+// rule turns listings on.
+type Listings map[string]bool
+
+// ParseListings reads comma separated prefix=on or prefix=off rules, such
+// as "/docs/=on,/docs/private/=off".
+func ParseListings(s string) (Listings, error) {
+	l := Listings{}
+	for _, rule := range strings.Split(s, ",") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+		prefix, value, ok := strings.Cut(rule, "=")
+		if !ok || !strings.HasPrefix(prefix, "/") {
+			return nil, fmt.Errorf("listing rule %q is not /prefix=on or /prefix=off", rule)
+		}
+		switch value {
+		case "on":
+			l[prefix] = true
+		case "off":
+			l[prefix] = false
+		default:
+			return nil, fmt.Errorf("listing rule %q is not /prefix=on or /prefix=off", rule)
+		}
+	}
+	return l, nil
+}
+
+// Allowed reports whether the directory at path dir may be listed. A rule
+// applies at a path boundary, so a rule for /docs covers /docs/ but not
+// /docsfoo/.
+func (l Listings) Allowed(dir string) bool {
+	allowed, longest := false, -1
+	for prefix, on := range l {
+		if !strings.HasPrefix(dir, prefix) || len(prefix) <= longest {
+			continue
+		}
+		if strings.HasSuffix(prefix, "/") || len(dir) == len(prefix) || dir[len(prefix)] == '/' {
+			allowed, longest = on, len(prefix)
+		}
+	}
+	return allowed
+}
+
+const (
+	// directory is the type listed for a subdirectory.
+	directory = "Directory"
+	// listLimit is how many entries a page of a listing holds.
+	listLimit = 1000
+	// skipLimit is how many paths in a subdirectory children reads past
+	// before listing again from after the subdirectory instead.
+	skipLimit = 100
+)
+
+// listing is the page of a directory listing served as JSON or HTML.
+type listing struct {
+	Path    string      `json:"path"`
+	Entries []listEntry `json:"entries"`
+	Next    string      `json:"next,omitempty"` // pass as ?after= for the next page
+}
+
+// listEntry is one child of a listed directory.
+type listEntry struct {
+	Name     string    `json:"name"` // ends in "/" for a subdirectory
+	Path     string    `json:"path"`
+	Type     string    `json:"type"` // Text, Javascript, Binary or Directory
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified,omitzero"`
+}
+
+// Href links to the entry.
+func (e listEntry) Href() string {
+	return (&url.URL{Path: e.Path}).EscapedPath()
+}
+
+// Parent links to the directory above, or is empty at the root.
+func (l listing) Parent() string {
+	if l.Path == "/" {
+		return ""
+	}
+	parent := path.Dir(strings.TrimSuffix(l.Path, "/"))
+	if parent != "/" {
+		parent += "/"
+	}
+	return (&url.URL{Path: parent}).EscapedPath()
+}
+
+var listingPage = template.Must(template.New("listing").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Index of {{.Path}}</title></head>
+<body>
+<h1>Index of {{.Path}}</h1>
+<table>
+<tr><th>Name</th><th>Type</th><th>Size</th><th>Modified</th></tr>
+{{- with .Parent}}
+<tr><td><a href="{{.}}">../</a></td><td>Directory</td><td></td><td></td></tr>
+{{- end}}
+{{- range .Entries}}
+<tr><td><a href="{{.Href}}">{{.Name}}</a></td><td>{{.Type}}</td><td>{{if ne .Type "Directory"}}{{.Size}}{{end}}</td><td>{{if not .Modified.IsZero}}{{.Modified.UTC.Format "2006-01-02 15:04:05"}}{{end}}</td></tr>
+{{- end}}
+</table>
+{{- with .Next}}
+<p><a href="?list&after={{.}}">More</a></p>
+{{- end}}
+</body>
+</html>
+`))
+
+// listRequested reports whether r asks outright for a listing, with ?list.
+func listRequested(r *http.Request) bool {
+	_, ok := r.URL.Query()["list"]
+	return ok && readOnly(r)
+}
+
+// readOnly reports whether r only reads, so may be answered with a listing.
+func readOnly(r *http.Request) bool {
+	return r.Method == "" || r.Method == http.MethodGet || r.Method == http.MethodHead
+}
+
+// serveListing writes a page of the listing of the directory at r's path,
+// as JSON if r accepts it and as HTML otherwise, and reports whether it
+// did. It writes nothing if listings are not allowed there, or if the
+// directory is empty and empty is false.
+func (s *Service) serveListing(ctx context.Context, w http.ResponseWriter, r *http.Request, empty bool) (bool, error) {
+	dir := r.URL.Path
+	if !strings.HasSuffix(dir, "/") {
+		dir += "/"
+	}
+	if !s.Listings.Allowed(dir) {
+		s.Logger.Debugf("listings are off for %s", dir)
+		return false, nil
+	}
+	l, err := s.children(ctx, dir, r.URL.Query().Get("after"))
+	if err != nil {
+		return false, fmt.Errorf("could not list %s: %w", dir, err)
+	}
+	if len(l.Entries) == 0 && !empty {
+		return false, nil
+	}
+	s.Logger.Debugf("listing %d entries of %s", len(l.Entries), dir)
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		return true, json.NewEncoder(w).Encode(l)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	return true, listingPage.Execute(w, l)
+}
+
+// children returns up to listLimit of what is directly under dir, a path
+// ending in "/", after the path after. Each subdirectory is listed once.
+// The paths in a subdirectory are read past in the same listing, unless
+// there are more than skipLimit of them, when listing starts again after
+// the subdirectory, so a large one is not read through.
+func (s *Service) children(ctx context.Context, dir, after string) (listing, error) {
+	l := listing{Path: dir, Entries: []listEntry{}}
+	cursor := after
+	if strings.HasSuffix(cursor, "/") {
+		// A subdirectory ended the last page, so skip what is in it.
+		cursor += "\xff"
+	}
+	for {
+		var sub, restart string
+		skipped := 0
+		for e, err := range s.Saver.List(ctx, dir, cursor, 0) {
+			if err != nil {
+				return listing{}, err
+			}
+			if sub != "" && strings.HasPrefix(e.Path, sub) {
+				if skipped++; skipped == skipLimit {
+					// Every path in the subdirectory sorts before this.
+					restart = sub + "\xff"
+					break
+				}
+				continue
+			}
+			rest := e.Path[len(dir):]
+			if rest == "" {
+				// Saved at the directory's own path.
+				continue
+			}
+			if len(l.Entries) == listLimit {
+				l.Next = l.Entries[len(l.Entries)-1].Path
+				return l, nil
+			}
+			if i := strings.Index(rest, "/"); i >= 0 {
+				sub, skipped = dir+rest[:i+1], 0
+				l.Entries = append(l.Entries, listEntry{Name: rest[:i+1], Path: sub, Type: directory})
+				continue
+			}
+			l.Entries = append(l.Entries, listEntry{Name: rest, Path: e.Path, Type: string(e.Type), Size: e.Size, Modified: e.Modified})
+		}
+		if restart == "" {
+			return l, nil
+		}
+		cursor = restart
+	}
+}
@@ -0,0 +1,310 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hput"
+	"iter"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestListSaver is a TestSaver whose List honors the prefix and cursor, as
+// the storage backends do
+type TestListSaver struct {
+	TestSaver
+	Modified time.Time // of every entry
+	Lists    int       // how many times List was called
+}
+
+func (t *TestListSaver) List(ctx context.Context, prefix, cursor string, limit int) iter.Seq2[hput.Entry, error] {
+	t.Lists++
+	return func(yield func(hput.Entry, error) bool) {
+		n := 0
+		for _, r := range t.Listed {
+			if !strings.HasPrefix(r.Path, prefix) || r.Path <= cursor {
+				continue
+			}
+			if limit > 0 && n == limit {
+				return
+			}
+			n++
+			e := hput.Entry{Path: r.Path, Type: r.Type, Size: int64(len(r.Text) + len(r.Binary)), Modified: t.Modified}
+			if !yield(e, nil) {
+				return
+			}
+		}
+		if t.ListErr != nil {
+			yield(hput.Entry{}, t.ListErr)
+		}
+	}
+}
+
+// site is what TestListSaver lists in these tests, in path order
+var site = []hput.Runnable{
+	{Path: "/", Type: hput.Text, Text: "home"},
+	{Path: "/about", Type: hput.Text, Text: "about us"},
+	{Path: "/api/", Type: hput.Text, Text: "api index"},
+	{Path: "/api/time", Type: hput.Js, Text: "new Date()"},
+	{Path: "/api/v2/time", Type: hput.Js, Text: "Date.now()"},
+	{Path: "/logo", Type: hput.Binary, Binary: []byte{0xff, 0}},
+	{Path: "/the docs/a&b", Type: hput.Text, Text: "escaped"},
+}
+
+// TestParseListings verifies listing rules are parsed and the longest matching prefix wins
+func TestParseListings(t *testing.T) {
+	l, err := ParseListings("/=off, /docs/=on,/docs/private/=off")
+	assert.NoError(t, err)
+	assert.Equal(t, Listings{"/": false, "/docs/": true, "/docs/private/": false}, l)
+	tt := []struct {
+		dir  string
+		want bool
+	}{
+		{dir: "/", want: false},
+		{dir: "/api/", want: false},
+		{dir: "/docs/", want: true},
+		{dir: "/docs/guide/", want: true},
+		{dir: "/docs/private/", want: false},
+	}
+	for _, test := range tt {
+		t.Run(test.dir, func(t *testing.T) {
+			assert.Equal(t, test.want, l.Allowed(test.dir))
+		})
+	}
+	assert.False(t, Listings{}.Allowed("/anything/"), "with no rules listings are not served")
+
+	sibling := Listings{"/": false, "/docs": true}
+	assert.True(t, sibling.Allowed("/docs/"))
+	assert.True(t, sibling.Allowed("/docs/guide/"))
+	assert.False(t, sibling.Allowed("/docsfoo/"), "a rule only applies at a path boundary")
+	assert.False(t, Listings(nil).Allowed("/"))
+
+	for _, bad := range []string{"docs/=on", "/docs/", "/docs/=yes"} {
+		_, err := ParseListings(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+// TestListing verifies what is served for a directory, as JSON or HTML, and when it falls through
+func TestListing(t *testing.T) {
+	modified := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	tt := []struct {
+		name     string
+		path     string
+		query    string
+		accept   string
+		method   string
+		listings Listings // nil lists everything
+		code     int
+		want     *listing // the JSON served, if any
+		contains string
+	}{
+		{
+			name:   "root as JSON",
+			path:   "/",
+			query:  "list",
+			accept: "application/json",
+			code:   http.StatusOK,
+			want: &listing{Path: "/", Entries: []listEntry{
+				{Name: "about", Path: "/about", Type: "Text", Size: 8, Modified: modified},
+				{Name: "api/", Path: "/api/", Type: directory},
+				{Name: "logo", Path: "/logo", Type: "Binary", Size: 2, Modified: modified},
+				{Name: "the docs/", Path: "/the docs/", Type: directory},
+			}},
+		},
+		{
+			name:   "a directory without a trailing slash",
+			path:   "/api",
+			query:  "list",
+			accept: "application/json",
+			code:   http.StatusOK,
+			want: &listing{Path: "/api/", Entries: []listEntry{
+				{Name: "time", Path: "/api/time", Type: "Javascript", Size: 10, Modified: modified},
+				{Name: "v2/", Path: "/api/v2/", Type: directory},
+			}},
+		},
+		{
+			name:   "after a subdirectory",
+			path:   "/",
+			query:  "list&after=/api/",
+			accept: "application/json",
+			code:   http.StatusOK,
+			want: &listing{Path: "/", Entries: []listEntry{
+				{Name: "logo", Path: "/logo", Type: "Binary", Size: 2, Modified: modified},
+				{Name: "the docs/", Path: "/the docs/", Type: directory},
+			}},
+		},
+		{
+			name:     "HTML with escaped links",
+			path:     "/the docs/",
+			code:     http.StatusOK,
+			contains: `<a href="/the%20docs/a&amp;b">a&amp;b</a>`,
+		},
+		{
+			name:     "HTML links to the parent",
+			path:     "/api/v2/",
+			code:     http.StatusOK,
+			contains: `<a href="/api/">../</a>`,
+		},
+		{
+			name:     "HTML links to the root",
+			path:     "/the docs/",
+			code:     http.StatusOK,
+			contains: `<a href="/">../</a>`,
+		},
+		{
+			name:     "a saved path ending in a slash is served",
+			path:     "/api/",
+			code:     http.StatusOK,
+			contains: "api index",
+		},
+		{
+			name:   "an empty directory",
+			path:   "/empty/",
+			code:   http.StatusBadRequest,
+			accept: "application/json",
+		},
+		{
+			name:   "an empty directory asked for outright",
+			path:   "/empty/",
+			query:  "list",
+			accept: "application/json",
+			code:   http.StatusOK,
+			want:   &listing{Path: "/empty/", Entries: []listEntry{}},
+		},
+		{
+			name:     "listings off",
+			path:     "/the docs/",
+			listings: Listings{"/": false},
+			code:     http.StatusBadRequest,
+		},
+		{
+			name:     "listings off without rules",
+			path:     "/",
+			query:    "list",
+			listings: Listings{},
+			code:     http.StatusOK,
+			contains: "home",
+		},
+		{
+			name:     "listings off falls through to what is saved",
+			path:     "/about",
+			query:    "list",
+			listings: Listings{"/": false},
+			code:     http.StatusOK,
+			contains: "about us",
+		},
+		{
+			name:     "listings on under a prefix",
+			path:     "/api/v2/",
+			listings: Listings{"/": false, "/api/": true},
+			code:     http.StatusOK,
+			contains: "Index of /api/v2/",
+		},
+		{
+			name:   "only reads are listed",
+			path:   "/the docs/",
+			method: http.MethodPost,
+			code:   http.StatusBadRequest,
+		},
+	}
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			s := Service{
+				Saver:       &TestListSaver{TestSaver: TestSaver{Listed: site}, Modified: modified},
+				Interpreter: &TestInterpreter{},
+				Logger:      &TestLogger{},
+				Listings:    test.listings,
+			}
+			if s.Listings == nil {
+				s.Listings = Listings{"/": true}
+			}
+			req := &http.Request{Method: test.method, URL: &url.URL{Path: test.path, RawQuery: test.query}, Header: http.Header{}}
+			if test.accept != "" {
+				req.Header.Set("Accept", test.accept)
+			}
+			rec := httptest.NewRecorder()
+			assert.NoError(t, s.Run(context.Background(), rec, req))
+			assert.Equal(t, test.code, rec.Code)
+			if test.want != nil {
+				assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+				var got listing
+				assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+				assert.Equal(t, *test.want, got)
+			}
+			assert.Contains(t, rec.Body.String(), test.contains)
+		})
+	}
+}
+
+// TestListingPages verifies a large directory is listed a page at a time, with each subdirectory listed once
+func TestListingPages(t *testing.T) {
+	var listed []hput.Runnable
+	for i := range listLimit + 5 {
+		listed = append(listed, hput.Runnable{Path: fmt.Sprintf("/big/%05d", i), Type: hput.Text, Text: "x"})
+		if i == listLimit-1 {
+			for j := range 3 {
+				listed = append(listed, hput.Runnable{Path: fmt.Sprintf("/big/%05d.d/%d", i, j), Type: hput.Text, Text: "x"})
+			}
+		}
+	}
+	saver := &TestListSaver{TestSaver: TestSaver{Listed: listed}}
+	s := Service{Saver: saver, Logger: &TestLogger{}}
+
+	l, err := s.children(context.Background(), "/big/", "")
+	assert.NoError(t, err)
+	assert.Len(t, l.Entries, listLimit)
+	assert.Equal(t, fmt.Sprintf("/big/%05d", listLimit-1), l.Next)
+
+	l, err = s.children(context.Background(), "/big/", l.Next)
+	assert.NoError(t, err)
+	assert.Equal(t, directory, l.Entries[0].Type)
+	assert.Equal(t, fmt.Sprintf("%05d.d/", listLimit-1), l.Entries[0].Name)
+	assert.Len(t, l.Entries, 6)
+	assert.Empty(t, l.Next)
+}
+
+// TestListingSubdirectories verifies subdirectories are read past in one listing, unless one is large
+func TestListingSubdirectories(t *testing.T) {
+	var listed []hput.Runnable
+	for _, sub := range []string{"a", "b", "c"} {
+		for i := range 3 {
+			listed = append(listed, hput.Runnable{Path: fmt.Sprintf("/%s/%d", sub, i), Type: hput.Text, Text: "x"})
+		}
+	}
+	for i := range skipLimit + 5 {
+		listed = append(listed, hput.Runnable{Path: fmt.Sprintf("/d/%05d", i), Type: hput.Text, Text: "x"})
+	}
+	listed = append(listed, hput.Runnable{Path: "/e", Type: hput.Text, Text: "x"})
+	saver := &TestListSaver{TestSaver: TestSaver{Listed: listed}}
+	s := Service{Saver: saver, Logger: &TestLogger{}}
+
+	l, err := s.children(context.Background(), "/", "")
+	assert.NoError(t, err)
+	var names []string
+	for _, e := range l.Entries {
+		names = append(names, e.Name)
+	}
+	assert.Equal(t, []string{"a/", "b/", "c/", "d/", "e"}, names)
+	assert.Equal(t, 2, saver.Lists, "only the large subdirectory is listed past")
+}
+
+// TestListingError verifies a listing error is returned rather than a partial listing
+func TestListingError(t *testing.T) {
+	s := Service{
+		Saver:    &TestListSaver{TestSaver: TestSaver{Listed: site, ListErr: fmt.Errorf("bucket unreachable")}},
+		Logger:   &TestLogger{},
+		Listings: Listings{"/": true},
+	}
+	rec := httptest.NewRecorder()
+	err := s.Run(context.Background(), rec, &http.Request{URL: &url.URL{Path: "/", RawQuery: "list"}, Header: http.Header{}})
+	assert.ErrorContains(t, err, "bucket unreachable")
+	assert.Empty(t, rec.Body.String())
+}
@@ -1,16 +1,23 @@
 package service
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"hput"
 	"hput/kv"
 	"io"
-	"math"
+	"iter"
 	"net/http"
 	"net/url"
+	"path"
 	"strings"
+	"time"
+	"unicode/utf8"
 )
 
 type input string
@@ -21,6 +28,11 @@ type Service struct {
 	Interpreter Interpreter
 	KV          kv.KV
 	Logger      Logger
+	MaxUpload   int64    // largest PUT body in bytes; 0 means unlimited
+	Listings    Listings // where directory listings are served; nil serves none
+	// Admin reports whether a request may use the admin API, and so see the
+	// KV data /dump includes. When nil, /dump leaves KV data out.
+	Admin func(r *http.Request) bool
 }
 
 // Saver describes what Service needs from a storage backend (defined here where USED)
@@ -29,7 +41,26 @@ type Saver interface {
 	SaveCode(ctx context.Context, s string, p url.URL, r *hput.PutResult) error
 	SaveBinary(ctx context.Context, b []byte, p url.URL, r *hput.PutResult) error
 	GetRunnable(ctx context.Context, p url.URL) (hput.Runnable, error)
-	SendRunnables(ctx context.Context, p string, runnables chan<- hput.Runnable, done chan<- bool) error
+	// List yields the paths starting with prefix that sort after cursor, in
+	// path order, up to limit of them if limit is above 0. To get the next
+	// page, pass the last path as the cursor. Listing stops at the first
+	// error, which is yielded with an empty Entry, including ctx's when it
+	// is cancelled.
+	List(ctx context.Context, prefix, cursor string, limit int) iter.Seq2[hput.Entry, error]
+}
+
+// StreamSaver is a Saver that can store and serve binaries without holding
+// them in memory. Service uses it for binaries when the Saver has it.
+type StreamSaver interface {
+	SaveStream(ctx context.Context, b io.Reader, p url.URL, r *hput.PutResult) error
+	GetStream(ctx context.Context, p url.URL) (io.ReadSeekCloser, error) // nil if p does not hold a binary
+}
+
+// RunnableStreamer is a StreamSaver that can read a path once for Run: it
+// returns a stream if the path holds a binary, and otherwise the runnable,
+// empty if nothing is there.
+type RunnableStreamer interface {
+	GetRunnableStream(ctx context.Context, p url.URL) (hput.Runnable, io.ReadSeekCloser, error)
 }
 
 // Interpreter describes what Service needs from a JavaScript runtime (defined here where USED)
@@ -59,12 +90,7 @@ const (
 // Put accepts a Put request and saves it
 func (s *Service) Put(ctx context.Context, w http.ResponseWriter, r *http.Request) (*hput.PutResult, error) {
 	s.Logger.Debug("processing PUT service")
-	b, err := io.ReadAll(r.Body)
-	r.Body.Close()
-	if err != nil {
-		s.Logger.Errorf("processing read payload error in put request with err: %v", err)
-		return nil, hput.ErrCannotReadPostPayload
-	}
+	defer r.Body.Close()
 	// Cannot put at "/dump"
 	if strings.ToLower(lastN(r.URL.Path, 5)) == "/dump" {
 		return nil, ErrPutToDump
@@ -73,29 +99,52 @@ func (s *Service) Put(ctx context.Context, w http.ResponseWriter, r *http.Reques
 	if strings.ToLower(lastN(r.URL.Path, 5)) == "/logs" {
 		return nil, ErrPutToLogs
 	}
+	body := r.Body
+	if s.MaxUpload > 0 {
+		if r.ContentLength > s.MaxUpload {
+			return nil, fmt.Errorf("%w: %d bytes is over the limit of %d bytes", hput.ErrTooLarge, r.ContentLength, s.MaxUpload)
+		}
+		body = http.MaxBytesReader(w, body, s.MaxUpload)
+	}
 	// Test whether input is a string by checking the first 200 characters for an invalid rune: �
+	head := make([]byte, 200)
+	n, err := io.ReadFull(body, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, s.payloadErr(err)
+	}
+	head = head[:n]
 
 	// See if the address is already assigned
-	runnable, err := s.getPathRunnable(ctx, *r.URL)
-	if runnable != nil {
-		// The address was assigned.
-		w.Write([]byte("overwriting something, use this Javascript to add it back.\n\n"))
-		s.respondWithRunnable(*runnable, false, w)
-		w.Write([]byte("\n\n"))
-	}
+	runnable := s.overwritten(ctx, *r.URL)
 
-	shortStr := string(b[:int(math.Min(200, float64(len(b))))])
-	if strings.ContainsRune(shortStr, invalidRune) {
+	if strings.ContainsRune(string(head), invalidRune) {
 		s.Logger.Debug("got bytes that don't look like a string")
 		res := &hput.PutResult{
 			Input:   hput.Binary,
 			Message: "I think this is a binary file, saving it as such",
 		}
-		err := s.Saver.SaveBinary(ctx, b, *r.URL, res)
-		return res, err
+		rest := io.MultiReader(bytes.NewReader(head), body)
+		if ss, ok := s.Saver.(StreamSaver); ok {
+			err = ss.SaveStream(ctx, rest, *r.URL, res)
+		} else {
+			var b []byte
+			if b, err = io.ReadAll(rest); err != nil {
+				return nil, s.payloadErr(err)
+			}
+			err = s.Saver.SaveBinary(ctx, b, *r.URL, res)
+		}
+		if err != nil {
+			return res, s.payloadErr(err)
+		}
+		s.echoOverwritten(runnable, w)
+		return res, nil
 	}
 
-	str := string(b)
+	b, err := io.ReadAll(body)
+	if err != nil {
+		return nil, s.payloadErr(err)
+	}
+	str := string(append(head, b...))
 	isCode, msg := s.Interpreter.IsCode(str)
 	if !isCode {
 		s.Logger.Debugf("processing PUT text service with text: %s to path: %s", str, r.URL.Path)
@@ -104,6 +153,9 @@ func (s *Service) Put(ctx context.Context, w http.ResponseWriter, r *http.Reques
 			Message: msg,
 		}
 		err := s.Saver.SaveText(ctx, str, *r.URL, res)
+		if err == nil {
+			s.echoOverwritten(runnable, w)
+		}
 		return res, err
 	}
 	s.Logger.Debugf("processing PUT code service with text: %s to path: %s", str, r.URL.Path)
@@ -111,34 +163,98 @@ func (s *Service) Put(ctx context.Context, w http.ResponseWriter, r *http.Reques
 		Input: hput.Js,
 	}
 	err = s.Saver.SaveCode(ctx, str, *r.URL, res)
+	if err == nil {
+		s.echoOverwritten(runnable, w)
+	}
 	return res, err
 }
 
+// payloadErr reports an error reading a PUT body as hput.ErrTooLarge if it
+// went over MaxUpload, and hput.ErrCannotReadPostPayload otherwise.
+func (s *Service) payloadErr(err error) error {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return fmt.Errorf("%w: the limit is %d bytes", hput.ErrTooLarge, tooLarge.Limit)
+	}
+	s.Logger.Errorf("processing read payload error in put request with err: %v", err)
+	return hput.ErrCannotReadPostPayload
+}
+
+// overwritten returns what is saved at p, to echo back once it is replaced.
+// A streamed binary is not read, since only its path is echoed.
+func (s *Service) overwritten(ctx context.Context, p url.URL) *hput.Runnable {
+	if ss, ok := s.Saver.(StreamSaver); ok {
+		rs, err := ss.GetStream(ctx, p)
+		if err != nil {
+			s.Logger.Errorf("service.overwritten(): unexpected error retrieving a binary: %+v", err)
+			return nil
+		}
+		if rs != nil {
+			rs.Close()
+			return &hput.Runnable{Path: p.Path, Type: hput.Binary}
+		}
+	}
+	runnable, _ := s.getPathRunnable(ctx, p)
+	return runnable
+}
+
+// echoOverwritten writes what a PUT replaced, if anything, so it can be added back.
+func (s *Service) echoOverwritten(runnable *hput.Runnable, w http.ResponseWriter) {
+	if runnable == nil {
+		return
+	}
+	w.Write([]byte("overwriting something, use this Javascript to add it back.\n\n"))
+	s.respondWithRunnable(*runnable, false, w)
+	w.Write([]byte("\n\n"))
+}
+
 // Run executes and whatever is at this path on the server. If text was saved that text is returned.
 // Code can write out to the http.ResponseWriter, and also return something to output.
 func (s *Service) Run(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 	if strings.ToLower(lastN(r.URL.Path, 5)) == "/dump" {
-		s.dumpPath(ctx, *r.URL, w)
+		s.dumpPath(ctx, r, w)
 		return nil
 	}
 	s.Logger.Debugf("processing RUN service with path, %s", r.URL.Path)
-	runnable, err := s.getPathRunnable(ctx, *r.URL)
+	if listRequested(r) {
+		listed, err := s.serveListing(ctx, w, r, true)
+		if err != nil {
+			s.Logger.Warnf("processing RUN service got an error, %+v", err)
+			return fmt.Errorf("Unexpected error running service at path: %s ,:%v", r.URL.Path, err)
+		}
+		if listed {
+			return nil
+		}
+	}
+	runnable, rs, err := s.lookup(ctx, *r.URL)
 	if err != nil {
 		s.Logger.Warnf("processing RUN service got an error, %+v", err)
 		return fmt.Errorf("Unexpected error running service at path: %s ,:%v", r.URL.Path, err)
 	}
+	if rs != nil {
+		defer rs.Close()
+		return s.serveBinary(rs, w, r)
+	}
 	if runnable == nil || (runnable.Type != hput.Binary && runnable.Text == "") || (runnable.Type == hput.Binary && len(runnable.Binary) == 0) {
 		s.Logger.Debug("processing RUN service got nil runnable")
+		if strings.HasSuffix(r.URL.Path, "/") && readOnly(r) {
+			listed, err := s.serveListing(ctx, w, r, false)
+			if err != nil {
+				s.Logger.Warnf("processing RUN service got an error, %+v", err)
+				return fmt.Errorf("Unexpected error running service at path: %s ,:%v", r.URL.Path, err)
+			}
+			if listed {
+				return nil
+			}
+		}
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte(fmt.Sprintf("There is nothing at path: '%s', you can use a PUT verb to add something\n", r.URL.Path)))
 		return nil
 	}
 	switch runnable.Type {
 	case hput.Binary:
-		s.Logger.Debugf("processing RUN service got binary length %d", len(runnable.Binary))
-		w.WriteHeader(http.StatusOK)
-		w.Write(runnable.Binary)
-		return nil
+		w.Header().Set("ETag", binaryETag(runnable.Binary))
+		return s.serveBinary(bytes.NewReader(runnable.Binary), w, r)
 	case hput.Text:
 		s.Logger.Debugf("processing RUN service got text, %s", runnable.Text)
 		w.WriteHeader(http.StatusOK)
@@ -155,30 +271,181 @@ func (s *Service) Run(ctx context.Context, w http.ResponseWriter, r *http.Reques
 	return nil
 }
 
-func (s *Service) dumpPath(ctx context.Context, p url.URL, w http.ResponseWriter) {
-	runnablesChan := make(chan hput.Runnable)
-	doneChan := make(chan bool, 1)
-	pStr := p.Path[:len(p.Path)-5]
-	var err error
-	go func() {
-		s.Logger.Debugf("sending runnables for %s", pStr)
-		err = s.Saver.SendRunnables(ctx, pStr, runnablesChan, doneChan)
-	}()
+// lookup returns what is at p for Run: a stream if the Saver streams the
+// binary there, and otherwise the runnable, or nil if there is nothing.
+func (s *Service) lookup(ctx context.Context, p url.URL) (*hput.Runnable, io.ReadSeekCloser, error) {
+	if rst, ok := s.Saver.(RunnableStreamer); ok {
+		runnable, rs, err := rst.GetRunnableStream(ctx, p)
+		if err != nil || rs != nil || runnable.Type == "" {
+			return nil, rs, err
+		}
+		return &runnable, nil, nil
+	}
+	if ss, ok := s.Saver.(StreamSaver); ok {
+		rs, err := ss.GetStream(ctx, p)
+		if err != nil || rs != nil {
+			return nil, rs, err
+		}
+	}
+	runnable, err := s.getPathRunnable(ctx, p)
+	return runnable, nil, err
+}
+
+// BinaryInfo is what a stream from GetStream may also tell about its
+// binary, so clients can revalidate it and resume downloads with If-Range.
+type BinaryInfo interface {
+	ETag() string       // quoted entity tag, or "" if unknown
+	ModTime() time.Time // zero if unknown
+}
+
+// serveBinary serves a binary with http.ServeContent, so Range requests get
+// 206 Partial Content, several ranges come back as multipart/byteranges, and
+// If-Range, If-None-Match and If-Modified-Since are honored.
+func (s *Service) serveBinary(rs io.ReadSeeker, w http.ResponseWriter, r *http.Request) error {
+	size, err := rs.Seek(0, io.SeekEnd)
+	if err == nil {
+		_, err = rs.Seek(0, io.SeekStart)
+	}
 	if err != nil {
-		s.Logger.Errorf("got an error dumping from path %+v: %+v", p, err)
-		return
+		return fmt.Errorf("could not size binary: %w", err)
+	}
+	if size == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("There is nothing at path: '%s', you can use a PUT verb to add something\n", r.URL.Path)))
+		return nil
 	}
+	s.Logger.Debugf("processing RUN service serving binary length %d", size)
+	var modTime time.Time
+	if info, ok := rs.(BinaryInfo); ok {
+		if etag := info.ETag(); etag != "" {
+			w.Header().Set("ETag", etag)
+		}
+		modTime = info.ModTime()
+	}
+	http.ServeContent(w, r, path.Base(r.URL.Path), modTime, rs)
+	return nil
+}
+
+// binaryETag is the entity tag of a binary held in memory.
+func binaryETag(b []byte) string {
+	sum := sha256.Sum256(b)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// dumpPath outputs instructions that recreate every path under p, and then
+// their KV data. Listing stops if the client goes away. An error part way
+// is logged and noted at the end of the dump, so it is not mistaken for a
+// whole one.
+func (s *Service) dumpPath(ctx context.Context, r *http.Request, w http.ResponseWriter) {
+	p := *r.URL
+	pStr := p.Path[:len(p.Path)-5]
+	s.Logger.Debugf("dumping runnables for %s", pStr)
 	w.Write([]byte("//Dumping creation instructions v0.2\n"))
 	var dumpedFirst bool
-	for {
-		select {
-		case run := <-runnablesChan:
-			s.respondWithRunnable(run, dumpedFirst, w)
-			dumpedFirst = true
-		case <-doneChan:
+	for e, err := range s.Saver.List(ctx, pStr, "", 0) {
+		var run hput.Runnable
+		if err == nil {
+			run, err = s.Saver.GetRunnable(ctx, url.URL{Path: e.Path})
+		}
+		if err != nil {
+			s.Logger.Errorf("got an error dumping from path %+v: %+v", p, err)
+			w.Write([]byte("// dump incomplete, an error stopped it\n"))
 			return
 		}
+		if run.Type == "" {
+			// Deleted since it was listed.
+			continue
+		}
+		s.respondWithRunnable(run, dumpedFirst, w)
+		dumpedFirst = true
+	}
+	if s.KV == nil {
+		return
+	}
+	if s.Admin == nil || !s.Admin(r) {
+		w.Write([]byte("// KV data is left out; dump as an admin to include it\n"))
+		return
+	}
+	if err := s.dumpKV(ctx, pStr, dumpedFirst, w); err != nil {
+		s.Logger.Errorf("got an error dumping KV data from path %+v: %+v", p, err)
+		w.Write([]byte("// dump incomplete, an error stopped it\n"))
+	}
+}
+
+// dumpKV outputs instructions that recreate the KV data of every namespace
+// at or under path through the admin API, with when each key expires. A
+// dump of the whole server, where path is empty, also includes shared
+// namespaces and their grants.
+func (s *Service) dumpKV(ctx context.Context, path string, dumpedFirst bool, w http.ResponseWriter) error {
+	paths, err := s.KV.Paths(ctx)
+	if err != nil {
+		return fmt.Errorf("could not list KV namespaces: %w", err)
+	}
+	for _, p := range paths {
+		if path != "" && p != path && !strings.HasPrefix(p, path+"/") {
+			continue
+		}
+		entries, err := s.kvEntries(ctx, p)
+		if err != nil {
+			return fmt.Errorf("could not read KV namespace %s: %w", p, err)
+		}
+		for _, e := range entries {
+			s.respondWithKV(p, e, dumpedFirst, w)
+			dumpedFirst = true
+		}
+	}
+	return nil
+}
+
+// kvEntries returns every live key in path's namespace with its value and
+// expiry, read in one transaction.
+func (s *Service) kvEntries(ctx context.Context, path string) ([]kv.Entry, error) {
+	res, err := s.KV.List(ctx, path, kv.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	var entries []kv.Entry
+	err = s.KV.Update(ctx, path, func(tx kv.Tx) error {
+		for _, key := range res.Keys {
+			v, err := tx.Get(key)
+			if err != nil {
+				return err
+			}
+			if v == nil {
+				// Deleted or expired since it was listed.
+				continue
+			}
+			expiresAt, err := tx.ExpiresAt(key)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, kv.Entry{Key: key, Value: v, ExpiresAt: expiresAt})
+		}
+		return nil
+	})
+	return entries, err
+}
+
+// respondWithKV outputs the instructions for one KV key to the response
+func (s *Service) respondWithKV(path string, e kv.Entry, dumpedFirst bool, w http.ResponseWriter) {
+	q := url.Values{"path": {path}, "key": {e.Key}}
+	if !e.ExpiresAt.IsZero() {
+		q.Set("expires", e.ExpiresAt.UTC().Format(time.RFC3339Nano))
+	}
+	target := "http://localhost/_hput/kv/key?" + q.Encode()
+	if !utf8.Valid(e.Value) {
+		w.Write([]byte(fmt.Sprintf("// binary KV value at %s\n", target)))
+		return
+	}
+	if !dumpedFirst {
+		w.Write([]byte("var xhr = new XMLHttpRequest();\n"))
+	} else {
+		w.Write([]byte("xhr = new XMLHttpRequest();\n"))
 	}
+	body, _ := json.Marshal(string(e.Value))
+	w.Write([]byte("xhr.withCredentials = true;\n"))
+	w.Write([]byte(fmt.Sprintf("xhr.open(\"PUT\", %q);\n", target)))
+	w.Write([]byte(fmt.Sprintf("xhr.send(%s);\n", body)))
 }
 
 // respondWithRunnable outputs a specific runnable to the response
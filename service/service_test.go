@@ -3,20 +3,26 @@ package service
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"hput"
 	"hput/kv"
 	"io"
+	"iter"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
 
 type TestSaver struct {
 	GiveRunnable hput.Runnable
+	Listed       []hput.Runnable // what List yields, and GetRunnable returns at their paths
+	ListErr      error           // yielded after Listed
 }
 
 func (t *TestSaver) SaveText(ctx context.Context, s string, p url.URL, r *hput.PutResult) error {
@@ -26,17 +32,26 @@ func (t *TestSaver) SaveText(ctx context.Context, s string, p url.URL, r *hput.P
 }
 
 func (t *TestSaver) GetRunnable(ctx context.Context, p url.URL) (hput.Runnable, error) {
+	for _, r := range t.Listed {
+		if r.Path == p.Path {
+			return r, nil
+		}
+	}
 	return t.GiveRunnable, nil
 }
 
-func (t *TestSaver) SendRunnables(ctx context.Context, p string, runnables chan<- hput.Runnable, done chan<- bool) error {
-	runnables <- hput.Runnable{
-		Type: hput.Text,
-		Text: "aText",
-		Path: "/pth",
+func (t *TestSaver) List(ctx context.Context, prefix, cursor string, limit int) iter.Seq2[hput.Entry, error] {
+	return func(yield func(hput.Entry, error) bool) {
+		for _, r := range t.Listed {
+			e := hput.Entry{Path: r.Path, Type: r.Type, Size: int64(len(r.Text) + len(r.Binary))}
+			if !yield(e, nil) {
+				return
+			}
+		}
+		if t.ListErr != nil {
+			yield(hput.Entry{}, t.ListErr)
+		}
 	}
-	done <- true
-	return nil
 }
 
 func (t *TestSaver) SaveCode(ctx context.Context, s string, p url.URL, r *hput.PutResult) error {
@@ -167,6 +182,7 @@ func TestRun(t *testing.T) {
 		name     string
 		req      *http.Request
 		runnable hput.Runnable
+		listed   []hput.Runnable
 		dumpText string
 	}{
 		{
@@ -207,6 +223,7 @@ func TestRun(t *testing.T) {
 			req: &http.Request{
 				URL: &url.URL{Path: "/dump"},
 			},
+			listed:   []hput.Runnable{{Path: "/pth", Type: hput.Text, Text: "aText"}},
 			dumpText: "//Dumping creation instructions v0.2\nvar xhr = new XMLHttpRequest();\nxhr.withCredentials = true;\nxhr.open(\"PUT\", \"http://localhost/pth\");\nxhr.send(`aText`);\n",
 		},
 	}
@@ -215,6 +232,7 @@ func TestRun(t *testing.T) {
 			s := Service{
 				Saver: &TestSaver{
 					GiveRunnable: test.runnable,
+					Listed:       test.listed,
 				},
 				Interpreter: &TestInterpreter{},
 				Logger:      &TestLogger{},
@@ -236,3 +254,277 @@ func TestRun(t *testing.T) {
 		})
 	}
 }
+
+// TestDumpKV verifies that /dump recreates the KV data of the dumped subtree
+func TestDumpKV(t *testing.T) {
+	ctx := context.Background()
+	store := kv.NewMemory()
+	defer store.Close()
+	assert.NoError(t, store.Put(ctx, "/app", "greeting", []byte("say \"hi\"\n")))
+	assert.NoError(t, store.Put(ctx, "/app/sub", "n", []byte(`1`)))
+	assert.NoError(t, store.Put(ctx, "/apple", "n", []byte(`2`)))
+	assert.NoError(t, store.Put(ctx, "_shared/flags", "beta", []byte(`true`)))
+	assert.NoError(t, store.Put(ctx, "/app", "raw", []byte{0xff}))
+	expires := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	assert.NoError(t, store.PutExpiring(ctx, "/app", "session", []byte(`"s"`), expires))
+	s := Service{
+		Saver:       &TestSaver{Listed: []hput.Runnable{{Path: "/pth", Type: hput.Text, Text: "aText"}}},
+		Interpreter: &TestInterpreter{},
+		KV:          store,
+		Logger:      &TestLogger{},
+		Admin:       func(*http.Request) bool { return true },
+	}
+
+	rec := httptest.NewRecorder()
+	assert.NoError(t, s.Run(ctx, rec, &http.Request{URL: &url.URL{Path: "/app/dump"}}))
+	assert.Equal(t, "//Dumping creation instructions v0.2\n"+
+		"var xhr = new XMLHttpRequest();\nxhr.withCredentials = true;\nxhr.open(\"PUT\", \"http://localhost/pth\");\nxhr.send(`aText`);\n"+
+		"xhr = new XMLHttpRequest();\nxhr.withCredentials = true;\nxhr.open(\"PUT\", \"http://localhost/_hput/kv/key?key=greeting&path=%2Fapp\");\nxhr.send(\"say \\\"hi\\\"\\n\");\n"+
+		"// binary KV value at http://localhost/_hput/kv/key?key=raw&path=%2Fapp\n"+
+		"xhr = new XMLHttpRequest();\nxhr.withCredentials = true;\nxhr.open(\"PUT\", \"http://localhost/_hput/kv/key?expires=2030-01-02T03%3A04%3A05Z&key=session&path=%2Fapp\");\nxhr.send(\"\\\"s\\\"\");\n"+
+		"xhr = new XMLHttpRequest();\nxhr.withCredentials = true;\nxhr.open(\"PUT\", \"http://localhost/_hput/kv/key?key=n&path=%2Fapp%2Fsub\");\nxhr.send(\"1\");\n",
+		rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	assert.NoError(t, s.Run(ctx, rec, &http.Request{URL: &url.URL{Path: "/dump"}}))
+	assert.Contains(t, rec.Body.String(), "path=%2Fapple")
+	assert.Contains(t, rec.Body.String(), "path=_shared%2Fflags", "a whole-server dump includes shared namespaces")
+
+	s.Admin = func(*http.Request) bool { return false }
+	rec = httptest.NewRecorder()
+	assert.NoError(t, s.Run(ctx, rec, &http.Request{URL: &url.URL{Path: "/dump"}}))
+	assert.NotContains(t, rec.Body.String(), "/_hput/kv/", "only admins see KV data")
+	assert.Contains(t, rec.Body.String(), "// KV data is left out")
+
+	s.Admin = func(*http.Request) bool { return true }
+	s.KV = failingKV{store}
+	rec = httptest.NewRecorder()
+	assert.NoError(t, s.Run(ctx, rec, &http.Request{URL: &url.URL{Path: "/dump"}}))
+	assert.True(t, strings.HasSuffix(rec.Body.String(), "// dump incomplete, an error stopped it\n"))
+}
+
+// failingKV is a KV whose namespaces cannot be listed
+type failingKV struct {
+	kv.KV
+}
+
+func (failingKV) Paths(ctx context.Context) ([]string, error) {
+	return nil, errors.New("kv unreachable")
+}
+
+// TestDumpError verifies that a dump stopped by an error says it is incomplete
+func TestDumpError(t *testing.T) {
+	s := Service{
+		Saver: &TestSaver{
+			Listed:  []hput.Runnable{{Path: "/pth", Type: hput.Text, Text: "aText"}},
+			ListErr: errors.New("bucket unreachable"),
+		},
+		Interpreter: &TestInterpreter{},
+		Logger:      &TestLogger{},
+	}
+	rec := httptest.NewRecorder()
+	assert.NoError(t, s.Run(context.Background(), rec, &http.Request{URL: &url.URL{Path: "/dump"}}))
+	assert.Equal(t, "//Dumping creation instructions v0.2\n"+
+		"var xhr = new XMLHttpRequest();\nxhr.withCredentials = true;\nxhr.open(\"PUT\", \"http://localhost/pth\");\nxhr.send(`aText`);\n"+
+		"// dump incomplete, an error stopped it\n",
+		rec.Body.String())
+}
+
+// TestStreamSaver is a TestSaver that also streams binaries
+type TestStreamSaver struct {
+	TestSaver
+	Streamed []byte // what SaveStream read
+	Stream   []byte // what GetStream returns, if not nil
+}
+
+func (t *TestStreamSaver) SaveStream(ctx context.Context, b io.Reader, p url.URL, r *hput.PutResult) error {
+	var err error
+	t.Streamed, err = io.ReadAll(b)
+	return err
+}
+
+func (t *TestStreamSaver) GetStream(ctx context.Context, p url.URL) (io.ReadSeekCloser, error) {
+	if t.Stream == nil {
+		return nil, nil
+	}
+	return testStream{bytes.NewReader(t.Stream), binaryETag(t.Stream)}, nil
+}
+
+// testStream is a stream from TestStreamSaver
+type testStream struct {
+	*bytes.Reader
+	etag string
+}
+
+func (testStream) Close() error { return nil }
+
+func (s testStream) ETag() string { return s.etag }
+
+func (testStream) ModTime() time.Time { return time.Time{} }
+
+// TestPutStream verifies binaries are streamed to a StreamSaver and text is not
+func TestPutStream(t *testing.T) {
+	tt := []struct {
+		name     string
+		body     []byte
+		stream   []byte
+		streamed []byte
+		echo     string
+	}{
+		{name: "binary", body: []byte{200, 200, 200, 0, 1}, streamed: []byte{200, 200, 200, 0, 1}},
+		{name: "large binary", body: bytes.Repeat([]byte{200}, 1000), streamed: bytes.Repeat([]byte{200}, 1000)},
+		{name: "binary over a binary", body: []byte{200, 0}, stream: []byte{200}, streamed: []byte{200, 0}, echo: "// binary at http://localhost/pth"},
+		{name: "text", body: []byte("aText")},
+	}
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			saver := &TestStreamSaver{Stream: test.stream}
+			s := Service{
+				Saver:       saver,
+				Interpreter: &TestInterpreter{},
+				Logger:      &TestLogger{},
+			}
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPut, "/pth", bytes.NewReader(test.body))
+			_, err := s.Put(context.Background(), w, req)
+			assert.NoError(t, err)
+			assert.Equal(t, test.streamed, saver.Streamed)
+			assert.Contains(t, w.Body.String(), test.echo)
+		})
+	}
+}
+
+// TestPutTooLarge verifies bodies over MaxUpload are rejected
+func TestPutTooLarge(t *testing.T) {
+	tt := []struct {
+		name    string
+		saver   Saver
+		body    []byte
+		chunked bool
+		err     error
+	}{
+		{name: "text at the limit", saver: &TestSaver{}, body: bytes.Repeat([]byte("a"), 300)},
+		{name: "text over the limit", saver: &TestSaver{}, body: bytes.Repeat([]byte("a"), 301), err: hput.ErrTooLarge},
+		{name: "chunked text over the limit", saver: &TestSaver{}, body: bytes.Repeat([]byte("a"), 301), chunked: true, err: hput.ErrTooLarge},
+		{name: "chunked binary over the limit", saver: &TestSaver{}, body: bytes.Repeat([]byte{200}, 301), chunked: true, err: hput.ErrTooLarge},
+		{name: "chunked streamed binary over the limit", saver: &TestStreamSaver{}, body: bytes.Repeat([]byte{200}, 301), chunked: true, err: hput.ErrTooLarge},
+	}
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			s := Service{
+				Saver:       test.saver,
+				Interpreter: &TestInterpreter{},
+				Logger:      &TestLogger{},
+				MaxUpload:   300,
+			}
+			req := httptest.NewRequest(http.MethodPut, "/pth", bytes.NewReader(test.body))
+			if test.chunked {
+				req.ContentLength = -1
+			}
+			_, err := s.Put(context.Background(), httptest.NewRecorder(), req)
+			assert.ErrorIs(t, err, test.err)
+		})
+	}
+}
+
+// TestRunStream verifies binaries are streamed from a StreamSaver
+func TestRunStream(t *testing.T) {
+	s := Service{
+		Saver:       &TestStreamSaver{Stream: []byte{200, 200, 0}},
+		Interpreter: &TestInterpreter{},
+		Logger:      &TestLogger{},
+	}
+	rec := httptest.NewRecorder()
+	assert.NoError(t, s.Run(context.Background(), rec, httptest.NewRequest(http.MethodGet, "/pth", nil)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "3", rec.Header().Get("Content-Length"))
+	assert.Equal(t, []byte{200, 200, 0}, rec.Body.Bytes())
+}
+
+// TestRunOneRead verifies a RunnableStreamer is read once for what is not a binary
+func TestRunOneRead(t *testing.T) {
+	saver := &TestRunnableStreamer{TestStreamSaver: TestStreamSaver{TestSaver: TestSaver{Listed: []hput.Runnable{{Path: "/pth", Type: hput.Text, Text: "hello"}}}}}
+	s := Service{Saver: saver, Interpreter: &TestInterpreter{}, Logger: &TestLogger{}}
+	rec := httptest.NewRecorder()
+	assert.NoError(t, s.Run(context.Background(), rec, httptest.NewRequest(http.MethodGet, "/pth", nil)))
+	assert.Equal(t, "hello", rec.Body.String())
+	assert.Equal(t, 1, saver.Reads)
+}
+
+// TestRunnableStreamer is a TestStreamSaver that counts every read of a path
+type TestRunnableStreamer struct {
+	TestStreamSaver
+	Reads int
+}
+
+func (t *TestRunnableStreamer) GetRunnable(ctx context.Context, p url.URL) (hput.Runnable, error) {
+	t.Reads++
+	return t.TestStreamSaver.GetRunnable(ctx, p)
+}
+
+func (t *TestRunnableStreamer) GetStream(ctx context.Context, p url.URL) (io.ReadSeekCloser, error) {
+	t.Reads++
+	return t.TestStreamSaver.GetStream(ctx, p)
+}
+
+func (t *TestRunnableStreamer) GetRunnableStream(ctx context.Context, p url.URL) (hput.Runnable, io.ReadSeekCloser, error) {
+	t.Reads++
+	if rs, _ := t.TestStreamSaver.GetStream(ctx, p); rs != nil {
+		return hput.Runnable{}, rs, nil
+	}
+	r, err := t.TestStreamSaver.GetRunnable(ctx, p)
+	return r, nil, err
+}
+
+// TestRunRange verifies binaries honor Range and If-Range
+func TestRunRange(t *testing.T) {
+	bin := []byte("0123456789")
+	etag := binaryETag(bin)
+	tt := []struct {
+		name          string
+		headers       map[string]string
+		code          int
+		contentRange  string
+		body          string
+		multipartBody bool
+	}{
+		{name: "no range", code: http.StatusOK, body: "0123456789"},
+		{name: "one range", headers: map[string]string{"Range": "bytes=2-4"}, code: http.StatusPartialContent, contentRange: "bytes 2-4/10", body: "234"},
+		{name: "suffix range", headers: map[string]string{"Range": "bytes=-3"}, code: http.StatusPartialContent, contentRange: "bytes 7-9/10", body: "789"},
+		{name: "several ranges", headers: map[string]string{"Range": "bytes=0-1,8-9"}, code: http.StatusPartialContent, multipartBody: true},
+		{name: "unsatisfiable range", headers: map[string]string{"Range": "bytes=20-30"}, code: http.StatusRequestedRangeNotSatisfiable, contentRange: "bytes */10"},
+		{name: "matching If-Range", headers: map[string]string{"Range": "bytes=2-4", "If-Range": etag}, code: http.StatusPartialContent, contentRange: "bytes 2-4/10", body: "234"},
+		{name: "stale If-Range", headers: map[string]string{"Range": "bytes=2-4", "If-Range": `"stale"`}, code: http.StatusOK, body: "0123456789"},
+		{name: "matching If-None-Match", headers: map[string]string{"If-None-Match": etag}, code: http.StatusNotModified},
+	}
+	for _, test := range tt {
+		t.Run(test.name, func(t *testing.T) {
+			for _, saver := range []Saver{
+				&TestSaver{GiveRunnable: hput.Runnable{Type: hput.Binary, Binary: bin}},
+				&TestStreamSaver{Stream: bin},
+			} {
+				s := Service{Saver: saver, Interpreter: &TestInterpreter{}, Logger: &TestLogger{}}
+				req := httptest.NewRequest(http.MethodGet, "/pth", nil)
+				for k, v := range test.headers {
+					req.Header.Set(k, v)
+				}
+				rec := httptest.NewRecorder()
+				assert.NoError(t, s.Run(context.Background(), rec, req))
+				assert.Equal(t, test.code, rec.Code)
+				if test.code == http.StatusOK || test.code == http.StatusPartialContent {
+					assert.Equal(t, "bytes", rec.Header().Get("Accept-Ranges"))
+				}
+				assert.Equal(t, test.contentRange, rec.Header().Get("Content-Range"))
+				if test.multipartBody {
+					assert.Contains(t, rec.Header().Get("Content-Type"), "multipart/byteranges")
+					assert.Contains(t, rec.Body.String(), "Content-Range: bytes 0-1/10\r\n")
+					assert.Contains(t, rec.Body.String(), "\r\n\r\n01\r\n")
+					assert.Contains(t, rec.Body.String(), "Content-Range: bytes 8-9/10\r\n")
+					assert.Contains(t, rec.Body.String(), "\r\n\r\n89\r\n")
+				} else if test.code != http.StatusRequestedRangeNotSatisfiable {
+					assert.Equal(t, test.body, rec.Body.String())
+				}
+			}
+		})
+	}
+}
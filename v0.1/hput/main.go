@@ -12,6 +12,7 @@ import (
 	"hput/mapsaver"
 	"hput/s3saver"
 	"hput/service"
+	"iter"
 	"net/url"
 )
 
@@ -19,7 +20,7 @@ import (
 type Saver interface {
 	SaveText(ctx context.Context, s string, p url.URL, r *hput.PutResult) error
 	GetRunnable(ctx context.Context, p url.URL) (hput.Runnable, error)
-	SendRunnables(ctx context.Context, p string, runnables chan<- hput.Runnable, done chan<- bool) error
+	List(ctx context.Context, prefix, cursor string, limit int) iter.Seq2[hput.Entry, error]
 	SaveCode(ctx context.Context, s string, p url.URL, r *hput.PutResult) error
 	SaveBinary(ctx context.Context, b []byte, p url.URL, r *hput.PutResult) error
 }
@@ -51,9 +52,7 @@ func main() {
 		}
 		l.Debug("Initialized local saver")
 	case "memory":
-		saver = &mapsaver.MapSaver{
-			Logger: &l,
-		}
+		saver = mapsaver.New(&l)
 		l.Debug("Initialized map saver")
 	case "s3":
 		saver, err = s3saver.New(ctx, &l, *bucketPtr, *&s3saver.PrefixOption{Prefix: *prefixPtr})